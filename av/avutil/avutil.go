@@ -186,7 +186,8 @@ func (handlers *Handlers) Open(uri string) (av.DemuxCloser, error) {
 	for _, handler := range handlers.handlers {
 		if handler.Ext == ext {
 			if handler.ReaderDemuxer != nil {
-				if _, err := handlers.openURL(u, uri); err != nil {
+				var err error
+				if r, err = handlers.openURL(u, uri); err != nil {
 					return nil, err
 				}
 
@@ -201,16 +202,18 @@ func (handlers *Handlers) Open(uri string) (av.DemuxCloser, error) {
 	}
 	var probebuf [1024]byte
 
-	if _, err := handlers.openURL(u, uri); err != nil {
+	r, err := handlers.openURL(u, uri)
+	if err != nil {
 		return nil, err
 	}
 
-	if _, err := io.ReadFull(r, probebuf[:]); err != nil {
+	n, err := io.ReadFull(r, probebuf[:])
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
 		return nil, err
 	}
 
 	for _, handler := range handlers.handlers {
-		if handler.Probe != nil && handler.Probe(probebuf[:]) && handler.ReaderDemuxer != nil {
+		if handler.Probe != nil && handler.Probe(probebuf[:n]) && handler.ReaderDemuxer != nil {
 			var _r io.Reader
 
 			if rs, ok := r.(io.ReadSeeker); ok {
@@ -220,7 +223,7 @@ func (handlers *Handlers) Open(uri string) (av.DemuxCloser, error) {
 
 				_r = rs
 			} else {
-				_r = io.MultiReader(bytes.NewReader(probebuf[:]), r)
+				_r = io.MultiReader(bytes.NewReader(probebuf[:n]), r)
 			}
 
 			demuxer := &HandlerDemuxer{
@@ -232,7 +235,7 @@ func (handlers *Handlers) Open(uri string) (av.DemuxCloser, error) {
 		}
 	}
 
-	// r.Close()
+	_ = r.Close()
 
 	return nil, ErrOpenURLFailed
 }
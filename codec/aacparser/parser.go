@@ -17,6 +17,7 @@ var (
 	ErrAACparserAdtsChannelCountInvalid = errors.New("aacparser: adts channel count invalid")
 	ErrAACparserAdtsFrameLen            = errors.New("aacparser: adts framelen < hdrlen")
 	ErrAACparserMPEG4AudioConfigFailed  = errors.New("aacparser: parse MPEG4AudioConfig failed")
+	ErrAACparserSampleRateIndexInvalid  = errors.New("aacparser: reserved sampling frequency index")
 )
 
 // copied from libavcodec/mpeg4audio.h.
@@ -106,6 +107,10 @@ var chanConfigTable = []av.ChannelLayout{
 	av.ChFrontCenter | av.ChFrontLeft | av.ChFrontRight | av.ChSideLeft | av.ChSideRight | av.ChBackLeft | av.ChBackRight | av.ChLowFreq,
 }
 
+// ParseADTSHeader parses the ADTS header at the start of frame. A reserved
+// sampling frequency index gives ErrAACparserSampleRateIndexInvalid along with
+// the frame and header lengths, so that the frame can be skipped.
+//
 //nolint:nonamedreturns
 func ParseADTSHeader(frame []byte) (config MPEG4AudioConfig, hdrlen int, framelen int, samples int, err error) {
 	if frame[0] != 0xff || frame[1]&0xf6 != 0xf0 {
@@ -140,6 +145,10 @@ func ParseADTSHeader(frame []byte) (config MPEG4AudioConfig, hdrlen int, framele
 		return config, hdrlen, framelen, samples, err
 	}
 
+	if config.SampleRate == 0 {
+		err = ErrAACparserSampleRateIndexInvalid
+	}
+
 	return config, hdrlen, framelen, samples, err
 }
 
@@ -244,9 +253,15 @@ func (s *MPEG4AudioConfig) IsValid() bool {
 	return s.ObjectType > 0
 }
 
+// Complete sets SampleRate and ChannelLayout from the indexes. An index above
+// 0xf is an explicit sampling frequency; indexes 13 and 14 are reserved and
+// leave SampleRate at 0.
 func (s *MPEG4AudioConfig) Complete() {
-	if int(s.SampleRateIndex) < len(sampleRateTable) {
+	switch {
+	case int(s.SampleRateIndex) < len(sampleRateTable):
 		s.SampleRate = sampleRateTable[s.SampleRateIndex]
+	case s.SampleRateIndex > 0xf:
+		s.SampleRate = int(s.SampleRateIndex)
 	}
 
 	if int(s.ChannelConfig) < len(chanConfigTable) {
@@ -276,6 +291,10 @@ func ParseMPEG4AudioConfigBytes(data []byte) (MPEG4AudioConfig, error) {
 
 	(&config).Complete()
 
+	if config.SampleRate == 0 {
+		return config, ErrAACparserSampleRateIndexInvalid
+	}
+
 	return config, err
 }
 
@@ -0,0 +1,344 @@
+package codec
+
+import (
+	"bytes"
+	"errors"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/utils/bits"
+)
+
+var (
+	ErrInvalidAV1SequenceHeader = errors.New("codec: invalid AV1 sequence header")
+	ErrInvalidAV1Config         = errors.New("codec: invalid AV1CodecConfigurationRecord")
+)
+
+const av1OBUSequenceHeader = 1
+
+// AV1CodecData describes an AV1 stream by its sequence header OBU, from which
+// the AV1CodecConfigurationRecord (av1C) stored by MP4 and Matroska is built.
+type AV1CodecData struct {
+	PicWidth       int
+	PicHeight      int
+	SequenceHeader []byte // sequence header OBU, in low-overhead bitstream format
+	config         [3]byte
+}
+
+// NewAV1CodecDataFromSequenceHeader returns the codec data of the sequence
+// header OBU obu (AV1 specification §5.5). The size is the maximum frame size.
+func NewAV1CodecDataFromSequenceHeader(obu []byte) (AV1CodecData, error) {
+	obu, payload, _, ok := av1SplitOBU(obu)
+	if !ok || obu[0]>>3&0x0f != av1OBUSequenceHeader {
+		return AV1CodecData{}, ErrInvalidAV1SequenceHeader
+	}
+
+	s := AV1CodecData{SequenceHeader: bytes.Clone(obu)}
+	if err := s.parseSequenceHeader(payload); err != nil {
+		return AV1CodecData{}, err
+	}
+
+	return s, nil
+}
+
+// ParseAV1CodecConfigurationRecord parses an av1C record (AV1 Codec ISO
+// Media File Format Binding §2.3) whose configOBUs hold the sequence header.
+func ParseAV1CodecConfigurationRecord(b []byte) (AV1CodecData, error) {
+	if len(b) < 4 || b[0] != 0x81 {
+		return AV1CodecData{}, ErrInvalidAV1Config
+	}
+
+	obu := AV1SequenceHeaderOBU(b[4:])
+	if obu == nil {
+		return AV1CodecData{}, ErrInvalidAV1Config
+	}
+
+	return NewAV1CodecDataFromSequenceHeader(obu)
+}
+
+// AV1SequenceHeaderOBU returns the sequence header OBU of a temporal unit in
+// low-overhead bitstream format, or nil if it has none.
+func AV1SequenceHeaderOBU(b []byte) []byte {
+	for len(b) > 0 {
+		obu, _, rest, ok := av1SplitOBU(b)
+		if !ok {
+			return nil
+		}
+
+		if obu[0]>>3&0x0f == av1OBUSequenceHeader {
+			return obu
+		}
+
+		b = rest
+	}
+
+	return nil
+}
+
+// AV1CodecConfigurationRecord returns the av1C record of the stream, with
+// the sequence header as its only config OBU.
+func (s AV1CodecData) AV1CodecConfigurationRecord() []byte {
+	b := make([]byte, 0, 4+len(s.SequenceHeader))
+	b = append(b, 0x81) // marker, version 1
+	b = append(b, s.config[:]...)
+
+	return append(b, s.SequenceHeader...)
+}
+
+// Type implements av.VideoCodecData.
+func (s AV1CodecData) Type() av.CodecType {
+	return av.AV1
+}
+
+// Width implements av.VideoCodecData.
+func (s AV1CodecData) Width() int {
+	return s.PicWidth
+}
+
+// Height implements av.VideoCodecData.
+func (s AV1CodecData) Height() int {
+	return s.PicHeight
+}
+
+// TimeScale implements av.VideoCodecData.
+func (s AV1CodecData) TimeScale() uint32 {
+	return 90000
+}
+
+// av1SplitOBU splits the OBU that starts b from the rest of b. An OBU without
+// obu_has_size_field extends to the end of b.
+func av1SplitOBU(b []byte) (obu, payload, rest []byte, ok bool) {
+	n := 1
+	if len(b) > 0 && b[0]&0x04 != 0 { // obu_extension_flag
+		n++
+	}
+
+	if len(b) < n {
+		return nil, nil, nil, false
+	}
+
+	if b[0]&0x02 == 0 {
+		return b, b[n:], nil, true
+	}
+
+	size, m := leb128(b[n:])
+	if m == 0 || size > uint64(len(b)-n-m) {
+		return nil, nil, nil, false
+	}
+
+	end := n + m + int(size)
+
+	return b[:end], b[n+m : end], b[end:], true
+}
+
+// parseSequenceHeader reads sequence_header_obu() (AV1 specification §5.5)
+// up to the end of color_config().
+//
+//nolint:funlen,gocognit,gocyclo,cyclop
+func (s *AV1CodecData) parseSequenceHeader(payload []byte) error {
+	r := &av1BitReader{r: bits.GolombBitReader{R: bytes.NewReader(payload)}}
+
+	profile := r.f(3)
+	r.f(1) // still_picture
+	reduced := r.f(1)
+
+	var level, tier uint
+
+	if reduced == 1 {
+		level = r.f(5)
+	} else {
+		var bufferDelayLen uint
+
+		decoderModel := uint(0)
+
+		if r.f(1) == 1 { // timing_info_present_flag
+			r.f(32) // num_units_in_display_tick
+			r.f(32) // time_scale
+
+			if r.f(1) == 1 { // equal_picture_interval
+				r.uvlc() // num_ticks_per_picture_minus_1
+			}
+
+			if decoderModel = r.f(1); decoderModel == 1 {
+				bufferDelayLen = r.f(5) + 1
+				r.f(32) // num_units_in_decoding_tick
+				r.f(5)  // buffer_removal_time_length_minus_1
+				r.f(5)  // frame_presentation_time_length_minus_1
+			}
+		}
+
+		initialDisplayDelay := r.f(1)
+		operatingPoints := r.f(5) + 1
+
+		for i := range operatingPoints {
+			r.f(12) // operating_point_idc
+
+			opLevel := r.f(5)
+			opTier := uint(0)
+
+			if opLevel > 7 {
+				opTier = r.f(1)
+			}
+
+			if i == 0 {
+				level, tier = opLevel, opTier
+			}
+
+			if decoderModel == 1 && r.f(1) == 1 { // decoder_model_present_for_this_op
+				r.f(int(bufferDelayLen)) // decoder_buffer_delay
+				r.f(int(bufferDelayLen)) // encoder_buffer_delay
+				r.f(1)                   // low_delay_mode_flag
+			}
+
+			if initialDisplayDelay == 1 && r.f(1) == 1 {
+				r.f(4) // initial_display_delay_minus_1
+			}
+		}
+	}
+
+	widthBits := int(r.f(4)) + 1
+	heightBits := int(r.f(4)) + 1
+	s.PicWidth = int(r.f(widthBits)) + 1
+	s.PicHeight = int(r.f(heightBits)) + 1
+
+	if reduced == 0 && r.f(1) == 1 { // frame_id_numbers_present_flag
+		r.f(4) // delta_frame_id_length_minus_2
+		r.f(3) // additional_frame_id_length_minus_1
+	}
+
+	r.f(1) // use_128x128_superblock
+	r.f(1) // enable_filter_intra
+	r.f(1) // enable_intra_edge_filter
+
+	if reduced == 0 {
+		r.f(1) // enable_interintra_compound
+		r.f(1) // enable_masked_compound
+		r.f(1) // enable_warped_motion
+		r.f(1) // enable_dual_filter
+
+		orderHint := r.f(1)
+		if orderHint == 1 {
+			r.f(1) // enable_jnt_comp
+			r.f(1) // enable_ref_frame_mvs
+		}
+
+		screenContent := uint(2) // SELECT_SCREEN_CONTENT_TOOLS
+
+		if r.f(1) == 0 { // seq_choose_screen_content_tools
+			screenContent = r.f(1)
+		}
+
+		if screenContent > 0 && r.f(1) == 0 { // seq_choose_integer_mv
+			r.f(1) // seq_force_integer_mv
+		}
+
+		if orderHint == 1 {
+			r.f(3) // order_hint_bits_minus_1
+		}
+	}
+
+	r.f(1) // enable_superres
+	r.f(1) // enable_cdef
+	r.f(1) // enable_restoration
+
+	// color_config()
+	highBitdepth := r.f(1)
+	twelveBit := uint(0)
+
+	if profile == 2 && highBitdepth == 1 {
+		twelveBit = r.f(1)
+	}
+
+	mono := uint(0)
+	if profile != 1 {
+		mono = r.f(1)
+	}
+
+	primaries, transfer, matrix := uint(2), uint(2), uint(2) // unspecified
+
+	if r.f(1) == 1 { // color_description_present_flag
+		primaries, transfer, matrix = r.f(8), r.f(8), r.f(8)
+	}
+
+	ssx, ssy, chromaPosition := uint(1), uint(1), uint(0)
+
+	switch {
+	case mono == 1:
+		r.f(1) // color_range
+	case primaries == 1 && transfer == 13 && matrix == 0: // sRGB
+		ssx, ssy = 0, 0
+	default:
+		r.f(1) // color_range
+
+		switch {
+		case profile == 1:
+			ssx, ssy = 0, 0
+		case profile == 2 && twelveBit == 1:
+			if ssx = r.f(1); ssx == 1 {
+				ssy = r.f(1)
+			} else {
+				ssy = 0
+			}
+		case profile == 2:
+			ssy = 0
+		}
+
+		if ssx == 1 && ssy == 1 {
+			chromaPosition = r.f(2)
+		}
+	}
+
+	if r.err != nil || profile > 2 {
+		return ErrInvalidAV1SequenceHeader
+	}
+
+	s.config = [3]byte{
+		byte(profile<<5 | level),
+		byte(tier<<7 | highBitdepth<<6 | twelveBit<<5 | mono<<4 | ssx<<3 | ssy<<2 | chromaPosition),
+		0, // no initial_presentation_delay
+	}
+
+	return nil
+}
+
+// av1BitReader reads the fixed-width and uvlc fields of AV1 OBUs, keeping
+// the first error.
+type av1BitReader struct {
+	r   bits.GolombBitReader
+	err error
+}
+
+func (r *av1BitReader) f(n int) uint {
+	if r.err != nil {
+		return 0
+	}
+
+	v, err := r.r.ReadBits(n)
+	r.err = err
+
+	return v
+}
+
+func (r *av1BitReader) uvlc() {
+	zeros := 0
+
+	for r.err == nil && r.f(1) == 0 {
+		zeros++
+	}
+
+	if zeros < 32 {
+		r.f(zeros)
+	}
+}
+
+func leb128(b []byte) (uint64, int) {
+	var v uint64
+
+	for i := 0; i < 8 && i < len(b); i++ {
+		v |= uint64(b[i]&0x7f) << (7 * i)
+		if b[i]&0x80 == 0 {
+			return v, i + 1
+		}
+	}
+
+	return 0, 0
+}
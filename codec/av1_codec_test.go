@@ -0,0 +1,46 @@
+package codec_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/vtpl1/avsdk/codec"
+	"github.com/vtpl1/avsdk/internal/avtest"
+)
+
+func TestAV1CodecData(t *testing.T) {
+	obu := avtest.Unhex(avtest.AV1SequenceHeader)
+	tu := append([]byte{0x12, 0x00}, obu...) // temporal delimiter first
+
+	if got := codec.AV1SequenceHeaderOBU(tu); !bytes.Equal(got, obu) {
+		t.Fatalf("AV1SequenceHeaderOBU = %x, want %x", got, obu)
+	}
+
+	c, err := codec.NewAV1CodecDataFromSequenceHeader(obu)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Width() != 1280 || c.Height() != 720 {
+		t.Fatalf("size = %dx%d", c.Width(), c.Height())
+	}
+
+	av1C := c.AV1CodecConfigurationRecord()
+	if want := append([]byte{0x81, 0x08, 0x0c, 0x00}, obu...); !bytes.Equal(av1C, want) {
+		t.Fatalf("av1C = %x, want %x", av1C, want)
+	}
+
+	parsed, err := codec.ParseAV1CodecConfigurationRecord(av1C)
+	if err != nil || !bytes.Equal(parsed.AV1CodecConfigurationRecord(), av1C) {
+		t.Fatalf("ParseAV1CodecConfigurationRecord = %x, %v", parsed.AV1CodecConfigurationRecord(), err)
+	}
+
+	if _, err := codec.NewAV1CodecDataFromSequenceHeader(obu[:6]); !errors.Is(err, codec.ErrInvalidAV1SequenceHeader) {
+		t.Fatalf("truncated sequence header: err = %v", err)
+	}
+
+	if _, err := codec.ParseAV1CodecConfigurationRecord(av1C[:4]); !errors.Is(err, codec.ErrInvalidAV1Config) {
+		t.Fatalf("av1C without config OBUs: err = %v", err)
+	}
+}
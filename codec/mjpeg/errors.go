@@ -0,0 +1,8 @@
+package mjpeg
+
+import "errors"
+
+var (
+	ErrInvalidJPEG = errors.New("mjpeg: invalid JPEG")
+	ErrNoSOF       = errors.New("mjpeg: no SOF marker before scan data")
+)
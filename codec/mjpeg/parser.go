@@ -3,8 +3,98 @@ package mjpeg
 
 import "github.com/vtpl1/avsdk/av"
 
-type CodecData struct{}
+// JPEG markers.
+const (
+	markerSOI = 0xd8
+	markerEOI = 0xd9
+	markerSOS = 0xda
+	markerDHT = 0xc4
+	markerJPG = 0xc8
+	markerDAC = 0xcc
+)
 
+// CodecData describes a Motion JPEG stream. Every packet is a complete JPEG image.
+type CodecData struct {
+	PicWidth  int
+	PicHeight int
+}
+
+// NewCodecDataFromJPEG returns the CodecData of a stream whose frames look
+// like image: the picture size is read from its SOF marker.
+func NewCodecDataFromJPEG(image []byte) (CodecData, error) {
+	width, height, err := ParseSOF(image)
+	if err != nil {
+		return CodecData{}, err
+	}
+
+	return CodecData{PicWidth: width, PicHeight: height}, nil
+}
+
+// ParseSOF returns the picture size from the first start-of-frame marker of a
+// JPEG image.
+func ParseSOF(image []byte) (width, height int, err error) {
+	if len(image) < 4 || image[0] != 0xff || image[1] != markerSOI {
+		return 0, 0, ErrInvalidJPEG
+	}
+
+	for i := 2; i+4 <= len(image); {
+		if image[i] != 0xff {
+			return 0, 0, ErrInvalidJPEG
+		}
+
+		marker := image[i+1]
+
+		switch {
+		case marker == 0xff: // fill byte
+			i++
+
+			continue
+		case marker == 0x01 || (marker >= 0xd0 && marker <= 0xd7): // TEM, RSTn: no payload
+			i += 2
+
+			continue
+		case marker == markerSOS || marker == markerEOI:
+			return 0, 0, ErrNoSOF
+		}
+
+		size := int(image[i+2])<<8 | int(image[i+3])
+		if size < 2 || i+2+size > len(image) {
+			return 0, 0, ErrInvalidJPEG
+		}
+
+		if marker >= 0xc0 && marker <= 0xcf && marker != markerDHT && marker != markerJPG && marker != markerDAC {
+			// Lf(2) P(1) Y(2) X(2)
+			if size < 7 {
+				return 0, 0, ErrInvalidJPEG
+			}
+
+			sof := image[i+4:]
+
+			return int(sof[3])<<8 | int(sof[4]), int(sof[1])<<8 | int(sof[2]), nil
+		}
+
+		i += 2 + size
+	}
+
+	return 0, 0, ErrInvalidJPEG
+}
+
+// Type implements av.VideoCodecData.
 func (d CodecData) Type() av.CodecType {
 	return av.MJPEG
 }
+
+// Width implements av.VideoCodecData.
+func (d CodecData) Width() int {
+	return d.PicWidth
+}
+
+// Height implements av.VideoCodecData.
+func (d CodecData) Height() int {
+	return d.PicHeight
+}
+
+// TimeScale implements av.VideoCodecData.
+func (d CodecData) TimeScale() uint32 {
+	return 90000
+}
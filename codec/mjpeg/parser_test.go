@@ -0,0 +1,39 @@
+package mjpeg_test
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/jpeg"
+	"testing"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/codec/mjpeg"
+)
+
+func TestNewCodecDataFromJPEG(t *testing.T) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, 64, 48)), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	codec, err := mjpeg.NewCodecDataFromJPEG(buf.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var video av.VideoCodecData = codec
+	if video.Width() != 64 || video.Height() != 48 || video.Type() != av.MJPEG {
+		t.Fatalf("codec = %+v", codec)
+	}
+
+	// SOI, APP0 with fill bytes before the marker, then SOS without a SOF.
+	noSOF := []byte{0xff, 0xd8, 0xff, 0xff, 0xe0, 0x00, 0x04, 0, 0, 0xff, 0xda, 0x00, 0x02}
+	if _, err := mjpeg.NewCodecDataFromJPEG(noSOF); !errors.Is(err, mjpeg.ErrNoSOF) {
+		t.Fatalf("err = %v, want ErrNoSOF", err)
+	}
+
+	if _, err := mjpeg.NewCodecDataFromJPEG([]byte("GIF89a")); !errors.Is(err, mjpeg.ErrInvalidJPEG) {
+		t.Fatalf("err = %v, want ErrInvalidJPEG", err)
+	}
+}
@@ -2,15 +2,38 @@
 package codec
 
 import (
+	"encoding/binary"
+	"errors"
 	"time"
 
 	"github.com/vtpl1/avsdk/av"
 )
 
+var (
+	ErrInvalidOpusPacket = errors.New("codec: invalid Opus packet")
+	ErrInvalidOpusHead   = errors.New("codec: invalid OpusHead")
+)
+
+// opusLayouts are the channel layouts of the Vorbis channel order used by
+// channel mapping family 1 (RFC 7845 §5.1.1.2), indexed by channel count - 1.
+//
+//nolint:gochecknoglobals
+var opusLayouts = [8]av.ChannelLayout{
+	av.ChMono,
+	av.ChStereo,
+	av.ChSurround,
+	av.ChStereo | av.ChBackLeft | av.ChBackRight,
+	av.ChSurround | av.ChBackLeft | av.ChBackRight,
+	av.ChSurround | av.ChBackLeft | av.ChBackRight | av.ChLowFreq,
+	av.ChSurround | av.ChSideLeft | av.ChSideRight | av.ChBackCenter | av.ChLowFreq,
+	av.ChSurround | av.ChSideLeft | av.ChSideRight | av.ChBackLeft | av.ChBackRight | av.ChLowFreq,
+}
+
 type OpusCodecData struct {
 	typ      av.CodecType
 	SampleRt int
 	ChLayout av.ChannelLayout
+	PreSkip  uint16 // samples at 48 kHz to discard from the start of the decoder output
 }
 
 func NewOpusCodecData(sr int, cc av.ChannelLayout) av.AudioCodecData {
@@ -21,16 +44,85 @@ func NewOpusCodecData(sr int, cc av.ChannelLayout) av.AudioCodecData {
 	}
 }
 
+// ParseOpusHead parses an Opus identification header (RFC 7845 §5.1). The
+// sample rate is always 48 kHz, the rate Opus decodes at; the input sample
+// rate of the header is informational only.
+func ParseOpusHead(b []byte) (OpusCodecData, error) {
+	if len(b) < 19 || string(b[:8]) != "OpusHead" || b[8]>>4 != 0 {
+		return OpusCodecData{}, ErrInvalidOpusHead
+	}
+
+	channels := int(b[9])
+	if channels == 0 || channels > len(opusLayouts) || (b[18] == 0 && channels > 2) {
+		return OpusCodecData{}, ErrInvalidOpusHead
+	}
+
+	return OpusCodecData{
+		typ:      av.OPUS,
+		SampleRt: 48000,
+		ChLayout: opusLayouts[channels-1],
+		PreSkip:  binary.LittleEndian.Uint16(b[10:]),
+	}, nil
+}
+
 // ChannelLayout implements av.AudioCodecData.
 func (s OpusCodecData) ChannelLayout() av.ChannelLayout {
-	var a av.ChannelLayout
+	return s.ChLayout
+}
 
-	return a
+// PacketDuration implements av.AudioCodecData. The duration is derived from
+// the TOC byte (RFC 6716 §3.1); an empty packet is assumed to last 20 ms.
+func (s OpusCodecData) PacketDuration(pkt []byte) (time.Duration, error) {
+	if len(pkt) == 0 {
+		return 20 * time.Millisecond, nil
+	}
+
+	config := pkt[0] >> 3
+
+	var frame time.Duration
+
+	switch {
+	case config < 12: // SILK: 10, 20, 40, 60 ms
+		frame = [4]time.Duration{10, 20, 40, 60}[config&3] * time.Millisecond
+	case config < 16: // Hybrid: 10, 20 ms
+		frame = [2]time.Duration{10, 20}[config&1] * time.Millisecond
+	default: // CELT: 2.5, 5, 10, 20 ms
+		frame = [4]time.Duration{2500, 5000, 10000, 20000}[config&3] * time.Microsecond
+	}
+
+	frames := 1
+
+	switch pkt[0] & 3 {
+	case 1, 2:
+		frames = 2
+	case 3:
+		if len(pkt) < 2 {
+			return 0, ErrInvalidOpusPacket
+		}
+
+		frames = int(pkt[1] & 0x3f)
+	}
+
+	return time.Duration(frames) * frame, nil
 }
 
-// PacketDuration implements av.AudioCodecData.
-func (s OpusCodecData) PacketDuration(_ []byte) (time.Duration, error) {
-	return time.Duration(20) * time.Millisecond, nil
+// OpusHead returns the Opus identification header (RFC 7845 §5.1) used by
+// Ogg and as Matroska CodecPrivate, with channel mapping family 0. An unknown
+// sample rate is written as 48 kHz.
+func (s OpusCodecData) OpusHead() []byte {
+	inputRate := s.SampleRt
+	if inputRate == 0 {
+		inputRate = 48000
+	}
+
+	b := make([]byte, 19)
+	copy(b, "OpusHead")
+	b[8] = 1 // version
+	b[9] = byte(max(s.ChLayout.Count(), 1))
+	binary.LittleEndian.PutUint16(b[10:], s.PreSkip)
+	binary.LittleEndian.PutUint32(b[12:], uint32(inputRate))
+
+	return b
 }
 
 // SampleFormat implements av.AudioCodecData.
@@ -40,9 +132,7 @@ func (s OpusCodecData) SampleFormat() av.SampleFormat {
 
 // SampleRate implements av.AudioCodecData.
 func (s OpusCodecData) SampleRate() int {
-	var a int
-
-	return a
+	return s.SampleRt
 }
 
 func (s OpusCodecData) Type() av.CodecType {
@@ -0,0 +1,36 @@
+package codec_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/codec"
+)
+
+func opusHead(channels, family byte) []byte {
+	b := append([]byte("OpusHead"), 1, channels, 0x38, 0x01, 0x80, 0xbb, 0, 0, 0, 0, family)
+	if family != 0 {
+		b = append(b, 1, 1, 0, 1, 2, 3, 4, 5) // stream counts and channel mapping
+	}
+
+	return b
+}
+
+func TestParseOpusHead(t *testing.T) {
+	head, err := codec.ParseOpusHead(opusHead(6, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := av.ChSurround | av.ChBackLeft | av.ChBackRight | av.ChLowFreq
+	if head.ChLayout != want || head.SampleRt != 48000 || head.PreSkip != 312 {
+		t.Fatalf("head = %+v", head)
+	}
+
+	for _, b := range [][]byte{opusHead(0, 0), opusHead(6, 0), opusHead(9, 1), opusHead(2, 0)[:18]} {
+		if _, err := codec.ParseOpusHead(b); !errors.Is(err, codec.ErrInvalidOpusHead) {
+			t.Fatalf("ParseOpusHead(%x) err = %v, want ErrInvalidOpusHead", b, err)
+		}
+	}
+}
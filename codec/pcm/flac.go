@@ -2,6 +2,9 @@ package pcm
 
 import (
 	"encoding/binary"
+	"errors"
+	"math/bits"
+	"time"
 	"unicode/utf8"
 
 	"github.com/sigurn/crc16"
@@ -144,3 +147,103 @@ func FLACEncoder(codecName av.CodecType, clockRate uint32) func([]byte) []byte {
 		return dst
 	}
 }
+
+// FLACCodecData describes a FLAC stream. Packets are complete FLAC frames.
+type FLACCodecData struct {
+	Typ        av.CodecType
+	SmplFormat av.SampleFormat
+	SmplRate   int
+	ChLayout   av.ChannelLayout
+}
+
+// ChannelLayout implements av.AudioCodecData.
+func (m FLACCodecData) ChannelLayout() av.ChannelLayout {
+	return m.ChLayout
+}
+
+// PacketDuration implements av.AudioCodecData. The block size is read from the
+// FLAC frame header.
+func (m FLACCodecData) PacketDuration(pkt []byte) (time.Duration, error) {
+	if m.SampleRate() <= 0 {
+		return 0, ErrInvalidFLACSampleRate
+	}
+
+	samples, err := FLACFrameBlockSize(pkt)
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(samples) * time.Second / time.Duration(m.SampleRate()), nil
+}
+
+// SampleFormat implements av.AudioCodecData.
+func (m FLACCodecData) SampleFormat() av.SampleFormat {
+	return m.SmplFormat
+}
+
+// SampleRate implements av.AudioCodecData.
+func (m FLACCodecData) SampleRate() int {
+	return m.SmplRate
+}
+
+// Type implements av.AudioCodecData.
+func (m FLACCodecData) Type() av.CodecType {
+	return m.Typ
+}
+
+// NewFLACCodecData returns mono 16-bit FLAC codec data, matching FLACEncoder.
+func NewFLACCodecData(sampleRate int) av.AudioCodecData {
+	return FLACCodecData{
+		Typ:        av.FLAC,
+		SmplFormat: av.S16,
+		SmplRate:   sampleRate,
+		ChLayout:   av.ChMono,
+	}
+}
+
+var (
+	ErrInvalidFLACFrame      = errors.New("pcm: invalid FLAC frame header")
+	ErrInvalidFLACSampleRate = errors.New("pcm: invalid FLAC sample rate")
+)
+
+// FLACFrameBlockSize returns the number of samples in a FLAC frame.
+// https://xiph.org/flac/format.html#frame_header
+func FLACFrameBlockSize(frame []byte) (int, error) {
+	if len(frame) < 5 || frame[0] != 0xFF || frame[1]&0xFE != 0xF8 {
+		return 0, ErrInvalidFLACFrame
+	}
+
+	code := frame[2] >> 4
+
+	switch {
+	case code == 1:
+		return 192, nil
+	case code >= 2 && code <= 5:
+		return 576 << (code - 2), nil
+	case code >= 8:
+		return 256 << (code - 8), nil
+	case code == 0:
+		return 0, ErrInvalidFLACFrame
+	}
+
+	// Block size follows the UTF-8 coded frame or sample number, whose lead
+	// byte is 0xxxxxxx or has 2 to 7 leading ones.
+	n := 5
+	if frame[4]&0x80 != 0 {
+		ones := bits.LeadingZeros8(^frame[4])
+		if ones < 2 || ones > 7 {
+			return 0, ErrInvalidFLACFrame
+		}
+
+		n = 4 + ones
+	}
+
+	switch {
+	case code == 6 && len(frame) > n:
+		return int(frame[n]) + 1, nil
+	case code == 7 && len(frame) > n+1:
+		return int(binary.BigEndian.Uint16(frame[n:])) + 1, nil
+	default:
+		return 0, ErrInvalidFLACFrame
+	}
+}
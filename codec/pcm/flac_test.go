@@ -0,0 +1,35 @@
+package pcm_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/vtpl1/avsdk/codec/pcm"
+)
+
+func TestFLACFrameBlockSize(t *testing.T) {
+	// Block size code 6: an 8-bit block size - 1 follows a two-byte frame number.
+	if n, err := pcm.FLACFrameBlockSize([]byte{0xff, 0xf8, 0x69, 0x08, 0xc2, 0x80, 0xff}); err != nil || n != 256 {
+		t.Fatalf("block size = %d, %v, want 256", n, err)
+	}
+
+	// A continuation byte, or 0xff, cannot start a frame number.
+	for _, lead := range []byte{0x80, 0xbf, 0xff} {
+		if _, err := pcm.FLACFrameBlockSize([]byte{0xff, 0xf8, 0x69, 0x08, lead, 0x80, 0xff}); !errors.Is(err, pcm.ErrInvalidFLACFrame) {
+			t.Fatalf("lead byte %#x: err = %v, want ErrInvalidFLACFrame", lead, err)
+		}
+	}
+}
+
+func TestFLACPacketDuration(t *testing.T) {
+	frame := []byte{0xff, 0xf8, 0x69, 0x08, 0x00, 0xff}
+
+	if d, err := pcm.NewFLACCodecData(8000).PacketDuration(frame); err != nil || d != 32*time.Millisecond {
+		t.Fatalf("duration = %v, %v, want 32ms", d, err)
+	}
+
+	if _, err := (pcm.FLACCodecData{}).PacketDuration(frame); !errors.Is(err, pcm.ErrInvalidFLACSampleRate) {
+		t.Fatalf("err = %v, want ErrInvalidFLACSampleRate", err)
+	}
+}
@@ -0,0 +1,41 @@
+package codec
+
+import (
+	"github.com/vtpl1/avsdk/av"
+)
+
+// VideoCodecData describes a video stream whose decoder needs no out-of-band
+// configuration, such as VP8, VP9 or AV1.
+type VideoCodecData struct {
+	typ       av.CodecType
+	PicWidth  int
+	PicHeight int
+}
+
+func NewVideoCodecData(typ av.CodecType, width, height int) av.VideoCodecData {
+	return VideoCodecData{
+		typ:       typ,
+		PicWidth:  width,
+		PicHeight: height,
+	}
+}
+
+// Type implements av.VideoCodecData.
+func (s VideoCodecData) Type() av.CodecType {
+	return s.typ
+}
+
+// Width implements av.VideoCodecData.
+func (s VideoCodecData) Width() int {
+	return s.PicWidth
+}
+
+// Height implements av.VideoCodecData.
+func (s VideoCodecData) Height() int {
+	return s.PicHeight
+}
+
+// TimeScale implements av.VideoCodecData.
+func (s VideoCodecData) TimeScale() uint32 {
+	return 90000
+}
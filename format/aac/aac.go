@@ -0,0 +1,47 @@
+// Package aac implements a demuxer and muxer for raw AAC in ADTS framing (.aac).
+package aac
+
+import (
+	"io"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/av/avutil"
+	"github.com/vtpl1/avsdk/codec/aacparser"
+)
+
+// Probe reports whether b starts with an ADTS frame. When b is long enough the
+// sync word of the following frame is checked as well.
+func Probe(b []byte) bool {
+	if len(b) < aacparser.ADTSHeaderLength {
+		return false
+	}
+
+	_, _, frameLen, _, err := aacparser.ParseADTSHeader(b)
+	if err != nil {
+		return false
+	}
+
+	if frameLen+2 <= len(b) {
+		return isSyncWord(b[frameLen:])
+	}
+
+	return true
+}
+
+// Handler registers the ".aac" extension with avutil.
+func Handler(h *avutil.RegisterHandler) {
+	h.Ext = ".aac"
+	h.Probe = Probe
+	h.ReaderDemuxer = func(r io.Reader) av.Demuxer {
+		return NewDemuxer(r)
+	}
+	h.WriterMuxer = func(w io.Writer) av.Muxer {
+		return NewMuxer(w)
+	}
+	h.CodecTypes = []av.CodecType{av.AAC}
+}
+
+// isSyncWord reports whether b starts with the 12-bit ADTS sync word and layer 0.
+func isSyncWord(b []byte) bool {
+	return b[0] == 0xff && b[1]&0xf6 == 0xf0
+}
@@ -0,0 +1,155 @@
+package aac
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/codec/aacparser"
+	"github.com/vtpl1/avsdk/internal/ticks"
+	"github.com/vtpl1/avsdk/utils/bits/pio"
+)
+
+// maxResyncBytes bounds how far the demuxer scans for the next sync word.
+const maxResyncBytes = 64 * 1024
+
+// Demuxer splits an ADTS byte stream into one packet per frame. Timestamps
+// are derived from the number of samples per frame. Stream.Idx is always 0.
+type Demuxer struct {
+	r         *bufio.Reader
+	codec     aacparser.CodecData
+	hasCodec  bool
+	pending   *av.Packet
+	base      time.Duration
+	samples   int64
+	announced bool
+	frameID   int64
+}
+
+// NewDemuxer returns a Demuxer reading from r.
+func NewDemuxer(r io.Reader) *Demuxer {
+	return &Demuxer{
+		r: bufio.NewReaderSize(r, pio.RecommendBufioSize),
+	}
+}
+
+// GetCodecs implements av.Demuxer. The codec data is taken from the first ADTS header.
+func (m *Demuxer) GetCodecs(ctx context.Context) ([]av.Stream, error) {
+	if !m.hasCodec {
+		pkt, err := m.readFrame(ctx)
+		if errors.Is(err, io.EOF) {
+			return nil, ErrNoStreams
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		m.pending = &pkt
+	}
+
+	m.announced = true
+
+	return []av.Stream{{Idx: 0, Codec: m.codec}}, nil
+}
+
+// ReadPacket implements av.Demuxer.
+func (m *Demuxer) ReadPacket(ctx context.Context) (av.Packet, error) {
+	if m.pending != nil {
+		pkt := *m.pending
+		m.pending = nil
+
+		return pkt, nil
+	}
+
+	return m.readFrame(ctx)
+}
+
+func (m *Demuxer) readFrame(ctx context.Context) (av.Packet, error) {
+	if err := ctx.Err(); err != nil {
+		return av.Packet{}, err
+	}
+
+	var (
+		hdr      []byte
+		config   aacparser.MPEG4AudioConfig
+		hdrLen   int
+		frameLen int
+		samples  int
+		err      error
+	)
+
+	for skipped := 0; ; skipped++ {
+		if skipped > maxResyncBytes {
+			return av.Packet{}, ErrSyncWordNotFound
+		}
+
+		if hdr, err = m.r.Peek(aacparser.ADTSHeaderLength); err != nil {
+			return av.Packet{}, eof(err)
+		}
+
+		config, hdrLen, frameLen, samples, err = aacparser.ParseADTSHeader(hdr)
+		if err == nil {
+			break
+		}
+
+		if _, err = m.r.Discard(1); err != nil {
+			return av.Packet{}, eof(err)
+		}
+	}
+
+	frame := make([]byte, frameLen)
+	if _, err = io.ReadFull(m.r, frame); err != nil {
+		return av.Packet{}, eof(err)
+	}
+
+	var newCodecs []av.Stream
+
+	if !m.hasCodec || m.codec.Config != config {
+		codec, err := aacparser.NewCodecDataFromMPEG4AudioConfig(config)
+		if err != nil {
+			return av.Packet{}, err
+		}
+
+		if m.hasCodec {
+			// Restart sample counting at the new rate so DTS stays exact.
+			m.base += ticks.ToDuration(m.samples, int64(m.codec.Config.SampleRate))
+			m.samples = 0
+		}
+
+		if m.announced {
+			newCodecs = []av.Stream{{Idx: 0, Codec: codec}}
+		}
+
+		m.codec = codec
+		m.hasCodec = true
+	}
+
+	rate := int64(config.SampleRate)
+	pkt := av.Packet{
+		KeyFrame:  true,
+		Idx:       0,
+		DTS:       m.base + ticks.ToDuration(m.samples, rate),
+		Duration:  ticks.ToDuration(int64(samples), rate),
+		Data:      frame[hdrLen:],
+		FrameID:   m.frameID,
+		CodecType: av.AAC,
+		NewCodecs: newCodecs,
+	}
+
+	m.samples += int64(samples)
+	m.frameID++
+
+	return pkt, nil
+}
+
+func eof(err error) error {
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, bufio.ErrBufferFull) {
+		return io.EOF
+	}
+
+	return err
+}
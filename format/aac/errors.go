@@ -0,0 +1,13 @@
+package aac
+
+import "errors"
+
+var (
+	ErrNoStreams             = errors.New("aac: no ADTS frames found")
+	ErrSyncWordNotFound      = errors.New("aac: ADTS sync word not found")
+	ErrHeaderNotWritten      = errors.New("aac: WriteHeader not called")
+	ErrHeaderAlreadyWritten  = errors.New("aac: WriteHeader already called")
+	ErrTrailerAlreadyWritten = errors.New("aac: WriteTrailer already called")
+	ErrUnsupportedCodec      = errors.New("aac: unsupported codec")
+	ErrTooManyStreams        = errors.New("aac: only one AAC stream is supported")
+)
@@ -0,0 +1,115 @@
+package aac
+
+import (
+	"context"
+	"io"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/codec/aacparser"
+)
+
+// Muxer writes the AAC stream as ADTS frames. Non-audio streams are ignored.
+// It implements av.Muxer and av.CodecChanger.
+type Muxer struct {
+	w      io.Writer
+	idx    uint16
+	codec  aacparser.CodecData
+	hasAAC bool
+	buf    []byte
+	stage  int
+}
+
+// NewMuxer returns a Muxer writing to w.
+func NewMuxer(w io.Writer) *Muxer {
+	return &Muxer{w: w}
+}
+
+// WriteHeader implements av.Muxer. Exactly one AAC stream is required.
+func (m *Muxer) WriteHeader(_ context.Context, streams []av.Stream) error {
+	if m.stage != 0 {
+		return ErrHeaderAlreadyWritten
+	}
+
+	for _, stream := range streams {
+		if !stream.Codec.Type().IsAudio() {
+			continue
+		}
+
+		if m.hasAAC {
+			return ErrTooManyStreams
+		}
+
+		codec, ok := stream.Codec.(aacparser.CodecData)
+		if !ok {
+			return ErrUnsupportedCodec
+		}
+
+		m.idx = stream.Idx
+		m.codec = codec
+		m.hasAAC = true
+	}
+
+	if !m.hasAAC {
+		return ErrNoStreams
+	}
+
+	m.stage++
+
+	return nil
+}
+
+// WritePacket implements av.Muxer. Each raw AAC packet is prefixed with an
+// ADTS header built from the stream's codec data.
+func (m *Muxer) WritePacket(_ context.Context, pkt av.Packet) error {
+	if m.stage != 1 {
+		return ErrHeaderNotWritten
+	}
+
+	if pkt.Idx != m.idx {
+		return nil
+	}
+
+	m.buf = append(m.buf[:0], make([]byte, aacparser.ADTSHeaderLength)...)
+	aacparser.FillADTSHeader(m.buf, m.codec.Config, 1024, len(pkt.Data))
+	m.buf = append(m.buf, pkt.Data...)
+
+	_, err := m.w.Write(m.buf)
+
+	return err
+}
+
+// WriteTrailer implements av.Muxer.
+func (m *Muxer) WriteTrailer(_ context.Context) error {
+	switch m.stage {
+	case 0:
+		return ErrHeaderNotWritten
+	case 2:
+		return ErrTrailerAlreadyWritten
+	}
+
+	m.stage++
+
+	return nil
+}
+
+// WriteCodecChange implements av.CodecChanger. Subsequent ADTS headers use the new configuration.
+func (m *Muxer) WriteCodecChange(_ context.Context, changed []av.Stream) error {
+	if m.stage != 1 {
+		return ErrHeaderNotWritten
+	}
+
+	for _, stream := range changed {
+		if stream.Idx != m.idx {
+			continue
+		}
+
+		codec, ok := stream.Codec.(aacparser.CodecData)
+		if !ok {
+			return ErrUnsupportedCodec
+		}
+
+		m.codec = codec
+	}
+
+	return nil
+}
@@ -0,0 +1,200 @@
+package aac_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/codec/aacparser"
+	"github.com/vtpl1/avsdk/format/aac"
+	"github.com/vtpl1/avsdk/internal/avtest"
+)
+
+func testAAC(t *testing.T, config []byte) aacparser.CodecData {
+	t.Helper()
+
+	codec, err := aacparser.NewCodecDataFromMPEG4AudioConfigBytes(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return codec
+}
+
+func TestRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	lc44100 := avtest.AAC(t)
+	lc48000 := testAAC(t, []byte{0x11, 0x90})
+
+	var buf bytes.Buffer
+
+	// Leading garbage (e.g. an ID3 tag) must be skipped by the demuxer.
+	buf.WriteString("ID3junk")
+
+	video := avtest.H264(t, avtest.SPS320x192)
+
+	mux := aac.NewMuxer(&buf)
+	if err := mux.WriteHeader(ctx, []av.Stream{{Idx: 0, Codec: video}, {Idx: 2, Codec: lc44100}}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range 4 {
+		if i == 2 {
+			if err := mux.WriteCodecChange(ctx, []av.Stream{{Idx: 2, Codec: lc48000}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := mux.WritePacket(ctx, av.Packet{Idx: 2, Data: []byte{0x21, byte(i)}}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := mux.WritePacket(ctx, av.Packet{Idx: 0, Data: []byte{0xff}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := mux.WriteTrailer(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if !aac.Probe(buf.Bytes()[len("ID3junk"):]) {
+		t.Fatal("Probe rejected muxer output")
+	}
+
+	dmx := aac.NewDemuxer(bytes.NewReader(buf.Bytes()))
+
+	streams, err := dmx.GetCodecs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(streams) != 1 || streams[0].Codec.(aacparser.CodecData).SampleRate() != 44100 { //nolint:forcetypeassert
+		t.Fatalf("streams = %+v", streams)
+	}
+
+	var pkts []av.Packet
+
+	for {
+		pkt, err := dmx.ReadPacket(ctx)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pkts = append(pkts, pkt)
+	}
+
+	if len(pkts) != 4 {
+		t.Fatalf("got %d packets, want 4", len(pkts))
+	}
+
+	frame44 := 1024 * time.Second / 44100
+	frame48 := 1024 * time.Second / 48000
+	wantDTS := []time.Duration{0, frame44, 2 * frame44, 2*frame44 + frame48}
+
+	for i, pkt := range pkts {
+		if !bytes.Equal(pkt.Data, []byte{0x21, byte(i)}) || !pkt.KeyFrame {
+			t.Fatalf("packet %d: %v", i, pkt.String())
+		}
+
+		// Timestamps are sample accurate, so allow for sub-microsecond rounding.
+		if d := pkt.DTS - wantDTS[i]; d < -time.Microsecond || d > time.Microsecond {
+			t.Fatalf("packet %d: dts = %v, want %v", i, pkt.DTS, wantDTS[i])
+		}
+
+		if (pkt.NewCodecs != nil) != (i == 2) {
+			t.Fatalf("packet %d: NewCodecs = %+v", i, pkt.NewCodecs)
+		}
+	}
+
+	if pkts[3].Duration != frame48 {
+		t.Fatalf("duration = %v, want %v", pkts[3].Duration, frame48)
+	}
+}
+
+func TestProbe(t *testing.T) {
+	if aac.Probe([]byte("FLV\x01\x05\x00\x00\x00\x09")) {
+		t.Fatal("Probe accepted FLV header")
+	}
+
+	frame := make([]byte, 9)
+	aacparser.FillADTSHeader(frame, aacparser.MPEG4AudioConfig{ObjectType: 2, SampleRateIndex: 4, ChannelConfig: 2}, 1024, 2)
+
+	if !aac.Probe(append(frame, frame...)) {
+		t.Fatal("Probe rejected two ADTS frames")
+	}
+
+	if aac.Probe(append(frame, 0, 0)) {
+		t.Fatal("Probe accepted a frame not followed by a sync word")
+	}
+
+	reserved := make([]byte, 9)
+	aacparser.FillADTSHeader(reserved, aacparser.MPEG4AudioConfig{ObjectType: 2, SampleRateIndex: 13, ChannelConfig: 2}, 1024, 2)
+
+	if aac.Probe(append(reserved, reserved...)) {
+		t.Fatal("Probe accepted a reserved sampling frequency index")
+	}
+}
+
+func TestDemuxerReservedSampleRate(t *testing.T) {
+	ctx := context.Background()
+
+	reserved := make([]byte, 9)
+	aacparser.FillADTSHeader(reserved, aacparser.MPEG4AudioConfig{ObjectType: 2, SampleRateIndex: 13, ChannelConfig: 2}, 1024, 2)
+
+	frame := make([]byte, 9)
+	aacparser.FillADTSHeader(frame, aacparser.MPEG4AudioConfig{ObjectType: 2, SampleRateIndex: 4, ChannelConfig: 2}, 1024, 2)
+
+	var data []byte
+	for range 2 {
+		data = append(append(data, reserved...), frame...)
+	}
+
+	dmx := aac.NewDemuxer(bytes.NewReader(data))
+
+	streams, err := dmx.GetCodecs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(streams) != 1 || streams[0].Codec.(aacparser.CodecData).SampleRate() != 44100 { //nolint:forcetypeassert
+		t.Fatalf("streams = %+v", streams)
+	}
+
+	for i := range 2 {
+		if _, err := dmx.ReadPacket(ctx); err != nil {
+			t.Fatalf("packet %d: %v", i, err)
+		}
+	}
+
+	if _, err := dmx.ReadPacket(ctx); !errors.Is(err, io.EOF) {
+		t.Fatalf("err = %v, want io.EOF", err)
+	}
+}
+
+func TestDemuxerSyncWordNotFound(t *testing.T) {
+	ctx := context.Background()
+
+	frame := make([]byte, 9)
+	aacparser.FillADTSHeader(frame, aacparser.MPEG4AudioConfig{ObjectType: 2, SampleRateIndex: 4, ChannelConfig: 2}, 1024, 2)
+
+	data := append(append([]byte{}, frame...), make([]byte, 128*1024)...)
+	data = append(data, frame...)
+
+	dmx := aac.NewDemuxer(bytes.NewReader(data))
+	if _, err := dmx.ReadPacket(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := dmx.ReadPacket(ctx); !errors.Is(err, aac.ErrSyncWordNotFound) {
+		t.Fatalf("err = %v, want ErrSyncWordNotFound", err)
+	}
+}
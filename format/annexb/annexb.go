@@ -0,0 +1,136 @@
+// Package annexb implements a demuxer and muxer for raw H.264 and H.265
+// elementary streams in Annex-B byte stream format (.h264, .264, .h265, .hevc).
+package annexb
+
+import (
+	"io"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/av/avutil"
+	"github.com/vtpl1/avsdk/codec/parser"
+)
+
+// DefaultFrameRate is used for synthetic timestamps when the SPS carries no
+// VUI timing information.
+const DefaultFrameRate = 25
+
+// Extensions lists the file extensions registered by Handlers.
+//
+//nolint:gochecknoglobals
+var Extensions = []string{".h264", ".264", ".h265", ".hevc"}
+
+// Probe reports whether b starts with an Annex-B start code followed by an
+// H.264 or H.265 parameter set, SEI or access unit delimiter.
+func Probe(b []byte) bool {
+	start, end := parser.FindNextAnnexBNALUnit(b, 0)
+	if start < 0 || end-start < 2 {
+		return false
+	}
+
+	// Only leading zero bytes may precede the first start code.
+	for _, v := range b[:start-3] {
+		if v != 0 {
+			return false
+		}
+	}
+
+	return detectCodec(b[start:end]) != av.UNKNOWN
+}
+
+// Handlers returns one avutil handler per entry of Extensions. Register them
+// with avutil.Handlers.Add.
+func Handlers() []func(*avutil.RegisterHandler) {
+	handlers := make([]func(*avutil.RegisterHandler), 0, len(Extensions))
+
+	for _, ext := range Extensions {
+		handlers = append(handlers, func(h *avutil.RegisterHandler) {
+			h.Ext = ext
+			h.Probe = Probe
+			h.ReaderDemuxer = func(r io.Reader) av.Demuxer {
+				return NewDemuxer(r)
+			}
+			h.WriterMuxer = func(w io.Writer) av.Muxer {
+				return NewMuxer(w)
+			}
+			h.CodecTypes = []av.CodecType{av.H264, av.H265}
+		})
+	}
+
+	return handlers
+}
+
+// detectCodec identifies the codec from a NAL unit that can only start a
+// stream of that codec: parameter sets, SEI or access unit delimiters.
+func detectCodec(nalu []byte) av.CodecType {
+	if len(nalu) < 2 || nalu[0]&0x80 != 0 {
+		return av.UNKNOWN
+	}
+
+	// H.265 headers are two bytes with nuh_layer_id 0 and nuh_temporal_id_plus1 1.
+	if nalu[0]&0x01 == 0 && nalu[1] == 0x01 {
+		switch av.H265NaluType(nalu[0] >> 1) {
+		case av.HEVC_NAL_VPS, av.HEVC_NAL_SPS, av.HEVC_NAL_PPS, av.HEVC_NAL_AUD, av.HEVC_NAL_SEI_PREFIX:
+			return av.H265
+		}
+	}
+
+	switch av.H264NaluType(nalu[0]) & av.H264NALTypeMask {
+	case av.H264_NAL_SPS, av.H264_NAL_PPS, av.H264_NAL_AUD, av.H264_NAL_SEI:
+		return av.H264
+	}
+
+	return av.UNKNOWN
+}
+
+func isVCL(codec av.CodecType, nalu []byte) bool {
+	if codec == av.H265 {
+		return av.H265NaluType(nalu[0]>>1)&av.H265NALTypeMask <= av.HEVC_NAL_RSV_VCL31
+	}
+
+	typ := av.H264NaluType(nalu[0]) & av.H264NALTypeMask
+
+	return typ >= av.H264_NAL_SLICE && typ <= av.H264_NAL_IDR_SLICE
+}
+
+// startsPicture reports whether a VCL NAL unit is the first slice of a picture:
+// first_mb_in_slice == 0 for H.264, first_slice_segment_in_pic_flag for H.265.
+func startsPicture(codec av.CodecType, nalu []byte) bool {
+	if codec == av.H265 {
+		return len(nalu) > 2 && nalu[2]&0x80 != 0
+	}
+
+	// first_mb_in_slice is ue(v); the value 0 is coded as a single 1 bit.
+	return len(nalu) > 1 && nalu[1]&0x80 != 0
+}
+
+// startsAccessUnit reports whether a non-VCL NAL unit may only appear before
+// the first VCL NAL unit of an access unit (H.264 §7.4.1.2.3, H.265 §7.4.2.4.4).
+func startsAccessUnit(codec av.CodecType, nalu []byte) bool {
+	if codec == av.H265 {
+		switch typ := av.H265NaluType(nalu[0]>>1) & av.H265NALTypeMask; {
+		case typ >= av.HEVC_NAL_VPS && typ <= av.HEVC_NAL_AUD, typ == av.HEVC_NAL_SEI_PREFIX:
+			return true
+		case typ >= av.HEVC_NAL_RSV_NVCL41 && typ <= av.HEVC_NAL_RSV_NVCL44:
+			return true
+		}
+
+		return false
+	}
+
+	switch typ := av.H264NaluType(nalu[0]) & av.H264NALTypeMask; {
+	case typ >= av.H264_NAL_SEI && typ <= av.H264_NAL_AUD:
+		return true
+	case typ >= av.H264_NAL_PREFIX && typ <= av.H264_NAL_RESERVED18:
+		return true
+	}
+
+	return false
+}
+
+func isAUD(codec av.CodecType, nalu []byte) bool {
+	if codec == av.H265 {
+		return av.H265NaluType(nalu[0]>>1)&av.H265NALTypeMask == av.HEVC_NAL_AUD
+	}
+
+	return av.H264NaluType(nalu[0])&av.H264NALTypeMask == av.H264_NAL_AUD
+}
@@ -0,0 +1,290 @@
+package annexb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/codec/h264parser"
+	"github.com/vtpl1/avsdk/codec/h265parser"
+	"github.com/vtpl1/avsdk/codec/parser"
+	"github.com/vtpl1/avsdk/utils/bits/pio"
+)
+
+// maxProbeBytes bounds how much input GetCodecs reads looking for parameter sets.
+const maxProbeBytes = 4 << 20
+
+// Demuxer reads a raw H.264 or H.265 Annex-B stream; the codec is detected
+// from the first parameter set. Packets carry one access unit in AVCC framing
+// (4-byte lengths, access unit delimiters removed) with DTS synthesised from
+// the SPS frame rate. Stream.Idx is always 0.
+type Demuxer struct {
+	r        io.Reader
+	buf      []byte
+	typ      av.CodecType
+	codec    av.CodecData
+	frameDur time.Duration
+	vps      []byte
+	sps      []byte
+	pps      []byte
+
+	au       [][]byte
+	auHasVCL bool
+
+	pending   []av.Packet
+	announced bool
+	probed    bool
+	eof       bool // input exhausted
+	done      bool // input exhausted and every buffered NAL unit handled
+	read      int
+	frames    int64
+	dts       time.Duration
+}
+
+// NewDemuxer returns a Demuxer reading from r.
+func NewDemuxer(r io.Reader) *Demuxer {
+	return &Demuxer{
+		r:   r,
+		typ: av.UNKNOWN,
+	}
+}
+
+// GetCodecs implements av.Demuxer.
+func (m *Demuxer) GetCodecs(ctx context.Context) ([]av.Stream, error) {
+	if err := m.probe(ctx); err != nil {
+		return nil, err
+	}
+
+	if m.codec == nil {
+		return nil, ErrNoStreams
+	}
+
+	return []av.Stream{{Idx: 0, Codec: m.codec}}, nil
+}
+
+// ReadPacket implements av.Demuxer.
+func (m *Demuxer) ReadPacket(ctx context.Context) (av.Packet, error) {
+	if err := m.probe(ctx); err != nil {
+		return av.Packet{}, err
+	}
+
+	for len(m.pending) == 0 {
+		if err := ctx.Err(); err != nil {
+			return av.Packet{}, err
+		}
+
+		if m.done {
+			return av.Packet{}, io.EOF
+		}
+
+		if err := m.readNALU(); err != nil {
+			return av.Packet{}, err
+		}
+	}
+
+	pkt := m.pending[0]
+	m.pending = m.pending[1:]
+
+	return pkt, nil
+}
+
+func (m *Demuxer) probe(ctx context.Context) error {
+	if m.probed {
+		return nil
+	}
+
+	for m.codec == nil && !m.done && m.read < maxProbeBytes {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if err := m.readNALU(); err != nil {
+			return err
+		}
+	}
+
+	m.probed = true
+	m.announced = m.codec != nil
+
+	return nil
+}
+
+// readNALU extracts the next complete NAL unit from the input. A NAL unit is
+// complete once the following start code (or the end of input) has been seen.
+func (m *Demuxer) readNALU() error {
+	for {
+		start, end := parser.FindNextAnnexBNALUnit(m.buf, 0)
+		if start >= 0 && (end < len(m.buf) || m.eof) {
+			nalu := bytes.TrimRight(m.buf[start:end], "\x00") // trailing_zero_8bits
+			m.buf = m.buf[end:]
+			m.handleNALU(nalu)
+
+			return nil
+		}
+
+		if m.eof {
+			m.buf = nil
+			m.done = true
+			m.flushAU()
+
+			return nil
+		}
+
+		if err := m.fill(); err != nil {
+			return err
+		}
+	}
+}
+
+func (m *Demuxer) fill() error {
+	chunk := make([]byte, pio.RecommendBufioSize)
+
+	n, err := io.ReadFull(m.r, chunk)
+	m.buf = append(m.buf, chunk[:n]...)
+	m.read += n
+
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		m.eof = true
+
+		return nil
+	}
+
+	return err
+}
+
+func (m *Demuxer) handleNALU(nalu []byte) {
+	if len(nalu) == 0 {
+		return
+	}
+
+	if m.typ == av.UNKNOWN {
+		if m.typ = detectCodec(nalu); m.typ == av.UNKNOWN {
+			return // skip anything before the first recognisable NAL unit
+		}
+	}
+
+	vcl := isVCL(m.typ, nalu)
+	if m.auHasVCL && (vcl && startsPicture(m.typ, nalu) || !vcl && startsAccessUnit(m.typ, nalu)) {
+		m.flushAU()
+	}
+
+	if isAUD(m.typ, nalu) {
+		return
+	}
+
+	m.au = append(m.au, append([]byte(nil), nalu...))
+	m.auHasVCL = m.auHasVCL || vcl
+}
+
+// flushAU turns the collected NAL units into a packet.
+func (m *Demuxer) flushAU() {
+	au := m.au
+	hasVCL := m.auHasVCL
+	m.au = nil
+	m.auHasVCL = false
+
+	keyFrame := false
+	paramSet := false
+
+	for _, nalu := range au {
+		switch {
+		case m.typ == av.H265 && h265parser.IsVPSNALU(nalu):
+			m.vps, paramSet = nalu, true
+		case m.typ == av.H265 && h265parser.IsSPSNALU(nalu), m.typ == av.H264 && h264parser.IsSPSNALU(nalu):
+			m.sps, paramSet = nalu, true
+		case m.typ == av.H265 && h265parser.IsPPSNALU(nalu), m.typ == av.H264 && h264parser.IsPPSNALU(nalu):
+			m.pps, paramSet = nalu, true
+		case m.typ == av.H265 && h265parser.IsKeyFrame(nalu), m.typ == av.H264 && h264parser.IsKeyFrame(nalu):
+			keyFrame = true
+		}
+	}
+
+	if paramSet {
+		m.updateCodec()
+	}
+
+	if !hasVCL || m.codec == nil {
+		return // not decodable before the first parameter sets
+	}
+
+	var data []byte
+
+	for _, nalu := range au {
+		data = append(data, byte(len(nalu)>>24), byte(len(nalu)>>16), byte(len(nalu)>>8), byte(len(nalu)))
+		data = append(data, nalu...)
+	}
+
+	pkt := av.Packet{
+		KeyFrame:       keyFrame,
+		IsParamSetNALU: paramSet,
+		Idx:            0,
+		DTS:            m.dts,
+		Duration:       m.frameDur,
+		Data:           data,
+		FrameID:        m.frames,
+		CodecType:      m.typ,
+	}
+	m.frames++
+	m.dts += m.frameDur
+
+	if m.probed && !m.announced {
+		pkt.NewCodecs = []av.Stream{{Idx: 0, Codec: m.codec}}
+		m.announced = true
+	}
+
+	m.pending = append(m.pending, pkt)
+}
+
+// updateCodec rebuilds the codec data when the parameter sets differ from the current ones.
+func (m *Demuxer) updateCodec() {
+	if m.sps == nil || m.pps == nil {
+		return
+	}
+
+	var (
+		codec av.VideoCodecData
+		fps   int
+		err   error
+	)
+
+	switch m.typ {
+	case av.H264:
+		if c, ok := m.codec.(h264parser.CodecData); ok && bytes.Equal(c.SPS(), m.sps) && bytes.Equal(c.PPS(), m.pps) {
+			return
+		}
+
+		var c h264parser.CodecData
+		if c, err = h264parser.NewCodecDataFromSPSAndPPS(m.sps, m.pps); err == nil {
+			codec, fps = c, c.FPS()
+		}
+	case av.H265:
+		if m.vps == nil {
+			return
+		}
+
+		if c, ok := m.codec.(h265parser.CodecData); ok &&
+			bytes.Equal(c.VPS(), m.vps) && bytes.Equal(c.SPS(), m.sps) && bytes.Equal(c.PPS(), m.pps) {
+			return
+		}
+
+		var c h265parser.CodecData
+		if c, err = h265parser.NewCodecDataFromVPSAndSPSAndPPS(m.vps, m.sps, m.pps); err == nil {
+			codec, fps = c, c.FPS()
+		}
+	}
+
+	if err != nil || codec == nil {
+		return
+	}
+
+	if fps <= 0 {
+		fps = DefaultFrameRate
+	}
+
+	m.codec = codec
+	m.frameDur = time.Second / time.Duration(fps)
+	m.announced = false
+}
@@ -0,0 +1,173 @@
+package annexb_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/vtpl1/avsdk/codec/h264parser"
+	"github.com/vtpl1/avsdk/codec/h265parser"
+	"github.com/vtpl1/avsdk/format/annexb"
+	"github.com/vtpl1/avsdk/internal/avtest"
+)
+
+func annexB(nalus ...[]byte) []byte {
+	var b []byte
+
+	for _, nalu := range nalus {
+		b = append(b, 0, 0, 0, 1)
+		b = append(b, nalu...)
+	}
+
+	return b
+}
+
+// splitAVCC returns the NAL units of an AVCC packet.
+func splitAVCC(t *testing.T, b []byte) [][]byte {
+	t.Helper()
+
+	var nalus [][]byte
+
+	for len(b) > 0 {
+		if len(b) < 4 || int(binary.BigEndian.Uint32(b)) > len(b)-4 {
+			t.Fatalf("bad AVCC framing %x", b)
+		}
+
+		n := int(binary.BigEndian.Uint32(b))
+		nalus = append(nalus, b[4:4+n])
+		b = b[4+n:]
+	}
+
+	return nalus
+}
+
+func TestDemuxH264(t *testing.T) {
+	ctx := context.Background()
+
+	aud := []byte{0x09, 0xf0}
+	sei := []byte{0x06, 0x05, 0x01, 0x00, 0x80}
+	stream := annexB(
+		aud, avtest.Unhex(avtest.SPS320x192), avtest.Unhex(avtest.PPS), sei,
+		[]byte{0x65, 0x88, 0x01}, // IDR, first_mb_in_slice 0
+		[]byte{0x65, 0x44, 0x02}, // IDR, first_mb_in_slice 1: same picture
+		[]byte{0x41, 0x9a, 0x03}, // new picture without AUD
+		aud, []byte{0x41, 0x9a, 0x04},
+		avtest.Unhex(avtest.SPS1280x720), avtest.Unhex(avtest.PPS), []byte{0x65, 0x88, 0x05},
+		[]byte{0x41, 0x9a, 0x06},
+	)
+
+	if !annexb.Probe(stream) {
+		t.Fatal("Probe rejected H.264 stream")
+	}
+
+	// Short reads exercise NAL units split across buffer refills.
+	dmx := annexb.NewDemuxer(io.MultiReader(bytes.NewReader(stream[:7]), bytes.NewReader(stream[7:])))
+
+	streams, err := dmx.GetCodecs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	codec, ok := streams[0].Codec.(h264parser.CodecData)
+	if len(streams) != 1 || !ok || codec.Width() != 320 {
+		t.Fatalf("streams = %+v", streams)
+	}
+
+	pkts := avtest.ReadAll(t, dmx)
+	if len(pkts) != 5 {
+		t.Fatalf("got %d packets, want 5", len(pkts))
+	}
+
+	if nalus := splitAVCC(t, pkts[0].Data); len(nalus) != 5 || !bytes.Equal(nalus[4], []byte{0x65, 0x44, 0x02}) {
+		t.Fatalf("first access unit = %x", nalus)
+	}
+
+	frameDur := time.Second / time.Duration(codec.FPS())
+	for i, pkt := range pkts {
+		if i < 3 && (pkt.DTS != time.Duration(i)*frameDur || pkt.Duration != frameDur) {
+			t.Fatalf("packet %d: dts=%v duration=%v", i, pkt.DTS, pkt.Duration)
+		}
+
+		if pkt.KeyFrame != (i == 0 || i == 3) {
+			t.Fatalf("packet %d: keyframe=%v", i, pkt.KeyFrame)
+		}
+
+		if (pkt.NewCodecs != nil) != (i == 3) {
+			t.Fatalf("packet %d: NewCodecs = %+v", i, pkt.NewCodecs)
+		}
+
+		for _, nalu := range splitAVCC(t, pkt.Data) {
+			if nalu[0]&0x1f == 9 {
+				t.Fatalf("packet %d still contains an access unit delimiter", i)
+			}
+		}
+	}
+
+	if w := pkts[3].NewCodecs[0].Codec.(h264parser.CodecData).Width(); w != 1280 { //nolint:forcetypeassert
+		t.Fatalf("changed width = %d, want 1280", w)
+	}
+
+	// The new SPS declares 50 fps; timestamps continue from the last frame.
+	if pkts[3].DTS != 3*frameDur || pkts[3].Duration != time.Second/50 || pkts[4].DTS != 3*frameDur+time.Second/50 {
+		t.Fatalf("after change: %v, %v", pkts[3].String(), pkts[4].String())
+	}
+}
+
+func TestDemuxH265(t *testing.T) {
+	ctx := context.Background()
+
+	stream := annexB(
+		avtest.Unhex(avtest.H265VPS), avtest.Unhex(avtest.H265SPS), avtest.Unhex(avtest.H265PPS),
+		[]byte{0x26, 0x01, 0xaf, 0x01}, // IDR_W_RADL, first slice
+		[]byte{0x26, 0x01, 0x40, 0x02}, // IDR_W_RADL, dependent slice
+		[]byte{0x02, 0x01, 0xd0, 0x03}, // TRAIL_R, first slice
+	)
+
+	if !annexb.Probe(stream) {
+		t.Fatal("Probe rejected H.265 stream")
+	}
+
+	dmx := annexb.NewDemuxer(bytes.NewReader(stream))
+
+	streams, err := dmx.GetCodecs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := streams[0].Codec.(h265parser.CodecData); !ok {
+		t.Fatalf("streams = %+v", streams)
+	}
+
+	pkts := avtest.ReadAll(t, dmx)
+	if len(pkts) != 2 || !pkts[0].KeyFrame || pkts[1].KeyFrame {
+		t.Fatalf("packets = %+v", pkts)
+	}
+
+	if nalus := splitAVCC(t, pkts[0].Data); len(nalus) != 5 {
+		t.Fatalf("first access unit has %d NAL units, want 5", len(nalus))
+	}
+
+	if pkts[1].DTS != time.Second/annexb.DefaultFrameRate {
+		t.Fatalf("second packet dts = %v", pkts[1].DTS)
+	}
+}
+
+func TestProbe(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		b    []byte
+		want bool
+	}{
+		{"h264", annexB(avtest.Unhex(avtest.SPS320x192)), true},
+		{"h265 leading zeros", append([]byte{0, 0}, annexB(avtest.Unhex(avtest.H265VPS))...), true},
+		{"slice first", annexB([]byte{0x41, 0x9a}), false},
+		{"flv", []byte("FLV\x01\x05\x00\x00\x00\x09"), false},
+	} {
+		if got := annexb.Probe(tc.b); got != tc.want {
+			t.Errorf("%s: Probe = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,14 @@
+package annexb
+
+import "errors"
+
+var (
+	ErrNoStreams             = errors.New("annexb: no parameter sets found")
+	ErrNoVideoStream         = errors.New("annexb: no H.264 or H.265 stream")
+	ErrHeaderNotWritten      = errors.New("annexb: WriteHeader not called")
+	ErrHeaderAlreadyWritten  = errors.New("annexb: WriteHeader already called")
+	ErrTrailerAlreadyWritten = errors.New("annexb: WriteTrailer already called")
+	ErrUnsupportedCodec      = errors.New("annexb: unsupported codec")
+	ErrStreamNotFound        = errors.New("annexb: stream not found")
+	ErrTooManyStreams        = errors.New("annexb: only one video stream is supported")
+)
@@ -0,0 +1,186 @@
+package annexb
+
+import (
+	"context"
+	"io"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/codec/h264parser"
+	"github.com/vtpl1/avsdk/codec/h265parser"
+	"github.com/vtpl1/avsdk/codec/parser"
+)
+
+// Muxer writes the video stream as a raw Annex-B elementary stream. Parameter
+// sets from the codec data are written before every keyframe that does not
+// carry its own. Non-video streams are ignored; packets of streams not passed
+// to WriteHeader fail with ErrStreamNotFound. It implements av.Muxer and
+// av.CodecChanger.
+type Muxer struct {
+	w          io.Writer
+	stream     av.Stream
+	hasStream  bool
+	others     map[uint16]bool // non-video streams, whose packets are dropped
+	paramsSent bool            // parameter sets written since the last packet
+	stage      int
+}
+
+// NewMuxer returns a Muxer writing to w.
+func NewMuxer(w io.Writer) *Muxer {
+	return &Muxer{w: w}
+}
+
+// WriteHeader implements av.Muxer. Exactly one H.264 or H.265 stream is required.
+func (m *Muxer) WriteHeader(_ context.Context, streams []av.Stream) error {
+	if m.stage != 0 {
+		return ErrHeaderAlreadyWritten
+	}
+
+	others := make(map[uint16]bool)
+
+	for _, stream := range streams {
+		if !stream.Codec.Type().IsVideo() {
+			others[stream.Idx] = true
+
+			continue
+		}
+
+		if m.hasStream {
+			return ErrTooManyStreams
+		}
+
+		if err := checkCodec(stream.Codec); err != nil {
+			return err
+		}
+
+		m.stream = stream
+		m.hasStream = true
+	}
+
+	if !m.hasStream {
+		return ErrNoVideoStream
+	}
+
+	m.others = others
+	m.stage++
+
+	return nil
+}
+
+// WritePacket implements av.Muxer. AVCC packets are converted to Annex-B.
+func (m *Muxer) WritePacket(_ context.Context, pkt av.Packet) error {
+	if m.stage != 1 {
+		return ErrHeaderNotWritten
+	}
+
+	if pkt.Idx != m.stream.Idx {
+		if !m.others[pkt.Idx] {
+			return ErrStreamNotFound
+		}
+
+		return nil // audio and other streams have no place in an elementary stream
+	}
+
+	data := pkt.Data
+	if parser.IsAnnexBOrAVCC(data) == parser.NALUAvcc {
+		var err error
+		if data, err = parser.AVCCToAnnexB(data); err != nil {
+			return err
+		}
+	}
+
+	var out []byte
+
+	if pkt.KeyFrame && !m.paramsSent && !hasSPS(m.stream.Codec, data) {
+		out = appendParamSets(out, m.stream.Codec)
+	}
+
+	m.paramsSent = false
+
+	_, err := m.w.Write(append(out, data...))
+
+	return err
+}
+
+// WriteTrailer implements av.Muxer.
+func (m *Muxer) WriteTrailer(_ context.Context) error {
+	switch m.stage {
+	case 0:
+		return ErrHeaderNotWritten
+	case 2:
+		return ErrTrailerAlreadyWritten
+	}
+
+	m.stage++
+
+	return nil
+}
+
+// WriteCodecChange implements av.CodecChanger. The new parameter sets are
+// written to the stream immediately.
+func (m *Muxer) WriteCodecChange(_ context.Context, changed []av.Stream) error {
+	if m.stage != 1 {
+		return ErrHeaderNotWritten
+	}
+
+	for _, stream := range changed {
+		if stream.Idx != m.stream.Idx {
+			continue
+		}
+
+		if err := checkCodec(stream.Codec); err != nil {
+			return err
+		}
+
+		m.stream = stream
+
+		if _, err := m.w.Write(appendParamSets(nil, stream.Codec)); err != nil {
+			return err
+		}
+
+		m.paramsSent = true
+	}
+
+	return nil
+}
+
+func checkCodec(codec av.CodecData) error {
+	switch codec.(type) {
+	case h264parser.CodecData, h265parser.CodecData:
+		return nil
+	}
+
+	return ErrUnsupportedCodec
+}
+
+func appendParamSets(b []byte, codec av.CodecData) []byte {
+	var sets [][]byte
+
+	switch codec := codec.(type) {
+	case h264parser.CodecData:
+		sets = [][]byte{codec.SPS(), codec.PPS()}
+	case h265parser.CodecData:
+		sets = [][]byte{codec.VPS(), codec.SPS(), codec.PPS()}
+	}
+
+	for _, set := range sets {
+		b = append(b, parser.StartCode4...)
+		b = append(b, set...)
+	}
+
+	return b
+}
+
+func hasSPS(codec av.CodecData, annexB []byte) bool {
+	nalus, _ := parser.SplitNALUs(annexB)
+	for _, nalu := range nalus {
+		if len(nalu) == 0 {
+			continue
+		}
+
+		if codec.Type() == av.H265 && h265parser.IsSPSNALU(nalu) || codec.Type() == av.H264 && h264parser.IsSPSNALU(nalu) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,120 @@
+package annexb_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/codec/h265parser"
+	"github.com/vtpl1/avsdk/codec/parser"
+	"github.com/vtpl1/avsdk/codec/pcm"
+	"github.com/vtpl1/avsdk/format/annexb"
+	"github.com/vtpl1/avsdk/internal/avtest"
+)
+
+func avcc(nalus ...[]byte) []byte {
+	var b []byte
+
+	for _, nalu := range nalus {
+		b = append(b, 0, 0, 0, byte(len(nalu)))
+		b = append(b, nalu...)
+	}
+
+	return b
+}
+
+func TestMuxer(t *testing.T) {
+	ctx := context.Background()
+	streams := []av.Stream{
+		{Idx: 0, Codec: pcm.NewPCMMulawCodecData()},
+		{Idx: 1, Codec: avtest.H264(t, avtest.SPS320x192)},
+	}
+
+	var buf bytes.Buffer
+
+	mux := annexb.NewMuxer(&buf)
+	if err := mux.WriteHeader(ctx, streams); err != nil {
+		t.Fatal(err)
+	}
+
+	write := func(pkt av.Packet) {
+		t.Helper()
+
+		if err := mux.WritePacket(ctx, pkt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write(av.Packet{Idx: 1, KeyFrame: true, Data: avcc([]byte{0x65, 0x88, 0x01})})
+	write(av.Packet{Idx: 0, Data: []byte{0xff, 0xff}})
+	write(av.Packet{Idx: 1, Data: avcc([]byte{0x41, 0x9a, 0x02})})
+
+	if err := mux.WritePacket(ctx, av.Packet{Idx: 2, Data: []byte{0xff}}); !errors.Is(err, annexb.ErrStreamNotFound) {
+		t.Fatalf("unknown stream: err = %v, want ErrStreamNotFound", err)
+	}
+
+	if err := mux.WriteCodecChange(ctx, []av.Stream{{Idx: 1, Codec: avtest.H264(t, avtest.SPS1280x720)}}); err != nil {
+		t.Fatal(err)
+	}
+
+	write(av.Packet{Idx: 1, KeyFrame: true, Data: avcc([]byte{0x65, 0x88, 0x03})})
+	write(av.Packet{Idx: 1, Data: avcc([]byte{0x41, 0x9a, 0x04})})
+	// Annex-B input that already carries parameter sets is passed through.
+	write(av.Packet{Idx: 1, KeyFrame: true, Data: annexB(avtest.Unhex(avtest.SPS1280x720), avtest.Unhex(avtest.PPS), []byte{0x65, 0x88, 0x05})})
+
+	if err := mux.WriteTrailer(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	want := annexB(
+		avtest.Unhex(avtest.SPS320x192), avtest.Unhex(avtest.PPS), []byte{0x65, 0x88, 0x01},
+		[]byte{0x41, 0x9a, 0x02},
+		avtest.Unhex(avtest.SPS1280x720), avtest.Unhex(avtest.PPS), []byte{0x65, 0x88, 0x03},
+		[]byte{0x41, 0x9a, 0x04},
+		avtest.Unhex(avtest.SPS1280x720), avtest.Unhex(avtest.PPS), []byte{0x65, 0x88, 0x05},
+	)
+	if !bytes.Equal(buf.Bytes(), want) {
+		t.Fatalf("output\n%x\nwant\n%x", buf.Bytes(), want)
+	}
+
+	dmx := annexb.NewDemuxer(bytes.NewReader(buf.Bytes()))
+
+	pkts := avtest.ReadAll(t, dmx)
+	if len(pkts) != 5 || pkts[2].NewCodecs == nil {
+		t.Fatalf("demuxed %d packets, NewCodecs on third = %v", len(pkts), pkts[2].NewCodecs)
+	}
+}
+
+func TestMuxerH265(t *testing.T) {
+	ctx := context.Background()
+
+	codec := avtest.H265(t)
+
+	var buf bytes.Buffer
+
+	mux := annexb.NewMuxer(&buf)
+	if err := mux.WriteHeader(ctx, []av.Stream{{Idx: 0, Codec: codec}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mux.WritePacket(ctx, av.Packet{KeyFrame: true, Data: avcc([]byte{0x26, 0x01, 0xaf, 0x01})}); err != nil {
+		t.Fatal(err)
+	}
+
+	nalus, _ := parser.SplitNALUs(buf.Bytes())
+	if len(nalus) != 4 || !h265parser.IsVPSNALU(nalus[0]) || !h265parser.IsKeyFrame(nalus[3]) {
+		t.Fatalf("output NAL units = %x", nalus)
+	}
+}
+
+func TestMuxerNoVideo(t *testing.T) {
+	mux := annexb.NewMuxer(io.Discard)
+
+	err := mux.WriteHeader(context.Background(), []av.Stream{{Idx: 0, Codec: pcm.NewPCMMulawCodecData()}})
+	if !errors.Is(err, annexb.ErrNoVideoStream) {
+		t.Fatalf("err = %v, want ErrNoVideoStream", err)
+	}
+}
@@ -0,0 +1,12 @@
+package dash
+
+import "errors"
+
+var (
+	ErrHeaderNotWritten      = errors.New("dash: WriteHeader not called")
+	ErrHeaderAlreadyWritten  = errors.New("dash: WriteHeader already called")
+	ErrTrailerAlreadyWritten = errors.New("dash: WriteTrailer already called")
+	ErrNoStreams             = errors.New("dash: no streams")
+	ErrUnsupportedCodec      = errors.New("dash: unsupported codec")
+	ErrStreamNotFound        = errors.New("dash: stream not found")
+)
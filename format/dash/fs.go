@@ -0,0 +1,22 @@
+package dash
+
+import "github.com/vtpl1/avsdk/format/internal/fsutil"
+
+// FS is the storage the MPD and segments are written to. A file created with
+// Create must only become visible once the returned writer is closed.
+type FS = fsutil.FS
+
+// MemFS is an in-memory FS. It is safe for concurrent use, so files can be
+// served while a Muxer writes them.
+type MemFS = fsutil.MemFS
+
+// DirFS returns an FS that writes files into dir. Files are written under a
+// temporary name and renamed into place on Close.
+func DirFS(dir string) FS {
+	return fsutil.DirFS(dir)
+}
+
+// NewMemFS returns an empty MemFS.
+func NewMemFS() *MemFS {
+	return fsutil.NewMemFS()
+}
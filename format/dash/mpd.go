@@ -0,0 +1,113 @@
+// Package dash implements an MPEG-DASH live packager that writes CMAF
+// segments and a dynamic MPD with SegmentTemplate and SegmentTimeline.
+package dash
+
+import (
+	"encoding/xml"
+	"strconv"
+	"time"
+)
+
+const (
+	mpdNamespace = "urn:mpeg:dash:schema:mpd:2011"
+	liveProfile  = "urn:mpeg:dash:profile:isoff-live:2011"
+	channelsURI  = "urn:mpeg:dash:23003:3:audio_channel_configuration:2011"
+
+	initTemplate  = "init-$RepresentationID$.mp4"
+	mediaTemplate = "chunk-$RepresentationID$-$Number$.m4s"
+)
+
+type mpd struct {
+	XMLName                   xml.Name `xml:"MPD"`
+	XMLNS                     string   `xml:"xmlns,attr"`
+	Profiles                  string   `xml:"profiles,attr"`
+	Type                      string   `xml:"type,attr"`
+	AvailabilityStartTime     string   `xml:"availabilityStartTime,attr,omitempty"`
+	PublishTime               string   `xml:"publishTime,attr,omitempty"`
+	MinimumUpdatePeriod       string   `xml:"minimumUpdatePeriod,attr,omitempty"`
+	TimeShiftBufferDepth      string   `xml:"timeShiftBufferDepth,attr,omitempty"`
+	MediaPresentationDuration string   `xml:"mediaPresentationDuration,attr,omitempty"`
+	MinBufferTime             string   `xml:"minBufferTime,attr"`
+	Period                    period   `xml:"Period"`
+}
+
+type period struct {
+	ID             string          `xml:"id,attr"`
+	Start          string          `xml:"start,attr"`
+	AdaptationSets []adaptationSet `xml:"AdaptationSet"`
+}
+
+type adaptationSet struct {
+	ID               uint16         `xml:"id,attr"`
+	ContentType      string         `xml:"contentType,attr"`
+	MimeType         string         `xml:"mimeType,attr"`
+	SegmentAlignment bool           `xml:"segmentAlignment,attr"`
+	StartWithSAP     int            `xml:"startWithSAP,attr"`
+	Representation   representation `xml:"Representation"`
+}
+
+type representation struct {
+	ID                        string          `xml:"id,attr"`
+	Codecs                    string          `xml:"codecs,attr"`
+	Bandwidth                 int             `xml:"bandwidth,attr"`
+	Width                     int             `xml:"width,attr,omitempty"`
+	Height                    int             `xml:"height,attr,omitempty"`
+	FrameRate                 string          `xml:"frameRate,attr,omitempty"`
+	AudioSamplingRate         int             `xml:"audioSamplingRate,attr,omitempty"`
+	AudioChannelConfiguration *descriptor     `xml:"AudioChannelConfiguration"`
+	SegmentTemplate           segmentTemplate `xml:"SegmentTemplate"`
+}
+
+type descriptor struct {
+	SchemeIDURI string `xml:"schemeIdUri,attr"`
+	Value       string `xml:"value,attr"`
+}
+
+type segmentTemplate struct {
+	Timescale      uint32         `xml:"timescale,attr"`
+	Initialization string         `xml:"initialization,attr"`
+	Media          string         `xml:"media,attr"`
+	StartNumber    uint64         `xml:"startNumber,attr"`
+	Timeline       []timelineItem `xml:"SegmentTimeline>S"`
+}
+
+type timelineItem struct {
+	T uint64 `xml:"t,attr"`
+	D uint64 `xml:"d,attr"`
+	R int    `xml:"r,attr,omitempty"`
+}
+
+func (m *mpd) marshal() ([]byte, error) {
+	b, err := xml.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(xml.Header), append(b, '\n')...), nil
+}
+
+// isoDuration formats d as an ISO 8601 duration in seconds.
+func isoDuration(d time.Duration) string {
+	return "PT" + strconv.FormatFloat(d.Seconds(), 'f', -1, 64) + "S"
+}
+
+// compact folds runs of contiguous segments of equal duration into one
+// timeline item with a repeat count.
+func compact(segs []segment) []timelineItem {
+	var items []timelineItem
+
+	for _, s := range segs {
+		if n := len(items); n > 0 {
+			last := &items[n-1]
+			if last.D == s.d && last.T+uint64(last.R+1)*last.D == s.t {
+				last.R++
+
+				continue
+			}
+		}
+
+		items = append(items, timelineItem{T: s.t, D: s.d})
+	}
+
+	return items
+}
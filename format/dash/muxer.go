@@ -0,0 +1,412 @@
+package dash
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/codec/aacparser"
+	"github.com/vtpl1/avsdk/format/fmp4"
+	"github.com/vtpl1/avsdk/format/internal/fsutil"
+	"github.com/vtpl1/avsdk/internal/ticks"
+)
+
+// Defaults used by NewMuxer.
+const (
+	DefaultTargetDuration = 2 * time.Second
+	DefaultWindowSize     = 6
+	DefaultManifestName   = "manifest.mpd"
+)
+
+// Option configures a Muxer.
+type Option func(*Muxer)
+
+// WithTargetDuration sets the duration after which segments are cut at the
+// next video keyframe.
+func WithTargetDuration(d time.Duration) Option {
+	return func(m *Muxer) {
+		m.target = d
+	}
+}
+
+// WithWindowSize sets the number of segments per representation listed in
+// the MPD, which also sets timeShiftBufferDepth.
+func WithWindowSize(n int) Option {
+	return func(m *Muxer) {
+		m.window = n
+	}
+}
+
+// WithManifestName sets the name of the MPD file.
+func WithManifestName(name string) Option {
+	return func(m *Muxer) {
+		m.manifestName = name
+	}
+}
+
+// videoCodec is implemented by h264parser.CodecData and h265parser.CodecData.
+type videoCodec interface {
+	Tag() string
+	Resolution() string
+	Bandwidth() string
+	FPS() int
+}
+
+// segment is one entry of a representation's SegmentTimeline, in track ticks.
+type segment struct {
+	number uint64
+	t, d   uint64
+}
+
+type track struct {
+	stream    av.Stream
+	id        string
+	timeScale uint32
+	mux       *fmp4.Muxer
+	buf       bytes.Buffer
+	open      bool
+	start     time.Duration
+	end       time.Duration
+	segments  []segment
+	expired   []segment
+	number    uint64 // of the next segment
+	bandwidth int    // peak segment bitrate
+}
+
+// Muxer packages H.264, H.265 and AAC streams as MPEG-DASH. Each stream is
+// written as its own CMAF track in its own AdaptationSet. Segments of all
+// tracks are cut together on a video keyframe (any packet for audio-only
+// output) once the target duration is reached, and the MPD is rewritten
+// after every cut. Each track numbers its own segments, so a track without
+// samples in an interval leaves no gap in its numbering. Segments are
+// removed from the FS once they have been out of the window for as many
+// segments as the window holds. It implements av.MuxCloser.
+type Muxer struct {
+	fs           FS
+	target       time.Duration
+	window       int
+	manifestName string
+	now          func() time.Time
+
+	tracks     []*track
+	trackByIdx map[uint16]*track
+	hasVideo   bool
+	started    bool
+	segStart   time.Duration
+	startTime  time.Time // wall clock time of media time 0
+
+	stage int
+}
+
+// NewMuxer returns a Muxer writing to fsys.
+func NewMuxer(fsys FS, opts ...Option) *Muxer {
+	m := &Muxer{
+		fs:           fsys,
+		target:       DefaultTargetDuration,
+		window:       DefaultWindowSize,
+		manifestName: DefaultManifestName,
+		now:          time.Now,
+		trackByIdx:   make(map[uint16]*track),
+	}
+	for _, o := range opts {
+		o(m)
+	}
+
+	return m
+}
+
+// WriteHeader implements av.Muxer. It writes one init segment per stream.
+// The MPD is first written when the first segment is complete.
+func (m *Muxer) WriteHeader(ctx context.Context, streams []av.Stream) error {
+	if m.stage != 0 {
+		return ErrHeaderAlreadyWritten
+	}
+
+	if len(streams) == 0 {
+		return ErrNoStreams
+	}
+
+	for _, stream := range streams {
+		t := &track{stream: stream, id: strconv.Itoa(int(stream.Idx))}
+
+		switch codec := stream.Codec.(type) {
+		case videoCodec:
+			vc, ok := codec.(av.VideoCodecData)
+			if !ok {
+				return ErrUnsupportedCodec
+			}
+
+			t.timeScale = vc.TimeScale()
+			m.hasVideo = true
+		case aacparser.CodecData:
+			t.timeScale = uint32(codec.SampleRate())
+		default:
+			return ErrUnsupportedCodec
+		}
+
+		t.mux = fmp4.NewMuxer(&t.buf)
+		if err := t.mux.WriteHeader(ctx, []av.Stream{stream}); err != nil {
+			return err
+		}
+
+		if err := fsutil.WriteFile(m.fs, t.name(initTemplate, 0), t.buf.Bytes()); err != nil {
+			return err
+		}
+
+		t.buf.Reset()
+		m.tracks = append(m.tracks, t)
+		m.trackByIdx[stream.Idx] = t
+	}
+
+	m.stage++
+
+	return nil
+}
+
+// WritePacket implements av.Muxer. Packets before the first video keyframe
+// are dropped.
+func (m *Muxer) WritePacket(ctx context.Context, pkt av.Packet) error {
+	if m.stage != 1 {
+		return ErrHeaderNotWritten
+	}
+
+	t, ok := m.trackByIdx[pkt.Idx]
+	if !ok {
+		return ErrStreamNotFound
+	}
+
+	startsSegment := !m.hasVideo || (pkt.KeyFrame && t.stream.Codec.Type().IsVideo())
+
+	if !m.started {
+		if !startsSegment {
+			return nil
+		}
+
+		m.started = true
+		m.segStart = pkt.DTS
+		m.startTime = m.now().Add(-pkt.DTS)
+	} else if startsSegment && pkt.DTS-m.segStart >= m.target {
+		if err := m.cut(ctx, pkt.DTS, false); err != nil {
+			return err
+		}
+
+		m.segStart = pkt.DTS
+	}
+
+	dur := pkt.Duration
+	if ac, ok := t.stream.Codec.(av.AudioCodecData); ok && dur == 0 {
+		dur, _ = ac.PacketDuration(pkt.Data)
+	}
+
+	if !t.open {
+		t.open = true
+		t.start = pkt.DTS
+		t.end = pkt.DTS
+	}
+
+	t.end = max(t.end, pkt.DTS+dur)
+
+	return t.mux.WritePacket(ctx, pkt)
+}
+
+// WriteTrailer implements av.Muxer. It writes the last segments and a static
+// MPD covering the segments still in the window.
+func (m *Muxer) WriteTrailer(ctx context.Context) error {
+	switch m.stage {
+	case 0:
+		return ErrHeaderNotWritten
+	case 2:
+		return ErrTrailerAlreadyWritten
+	}
+
+	m.stage++
+
+	if !m.started {
+		return nil
+	}
+
+	return m.cut(ctx, -1, true)
+}
+
+// Close implements av.MuxCloser. The FS is left as it is; call WriteTrailer
+// first to finalize the MPD.
+func (m *Muxer) Close() error {
+	return nil
+}
+
+func (t *track) name(template string, number uint64) string {
+	name := strings.ReplaceAll(template, "$RepresentationID$", t.id)
+
+	return strings.ReplaceAll(name, "$Number$", strconv.FormatUint(number, 10))
+}
+
+// cut closes the open segment of every track. For video tracks the segment
+// ends at next, the DTS of the keyframe starting the next segment, unless next
+// is negative.
+func (m *Muxer) cut(ctx context.Context, next time.Duration, ended bool) error {
+	for _, t := range m.tracks {
+		if !t.open {
+			continue
+		}
+
+		t.open = false
+
+		if err := t.mux.Flush(ctx); err != nil {
+			return err
+		}
+
+		end := t.end
+		if next >= 0 && t.stream.Codec.Type().IsVideo() {
+			end = next
+		}
+
+		start := uint64(ticks.FromDuration(max(t.start, 0), int64(t.timeScale)))
+		seg := segment{
+			number: t.number,
+			t:      start,
+			d:      uint64(ticks.FromDuration(max(end, 0), int64(t.timeScale))) - start,
+		}
+
+		if end > t.start {
+			t.bandwidth = max(t.bandwidth, int(float64(t.buf.Len()*8)/(end-t.start).Seconds()))
+		}
+
+		err := fsutil.WriteFile(m.fs, t.name(mediaTemplate, t.number), t.buf.Bytes())
+		t.buf.Reset()
+
+		if err != nil {
+			return err
+		}
+
+		t.segments = append(t.segments, seg)
+		t.number++
+
+		if err := m.slideWindow(t); err != nil {
+			return err
+		}
+	}
+
+	return m.writeManifest(ended)
+}
+
+// slideWindow drops segments that no longer fit the window from the timeline
+// and removes those that left it a full window ago.
+func (m *Muxer) slideWindow(t *track) error {
+	if m.window <= 0 {
+		return nil
+	}
+
+	for len(t.segments) > m.window {
+		t.expired = append(t.expired, t.segments[0])
+		t.segments = t.segments[1:]
+	}
+
+	for len(t.expired) > m.window {
+		if err := m.fs.Remove(t.name(mediaTemplate, t.expired[0].number)); err != nil {
+			return err
+		}
+
+		t.expired = t.expired[1:]
+	}
+
+	return nil
+}
+
+func (m *Muxer) writeManifest(ended bool) error {
+	doc := mpd{
+		XMLNS:         mpdNamespace,
+		Profiles:      liveProfile,
+		Type:          "dynamic",
+		MinBufferTime: isoDuration(m.target),
+		Period:        period{ID: "0", Start: "PT0S"},
+	}
+
+	if ended {
+		doc.Type = "static"
+	} else {
+		doc.AvailabilityStartTime = m.startTime.UTC().Format(time.RFC3339Nano)
+		doc.PublishTime = m.now().UTC().Format(time.RFC3339Nano)
+		doc.MinimumUpdatePeriod = isoDuration(m.target)
+
+		if m.window > 0 {
+			doc.TimeShiftBufferDepth = isoDuration(time.Duration(m.window) * m.target)
+		}
+	}
+
+	var end time.Duration
+
+	for _, t := range m.tracks {
+		if len(t.segments) == 0 {
+			continue
+		}
+
+		last := t.segments[len(t.segments)-1]
+		end = max(end, ticks.ToDuration(int64(last.t+last.d), int64(t.timeScale)))
+
+		doc.Period.AdaptationSets = append(doc.Period.AdaptationSets, t.adaptationSet())
+	}
+
+	if ended {
+		doc.MediaPresentationDuration = isoDuration(end)
+	}
+
+	b, err := doc.marshal()
+	if err != nil {
+		return err
+	}
+
+	return fsutil.WriteFile(m.fs, m.manifestName, b)
+}
+
+func (t *track) adaptationSet() adaptationSet {
+	rep := representation{
+		ID:        t.id,
+		Bandwidth: t.bandwidth,
+		SegmentTemplate: segmentTemplate{
+			Timescale:      t.timeScale,
+			Initialization: initTemplate,
+			Media:          mediaTemplate,
+			StartNumber:    t.segments[0].number,
+			Timeline:       compact(t.segments),
+		},
+	}
+
+	set := adaptationSet{
+		ID:               t.stream.Idx,
+		SegmentAlignment: true,
+		StartWithSAP:     1,
+	}
+
+	switch codec := t.stream.Codec.(type) {
+	case videoCodec:
+		set.ContentType, set.MimeType = "video", "video/mp4"
+		rep.Codecs = codec.Tag()
+		_, _ = fmt.Sscanf(codec.Resolution(), "%dx%d", &rep.Width, &rep.Height)
+
+		// The bitrate estimate of the codec data depends on the frame rate
+		// signalled in the SPS; without it the measured peak is used.
+		if fps := codec.FPS(); fps > 0 {
+			rep.FrameRate = strconv.Itoa(fps)
+
+			if bw, err := strconv.Atoi(codec.Bandwidth()); err == nil && bw > 0 {
+				rep.Bandwidth = bw
+			}
+		}
+	case aacparser.CodecData:
+		set.ContentType, set.MimeType = "audio", "audio/mp4"
+		rep.Codecs = codec.Tag()
+		rep.AudioSamplingRate = codec.SampleRate()
+		rep.AudioChannelConfiguration = &descriptor{
+			SchemeIDURI: channelsURI,
+			Value:       strconv.Itoa(codec.ChannelLayout().Count()),
+		}
+	}
+
+	set.Representation = rep
+
+	return set
+}
@@ -0,0 +1,240 @@
+package dash_test
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/codec/aacparser"
+	"github.com/vtpl1/avsdk/format/dash"
+	"github.com/vtpl1/avsdk/internal/avtest"
+)
+
+type mpd struct {
+	Type                      string `xml:"type,attr"`
+	AvailabilityStartTime     string `xml:"availabilityStartTime,attr"`
+	MinimumUpdatePeriod       string `xml:"minimumUpdatePeriod,attr"`
+	TimeShiftBufferDepth      string `xml:"timeShiftBufferDepth,attr"`
+	MediaPresentationDuration string `xml:"mediaPresentationDuration,attr"`
+	AdaptationSets            []struct {
+		ContentType    string `xml:"contentType,attr"`
+		Representation struct {
+			ID                string `xml:"id,attr"`
+			Codecs            string `xml:"codecs,attr"`
+			Bandwidth         int    `xml:"bandwidth,attr"`
+			Width             int    `xml:"width,attr"`
+			Height            int    `xml:"height,attr"`
+			AudioSamplingRate int    `xml:"audioSamplingRate,attr"`
+			Channels          struct {
+				Value string `xml:"value,attr"`
+			} `xml:"AudioChannelConfiguration"`
+			Template struct {
+				Timescale      uint32 `xml:"timescale,attr"`
+				Initialization string `xml:"initialization,attr"`
+				Media          string `xml:"media,attr"`
+				StartNumber    uint64 `xml:"startNumber,attr"`
+				S              []struct {
+					T uint64 `xml:"t,attr"`
+					D uint64 `xml:"d,attr"`
+					R int    `xml:"r,attr"`
+				} `xml:"SegmentTimeline>S"`
+			} `xml:"SegmentTemplate"`
+		} `xml:"Representation"`
+	} `xml:"Period>AdaptationSet"`
+}
+
+func readMPD(t *testing.T, fsys *dash.MemFS) mpd {
+	t.Helper()
+
+	b, err := fsys.ReadFile(dash.DefaultManifestName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var doc mpd
+	if err := xml.Unmarshal(b, &doc); err != nil {
+		t.Fatal(err)
+	}
+
+	return doc
+}
+
+func TestMuxer(t *testing.T) {
+	ctx := context.Background()
+	fsys := dash.NewMemFS()
+
+	video := avtest.H264(t, avtest.SPS320x192)
+
+	audio, err := aacparser.NewCodecDataFromMPEG4AudioConfigBytes([]byte{0x11, 0x90})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := dash.NewMuxer(fsys, dash.WithWindowSize(2))
+	if err := mux.WriteHeader(ctx, []av.Stream{{Idx: 0, Codec: video}, {Idx: 1, Codec: audio}}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"init-0.mp4", "init-1.mp4"} {
+		if b, err := fsys.ReadFile(name); err != nil || string(b[4:8]) != "ftyp" {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+
+	frame := 40 * time.Millisecond
+	aframe := 1024 * time.Second / 48000
+	adts := time.Duration(0)
+
+	// 10 s of 25 fps video with a keyframe every second, and 48 kHz AAC.
+	for i := range 250 {
+		dts := time.Duration(i) * frame
+		data := []byte{0, 0, 0, 3, 0x41, 0x9a, byte(i)}
+
+		if i%25 == 0 {
+			data[4] = 0x65
+		}
+
+		if err := mux.WritePacket(ctx, av.Packet{Idx: 0, KeyFrame: i%25 == 0, DTS: dts, Duration: frame, Data: data}); err != nil {
+			t.Fatal(err)
+		}
+
+		for ; adts < dts+frame; adts += aframe {
+			if err := mux.WritePacket(ctx, av.Packet{Idx: 1, DTS: adts, Data: []byte{0x21, byte(i)}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	live := readMPD(t, fsys)
+	if live.Type != "dynamic" || live.AvailabilityStartTime == "" || live.MinimumUpdatePeriod != "PT2S" || live.TimeShiftBufferDepth != "PT4S" {
+		t.Fatalf("live MPD = %+v", live)
+	}
+
+	if err := mux.WriteTrailer(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	doc := readMPD(t, fsys)
+	if doc.Type != "static" || !strings.HasPrefix(doc.MediaPresentationDuration, "PT10.0") || len(doc.AdaptationSets) != 2 {
+		t.Fatalf("final MPD = %+v", doc)
+	}
+
+	v := doc.AdaptationSets[0].Representation
+	if doc.AdaptationSets[0].ContentType != "video" || v.Codecs != "avc1.64000D" || v.Width != 320 || v.Height != 180 || v.Bandwidth <= 0 {
+		t.Fatalf("video representation = %+v", v)
+	}
+
+	tmpl := v.Template
+	if tmpl.Timescale != 90000 || tmpl.StartNumber != 3 || tmpl.Media != "chunk-$RepresentationID$-$Number$.m4s" ||
+		len(tmpl.S) != 1 || tmpl.S[0].T != 6*90000 || tmpl.S[0].D != 2*90000 || tmpl.S[0].R != 1 {
+		t.Fatalf("video template = %+v", tmpl)
+	}
+
+	a := doc.AdaptationSets[1].Representation
+	if doc.AdaptationSets[1].ContentType != "audio" || a.Codecs != "mp4a.40.2" || a.AudioSamplingRate != 48000 ||
+		a.Channels.Value != "2" || a.Template.Timescale != 48000 || a.Template.StartNumber != 3 {
+		t.Fatalf("audio representation = %+v", a)
+	}
+
+	// Segments are kept for one window after leaving the MPD.
+	for number, removed := range []bool{true, false, false, false, false} {
+		for _, id := range []string{"0", "1"} {
+			name := fmt.Sprintf("chunk-%s-%d.m4s", id, number)
+
+			b, err := fsys.ReadFile(name)
+			if removed != errors.Is(err, fs.ErrNotExist) {
+				t.Fatalf("%s: err = %v, want removed = %v", name, err, removed)
+			}
+
+			if !removed && string(b[4:8]) != "moof" {
+				t.Fatalf("%s does not start with moof", name)
+			}
+		}
+	}
+}
+
+func TestMuxerAudioGap(t *testing.T) {
+	ctx := context.Background()
+	fsys := dash.NewMemFS()
+
+	video := avtest.H264(t, avtest.SPS320x192)
+
+	audio, err := aacparser.NewCodecDataFromMPEG4AudioConfigBytes([]byte{0x11, 0x90})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mux := dash.NewMuxer(fsys, dash.WithWindowSize(0))
+	if err := mux.WriteHeader(ctx, []av.Stream{{Idx: 0, Codec: video}, {Idx: 1, Codec: audio}}); err != nil {
+		t.Fatal(err)
+	}
+
+	frame := 40 * time.Millisecond
+
+	// 6 s of video in 2 s segments; audio drops out during the second one.
+	for i := range 150 {
+		dts := time.Duration(i) * frame
+
+		if err := mux.WritePacket(ctx, av.Packet{Idx: 0, KeyFrame: i%50 == 0, DTS: dts, Duration: frame, Data: []byte{0, 0, 0, 1, 0x65}}); err != nil {
+			t.Fatal(err)
+		}
+
+		if i/50 != 1 {
+			if err := mux.WritePacket(ctx, av.Packet{Idx: 1, DTS: dts, Duration: frame, Data: []byte{0x21, byte(i)}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	if err := mux.WriteTrailer(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Segment numbers are implied by the timeline, so the audio track must
+	// number its two segments 0 and 1.
+	a := readMPD(t, fsys).AdaptationSets[1].Representation.Template
+	if a.StartNumber != 0 || len(a.S) != 2 || a.S[1].T != 4*48000 {
+		t.Fatalf("audio template = %+v", a)
+	}
+
+	for _, name := range []string{"chunk-1-0.m4s", "chunk-1-1.m4s", "chunk-0-2.m4s"} {
+		if _, err := fsys.ReadFile(name); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+	}
+
+	if _, err := fsys.ReadFile("chunk-1-2.m4s"); !errors.Is(err, fs.ErrNotExist) {
+		t.Fatalf("chunk-1-2.m4s: err = %v, want fs.ErrNotExist", err)
+	}
+}
+
+func TestMuxerLongRunning(t *testing.T) {
+	ctx := context.Background()
+	fsys := dash.NewMemFS()
+
+	video := avtest.H264(t, avtest.SPS320x192)
+
+	mux := dash.NewMuxer(fsys)
+	if err := mux.WriteHeader(ctx, []av.Stream{{Idx: 0, Codec: video}}); err != nil {
+		t.Fatal(err)
+	}
+
+	start := 30 * time.Hour
+	for i := range 3 {
+		pkt := av.Packet{Idx: 0, KeyFrame: true, DTS: start + time.Duration(i)*2*time.Second, Duration: 2 * time.Second, Data: []byte{0, 0, 0, 1, 0x65}}
+		if err := mux.WritePacket(ctx, pkt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tmpl := readMPD(t, fsys).AdaptationSets[0].Representation.Template
+	if len(tmpl.S) != 1 || tmpl.S[0].T != 30*3600*90000 || tmpl.S[0].D != 2*90000 {
+		t.Fatalf("video template = %+v", tmpl)
+	}
+}
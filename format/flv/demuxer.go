@@ -0,0 +1,362 @@
+package flv
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/av/avutil"
+	"github.com/vtpl1/avsdk/codec/aacparser"
+	"github.com/vtpl1/avsdk/codec/h264parser"
+	"github.com/vtpl1/avsdk/codec/h265parser"
+	"github.com/vtpl1/avsdk/codec/pcm"
+	"github.com/vtpl1/avsdk/utils/bits/pio"
+)
+
+// maxProbeTags bounds how many tags GetCodecs reads while waiting for the
+// sequence headers of the streams announced in the file header.
+const maxProbeTags = 1000
+
+type demuxStream struct {
+	codec     av.CodecData
+	announced bool // codec reported through GetCodecs or Packet.NewCodecs
+}
+
+// Demuxer reads FLV tags. Stream.Idx is VideoStreamIdx for the video stream and
+// AudioStreamIdx for the audio stream.
+type Demuxer struct {
+	r        *bufio.Reader
+	hasAudio bool
+	hasVideo bool
+	video    demuxStream
+	audio    demuxStream
+	pending  []av.Packet
+	probed   bool // file header read
+	ready    bool // initial codecs reported by GetCodecs
+	eof      bool
+	frameID  int64
+	tagHdr   [tagHeaderLength]byte
+}
+
+// NewDemuxer returns a Demuxer reading from r.
+func NewDemuxer(r io.Reader) *Demuxer {
+	return &Demuxer{
+		r: bufio.NewReaderSize(r, pio.RecommendBufioSize),
+	}
+}
+
+// GetCodecs implements av.Demuxer. Tags read while waiting for sequence
+// headers are queued and returned by ReadPacket.
+func (m *Demuxer) GetCodecs(ctx context.Context) ([]av.Stream, error) {
+	if err := m.probe(ctx); err != nil {
+		return nil, err
+	}
+
+	var streams []av.Stream
+
+	if m.video.codec != nil {
+		streams = append(streams, av.Stream{Idx: VideoStreamIdx, Codec: m.video.codec})
+	}
+
+	if m.audio.codec != nil {
+		streams = append(streams, av.Stream{Idx: AudioStreamIdx, Codec: m.audio.codec})
+	}
+
+	if len(streams) == 0 {
+		return nil, ErrNoStreams
+	}
+
+	return streams, nil
+}
+
+// ReadPacket implements av.Demuxer.
+func (m *Demuxer) ReadPacket(ctx context.Context) (av.Packet, error) {
+	if err := m.probe(ctx); err != nil {
+		return av.Packet{}, err
+	}
+
+	for len(m.pending) == 0 {
+		if err := ctx.Err(); err != nil {
+			return av.Packet{}, err
+		}
+
+		if m.eof {
+			return av.Packet{}, io.EOF
+		}
+
+		if err := m.readTag(); err != nil {
+			return av.Packet{}, err
+		}
+	}
+
+	pkt := m.pending[0]
+	m.pending = m.pending[1:]
+
+	return pkt, nil
+}
+
+func (m *Demuxer) probe(ctx context.Context) error {
+	if m.probed {
+		return nil
+	}
+
+	m.probed = true
+
+	var hdr [headerLength + 4]byte
+	if _, err := io.ReadFull(m.r, hdr[:]); err != nil {
+		return ErrInvalidHeader
+	}
+
+	if !Probe(hdr[:]) {
+		return ErrInvalidHeader
+	}
+
+	m.hasAudio = hdr[4]&headerFlagAudio != 0
+	m.hasVideo = hdr[4]&headerFlagVideo != 0
+
+	// Skip any header extension before the first PreviousTagSize.
+	if offset := int(pio.U32BE(hdr[5:])); offset > headerLength {
+		if _, err := m.r.Discard(offset - headerLength); err != nil {
+			return ErrInvalidHeader
+		}
+	}
+
+	for range maxProbeTags {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if m.eof || (m.video.codec != nil || !m.hasVideo) && (m.audio.codec != nil || !m.hasAudio) {
+			break
+		}
+
+		if err := m.readTag(); err != nil {
+			return err
+		}
+	}
+
+	m.video.announced = m.video.codec != nil
+	m.audio.announced = m.audio.codec != nil
+	m.ready = true
+
+	return nil
+}
+
+// readTag reads one tag and its trailing PreviousTagSize. A truncated tag at
+// the end of the input is treated as end of stream.
+func (m *Demuxer) readTag() error {
+	hdr := m.tagHdr[:]
+	if _, err := io.ReadFull(m.r, hdr); err != nil {
+		return m.finish(err)
+	}
+
+	typ := hdr[0] & 0x1f
+	size := int(pio.U24BE(hdr[1:]))
+	ts := int64(pio.U24BE(hdr[4:])) | int64(hdr[7])<<24
+
+	data := make([]byte, size+4)
+	if _, err := io.ReadFull(m.r, data); err != nil {
+		return m.finish(err)
+	}
+
+	data = data[:size]
+	dts := time.Duration(ts) * time.Millisecond
+
+	switch typ {
+	case tagVideo:
+		return m.handleVideo(data, dts)
+	case tagAudio:
+		return m.handleAudio(data, dts)
+	}
+
+	return nil // script data and unknown tags
+}
+
+func (m *Demuxer) finish(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		m.eof = true
+
+		return nil
+	}
+
+	return err
+}
+
+func (m *Demuxer) handleVideo(data []byte, dts time.Duration) error {
+	if len(data) < 1 {
+		return nil
+	}
+
+	if data[0]&videoExHeader != 0 {
+		return m.handleExVideo(data, dts)
+	}
+
+	frameType := data[0] >> 4 & 0x07
+	if data[0]&0x0f != videoCodecAVC || len(data) < 5 {
+		return nil
+	}
+
+	cts := pio.I24BE(data[2:])
+	body := data[5:]
+
+	switch data[1] {
+	case avcPacketSequenceHeader:
+		codec, err := h264parser.NewCodecDataFromAVCDecoderConfRecord(body)
+		if err != nil {
+			return err
+		}
+
+		m.setCodec(&m.video, VideoStreamIdx, codec)
+	case avcPacketNALU:
+		m.appendVideo(body, dts, cts, frameType == frameTypeKey)
+	}
+
+	return nil
+}
+
+// handleExVideo parses an enhanced RTMP video tag.
+func (m *Demuxer) handleExVideo(data []byte, dts time.Duration) error {
+	if len(data) < 5 || string(data[1:5]) != fourCCHEVC {
+		return nil
+	}
+
+	frameType := data[0] >> 4 & 0x07
+	body := data[5:]
+
+	switch data[0] & 0x0f {
+	case packetTypeSequenceStart:
+		codec, err := h265parser.NewCodecDataFromAVCDecoderConfRecord(body)
+		if err != nil {
+			return err
+		}
+
+		m.setCodec(&m.video, VideoStreamIdx, codec)
+	case packetTypeCodedFrames:
+		if len(body) < 3 {
+			return ErrInvalidTag
+		}
+
+		m.appendVideo(body[3:], dts, pio.I24BE(body), frameType == frameTypeKey)
+	case packetTypeCodedFramesX:
+		m.appendVideo(body, dts, 0, frameType == frameTypeKey)
+	}
+
+	return nil
+}
+
+func (m *Demuxer) appendVideo(data []byte, dts time.Duration, cts int32, keyFrame bool) {
+	if m.video.codec == nil || len(data) == 0 {
+		return
+	}
+
+	pkt := m.newPacket(&m.video, VideoStreamIdx, data, dts)
+	pkt.KeyFrame = keyFrame
+	pkt.PTSOffset = time.Duration(cts) * time.Millisecond
+	m.pending = append(m.pending, pkt)
+}
+
+func (m *Demuxer) handleAudio(data []byte, dts time.Duration) error {
+	if len(data) < 1 {
+		return nil
+	}
+
+	stereo := data[0]&soundStereo != 0
+
+	switch data[0] >> 4 {
+	case soundFormatAAC:
+		if len(data) < 2 {
+			return ErrInvalidTag
+		}
+
+		if data[1] == aacPacketSequenceHeader {
+			codec, err := aacparser.NewCodecDataFromMPEG4AudioConfigBytes(data[2:])
+			if err != nil {
+				return err
+			}
+
+			m.setCodec(&m.audio, AudioStreamIdx, codec)
+
+			return nil
+		}
+
+		m.appendAudio(data[2:], dts)
+	case soundFormatAlaw, soundFormatMulaw:
+		if m.audio.codec == nil || m.audio.codec.Type() != g711Type(data[0]>>4) {
+			m.setCodec(&m.audio, AudioStreamIdx, newG711CodecData(data[0]>>4, stereo))
+		}
+
+		m.appendAudio(data[1:], dts)
+	}
+
+	return nil
+}
+
+func (m *Demuxer) appendAudio(data []byte, dts time.Duration) {
+	if m.audio.codec == nil || len(data) == 0 {
+		return
+	}
+
+	pkt := m.newPacket(&m.audio, AudioStreamIdx, data, dts)
+	pkt.KeyFrame = true
+
+	if ac, ok := m.audio.codec.(av.AudioCodecData); ok {
+		if d, err := ac.PacketDuration(data); err == nil {
+			pkt.Duration = d
+		}
+	}
+
+	m.pending = append(m.pending, pkt)
+}
+
+// setCodec records a sequence header. Repeated identical headers are ignored;
+// a different one is reported through the next packet's NewCodecs.
+func (m *Demuxer) setCodec(s *demuxStream, idx uint16, codec av.CodecData) {
+	if s.codec != nil && avutil.Equal([]av.Stream{{Idx: idx, Codec: s.codec}}, []av.Stream{{Idx: idx, Codec: codec}}) {
+		return
+	}
+
+	s.codec = codec
+	s.announced = false
+}
+
+func (m *Demuxer) newPacket(s *demuxStream, idx uint16, data []byte, dts time.Duration) av.Packet {
+	pkt := av.Packet{
+		Idx:       idx,
+		DTS:       dts,
+		Data:      data,
+		FrameID:   m.frameID,
+		CodecType: s.codec.Type(),
+	}
+	m.frameID++
+
+	if m.ready && !s.announced {
+		pkt.NewCodecs = []av.Stream{{Idx: idx, Codec: s.codec}}
+		s.announced = true
+	}
+
+	return pkt
+}
+
+func g711Type(format uint8) av.CodecType {
+	if format == soundFormatAlaw {
+		return av.PCM_ALAW
+	}
+
+	return av.PCM_MULAW
+}
+
+func newG711CodecData(format uint8, stereo bool) av.AudioCodecData {
+	layout := av.ChMono
+	if stereo {
+		layout = av.ChStereo
+	}
+
+	if format == soundFormatAlaw {
+		return pcm.PCMAlawCodecData{Typ: av.PCM_ALAW, SmplFormat: av.S16, SmplRate: g711SampleRate, ChLayout: layout}
+	}
+
+	return pcm.PCMMulawCodecData{Typ: av.PCM_MULAW, SmplFormat: av.S16, SmplRate: g711SampleRate, ChLayout: layout}
+}
@@ -0,0 +1,15 @@
+package flv
+
+import "errors"
+
+var (
+	ErrInvalidHeader         = errors.New("flv: invalid file header")
+	ErrInvalidTag            = errors.New("flv: invalid tag")
+	ErrNoStreams             = errors.New("flv: no streams")
+	ErrHeaderNotWritten      = errors.New("flv: WriteHeader not called")
+	ErrHeaderAlreadyWritten  = errors.New("flv: WriteHeader already called")
+	ErrTrailerAlreadyWritten = errors.New("flv: WriteTrailer already called")
+	ErrUnsupportedCodec      = errors.New("flv: unsupported codec")
+	ErrStreamNotFound        = errors.New("flv: stream not found")
+	ErrDuplicateStream       = errors.New("flv: more than one audio or video stream")
+)
@@ -0,0 +1,89 @@
+// Package flv implements an FLV demuxer and muxer, including the enhanced-RTMP
+// extension for HEVC (FourCC "hvc1").
+package flv
+
+import (
+	"io"
+	"time"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/av/avutil"
+)
+
+// Stream indices used by the Demuxer.
+const (
+	VideoStreamIdx = 0
+	AudioStreamIdx = 1
+)
+
+const (
+	headerLength    = 9
+	tagHeaderLength = 11
+
+	tagAudio = 8
+	tagVideo = 9
+
+	headerFlagAudio = 0x04
+	headerFlagVideo = 0x01
+)
+
+// Legacy video tag fields (FLV spec §E.4.3.1).
+const (
+	frameTypeKey   = 1
+	frameTypeInter = 2
+
+	videoCodecAVC = 7
+
+	avcPacketSequenceHeader = 0
+	avcPacketNALU           = 1
+)
+
+// Enhanced RTMP video tag fields.
+const (
+	videoExHeader = 0x80
+
+	packetTypeSequenceStart = 0
+	packetTypeCodedFrames   = 1
+	packetTypeCodedFramesX  = 3
+
+	fourCCHEVC = "hvc1"
+)
+
+// Audio tag fields (FLV spec §E.4.2.1).
+const (
+	soundFormatAlaw  = 7
+	soundFormatMulaw = 8
+	soundFormatAAC   = 10
+
+	aacPacketSequenceHeader = 0
+	aacPacketRaw            = 1
+
+	soundRate44k   = 3
+	soundSize16    = 1
+	soundStereo    = 1
+	g711SampleRate = 8000
+
+	aacSoundFlags = soundFormatAAC<<4 | soundRate44k<<2 | soundSize16<<1 | soundStereo
+)
+
+// Probe reports whether b starts with an FLV file header.
+func Probe(b []byte) bool {
+	return len(b) >= 3 && b[0] == 'F' && b[1] == 'L' && b[2] == 'V'
+}
+
+// Handler registers the ".flv" extension with avutil.
+func Handler(h *avutil.RegisterHandler) {
+	h.Ext = ".flv"
+	h.Probe = Probe
+	h.ReaderDemuxer = func(r io.Reader) av.Demuxer {
+		return NewDemuxer(r)
+	}
+	h.WriterMuxer = func(w io.Writer) av.Muxer {
+		return NewMuxer(w)
+	}
+	h.CodecTypes = []av.CodecType{av.H264, av.H265, av.AAC, av.PCM_ALAW, av.PCM_MULAW}
+}
+
+func toMillis(d time.Duration) int32 {
+	return int32(d / time.Millisecond)
+}
@@ -0,0 +1,246 @@
+package flv
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/codec/aacparser"
+	"github.com/vtpl1/avsdk/codec/h264parser"
+	"github.com/vtpl1/avsdk/codec/h265parser"
+	"github.com/vtpl1/avsdk/codec/parser"
+	"github.com/vtpl1/avsdk/utils/bits/pio"
+)
+
+// Muxer writes FLV with at most one video (H.264, or H.265 as enhanced RTMP
+// "hvc1") and one audio (AAC, G.711) stream. It implements av.Muxer and
+// av.CodecChanger.
+type Muxer struct {
+	w       io.Writer
+	video   *av.Stream
+	audio   *av.Stream
+	lastDTS time.Duration
+	buf     []byte
+	stage   int
+}
+
+// NewMuxer returns a Muxer writing to w.
+func NewMuxer(w io.Writer) *Muxer {
+	return &Muxer{w: w}
+}
+
+// WriteHeader implements av.Muxer. It writes the FLV header followed by a
+// sequence header tag for every stream that needs one.
+func (m *Muxer) WriteHeader(_ context.Context, streams []av.Stream) error {
+	if m.stage != 0 {
+		return ErrHeaderAlreadyWritten
+	}
+
+	if len(streams) == 0 {
+		return ErrNoStreams
+	}
+
+	var flags byte
+
+	for _, stream := range streams {
+		if err := checkCodec(stream.Codec); err != nil {
+			return err
+		}
+
+		s := stream
+		if stream.Codec.Type().IsVideo() {
+			if m.video != nil {
+				return ErrDuplicateStream
+			}
+
+			m.video = &s
+			flags |= headerFlagVideo
+		} else {
+			if m.audio != nil {
+				return ErrDuplicateStream
+			}
+
+			m.audio = &s
+			flags |= headerFlagAudio
+		}
+	}
+
+	m.buf = append(m.buf[:0], 'F', 'L', 'V', 1, flags, 0, 0, 0, headerLength, 0, 0, 0, 0)
+
+	if m.video != nil {
+		m.appendSequenceHeader(m.video.Codec, 0)
+	}
+
+	if m.audio != nil {
+		m.appendSequenceHeader(m.audio.Codec, 0)
+	}
+
+	if _, err := m.w.Write(m.buf); err != nil {
+		return err
+	}
+
+	m.stage++
+
+	return nil
+}
+
+// WritePacket implements av.Muxer. Annex-B video is converted to AVCC;
+// Packet.PTSOffset becomes the tag composition time.
+func (m *Muxer) WritePacket(_ context.Context, pkt av.Packet) error {
+	if m.stage != 1 {
+		return ErrHeaderNotWritten
+	}
+
+	m.buf = m.buf[:0]
+	m.lastDTS = pkt.DTS
+
+	switch {
+	case m.video != nil && pkt.Idx == m.video.Idx:
+		data := pkt.Data
+		if parser.IsAnnexBOrAVCC(data) == parser.NALUAnnexb {
+			data, _ = parser.AnnexBToAVCC(data)
+		}
+
+		m.appendVideo(m.video.Codec, data, pkt.DTS, pkt.PTSOffset, pkt.KeyFrame)
+	case m.audio != nil && pkt.Idx == m.audio.Idx:
+		m.appendAudio(m.audio.Codec, pkt.Data, pkt.DTS)
+	default:
+		return ErrStreamNotFound
+	}
+
+	_, err := m.w.Write(m.buf)
+
+	return err
+}
+
+// WriteTrailer implements av.Muxer.
+func (m *Muxer) WriteTrailer(_ context.Context) error {
+	switch m.stage {
+	case 0:
+		return ErrHeaderNotWritten
+	case 2:
+		return ErrTrailerAlreadyWritten
+	}
+
+	m.stage++
+
+	return nil
+}
+
+// WriteCodecChange implements av.CodecChanger. A new sequence header tag is
+// written for every changed stream.
+func (m *Muxer) WriteCodecChange(_ context.Context, changed []av.Stream) error {
+	if m.stage != 1 {
+		return ErrHeaderNotWritten
+	}
+
+	m.buf = m.buf[:0]
+
+	for _, stream := range changed {
+		if err := checkCodec(stream.Codec); err != nil {
+			return err
+		}
+
+		switch {
+		case m.video != nil && stream.Idx == m.video.Idx && stream.Codec.Type().IsVideo():
+			m.video.Codec = stream.Codec
+		case m.audio != nil && stream.Idx == m.audio.Idx && stream.Codec.Type().IsAudio():
+			m.audio.Codec = stream.Codec
+		default:
+			return ErrStreamNotFound
+		}
+
+		m.appendSequenceHeader(stream.Codec, m.lastDTS)
+	}
+
+	_, err := m.w.Write(m.buf)
+
+	return err
+}
+
+func checkCodec(codec av.CodecData) error {
+	switch codec.(type) {
+	case h264parser.CodecData, h265parser.CodecData, aacparser.CodecData:
+		return nil
+	}
+
+	if typ := codec.Type(); typ == av.PCM_ALAW || typ == av.PCM_MULAW {
+		return nil
+	}
+
+	return ErrUnsupportedCodec
+}
+
+// appendTag appends a tag header, body and PreviousTagSize to m.buf.
+func (m *Muxer) appendTag(typ byte, dts time.Duration, body ...[]byte) {
+	size := 0
+	for _, b := range body {
+		size += len(b)
+	}
+
+	ts := uint32(toMillis(dts))
+	hdr := make([]byte, tagHeaderLength)
+	hdr[0] = typ
+	pio.PutU24BE(hdr[1:], uint32(size))
+	pio.PutU24BE(hdr[4:], ts&0xffffff)
+	hdr[7] = byte(ts >> 24)
+
+	m.buf = append(m.buf, hdr...)
+	for _, b := range body {
+		m.buf = append(m.buf, b...)
+	}
+
+	m.buf = append(m.buf, 0, 0, 0, 0)
+	pio.PutU32BE(m.buf[len(m.buf)-4:], uint32(tagHeaderLength+size))
+}
+
+func (m *Muxer) appendSequenceHeader(codec av.CodecData, dts time.Duration) {
+	switch codec := codec.(type) {
+	case h264parser.CodecData:
+		hdr := []byte{frameTypeKey<<4 | videoCodecAVC, avcPacketSequenceHeader, 0, 0, 0}
+		m.appendTag(tagVideo, dts, hdr, codec.AVCDecoderConfRecordBytes())
+	case h265parser.CodecData:
+		hdr := append([]byte{videoExHeader | frameTypeKey<<4 | packetTypeSequenceStart}, fourCCHEVC...)
+		m.appendTag(tagVideo, dts, hdr, codec.AVCDecoderConfRecordBytes())
+	case aacparser.CodecData:
+		m.appendTag(tagAudio, dts, []byte{aacSoundFlags, aacPacketSequenceHeader}, codec.MPEG4AudioConfigBytes())
+	}
+}
+
+func (m *Muxer) appendVideo(codec av.CodecData, data []byte, dts, ptsOffset time.Duration, keyFrame bool) {
+	frameType := byte(frameTypeInter)
+	if keyFrame {
+		frameType = frameTypeKey
+	}
+
+	cts := make([]byte, 3)
+	pio.PutI24BE(cts, toMillis(ptsOffset))
+
+	if codec.Type() == av.H265 {
+		hdr := append([]byte{videoExHeader | frameType<<4 | packetTypeCodedFrames}, fourCCHEVC...)
+		m.appendTag(tagVideo, dts, hdr, cts, data)
+
+		return
+	}
+
+	m.appendTag(tagVideo, dts, []byte{frameType<<4 | videoCodecAVC, avcPacketNALU}, cts, data)
+}
+
+func (m *Muxer) appendAudio(codec av.CodecData, data []byte, dts time.Duration) {
+	switch codec.Type() {
+	case av.AAC:
+		m.appendTag(tagAudio, dts, []byte{aacSoundFlags, aacPacketRaw}, data)
+	case av.PCM_ALAW, av.PCM_MULAW:
+		flags := byte(soundFormatMulaw<<4 | soundSize16<<1)
+		if codec.Type() == av.PCM_ALAW {
+			flags = soundFormatAlaw<<4 | soundSize16<<1
+		}
+
+		if ac, ok := codec.(av.AudioCodecData); ok && ac.ChannelLayout().Count() > 1 {
+			flags |= soundStereo
+		}
+
+		m.appendTag(tagAudio, dts, []byte{flags}, data)
+	}
+}
@@ -0,0 +1,188 @@
+package flv_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/av/avutil"
+	"github.com/vtpl1/avsdk/codec/h264parser"
+	"github.com/vtpl1/avsdk/codec/h265parser"
+	"github.com/vtpl1/avsdk/codec/pcm"
+	"github.com/vtpl1/avsdk/format/flv"
+	"github.com/vtpl1/avsdk/internal/avtest"
+)
+
+func writeFile(t *testing.T, streams []av.Stream, pkts []av.Packet, change map[int][]av.Stream) []byte {
+	t.Helper()
+
+	ctx := context.Background()
+
+	var buf bytes.Buffer
+
+	mux := flv.NewMuxer(&buf)
+	if err := mux.WriteHeader(ctx, streams); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, pkt := range pkts {
+		if changed, ok := change[i]; ok {
+			if err := mux.WriteCodecChange(ctx, changed); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := mux.WritePacket(ctx, pkt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := mux.WriteTrailer(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestMuxerH264AAC(t *testing.T) {
+	ctx := context.Background()
+
+	aac := avtest.AAC(t)
+
+	streams := []av.Stream{{Idx: 0, Codec: avtest.H264(t, avtest.SPS320x192)}, {Idx: 1, Codec: aac}}
+
+	var pkts []av.Packet
+
+	for i := range 6 {
+		pkts = append(pkts,
+			av.Packet{
+				Idx:       0,
+				KeyFrame:  i%3 == 0,
+				DTS:       time.Duration(i) * 40 * time.Millisecond,
+				PTSOffset: 80 * time.Millisecond,
+				Data:      []byte{0, 0, 0, 2, 0x41, byte(i)},
+			},
+			av.Packet{Idx: 1, DTS: time.Duration(i) * 40 * time.Millisecond, Data: []byte{0x21, byte(i)}},
+		)
+	}
+
+	file := writeFile(t, streams, pkts, map[int][]av.Stream{6: {{Idx: 0, Codec: avtest.H264(t, avtest.SPS1280x720)}}})
+
+	if !flv.Probe(file) {
+		t.Fatal("Probe rejected muxer output")
+	}
+
+	dmx := flv.NewDemuxer(bytes.NewReader(file))
+
+	got, err := dmx.GetCodecs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !avutil.Equal(got, []av.Stream{{Idx: flv.VideoStreamIdx, Codec: streams[0].Codec}, {Idx: flv.AudioStreamIdx, Codec: aac}}) {
+		t.Fatalf("streams = %+v", got)
+	}
+
+	out := avtest.ReadAll(t, dmx)
+	if len(out) != len(pkts) {
+		t.Fatalf("got %d packets, want %d", len(out), len(pkts))
+	}
+
+	for i, pkt := range out {
+		want := pkts[i]
+		if pkt.Idx != want.Idx || pkt.DTS != want.DTS || pkt.PTSOffset != want.PTSOffset ||
+			pkt.KeyFrame != (want.KeyFrame || want.Idx == 1) || !bytes.Equal(pkt.Data, want.Data) {
+			t.Fatalf("packet %d = %v, want %v", i, pkt.String(), want.String())
+		}
+
+		if (pkt.NewCodecs != nil) != (i == 6) {
+			t.Fatalf("packet %d: NewCodecs = %+v", i, pkt.NewCodecs)
+		}
+	}
+
+	if w := out[6].NewCodecs[0].Codec.(h264parser.CodecData).Width(); w != 1280 { //nolint:forcetypeassert
+		t.Fatalf("changed width = %d, want 1280", w)
+	}
+}
+
+func TestMuxerHEVCG711(t *testing.T) {
+	ctx := context.Background()
+	hevc := avtest.H265(t)
+	streams := []av.Stream{{Idx: 0, Codec: hevc}, {Idx: 1, Codec: pcm.NewPCMMulawCodecData()}}
+	pkts := []av.Packet{
+		{Idx: 0, KeyFrame: true, PTSOffset: 40 * time.Millisecond, Data: []byte{0, 0, 0, 3, 0x26, 0x01, 0xaf}},
+		{Idx: 1, DTS: 20 * time.Millisecond, Data: bytes.Repeat([]byte{0xff}, 160)},
+		{Idx: 0, DTS: 40 * time.Millisecond, Data: []byte{0, 0, 0, 3, 0x02, 0x01, 0xd0}},
+	}
+
+	dmx := flv.NewDemuxer(bytes.NewReader(writeFile(t, streams, pkts, nil)))
+
+	got, err := dmx.GetCodecs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != 2 || got[0].Codec.Type() != av.H265 || got[1].Codec.Type() != av.PCM_MULAW {
+		t.Fatalf("streams = %+v", got)
+	}
+
+	if !bytes.Equal(got[0].Codec.(h265parser.CodecData).SPS(), hevc.SPS()) { //nolint:forcetypeassert
+		t.Fatal("HEVC SPS mismatch")
+	}
+
+	out := avtest.ReadAll(t, dmx)
+	if len(out) != len(pkts) {
+		t.Fatalf("got %d packets, want %d", len(out), len(pkts))
+	}
+
+	if out[0].PTSOffset != 40*time.Millisecond || !out[0].KeyFrame || out[2].KeyFrame {
+		t.Fatalf("unexpected video packets %v, %v", out[0].String(), out[2].String())
+	}
+
+	if out[1].Duration != 20*time.Millisecond || len(out[1].Data) != 160 {
+		t.Fatalf("unexpected audio packet %v", out[1].String())
+	}
+}
+
+func TestHandler(t *testing.T) {
+	ctx := context.Background()
+
+	aac := avtest.AAC(t)
+
+	file := writeFile(t, []av.Stream{{Idx: 0, Codec: aac}}, []av.Packet{{Idx: 0, Data: []byte{0x21, 0}}}, nil)
+
+	handlers := &avutil.Handlers{}
+	handlers.Add(flv.Handler)
+
+	dir := t.TempDir()
+
+	// Opened by extension, and by probing content behind an unknown extension.
+	for _, name := range []string{"a.flv", "a.bin"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, file, 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		dmx, err := handlers.Open(path)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+
+		streams, err := dmx.GetCodecs(ctx)
+		if err != nil || len(streams) != 1 || streams[0].Codec.Type() != av.AAC {
+			t.Fatalf("%s: streams = %+v, err = %v", name, streams, err)
+		}
+
+		if pkts := avtest.ReadAll(t, dmx); len(pkts) != 1 {
+			t.Fatalf("%s: got %d packets", name, len(pkts))
+		}
+
+		if err := dmx.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package fmp4
+
+import (
+	"github.com/vtpl1/avsdk/utils/bits/pio"
+)
+
+// boxWriter builds ISO BMFF boxes into a growing byte slice.
+// start/end pairs reserve and back-patch the 32-bit box size so nested boxes
+// can be written without computing their lengths up front.
+type boxWriter struct {
+	buf []byte
+}
+
+func (b *boxWriter) start(typ string) int {
+	off := len(b.buf)
+	b.u32(0)
+	b.buf = append(b.buf, typ[:4]...)
+
+	return off
+}
+
+func (b *boxWriter) startFull(typ string, version uint8, flags uint32) int {
+	off := b.start(typ)
+	b.u32(uint32(version)<<24 | flags&0xffffff)
+
+	return off
+}
+
+func (b *boxWriter) end(off int) {
+	pio.PutU32BE(b.buf[off:], uint32(len(b.buf)-off))
+}
+
+func (b *boxWriter) u8(v uint8) {
+	b.buf = append(b.buf, v)
+}
+
+func (b *boxWriter) u16(v uint16) {
+	b.buf = append(b.buf, byte(v>>8), byte(v))
+}
+
+func (b *boxWriter) u24(v uint32) {
+	b.buf = append(b.buf, byte(v>>16), byte(v>>8), byte(v))
+}
+
+func (b *boxWriter) u32(v uint32) {
+	b.buf = append(b.buf, byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+}
+
+func (b *boxWriter) u64(v uint64) {
+	b.u32(uint32(v >> 32))
+	b.u32(uint32(v))
+}
+
+func (b *boxWriter) zeros(n int) {
+	for range n {
+		b.buf = append(b.buf, 0)
+	}
+}
+
+func (b *boxWriter) bytes(p []byte) {
+	b.buf = append(b.buf, p...)
+}
+
+func (b *boxWriter) str(s string) {
+	b.buf = append(b.buf, s...)
+}
+
+// matrix writes the unity transformation matrix used by mvhd and tkhd.
+func (b *boxWriter) matrix() {
+	for _, v := range [9]uint32{0x00010000, 0, 0, 0, 0x00010000, 0, 0, 0, 0x40000000} {
+		b.u32(v)
+	}
+}
+
+// descriptor writes an MPEG-4 descriptor tag followed by its size in the
+// variable-length encoding of ISO/IEC 14496-1 §8.3.3.
+func (b *boxWriter) descriptor(tag uint8, size int) {
+	b.u8(tag)
+
+	var lens []byte
+	for {
+		lens = append([]byte{byte(size & 0x7f)}, lens...)
+		size >>= 7
+
+		if size == 0 {
+			break
+		}
+	}
+
+	for i := range len(lens) - 1 {
+		lens[i] |= 0x80
+	}
+
+	b.bytes(lens)
+}
@@ -0,0 +1,13 @@
+package fmp4
+
+import "errors"
+
+var (
+	ErrHeaderNotWritten      = errors.New("fmp4: WriteHeader not called")
+	ErrHeaderAlreadyWritten  = errors.New("fmp4: WriteHeader already called")
+	ErrTrailerAlreadyWritten = errors.New("fmp4: WriteTrailer already called")
+	ErrNoStreams             = errors.New("fmp4: no streams")
+	ErrUnsupportedCodec      = errors.New("fmp4: unsupported codec")
+	ErrStreamNotFound        = errors.New("fmp4: stream not found")
+	ErrDuplicateStream       = errors.New("fmp4: duplicate stream index")
+)
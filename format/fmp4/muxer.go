@@ -0,0 +1,616 @@
+// Package fmp4 implements a fragmented MP4 (ISO BMFF / CMAF) muxer.
+//
+// The output is an init segment (ftyp+moov) followed by one moof+mdat fragment
+// per GOP. A codec change starts a new init segment in the same output.
+package fmp4
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"time"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/codec/aacparser"
+	"github.com/vtpl1/avsdk/codec/h264parser"
+	"github.com/vtpl1/avsdk/codec/h265parser"
+	"github.com/vtpl1/avsdk/codec/parser"
+	"github.com/vtpl1/avsdk/internal/ticks"
+	"github.com/vtpl1/avsdk/utils/bits/pio"
+)
+
+// maxAudioFragment bounds fragment length when there is no video track to
+// provide GOP boundaries.
+const maxAudioFragment = time.Second
+
+// Sample flags (ISO/IEC 14496-12 §8.8.3.1).
+const (
+	sampleFlagsSync    = 0x02000000 // sample_depends_on=2 (does not depend on others)
+	sampleFlagsNonSync = 0x01010000 // sample_depends_on=1, sample_is_non_sync_sample=1
+)
+
+// trun flags (ISO/IEC 14496-12 §8.8.8.1).
+const (
+	trunDataOffsetPresent     = 0x000001
+	trunSampleDurationPresent = 0x000100
+	trunSampleSizePresent     = 0x000200
+	trunSampleFlagsPresent    = 0x000400
+	trunSampleCTOPresent      = 0x000800
+	tfhdDefaultBaseIsMoof     = 0x020000
+)
+
+type sample struct {
+	dts       time.Duration
+	duration  time.Duration
+	ptsOffset time.Duration
+	keyFrame  bool
+	data      []byte
+}
+
+type track struct {
+	id        uint32
+	stream    av.Stream
+	timeScale uint32
+	samples   []sample
+	lastDur   uint32
+}
+
+// Muxer writes fragmented MP4. It implements av.Muxer and av.CodecChanger.
+type Muxer struct {
+	w          io.Writer
+	tracks     []*track
+	trackByIdx map[uint16]*track
+	hasVideo   bool
+	seqNum     uint32
+	stage      int
+}
+
+// NewMuxer returns a Muxer writing to w.
+func NewMuxer(w io.Writer) *Muxer {
+	return &Muxer{
+		w:          w,
+		trackByIdx: make(map[uint16]*track),
+	}
+}
+
+// WriteHeader implements av.Muxer. It writes the ftyp and moov boxes.
+func (m *Muxer) WriteHeader(_ context.Context, streams []av.Stream) error {
+	if m.stage != 0 {
+		return ErrHeaderAlreadyWritten
+	}
+
+	if len(streams) == 0 {
+		return ErrNoStreams
+	}
+
+	for _, stream := range streams {
+		if _, ok := m.trackByIdx[stream.Idx]; ok {
+			return ErrDuplicateStream
+		}
+
+		// track_ID 0 is reserved, so Stream.Idx maps to track_ID Idx+1.
+		t := &track{id: uint32(stream.Idx) + 1}
+		if err := t.setCodec(stream); err != nil {
+			return err
+		}
+
+		if stream.Codec.Type().IsVideo() {
+			m.hasVideo = true
+		}
+
+		m.tracks = append(m.tracks, t)
+		m.trackByIdx[stream.Idx] = t
+	}
+
+	if err := m.writeInit(); err != nil {
+		return err
+	}
+
+	m.stage++
+
+	return nil
+}
+
+// WritePacket implements av.Muxer. Packets are buffered until the next video
+// keyframe (or maxAudioFragment for audio-only output) and then written as one fragment.
+// pkt.Data is copied, so the caller may reuse it.
+func (m *Muxer) WritePacket(_ context.Context, pkt av.Packet) error {
+	if m.stage != 1 {
+		return ErrHeaderNotWritten
+	}
+
+	t, ok := m.trackByIdx[pkt.Idx]
+	if !ok {
+		return ErrStreamNotFound
+	}
+
+	if m.cutBefore(t, pkt) {
+		if err := m.flush(); err != nil {
+			return err
+		}
+	}
+
+	// Samples are held until the next fragment, so they must not share the
+	// caller's buffer.
+	data := pkt.Data
+	if t.stream.Codec.Type().IsVideo() && parser.IsAnnexBOrAVCC(data) == parser.NALUAnnexb {
+		data, _ = parser.AnnexBToAVCC(data)
+	} else {
+		data = bytes.Clone(data)
+	}
+
+	t.samples = append(t.samples, sample{
+		dts:       pkt.DTS,
+		duration:  pkt.Duration,
+		ptsOffset: pkt.PTSOffset,
+		keyFrame:  pkt.KeyFrame || t.stream.Codec.Type().IsAudio(),
+		data:      data,
+	})
+
+	return nil
+}
+
+// WriteTrailer implements av.Muxer. It flushes the last pending fragment.
+func (m *Muxer) WriteTrailer(_ context.Context) error {
+	switch m.stage {
+	case 0:
+		return ErrHeaderNotWritten
+	case 2:
+		return ErrTrailerAlreadyWritten
+	}
+
+	m.stage++
+
+	return m.flush()
+}
+
+// WriteCodecChange implements av.CodecChanger. Pending samples are flushed with
+// the old configuration, then a new init segment is written.
+func (m *Muxer) WriteCodecChange(_ context.Context, changed []av.Stream) error {
+	if m.stage != 1 {
+		return ErrHeaderNotWritten
+	}
+
+	if err := m.flush(); err != nil {
+		return err
+	}
+
+	for _, stream := range changed {
+		t, ok := m.trackByIdx[stream.Idx]
+		if !ok {
+			return ErrStreamNotFound
+		}
+
+		if err := t.setCodec(stream); err != nil {
+			return err
+		}
+	}
+
+	return m.writeInit()
+}
+
+// Flush writes all buffered samples as a fragment without waiting for the next
+// keyframe. Packagers use it to cut segments or partial segments on their own cadence.
+func (m *Muxer) Flush(_ context.Context) error {
+	if m.stage != 1 {
+		return ErrHeaderNotWritten
+	}
+
+	return m.flush()
+}
+
+func (t *track) setCodec(stream av.Stream) error {
+	switch codec := stream.Codec.(type) {
+	case h264parser.CodecData:
+		t.timeScale = codec.TimeScale()
+	case h265parser.CodecData:
+		t.timeScale = codec.TimeScale()
+	case aacparser.CodecData:
+		t.timeScale = uint32(codec.SampleRate())
+	default:
+		return ErrUnsupportedCodec
+	}
+
+	if t.timeScale == 0 {
+		return ErrUnsupportedCodec
+	}
+
+	t.stream = stream
+
+	return nil
+}
+
+func (m *Muxer) cutBefore(t *track, pkt av.Packet) bool {
+	if m.hasVideo {
+		return pkt.KeyFrame && t.stream.Codec.Type().IsVideo() && len(t.samples) > 0
+	}
+
+	return len(t.samples) > 0 && pkt.DTS-t.samples[0].dts >= maxAudioFragment
+}
+
+func (m *Muxer) writeInit() error {
+	b := &boxWriter{}
+	m.marshalFtyp(b)
+	m.marshalMoov(b)
+
+	_, err := m.w.Write(b.buf)
+
+	return err
+}
+
+func (m *Muxer) flush() error {
+	pending := false
+
+	for _, t := range m.tracks {
+		if len(t.samples) > 0 {
+			pending = true
+
+			break
+		}
+	}
+
+	if !pending {
+		return nil
+	}
+
+	m.seqNum++
+
+	b := &boxWriter{}
+	m.marshalFragment(b)
+
+	for _, t := range m.tracks {
+		t.samples = t.samples[:0]
+	}
+
+	_, err := m.w.Write(b.buf)
+
+	return err
+}
+
+func (m *Muxer) marshalFtyp(b *boxWriter) {
+	off := b.start("ftyp")
+	b.str("iso5")
+	b.u32(512)
+
+	for _, brand := range []string{"iso5", "iso6", "mp41", "cmfc"} {
+		b.str(brand)
+	}
+
+	b.end(off)
+}
+
+func (m *Muxer) marshalMoov(b *boxWriter) {
+	moov := b.start("moov")
+
+	mvhd := b.startFull("mvhd", 0, 0)
+	b.u32(0)    // creation_time
+	b.u32(0)    // modification_time
+	b.u32(1000) // timescale
+	b.u32(0)    // duration
+	b.u32(0x00010000)
+	b.u16(0x0100)
+	b.zeros(10)
+	b.matrix()
+	b.zeros(24)
+	nextTrackID := uint32(1)
+	for _, t := range m.tracks {
+		nextTrackID = max(nextTrackID, t.id+1)
+	}
+
+	b.u32(nextTrackID)
+	b.end(mvhd)
+
+	for _, t := range m.tracks {
+		t.marshalTrak(b)
+	}
+
+	mvex := b.start("mvex")
+
+	for _, t := range m.tracks {
+		trex := b.startFull("trex", 0, 0)
+		b.u32(t.id)
+		b.u32(1) // default_sample_description_index
+		b.u32(0) // default_sample_duration
+		b.u32(0) // default_sample_size
+		b.u32(0) // default_sample_flags
+		b.end(trex)
+	}
+
+	b.end(mvex)
+	b.end(moov)
+}
+
+func (t *track) marshalTrak(b *boxWriter) {
+	isVideo := t.stream.Codec.Type().IsVideo()
+
+	trak := b.start("trak")
+
+	tkhd := b.startFull("tkhd", 0, 0x000003) // track_enabled | track_in_movie
+	b.u32(0)                                 // creation_time
+	b.u32(0)                                 // modification_time
+	b.u32(t.id)
+	b.u32(0) // reserved
+	b.u32(0) // duration
+	b.zeros(8)
+	b.u16(0) // layer
+	b.u16(0) // alternate_group
+
+	if isVideo {
+		b.u16(0)
+	} else {
+		b.u16(0x0100)
+	}
+
+	b.u16(0)
+	b.matrix()
+
+	if vc, ok := t.stream.Codec.(av.VideoCodecData); ok {
+		b.u32(uint32(vc.Width()) << 16)
+		b.u32(uint32(vc.Height()) << 16)
+	} else {
+		b.u32(0)
+		b.u32(0)
+	}
+
+	b.end(tkhd)
+
+	mdia := b.start("mdia")
+
+	mdhd := b.startFull("mdhd", 0, 0)
+	b.u32(0) // creation_time
+	b.u32(0) // modification_time
+	b.u32(t.timeScale)
+	b.u32(0)      // duration
+	b.u16(0x55c4) // language "und"
+	b.u16(0)
+	b.end(mdhd)
+
+	hdlr := b.startFull("hdlr", 0, 0)
+	b.u32(0)
+
+	if isVideo {
+		b.str("vide")
+		b.zeros(12)
+		b.str("VideoHandler\x00")
+	} else {
+		b.str("soun")
+		b.zeros(12)
+		b.str("SoundHandler\x00")
+	}
+
+	b.end(hdlr)
+
+	minf := b.start("minf")
+
+	if isVideo {
+		vmhd := b.startFull("vmhd", 0, 1)
+		b.zeros(8)
+		b.end(vmhd)
+	} else {
+		smhd := b.startFull("smhd", 0, 0)
+		b.zeros(4)
+		b.end(smhd)
+	}
+
+	dinf := b.start("dinf")
+	dref := b.startFull("dref", 0, 0)
+	b.u32(1)
+	url := b.startFull("url ", 0, 1) // media data is in the same file
+	b.end(url)
+	b.end(dref)
+	b.end(dinf)
+
+	stbl := b.start("stbl")
+	stsd := b.startFull("stsd", 0, 0)
+	b.u32(1)
+	t.marshalSampleEntry(b)
+	b.end(stsd)
+
+	for _, typ := range []string{"stts", "stsc", "stco"} {
+		off := b.startFull(typ, 0, 0)
+		b.u32(0)
+		b.end(off)
+	}
+
+	stsz := b.startFull("stsz", 0, 0)
+	b.u32(0) // sample_size
+	b.u32(0) // sample_count
+	b.end(stsz)
+	b.end(stbl)
+
+	b.end(minf)
+	b.end(mdia)
+	b.end(trak)
+}
+
+func (t *track) marshalSampleEntry(b *boxWriter) {
+	switch codec := t.stream.Codec.(type) {
+	case h264parser.CodecData:
+		marshalVisualSampleEntry(b, "avc1", "avcC", codec, codec.AVCDecoderConfRecordBytes())
+	case h265parser.CodecData:
+		marshalVisualSampleEntry(b, "hvc1", "hvcC", codec, codec.AVCDecoderConfRecordBytes())
+	case aacparser.CodecData:
+		marshalMP4A(b, codec)
+	}
+}
+
+func marshalVisualSampleEntry(b *boxWriter, typ, confTyp string, codec av.VideoCodecData, record []byte) {
+	entry := b.start(typ)
+	b.zeros(6)
+	b.u16(1) // data_reference_index
+	b.zeros(16)
+	b.u16(uint16(codec.Width()))
+	b.u16(uint16(codec.Height()))
+	b.u32(0x00480000) // horizresolution 72 dpi
+	b.u32(0x00480000) // vertresolution 72 dpi
+	b.u32(0)
+	b.u16(1) // frame_count
+	b.zeros(32)
+	b.u16(0x0018) // depth
+	b.u16(0xffff) // pre_defined = -1
+
+	conf := b.start(confTyp)
+	b.bytes(record)
+	b.end(conf)
+	b.end(entry)
+}
+
+func marshalMP4A(b *boxWriter, codec aacparser.CodecData) {
+	config := codec.MPEG4AudioConfigBytes()
+
+	entry := b.start("mp4a")
+	b.zeros(6)
+	b.u16(1) // data_reference_index
+	b.zeros(8)
+	b.u16(uint16(codec.ChannelLayout().Count()))
+	b.u16(16) // samplesize
+	b.zeros(4)
+	b.u32(uint32(codec.SampleRate()) << 16)
+
+	decSpecific := &boxWriter{}
+	decSpecific.descriptor(0x05, len(config)) // DecSpecificInfoTag
+	decSpecific.bytes(config)
+
+	decConfig := &boxWriter{}
+	decConfig.u8(0x40) // objectTypeIndication: Audio ISO/IEC 14496-3
+	decConfig.u8(0x15) // streamType=5 (audio), upStream=0, reserved=1
+	decConfig.u24(0)   // bufferSizeDB
+	decConfig.u32(0)   // maxBitrate
+	decConfig.u32(0)   // avgBitrate
+	decConfig.bytes(decSpecific.buf)
+
+	es := &boxWriter{}
+	es.u16(0)                               // ES_ID
+	es.u8(0)                                // flags
+	es.descriptor(0x04, len(decConfig.buf)) // DecoderConfigDescrTag
+	es.bytes(decConfig.buf)
+	es.descriptor(0x06, 1) // SLConfigDescrTag
+	es.u8(0x02)            // predefined: reserved for use in MP4 files
+
+	esds := b.startFull("esds", 0, 0)
+	b.descriptor(0x03, len(es.buf)) // ES_DescrTag
+	b.bytes(es.buf)
+	b.end(esds)
+	b.end(entry)
+}
+
+func (m *Muxer) marshalFragment(b *boxWriter) {
+	moof := b.start("moof")
+
+	mfhd := b.startFull("mfhd", 0, 0)
+	b.u32(m.seqNum)
+	b.end(mfhd)
+
+	var dataOffsets []int
+
+	for _, t := range m.tracks {
+		if len(t.samples) == 0 {
+			continue
+		}
+
+		dataOffsets = append(dataOffsets, t.marshalTraf(b))
+	}
+
+	b.end(moof)
+
+	mdat := b.start("mdat")
+	i := 0
+
+	for _, t := range m.tracks {
+		if len(t.samples) == 0 {
+			continue
+		}
+
+		pio.PutU32BE(b.buf[dataOffsets[i]:], uint32(len(b.buf)-moof))
+		i++
+
+		for _, s := range t.samples {
+			b.bytes(s.data)
+		}
+	}
+
+	b.end(mdat)
+}
+
+// marshalTraf writes the traf box for t and returns the offset of the trun
+// data_offset field, which is patched once the mdat position is known.
+func (t *track) marshalTraf(b *boxWriter) int {
+	traf := b.start("traf")
+
+	tfhd := b.startFull("tfhd", 0, tfhdDefaultBaseIsMoof)
+	b.u32(t.id)
+	b.end(tfhd)
+
+	base := ticks.FromDuration(t.samples[0].dts, int64(t.timeScale))
+	if base < 0 {
+		base = 0
+	}
+
+	tfdt := b.startFull("tfdt", 1, 0)
+	b.u64(uint64(base))
+	b.end(tfdt)
+
+	flags := uint32(trunDataOffsetPresent | trunSampleDurationPresent | trunSampleSizePresent | trunSampleFlagsPresent)
+	if t.stream.Codec.Type().IsVideo() {
+		flags |= trunSampleCTOPresent
+	}
+
+	trun := b.startFull("trun", 1, flags)
+	b.u32(uint32(len(t.samples)))
+	dataOffset := len(b.buf)
+	b.u32(0)
+
+	for i, s := range t.samples {
+		b.u32(t.sampleDuration(i))
+		b.u32(uint32(len(s.data)))
+
+		if s.keyFrame {
+			b.u32(sampleFlagsSync)
+		} else {
+			b.u32(sampleFlagsNonSync)
+		}
+
+		if flags&trunSampleCTOPresent != 0 {
+			b.u32(uint32(int32(ticks.FromDuration(s.ptsOffset, int64(t.timeScale)))))
+		}
+	}
+
+	b.end(trun)
+	b.end(traf)
+
+	return dataOffset
+}
+
+// sampleDuration returns the duration in track ticks of the i-th buffered sample.
+// It is derived from the next sample's DTS when available, otherwise from
+// Packet.Duration, the audio frame duration, or the previous sample's duration.
+func (t *track) sampleDuration(i int) uint32 {
+	s := t.samples[i]
+
+	var dur int64
+
+	switch {
+	case i+1 < len(t.samples):
+		dur = ticks.FromDuration(t.samples[i+1].dts, int64(t.timeScale)) - ticks.FromDuration(s.dts, int64(t.timeScale))
+	case s.duration > 0:
+		dur = ticks.FromDuration(s.dts+s.duration, int64(t.timeScale)) - ticks.FromDuration(s.dts, int64(t.timeScale))
+	default:
+		if ac, ok := t.stream.Codec.(av.AudioCodecData); ok {
+			if d, err := ac.PacketDuration(s.data); err == nil {
+				dur = ticks.FromDuration(d, int64(t.timeScale))
+			}
+		}
+
+		if dur <= 0 {
+			dur = int64(t.lastDur)
+		}
+	}
+
+	if dur < 0 {
+		dur = 0
+	}
+
+	t.lastDur = uint32(dur)
+
+	return t.lastDur
+}
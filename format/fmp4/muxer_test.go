@@ -0,0 +1,186 @@
+package fmp4_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/codec/pcm"
+	"github.com/vtpl1/avsdk/format/fmp4"
+	"github.com/vtpl1/avsdk/internal/avtest"
+)
+
+func testStreams(t *testing.T) []av.Stream {
+	t.Helper()
+
+	h264 := avtest.H264(t, avtest.SPS320x192)
+
+	aac := avtest.AAC(t)
+
+	return []av.Stream{{Idx: 0, Codec: h264}, {Idx: 1, Codec: aac}}
+}
+
+func topLevelBoxes(t *testing.T, b []byte) []string {
+	t.Helper()
+
+	var types []string
+
+	for len(b) >= 8 {
+		size := int(binary.BigEndian.Uint32(b))
+		if size < 8 || size > len(b) {
+			t.Fatalf("invalid box size %d", size)
+		}
+
+		types = append(types, string(b[4:8]))
+		b = b[size:]
+	}
+
+	if len(b) != 0 {
+		t.Fatalf("%d trailing bytes", len(b))
+	}
+
+	return types
+}
+
+func TestMuxer(t *testing.T) {
+	ctx := context.Background()
+	streams := testStreams(t)
+
+	var buf bytes.Buffer
+
+	m := fmp4.NewMuxer(&buf)
+	if err := m.WriteHeader(ctx, streams); err != nil {
+		t.Fatal(err)
+	}
+
+	frame := []byte{0, 0, 0, 2, 0x65, 0x88}
+	for i := range 6 {
+		pkt := av.Packet{
+			Idx:       0,
+			KeyFrame:  i%3 == 0,
+			DTS:       time.Duration(i) * 40 * time.Millisecond,
+			PTSOffset: 40 * time.Millisecond,
+			CodecType: av.H264,
+			Data:      frame,
+		}
+		if err := m.WritePacket(ctx, pkt); err != nil {
+			t.Fatal(err)
+		}
+
+		audio := av.Packet{Idx: 1, DTS: pkt.DTS, CodecType: av.AAC, Data: []byte{0x21, 0x10}}
+		if err := m.WritePacket(ctx, audio); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := m.WriteCodecChange(ctx, streams[:1]); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.WriteTrailer(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.WriteTrailer(ctx); err == nil {
+		t.Fatal("expected error on second WriteTrailer")
+	}
+
+	got := topLevelBoxes(t, buf.Bytes())
+	want := []string{"ftyp", "moov", "moof", "mdat", "moof", "mdat", "ftyp", "moov"}
+
+	if len(got) != len(want) {
+		t.Fatalf("boxes = %v, want %v", got, want)
+	}
+
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("boxes = %v, want %v", got, want)
+		}
+	}
+
+	idx := bytes.Index(buf.Bytes(), []byte("tfdt"))
+	for range 2 {
+		idx += bytes.Index(buf.Bytes()[idx+4:], []byte("tfdt")) + 4
+	}
+
+	// Third tfdt is the video track of the second fragment: DTS 120 ms at 90 kHz.
+	if got := binary.BigEndian.Uint64(buf.Bytes()[idx+8:]); got != 10800 {
+		t.Fatalf("baseMediaDecodeTime = %d, want 10800", got)
+	}
+}
+
+func TestMuxerLongRunning(t *testing.T) {
+	ctx := context.Background()
+	streams := testStreams(t)[:1]
+
+	var buf bytes.Buffer
+
+	m := fmp4.NewMuxer(&buf)
+	if err := m.WriteHeader(ctx, streams); err != nil {
+		t.Fatal(err)
+	}
+
+	start := 30 * time.Hour
+	for i := range 2 {
+		pkt := av.Packet{Idx: 0, KeyFrame: i == 0, DTS: start + time.Duration(i)*40*time.Millisecond, Data: []byte{0, 0, 0, 2, 0x65, 0x88}}
+		if err := m.WritePacket(ctx, pkt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := m.WriteTrailer(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	b := buf.Bytes()
+	idx := bytes.Index(b, []byte("tfdt"))
+
+	if got := binary.BigEndian.Uint64(b[idx+8:]); got != 30*3600*90000 {
+		t.Fatalf("baseMediaDecodeTime = %d, want %d", got, 30*3600*90000)
+	}
+
+	// trun: flags, sample_count, data_offset, then duration of the first sample.
+	idx = bytes.Index(b, []byte("trun"))
+	if got := binary.BigEndian.Uint32(b[idx+16:]); got != 3600 {
+		t.Fatalf("sample duration = %d, want 3600", got)
+	}
+}
+
+func TestMuxerReusedBuffer(t *testing.T) {
+	ctx := context.Background()
+
+	var buf bytes.Buffer
+
+	m := fmp4.NewMuxer(&buf)
+	if err := m.WriteHeader(ctx, testStreams(t)[1:]); err != nil {
+		t.Fatal(err)
+	}
+
+	data := []byte{0x21, 0x10}
+	if err := m.WritePacket(ctx, av.Packet{Idx: 1, Data: data}); err != nil {
+		t.Fatal(err)
+	}
+
+	data[0] = 0xff
+
+	if err := m.WriteTrailer(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.HasSuffix(buf.Bytes(), []byte{0x21, 0x10}) {
+		t.Fatalf("mdat ends with %x, want the data as written", buf.Bytes()[buf.Len()-2:])
+	}
+}
+
+func TestMuxerUnsupportedCodec(t *testing.T) {
+	m := fmp4.NewMuxer(&bytes.Buffer{})
+
+	err := m.WriteHeader(context.Background(), []av.Stream{{Codec: pcm.NewPCMAlawCodecData()}})
+	if !errors.Is(err, fmp4.ErrUnsupportedCodec) {
+		t.Fatalf("err = %v, want ErrUnsupportedCodec", err)
+	}
+}
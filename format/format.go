@@ -0,0 +1,45 @@
+// Package format registers the container formats of this module with avutil.
+package format
+
+import (
+	"github.com/vtpl1/avsdk/av/avutil"
+	"github.com/vtpl1/avsdk/format/aac"
+	"github.com/vtpl1/avsdk/format/annexb"
+	"github.com/vtpl1/avsdk/format/flv"
+	"github.com/vtpl1/avsdk/format/hls"
+	"github.com/vtpl1/avsdk/format/ivf"
+	"github.com/vtpl1/avsdk/format/mkv"
+	"github.com/vtpl1/avsdk/format/mp4"
+	"github.com/vtpl1/avsdk/format/mpjpeg"
+	"github.com/vtpl1/avsdk/format/ogg"
+	"github.com/vtpl1/avsdk/format/wav"
+)
+
+// RegisterAll adds every format handler to avutil.DefaultHandlers.
+func RegisterAll() {
+	avutil.DefaultHandlers.Add(flv.Handler)
+	avutil.DefaultHandlers.Add(aac.Handler)
+	avutil.DefaultHandlers.Add(wav.Handler)
+	avutil.DefaultHandlers.Add(ivf.Handler)
+	avutil.DefaultHandlers.Add(hls.Handler)
+
+	for _, handler := range annexb.Handlers() {
+		avutil.DefaultHandlers.Add(handler)
+	}
+
+	for _, handler := range mkv.Handlers() {
+		avutil.DefaultHandlers.Add(handler)
+	}
+
+	for _, handler := range mp4.Handlers() {
+		avutil.DefaultHandlers.Add(handler)
+	}
+
+	for _, handler := range ogg.Handlers() {
+		avutil.DefaultHandlers.Add(handler)
+	}
+
+	for _, handler := range mpjpeg.Handlers() {
+		avutil.DefaultHandlers.Add(handler)
+	}
+}
@@ -0,0 +1,443 @@
+package hls
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/av/avutil"
+	"github.com/vtpl1/avsdk/format/internal/pes"
+	"github.com/vtpl1/avsdk/format/mp4"
+	"github.com/vtpl1/avsdk/format/ts"
+	"github.com/vtpl1/avsdk/internal/ticks"
+)
+
+// maxDownloadSize bounds the size of a playlist or segment.
+const maxDownloadSize = 64 << 20
+
+// liveStartSegments is how many segments from the end of a live playlist
+// playback starts.
+const liveStartSegments = 3
+
+// DemuxerOption configures a Demuxer.
+type DemuxerOption func(*Demuxer)
+
+// WithHTTPClient sets the client used for all requests. The default is
+// http.DefaultClient.
+func WithHTTPClient(client *http.Client) DemuxerOption {
+	return func(m *Demuxer) {
+		m.client = client
+	}
+}
+
+// WithVariantSelector sets the function choosing a variant of a master
+// playlist; it returns an index into variants. The default picks the highest
+// bandwidth.
+func WithVariantSelector(selector func(variants []Variant) int) DemuxerOption {
+	return func(m *Demuxer) {
+		m.selector = selector
+	}
+}
+
+// Demuxer reads an HLS stream over HTTP. Given a master playlist it selects a
+// variant; it then downloads the MPEG-TS or fragmented MP4 segments of the
+// media playlist in order, reloading live playlists as they grow.
+//
+// Packets keep the timestamps and stream indices of the segments; the 33-bit
+// timestamps of MPEG-TS segments are unwrapped across segment boundaries up to
+// the next discontinuity. The first packet of a segment marked
+// EXT-X-DISCONTINUITY, or following segments that expired before they could
+// be fetched, has IsDiscontinuity set, and codec changes between segments are
+// reported through Packet.NewCodecs.
+// It implements av.DemuxCloser.
+type Demuxer struct {
+	uri      string
+	client   *http.Client
+	selector func([]Variant) int
+
+	mediaURL   *url.URL
+	target     time.Duration
+	queue      []mediaSegment
+	nextSeq    uint64
+	loaded     bool
+	ended      bool
+	lastReload time.Time
+	inits      map[string][]byte
+
+	inner     av.Demuxer
+	streams   []av.Stream
+	segStart  bool
+	segDisc   bool
+	newCodecs []av.Stream
+	frameID   int64
+	unchanged bool
+
+	// MPEG-TS timestamps are unwrapped per segment by its ts.Demuxer; these
+	// carry the timeline from one segment to the next, in 90 kHz ticks.
+	tsOffset map[uint16]int64 // added to the DTS of a stream in the current segment
+	tsLast   map[uint16]int64 // last DTS of a stream, offset applied
+	tsSeen   map[uint16]bool  // streams with a packet in the current segment
+	tsPrev   int64            // last DTS of any stream, offset applied
+}
+
+// NewDemuxer returns a Demuxer for the master or media playlist at uri.
+func NewDemuxer(uri string, opts ...DemuxerOption) *Demuxer {
+	m := &Demuxer{
+		uri:    uri,
+		client: http.DefaultClient,
+		selector: func(variants []Variant) int {
+			best := 0
+			for i, v := range variants {
+				if v.Bandwidth > variants[best].Bandwidth {
+					best = i
+				}
+			}
+
+			return best
+		},
+		inits:    make(map[string][]byte),
+		tsOffset: make(map[uint16]int64),
+		tsLast:   make(map[uint16]int64),
+	}
+	for _, o := range opts {
+		o(m)
+	}
+
+	return m
+}
+
+// Handler registers the Demuxer for http and https URLs ending in .m3u8.
+func Handler(h *avutil.RegisterHandler) {
+	h.URLDemuxer = func(uri string) (bool, av.DemuxCloser, error) {
+		u, err := url.Parse(uri)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || path.Ext(u.Path) != ".m3u8" {
+			return false, nil, nil
+		}
+
+		return true, NewDemuxer(uri), nil
+	}
+}
+
+// GetCodecs implements av.Demuxer. It loads the playlists and the first segment.
+func (m *Demuxer) GetCodecs(ctx context.Context) ([]av.Stream, error) {
+	for m.inner == nil {
+		if err := m.openSegment(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	return m.streams, nil
+}
+
+// ReadPacket implements av.Demuxer.
+func (m *Demuxer) ReadPacket(ctx context.Context) (av.Packet, error) {
+	if _, err := m.GetCodecs(ctx); err != nil {
+		return av.Packet{}, err
+	}
+
+	for {
+		pkt, err := m.inner.ReadPacket(ctx)
+		if errors.Is(err, io.EOF) {
+			if err := m.openSegment(ctx); err != nil {
+				return av.Packet{}, err
+			}
+
+			continue
+		}
+
+		if err != nil {
+			return av.Packet{}, err
+		}
+
+		if m.tsSeen != nil {
+			m.unwrapTS(&pkt)
+		}
+
+		if m.segStart {
+			m.segStart = false
+			pkt.IsDiscontinuity = pkt.IsDiscontinuity || m.segDisc
+			pkt.NewCodecs = append(m.newCodecs, pkt.NewCodecs...)
+			m.newCodecs = nil
+
+			if len(pkt.NewCodecs) == 0 {
+				pkt.NewCodecs = nil
+			}
+		}
+
+		pkt.FrameID = m.frameID
+		m.frameID++
+
+		return pkt, nil
+	}
+}
+
+// Close implements av.DemuxCloser.
+func (m *Demuxer) Close() error {
+	m.inner = nil
+	m.queue = nil
+	m.ended = true
+
+	return nil
+}
+
+// openSegment downloads the next segment and opens a demuxer on it.
+func (m *Demuxer) openSegment(ctx context.Context) error {
+	seg, err := m.nextSegment(ctx)
+	if err != nil {
+		return err
+	}
+
+	data, err := m.get(ctx, seg.uri)
+	if err != nil {
+		return err
+	}
+
+	var inner av.Demuxer
+
+	if seg.initURI != "" {
+		init, ok := m.inits[seg.initURI]
+		if !ok {
+			if init, err = m.get(ctx, seg.initURI); err != nil {
+				return err
+			}
+
+			m.inits = map[string][]byte{seg.initURI: init}
+		}
+
+		inner = mp4.NewDemuxer(bytes.NewReader(append(init[:len(init):len(init)], data...)))
+	} else {
+		inner = ts.NewDemuxer(bytes.NewReader(data))
+	}
+
+	streams, err := inner.GetCodecs(ctx)
+	if err != nil {
+		return err
+	}
+
+	if m.streams == nil {
+		m.streams = streams
+	} else {
+		m.newCodecs = changedStreams(m.streams, streams)
+		m.streams = streams
+	}
+
+	m.inner = inner
+	m.segStart = true
+	m.segDisc = seg.discontinuity && m.frameID > 0
+
+	if seg.discontinuity {
+		clear(m.tsLast)
+	}
+
+	m.tsSeen = nil
+	if seg.initURI == "" {
+		m.tsSeen = make(map[uint16]bool)
+	}
+
+	return nil
+}
+
+// unwrapTS moves pkt from the timeline of its TS segment onto the timeline of
+// the previous segments. The first DTS of a stream in a segment is unwrapped
+// against the last DTS of that stream, or of any stream when it is new.
+func (m *Demuxer) unwrapTS(pkt *av.Packet) {
+	dts := ticks.FromDuration(pkt.DTS, pes.ClockRate)
+
+	if !m.tsSeen[pkt.Idx] {
+		m.tsSeen[pkt.Idx] = true
+
+		last, ok := m.tsLast[pkt.Idx]
+		if !ok {
+			last, ok = m.tsPrev, len(m.tsLast) > 0
+		}
+
+		m.tsOffset[pkt.Idx] = 0
+		if ok {
+			m.tsOffset[pkt.Idx] = last + pes.WrapDiff(dts, last) - dts
+		}
+	}
+
+	dts += m.tsOffset[pkt.Idx]
+	m.tsLast[pkt.Idx] = dts
+	m.tsPrev = dts
+	pkt.DTS = ticks.ToDuration(dts, pes.ClockRate)
+}
+
+// changedStreams returns the streams of next that are new or differ from prev.
+func changedStreams(prev, next []av.Stream) []av.Stream {
+	var changed []av.Stream
+
+	for _, s := range next {
+		same := false
+
+		for _, p := range prev {
+			if p.Idx == s.Idx {
+				same = avutil.Equal([]av.Stream{p}, []av.Stream{s})
+
+				break
+			}
+		}
+
+		if !same {
+			changed = append(changed, s)
+		}
+	}
+
+	return changed
+}
+
+// nextSegment returns the next segment to play, reloading a live playlist
+// until one is available.
+func (m *Demuxer) nextSegment(ctx context.Context) (mediaSegment, error) {
+	for len(m.queue) == 0 {
+		if m.ended {
+			return mediaSegment{}, io.EOF
+		}
+
+		if m.loaded {
+			// Reload after a target duration, or half of it when the last
+			// reload brought nothing new (RFC 8216 §6.3.4).
+			wait := m.target
+			if m.unchanged {
+				wait /= 2
+			}
+
+			select {
+			case <-time.After(time.Until(m.lastReload.Add(wait))):
+			case <-ctx.Done():
+				return mediaSegment{}, ctx.Err()
+			}
+		}
+
+		if err := m.reload(ctx); err != nil {
+			return mediaSegment{}, err
+		}
+	}
+
+	seg := m.queue[0]
+	m.queue = m.queue[1:]
+
+	return seg, nil
+}
+
+// reload fetches the media playlist, resolving a master playlist to one of its
+// variants, and queues the segments not seen yet. A media sequence number
+// going backwards means the server restarted the stream: playback resumes
+// from the live edge after a discontinuity.
+func (m *Demuxer) reload(ctx context.Context) error {
+	if m.mediaURL == nil {
+		u, err := url.Parse(m.uri)
+		if err != nil {
+			return err
+		}
+
+		m.mediaURL = u
+	}
+
+	media, err := m.fetchMedia(ctx)
+	if err != nil {
+		return err
+	}
+
+	segs := media.segments
+	reset := m.loaded && len(segs) > 0 && segs[len(segs)-1].seq+1 < m.nextSeq
+
+	if (!m.loaded || reset) && !media.ended && len(segs) > liveStartSegments {
+		segs = segs[len(segs)-liveStartSegments:]
+	}
+
+	gap := m.loaded && len(segs) > 0 && (reset || segs[0].seq > m.nextSeq)
+	queued := len(m.queue)
+
+	for _, seg := range segs {
+		if m.loaded && !reset && seg.seq < m.nextSeq {
+			continue
+		}
+
+		if gap {
+			// Segments expired before they could be fetched, or the
+			// numbering restarted.
+			seg.discontinuity = true
+			gap = false
+		}
+
+		m.queue = append(m.queue, seg)
+		m.nextSeq = seg.seq + 1
+	}
+
+	m.unchanged = len(m.queue) == queued
+	m.loaded = true
+	m.ended = media.ended
+	m.target = max(media.target, time.Second)
+	m.lastReload = time.Now()
+
+	return nil
+}
+
+// fetchMedia fetches the playlist at m.mediaURL. A master playlist is
+// resolved to the selected variant, which must be a media playlist.
+func (m *Demuxer) fetchMedia(ctx context.Context) (*mediaPlaylist, error) {
+	for hop := 0; ; hop++ {
+		b, err := m.get(ctx, m.mediaURL.String())
+		if err != nil {
+			return nil, err
+		}
+
+		variants, media, err := parsePlaylist(b, m.mediaURL)
+		if err != nil {
+			return nil, err
+		}
+
+		if media != nil {
+			return media, nil
+		}
+
+		if hop > 0 {
+			return nil, ErrNestedMaster
+		}
+
+		i := m.selector(variants)
+		if i < 0 || i >= len(variants) {
+			return nil, ErrNoVariants
+		}
+
+		if m.mediaURL, err = url.Parse(variants[i].URI); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (m *Demuxer) get(ctx context.Context, uri string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s: %w", uri, resp.Status, ErrBadStatus)
+	}
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxDownloadSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(b) > maxDownloadSize {
+		return nil, fmt.Errorf("%s: %w", uri, ErrTooLarge)
+	}
+
+	return b, nil
+}
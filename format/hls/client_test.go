@@ -0,0 +1,325 @@
+package hls_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/format/hls"
+	"github.com/vtpl1/avsdk/internal/avtest"
+	"github.com/vtpl1/avsdk/internal/ticks"
+)
+
+func readPackets(t *testing.T, dmx av.Demuxer, n int) []av.Packet {
+	t.Helper()
+
+	var pkts []av.Packet
+
+	for n < 0 || len(pkts) < n {
+		pkt, err := dmx.ReadPacket(context.Background())
+		if errors.Is(err, io.EOF) && n < 0 {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pkts = append(pkts, pkt)
+	}
+
+	return pkts
+}
+
+func videoOnly(pkts []av.Packet) []av.Packet {
+	var video []av.Packet
+
+	for _, pkt := range pkts {
+		if pkt.CodecType == av.H264 {
+			video = append(video, pkt)
+		}
+	}
+
+	return video
+}
+
+func TestDemuxerFMP4CodecChange(t *testing.T) {
+	ctx := context.Background()
+	mux := hls.NewMuxer(hls.NewMemFS(), hls.WithWindowSize(0))
+
+	if err := mux.WriteHeader(ctx, []av.Stream{{Idx: 0, Codec: avtest.H264(t, avtest.SPS320x192)}, {Idx: 1, Codec: avtest.AAC(t)}}); err != nil {
+		t.Fatal(err)
+	}
+
+	writeStream(t, mux, 6, func(i int) {
+		if i == 75 {
+			if err := mux.WriteCodecChange(ctx, []av.Stream{{Idx: 0, Codec: avtest.H264(t, avtest.SPS1280x720)}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+
+	if err := mux.WriteTrailer(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	dmx := hls.NewDemuxer(srv.URL + "/" + hls.DefaultMasterPlaylistName)
+	defer dmx.Close()
+
+	streams, err := dmx.GetCodecs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(streams) != 2 || streams[0].Codec.(av.VideoCodecData).Width() != 320 { //nolint:forcetypeassert
+		t.Fatalf("streams = %+v", streams)
+	}
+
+	pkts := readPackets(t, dmx, -1)
+	if len(pkts) != 6*25*3 {
+		t.Fatalf("got %d packets, want %d", len(pkts), 6*25*3)
+	}
+
+	video := videoOnly(pkts)
+	for i, pkt := range video {
+		if pkt.DTS != time.Duration(i)*40*time.Millisecond || pkt.KeyFrame != (i%25 == 0) {
+			t.Fatalf("video packet %d: %v", i, pkt.String())
+		}
+	}
+
+	for i, pkt := range pkts {
+		changed := pkt.DTS == 3*time.Second && pkt.CodecType == av.H264
+
+		if pkt.IsDiscontinuity != changed || (pkt.NewCodecs != nil) != changed {
+			t.Fatalf("packet %d: %v, NewCodecs = %+v", i, pkt.String(), pkt.NewCodecs)
+		}
+
+		if changed && (len(pkt.NewCodecs) != 1 || pkt.NewCodecs[0].Codec.(av.VideoCodecData).Width() != 1280) { //nolint:forcetypeassert
+			t.Fatalf("NewCodecs = %+v", pkt.NewCodecs)
+		}
+	}
+}
+
+func TestDemuxerTSLiveVariantSelection(t *testing.T) {
+	ctx := context.Background()
+	fsys := hls.NewMemFS()
+
+	mux := hls.NewMuxer(fsys, hls.WithFormat(hls.FormatTS), hls.WithTargetDuration(time.Second), hls.WithWindowSize(3))
+	if err := mux.WriteHeader(ctx, []av.Stream{{Idx: 0, Codec: avtest.H264(t, avtest.SPS320x192)}, {Idx: 1, Codec: avtest.AAC(t)}}); err != nil {
+		t.Fatal(err)
+	}
+
+	next := 0
+	// write adds frames up to second end, 25 fps with a keyframe every second.
+	write := func(end int) {
+		for ; next < end*25; next++ {
+			data := []byte{0, 0, 0, 3, 0x41, 0x9a, byte(next)}
+			if next%25 == 0 {
+				data[4] = 0x65
+			}
+
+			pkt := av.Packet{Idx: 0, KeyFrame: next%25 == 0, DTS: time.Duration(next) * 40 * time.Millisecond, Duration: 40 * time.Millisecond, Data: data}
+			if err := mux.WritePacket(ctx, pkt); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// Five segments are complete; playback starts three from the live edge.
+	write(6)
+
+	master := "#EXTM3U\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=100000\nlow/index.m3u8\n" +
+		"#EXT-X-STREAM-INF:BANDWIDTH=900000,RESOLUTION=320x180,CODECS=\"avc1.64000D,mp4a.40.2\"\nhigh/index.m3u8\n"
+
+	handler := http.NewServeMux()
+	handler.HandleFunc("/master.m3u8", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, master)
+	})
+	handler.Handle("/high/", http.StripPrefix("/high", mux))
+
+	srv := httptest.NewServer(handler)
+	defer srv.Close()
+
+	var offered []hls.Variant
+
+	dmx := hls.NewDemuxer(srv.URL+"/master.m3u8", hls.WithVariantSelector(func(variants []hls.Variant) int {
+		offered = variants
+
+		return 1
+	}))
+
+	pkts := readPackets(t, dmx, 75)
+	if len(offered) != 2 || offered[1].URI != srv.URL+"/high/index.m3u8" || offered[1].Width != 320 || offered[1].Codecs != "avc1.64000D,mp4a.40.2" {
+		t.Fatalf("variants = %+v", offered)
+	}
+
+	if pkts[0].DTS != 2*time.Second || !pkts[0].KeyFrame || pkts[0].IsDiscontinuity || pkts[0].Idx != 0x100 {
+		t.Fatalf("first packet: %v", pkts[0].String())
+	}
+
+	// Four more segments are written before the next reload; with a window of
+	// three, the first of them expires unseen and the gap is a discontinuity.
+	write(9)
+
+	if err := mux.WriteTrailer(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	pkts = append(pkts, readPackets(t, dmx, -1)...)
+	if len(pkts) != 6*25 {
+		t.Fatalf("got %d packets, want %d", len(pkts), 6*25)
+	}
+
+	for i, pkt := range pkts {
+		dts := 2*time.Second + time.Duration(i)*40*time.Millisecond
+		if i >= 75 {
+			dts += time.Second
+		}
+
+		if pkt.DTS != dts || pkt.IsDiscontinuity != (i == 75) {
+			t.Fatalf("packet %d: %v", i, pkt.String())
+		}
+	}
+}
+
+func TestDemuxerTSTimestampWrap(t *testing.T) {
+	ctx := context.Background()
+	fsys := hls.NewMemFS()
+
+	mux := hls.NewMuxer(fsys, hls.WithFormat(hls.FormatTS), hls.WithTargetDuration(time.Second))
+	if err := mux.WriteHeader(ctx, []av.Stream{{Idx: 0, Codec: avtest.H264(t, avtest.SPS320x192)}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The 33-bit PTS/DTS wraps one second into the stream, at the start of
+	// the second segment.
+	start := ticks.ToDuration(1<<33-90000, 90000)
+
+	for i := range 3 * 25 {
+		data := []byte{0, 0, 0, 3, 0x41, 0x9a, byte(i)}
+		if i%25 == 0 {
+			data[4] = 0x65
+		}
+
+		pkt := av.Packet{Idx: 0, KeyFrame: i%25 == 0, DTS: start + time.Duration(i)*40*time.Millisecond, Duration: 40 * time.Millisecond, Data: data}
+		if err := mux.WritePacket(ctx, pkt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := mux.WriteTrailer(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	pkts := readPackets(t, hls.NewDemuxer(srv.URL+"/index.m3u8"), -1)
+	if len(pkts) != 3*25 {
+		t.Fatalf("got %d packets, want %d", len(pkts), 3*25)
+	}
+
+	for i, pkt := range pkts {
+		if dts := start + time.Duration(i)*40*time.Millisecond; pkt.DTS != dts || pkt.IsDiscontinuity {
+			t.Fatalf("packet %d: %v, want DTS %v", i, pkt.String(), dts)
+		}
+	}
+}
+
+func TestDemuxerSequenceReset(t *testing.T) {
+	ctx := context.Background()
+
+	// newStream returns a muxer holding seconds of 25 fps video, one segment
+	// per second, ended if the stream is over.
+	newStream := func(seconds int, ended bool) *hls.Muxer {
+		mux := hls.NewMuxer(hls.NewMemFS(), hls.WithFormat(hls.FormatTS), hls.WithTargetDuration(time.Second))
+		if err := mux.WriteHeader(ctx, []av.Stream{{Idx: 0, Codec: avtest.H264(t, avtest.SPS320x192)}}); err != nil {
+			t.Fatal(err)
+		}
+
+		for i := range seconds * 25 {
+			data := []byte{0, 0, 0, 3, 0x41, 0x9a, byte(i)}
+			if i%25 == 0 {
+				data[4] = 0x65
+			}
+
+			pkt := av.Packet{Idx: 0, KeyFrame: i%25 == 0, DTS: time.Duration(i) * 40 * time.Millisecond, Duration: 40 * time.Millisecond, Data: data}
+			if err := mux.WritePacket(ctx, pkt); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if ended {
+			if err := mux.WriteTrailer(ctx); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		return mux
+	}
+
+	var current atomic.Pointer[hls.Muxer]
+
+	current.Store(newStream(6, false))
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		current.Load().ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	dmx := hls.NewDemuxer(srv.URL + "/index.m3u8")
+
+	pkts := readPackets(t, dmx, 75)
+	if pkts[0].DTS != 2*time.Second {
+		t.Fatalf("first packet: %v", pkts[0].String())
+	}
+
+	// The server restarts: the media sequence goes back to 0.
+	current.Store(newStream(3, true))
+
+	pkts = append(pkts, readPackets(t, dmx, -1)...)
+	if len(pkts) != 150 {
+		t.Fatalf("got %d packets, want 150", len(pkts))
+	}
+
+	if pkts[75].DTS != 0 || !pkts[75].IsDiscontinuity {
+		t.Fatalf("first packet after the restart: %v", pkts[75].String())
+	}
+}
+
+func TestDemuxerErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/text.m3u8":
+			_, _ = io.WriteString(w, "hello")
+		case "/loop.m3u8":
+			_, _ = io.WriteString(w, "#EXTM3U\n#EXT-X-STREAM-INF:BANDWIDTH=100000\nloop.m3u8\n")
+		case "/aes.m3u8":
+			_, _ = io.WriteString(w, "#EXTM3U\n#EXT-X-TARGETDURATION:2\n#EXT-X-KEY:METHOD=AES-128,URI=\"k\"\n#EXTINF:2,\na.ts\n")
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	for name, want := range map[string]error{
+		"/text.m3u8":    hls.ErrNotPlaylist,
+		"/aes.m3u8":     hls.ErrEncrypted,
+		"/loop.m3u8":    hls.ErrNestedMaster,
+		"/missing.m3u8": hls.ErrBadStatus,
+	} {
+		if _, err := hls.NewDemuxer(srv.URL + name).GetCodecs(context.Background()); !errors.Is(err, want) {
+			t.Fatalf("%s: err = %v, want %v", name, err, want)
+		}
+	}
+}
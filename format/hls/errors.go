@@ -0,0 +1,20 @@
+package hls
+
+import "errors"
+
+var (
+	ErrHeaderNotWritten      = errors.New("hls: WriteHeader not called")
+	ErrHeaderAlreadyWritten  = errors.New("hls: WriteHeader already called")
+	ErrTrailerAlreadyWritten = errors.New("hls: WriteTrailer already called")
+	ErrNoStreams             = errors.New("hls: no streams")
+	ErrUnsupportedCodec      = errors.New("hls: unsupported codec")
+	ErrStreamNotFound        = errors.New("hls: stream not found")
+	ErrNotPlaylist           = errors.New("hls: not an m3u8 playlist")
+	ErrNoVariants            = errors.New("hls: no playable variant")
+	ErrNestedMaster          = errors.New("hls: variant is a master playlist")
+	ErrEncrypted             = errors.New("hls: encrypted segments are not supported")
+	ErrByteRange             = errors.New("hls: byte-range segments are not supported")
+	ErrBadStatus             = errors.New("hls: unexpected HTTP status")
+	ErrTooLarge              = errors.New("hls: response too large")
+	ErrPartsRequireFMP4      = errors.New("hls: partial segments require FormatFMP4")
+)
@@ -0,0 +1,27 @@
+package hls
+
+import "github.com/vtpl1/avsdk/format/internal/fsutil"
+
+// FS is the storage playlists and segments are written to. A file created
+// with Create must only become visible once the returned writer is closed, so
+// readers never see a partial playlist or segment.
+type FS = fsutil.FS
+
+// ReadFS is an FS whose files can be read back. Muxer.ServeHTTP serves
+// segments, parts and the master playlist from it.
+type ReadFS = fsutil.ReadFS
+
+// MemFS is an in-memory FS. It is safe for concurrent use, so files can be
+// served while a Muxer writes them.
+type MemFS = fsutil.MemFS
+
+// DirFS returns a ReadFS that writes files into dir. Files are written under a
+// temporary name and renamed into place on Close.
+func DirFS(dir string) ReadFS {
+	return fsutil.DirFS(dir)
+}
+
+// NewMemFS returns an empty MemFS.
+func NewMemFS() *MemFS {
+	return fsutil.NewMemFS()
+}
@@ -0,0 +1,176 @@
+package hls
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// blockTimeout is how long a blocking request waits, in target durations,
+// before failing with 503 (RFC 8216bis §6.2.5.2).
+const blockTimeout = 3
+
+//nolint:gochecknoglobals
+var contentTypes = map[string]string{
+	".m3u8": "application/vnd.apple.mpegurl",
+	".mp4":  "video/mp4",
+	".m4s":  "video/iso.segment",
+	".ts":   "video/mp2t",
+}
+
+// ServeHTTP implements http.Handler. The request path, relative to the
+// handler root, names a file written by the Muxer; mount it under a prefix
+// with http.StripPrefix.
+//
+// The media playlist is served from memory. With WithPartDuration, the
+// _HLS_msn and _HLS_part query parameters block the response until the
+// playlist holds that segment or part, and a request for the part announced
+// by EXT-X-PRELOAD-HINT blocks until the part is written. Other files are
+// served from the FS, which must implement ReadFS.
+func (m *Muxer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if name == m.playlistName {
+		m.servePlaylist(w, r)
+	} else {
+		m.serveFile(w, r, name)
+	}
+}
+
+func (m *Muxer) servePlaylist(w http.ResponseWriter, r *http.Request) {
+	msn, part := int64(-1), int64(-1)
+
+	if m.partTarget > 0 {
+		q := r.URL.Query()
+
+		var err error
+
+		if v := q.Get("_HLS_msn"); v != "" {
+			if msn, err = strconv.ParseInt(v, 10, 64); err != nil || msn < 0 {
+				http.Error(w, "invalid _HLS_msn", http.StatusBadRequest)
+
+				return
+			}
+		}
+
+		if v := q.Get("_HLS_part"); v != "" {
+			if part, err = strconv.ParseInt(v, 10, 64); err != nil || part < 0 || msn < 0 {
+				http.Error(w, "invalid _HLS_part", http.StatusBadRequest)
+
+				return
+			}
+		}
+	}
+
+	timeout := time.NewTimer(blockTimeout * m.target)
+	defer timeout.Stop()
+
+	for {
+		m.mu.Lock()
+		playlist, updated := m.playlist, m.updated
+		ready := playlist != nil && (msn < 0 || m.ended || m.hasPart(uint64(msn), part))
+		tooFar := msn >= 0 && uint64(msn) > m.nextSeq+2
+		m.mu.Unlock()
+
+		if tooFar {
+			http.Error(w, "_HLS_msn is too far in the future", http.StatusBadRequest)
+
+			return
+		}
+
+		if ready {
+			w.Header().Set("Content-Type", contentTypes[".m3u8"])
+			w.Header().Set("Cache-Control", "no-cache")
+			_, _ = w.Write(playlist)
+
+			return
+		}
+
+		if playlist == nil && msn < 0 {
+			http.NotFound(w, r)
+
+			return
+		}
+
+		select {
+		case <-updated:
+		case <-timeout.C:
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// hasPart reports whether the playlist lists part of segment msn, or the
+// whole segment if part is negative. It must be called with mu held.
+func (m *Muxer) hasPart(msn uint64, part int64) bool {
+	if msn < m.nextSeq {
+		return true
+	}
+
+	return part >= 0 && m.cur.open && msn == m.cur.seq && part < int64(len(m.cur.parts))
+}
+
+func (m *Muxer) serveFile(w http.ResponseWriter, r *http.Request, name string) {
+	rfs, ok := m.fs.(ReadFS)
+	if !ok || name == "" {
+		http.NotFound(w, r)
+
+		return
+	}
+
+	timeout := time.NewTimer(blockTimeout * m.target)
+	defer timeout.Stop()
+
+	for {
+		m.mu.Lock()
+		hinted, updated := name == m.hint && !m.ended, m.updated
+		m.mu.Unlock()
+
+		b, err := rfs.ReadFile(name)
+		if err == nil {
+			if ct, ok := contentTypes[path.Ext(name)]; ok {
+				w.Header().Set("Content-Type", ct)
+			}
+
+			_, _ = w.Write(b)
+
+			return
+		}
+
+		if !errors.Is(err, fs.ErrNotExist) {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+
+			return
+		}
+
+		if !hinted {
+			http.NotFound(w, r)
+
+			return
+		}
+
+		select {
+		case <-updated:
+		case <-timeout.C:
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
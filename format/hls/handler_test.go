@@ -0,0 +1,191 @@
+package hls_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/av/avutil"
+	"github.com/vtpl1/avsdk/format/hls"
+	"github.com/vtpl1/avsdk/internal/avtest"
+)
+
+// chanDemuxer returns the packets sent on ch, and io.EOF once ch is closed.
+type chanDemuxer struct {
+	streams []av.Stream
+	ch      chan av.Packet
+}
+
+func (d *chanDemuxer) GetCodecs(context.Context) ([]av.Stream, error) {
+	return d.streams, nil
+}
+
+func (d *chanDemuxer) ReadPacket(ctx context.Context) (av.Packet, error) {
+	select {
+	case pkt, ok := <-d.ch:
+		if !ok {
+			return av.Packet{}, io.EOF
+		}
+
+		return pkt, nil
+	case <-ctx.Done():
+		return av.Packet{}, ctx.Err()
+	}
+}
+
+type response struct {
+	status int
+	body   string
+}
+
+func get(t *testing.T, url string) <-chan response {
+	t.Helper()
+
+	ch := make(chan response, 1)
+
+	go func() {
+		resp, err := http.Get(url) //nolint:noctx
+		if err != nil {
+			ch <- response{body: err.Error()}
+
+			return
+		}
+		defer resp.Body.Close()
+
+		b, _ := io.ReadAll(resp.Body)
+		ch <- response{status: resp.StatusCode, body: string(b)}
+	}()
+
+	return ch
+}
+
+func wait(t *testing.T, ch <-chan response) response {
+	t.Helper()
+
+	select {
+	case r := <-ch:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatal("request did not complete")
+
+		return response{}
+	}
+}
+
+func pending(t *testing.T, ch <-chan response) {
+	t.Helper()
+
+	select {
+	case r := <-ch:
+		t.Fatalf("blocking request returned early: %d %s", r.status, r.body)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestLowLatencyBlockingReload(t *testing.T) {
+	ctx := context.Background()
+	fsys := hls.NewMemFS()
+
+	mux := hls.NewMuxer(fsys, hls.WithTargetDuration(time.Second), hls.WithPartDuration(200*time.Millisecond))
+
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	dmx := &chanDemuxer{streams: []av.Stream{{Idx: 0, Codec: avtest.H264(t, avtest.SPS320x192)}}, ch: make(chan av.Packet)}
+	copied := make(chan error, 1)
+
+	go func() { copied <- avutil.CopyFile(ctx, mux, dmx) }()
+
+	frame := 40 * time.Millisecond
+	next := 0
+	// feed sends video frames up to and including the one at dts, with a keyframe every second.
+	feed := func(dts time.Duration) {
+		for ; time.Duration(next)*frame <= dts; next++ {
+			data := []byte{0, 0, 0, 3, 0x41, 0x9a, byte(next)}
+			if next%25 == 0 {
+				data[4] = 0x65
+			}
+
+			dmx.ch <- av.Packet{Idx: 0, KeyFrame: next%25 == 0, DTS: time.Duration(next) * frame, Duration: frame, Data: data}
+		}
+	}
+
+	if r := wait(t, get(t, srv.URL+"/index.m3u8")); r.status != http.StatusNotFound {
+		t.Fatalf("playlist before any part: %d", r.status)
+	}
+
+	blocked := get(t, srv.URL+"/index.m3u8?_HLS_msn=1&_HLS_part=2")
+
+	// Part 1 of segment 1 is complete once the frame at 1.4 s arrives.
+	feed(1400 * time.Millisecond)
+	pending(t, blocked)
+
+	feed(1600 * time.Millisecond)
+
+	r := wait(t, blocked)
+	if r.status != http.StatusOK {
+		t.Fatalf("blocking reload: %d %s", r.status, r.body)
+	}
+
+	for _, want := range []string{
+		"#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=0.600\n",
+		"#EXT-X-PART-INF:PART-TARGET=0.200\n",
+		"#EXT-X-MAP:URI=\"init0.mp4\"\n#EXT-X-PART:DURATION=0.200,URI=\"segment0.0.m4s\",INDEPENDENT=YES\n" +
+			"#EXT-X-PART:DURATION=0.200,URI=\"segment0.1.m4s\"\n",
+		"#EXT-X-PART:DURATION=0.200,URI=\"segment0.4.m4s\"\n#EXTINF:1.000,\nsegment0.m4s\n",
+		"#EXT-X-PART:DURATION=0.200,URI=\"segment1.2.m4s\"\n#EXT-X-PRELOAD-HINT:TYPE=PART,URI=\"segment1.3.m4s\"\n",
+	} {
+		if !strings.Contains(r.body, want) {
+			t.Fatalf("playlist lacks %q:\n%s", want, r.body)
+		}
+	}
+
+	// A request for the hinted part blocks until the part is written.
+	hinted := get(t, srv.URL+"/segment1.3.m4s")
+	pending(t, hinted)
+	feed(1800 * time.Millisecond)
+
+	if r := wait(t, hinted); r.status != http.StatusOK || r.body[4:8] != "moof" {
+		t.Fatalf("hinted part: %d %q", r.status, r.body[:min(len(r.body), 8)])
+	}
+
+	if r := wait(t, get(t, srv.URL+"/index.m3u8?_HLS_msn=9")); r.status != http.StatusBadRequest {
+		t.Fatalf("far future _HLS_msn: %d", r.status)
+	}
+
+	if r := wait(t, get(t, srv.URL+"/segment7.0.m4s")); r.status != http.StatusNotFound {
+		t.Fatalf("unknown part: %d", r.status)
+	}
+
+	blocked = get(t, srv.URL+"/index.m3u8?_HLS_msn=3")
+
+	close(dmx.ch)
+
+	if err := <-copied; err != nil {
+		t.Fatal(err)
+	}
+
+	// The playlist has ended, so requests for segments that will never exist return at once.
+	if r := wait(t, blocked); r.status != http.StatusOK || !strings.HasSuffix(r.body, "segment1.m4s\n#EXT-X-ENDLIST\n") {
+		t.Fatalf("ended playlist: %d\n%s", r.status, r.body)
+	}
+
+	if r := wait(t, get(t, srv.URL+"/segment1.m4s")); r.status != http.StatusOK || r.body[4:8] != "moof" {
+		t.Fatalf("segment: %d", r.status)
+	}
+}
+
+func TestMuxerPartsRequireFMP4(t *testing.T) {
+	mux := hls.NewMuxer(hls.NewMemFS(), hls.WithFormat(hls.FormatTS), hls.WithPartDuration(time.Second/3))
+
+	err := mux.WriteHeader(context.Background(), []av.Stream{{Idx: 0, Codec: avtest.AAC(t)}})
+	if !errors.Is(err, hls.ErrPartsRequireFMP4) {
+		t.Fatalf("err = %v, want ErrPartsRequireFMP4", err)
+	}
+}
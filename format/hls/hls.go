@@ -0,0 +1,188 @@
+// Package hls implements an HTTP Live Streaming (RFC 8216) packager that
+// writes fragmented MP4 or MPEG-TS segments and m3u8 playlists to an FS, and
+// a client Demuxer that plays HLS streams over HTTP.
+package hls
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vtpl1/avsdk/av"
+)
+
+// Format selects the segment container.
+type Format int
+
+const (
+	// FormatFMP4 writes CMAF fragmented MP4 segments with an init segment
+	// referenced by EXT-X-MAP.
+	FormatFMP4 Format = iota
+	// FormatTS writes self-contained MPEG-TS segments.
+	FormatTS
+)
+
+// Defaults used by NewMuxer.
+const (
+	DefaultTargetDuration     = 2 * time.Second
+	DefaultWindowSize         = 6
+	DefaultPlaylistName       = "index.m3u8"
+	DefaultMasterPlaylistName = "master.m3u8"
+)
+
+func (f Format) segmentExt() string {
+	if f == FormatTS {
+		return ".ts"
+	}
+
+	return ".m4s"
+}
+
+// tagger is implemented by codec data that knows its RFC 6381 codecs string.
+type tagger interface {
+	Tag() string
+}
+
+type part struct {
+	name        string
+	duration    time.Duration
+	independent bool
+}
+
+type segment struct {
+	seq           uint64
+	name          string
+	duration      time.Duration
+	size          int
+	discontinuity bool
+	initName      string
+	parts         []part
+	open          bool // still being written; only its parts are listed
+}
+
+// playlist holds what a media playlist lists.
+type playlist struct {
+	format     Format
+	target     time.Duration
+	partTarget time.Duration
+	segments   []segment
+	discSeq    uint64
+	hint       string // URI of the next part, announced with EXT-X-PRELOAD-HINT
+	ended      bool
+}
+
+// partWindow is how far from the live edge parts of complete segments are
+// listed, in target durations (RFC 8216bis §4.4.4.9).
+const partWindow = 3
+
+func (p *playlist) marshal() []byte {
+	var b strings.Builder
+
+	version := 3
+	if p.format == FormatFMP4 {
+		version = 7
+	}
+
+	targetSecs := int(math.Ceil(p.target.Seconds()))
+	for _, s := range p.segments {
+		targetSecs = max(targetSecs, int(math.Round(s.duration.Seconds())))
+	}
+
+	b.WriteString("#EXTM3U\n")
+	fmt.Fprintf(&b, "#EXT-X-VERSION:%d\n", version)
+	fmt.Fprintf(&b, "#EXT-X-TARGETDURATION:%d\n", targetSecs)
+
+	if p.partTarget > 0 {
+		fmt.Fprintf(&b, "#EXT-X-SERVER-CONTROL:CAN-BLOCK-RELOAD=YES,PART-HOLD-BACK=%.3f\n", 3*p.partTarget.Seconds())
+		fmt.Fprintf(&b, "#EXT-X-PART-INF:PART-TARGET=%.3f\n", p.partTarget.Seconds())
+	}
+
+	if len(p.segments) > 0 {
+		fmt.Fprintf(&b, "#EXT-X-MEDIA-SEQUENCE:%d\n", p.segments[0].seq)
+	}
+
+	if p.discSeq > 0 {
+		fmt.Fprintf(&b, "#EXT-X-DISCONTINUITY-SEQUENCE:%d\n", p.discSeq)
+	}
+
+	// Every segment starts with a keyframe.
+	b.WriteString("#EXT-X-INDEPENDENT-SEGMENTS\n")
+
+	// Parts are listed for the segments ending within partWindow target
+	// durations of the live edge.
+	partsFrom := len(p.segments)
+
+	for age := time.Duration(0); partsFrom > 0 && age < partWindow*p.target; {
+		partsFrom--
+		age += p.segments[partsFrom].duration
+	}
+
+	for i, s := range p.segments {
+		if s.discontinuity {
+			b.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+
+		if s.initName != "" && (i == 0 || p.segments[i-1].initName != s.initName) {
+			fmt.Fprintf(&b, "#EXT-X-MAP:URI=%q\n", s.initName)
+		}
+
+		if i >= partsFrom {
+			for _, pt := range s.parts {
+				fmt.Fprintf(&b, "#EXT-X-PART:DURATION=%.3f,URI=%q", pt.duration.Seconds(), pt.name)
+
+				if pt.independent {
+					b.WriteString(",INDEPENDENT=YES")
+				}
+
+				b.WriteString("\n")
+			}
+		}
+
+		if !s.open {
+			fmt.Fprintf(&b, "#EXTINF:%.3f,\n%s\n", s.duration.Seconds(), s.name)
+		}
+	}
+
+	if p.hint != "" && !p.ended {
+		fmt.Fprintf(&b, "#EXT-X-PRELOAD-HINT:TYPE=PART,URI=%q\n", p.hint)
+	}
+
+	if p.ended {
+		b.WriteString("#EXT-X-ENDLIST\n")
+	}
+
+	return []byte(b.String())
+}
+
+// masterPlaylist renders a master playlist with a single variant.
+func masterPlaylist(streams []av.Stream, bandwidth int, uri string) []byte {
+	var (
+		b      strings.Builder
+		codecs []string
+		attrs  = []string{"BANDWIDTH=" + strconv.Itoa(bandwidth)}
+	)
+
+	for _, stream := range streams {
+		if t, ok := stream.Codec.(tagger); ok {
+			codecs = append(codecs, t.Tag())
+		}
+	}
+
+	attrs = append(attrs, fmt.Sprintf("CODECS=%q", strings.Join(codecs, ",")))
+
+	for _, stream := range streams {
+		if vc, ok := stream.Codec.(av.VideoCodecData); ok {
+			attrs = append(attrs, fmt.Sprintf("RESOLUTION=%dx%d", vc.Width(), vc.Height()))
+
+			break
+		}
+	}
+
+	b.WriteString("#EXTM3U\n")
+	b.WriteString("#EXT-X-INDEPENDENT-SEGMENTS\n")
+	fmt.Fprintf(&b, "#EXT-X-STREAM-INF:%s\n%s\n", strings.Join(attrs, ","), uri)
+
+	return []byte(b.String())
+}
@@ -0,0 +1,154 @@
+package hls
+
+import (
+	"bufio"
+	"bytes"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Variant is a variant stream listed in a master playlist.
+type Variant struct {
+	URI       string // resolved against the master playlist URL
+	Bandwidth int
+	Codecs    string
+	Width     int
+	Height    int
+}
+
+type mediaSegment struct {
+	seq           uint64
+	uri           string
+	duration      time.Duration
+	discontinuity bool
+	initURI       string
+}
+
+type mediaPlaylist struct {
+	target   time.Duration
+	segments []mediaSegment
+	ended    bool
+}
+
+// parsePlaylist parses a master or media playlist; URIs are resolved against
+// base. For a master playlist only the variants are returned.
+func parsePlaylist(b []byte, base *url.URL) ([]Variant, *mediaPlaylist, error) {
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	sc.Buffer(nil, len(b)+1)
+
+	if !sc.Scan() || strings.TrimSpace(strings.TrimPrefix(sc.Text(), "\ufeff")) != "#EXTM3U" {
+		return nil, nil, ErrNotPlaylist
+	}
+
+	var (
+		variants []Variant
+		variant  *Variant
+		media    = &mediaPlaylist{}
+		seq      uint64
+		next     mediaSegment
+		initURI  string
+	)
+
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		tag, value, _ := strings.Cut(line, ":")
+
+		switch {
+		case line == "":
+		case tag == "#EXT-X-STREAM-INF":
+			attrs := parseAttributes(value)
+			v := Variant{Codecs: attrs["CODECS"]}
+			v.Bandwidth, _ = strconv.Atoi(attrs["BANDWIDTH"])
+
+			if w, h, ok := strings.Cut(attrs["RESOLUTION"], "x"); ok {
+				v.Width, _ = strconv.Atoi(w)
+				v.Height, _ = strconv.Atoi(h)
+			}
+
+			variant = &v
+		case tag == "#EXT-X-TARGETDURATION":
+			secs, _ := strconv.Atoi(value)
+			media.target = time.Duration(secs) * time.Second
+		case tag == "#EXT-X-MEDIA-SEQUENCE":
+			seq, _ = strconv.ParseUint(value, 10, 64)
+		case tag == "#EXT-X-DISCONTINUITY":
+			next.discontinuity = true
+		case tag == "#EXT-X-MAP":
+			initURI = resolve(base, parseAttributes(value)["URI"])
+		case tag == "#EXT-X-KEY":
+			if method := parseAttributes(value)["METHOD"]; method != "" && method != "NONE" {
+				return nil, nil, ErrEncrypted
+			}
+		case tag == "#EXT-X-BYTERANGE":
+			return nil, nil, ErrByteRange
+		case tag == "#EXTINF":
+			secs, _, _ := strings.Cut(value, ",")
+			d, _ := strconv.ParseFloat(secs, 64)
+			next.duration = time.Duration(d * float64(time.Second))
+		case tag == "#EXT-X-ENDLIST":
+			media.ended = true
+		case strings.HasPrefix(line, "#"):
+		case variant != nil:
+			variant.URI = resolve(base, line)
+			variants = append(variants, *variant)
+			variant = nil
+		default:
+			next.seq = seq
+			next.uri = resolve(base, line)
+			next.initURI = initURI
+			media.segments = append(media.segments, next)
+			next = mediaSegment{}
+			seq++
+		}
+	}
+
+	if len(variants) > 0 {
+		return variants, nil, nil
+	}
+
+	return nil, media, nil
+}
+
+// parseAttributes parses an attribute list (RFC 8216 §4.2). Quoted values
+// are unquoted.
+func parseAttributes(s string) map[string]string {
+	attrs := make(map[string]string)
+
+	for s != "" {
+		name, rest, ok := strings.Cut(s, "=")
+		if !ok {
+			break
+		}
+
+		var value string
+
+		if strings.HasPrefix(rest, `"`) {
+			end := strings.IndexByte(rest[1:], '"')
+			if end < 0 {
+				end = len(rest) - 1
+			}
+
+			value = rest[1 : end+1]
+			rest = rest[min(end+2, len(rest)):]
+		} else {
+			value, rest, _ = strings.Cut(rest, ",")
+			rest = "," + rest
+		}
+
+		attrs[strings.TrimSpace(name)] = value
+		_, s, _ = strings.Cut(rest, ",")
+	}
+
+	return attrs
+}
+
+func resolve(base *url.URL, ref string) string {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return ref
+	}
+
+	return base.ResolveReference(u).String()
+}
@@ -0,0 +1,525 @@
+package hls
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/format/fmp4"
+	"github.com/vtpl1/avsdk/format/internal/fsutil"
+	"github.com/vtpl1/avsdk/format/ts"
+)
+
+// Option configures a Muxer.
+type Option func(*Muxer)
+
+// WithFormat sets the segment container. The default is FormatFMP4.
+func WithFormat(format Format) Option {
+	return func(m *Muxer) {
+		m.format = format
+	}
+}
+
+// WithTargetDuration sets the duration after which a segment is cut at the
+// next video keyframe.
+func WithTargetDuration(d time.Duration) Option {
+	return func(m *Muxer) {
+		m.target = d
+	}
+}
+
+// WithPartDuration enables Low-Latency HLS: segments are additionally cut
+// into parts of at most d, listed with EXT-X-PART. It requires FormatFMP4.
+func WithPartDuration(d time.Duration) Option {
+	return func(m *Muxer) {
+		m.partTarget = d
+	}
+}
+
+// WithWindowSize sets the number of segments listed in the media playlist.
+// Zero keeps every segment, as in an event playlist.
+func WithWindowSize(n int) Option {
+	return func(m *Muxer) {
+		m.window = n
+	}
+}
+
+// WithPlaylistName sets the name of the media playlist.
+func WithPlaylistName(name string) Option {
+	return func(m *Muxer) {
+		m.playlistName = name
+	}
+}
+
+// WithMasterPlaylistName sets the name of the master playlist. An empty name
+// disables it.
+func WithMasterPlaylistName(name string) Option {
+	return func(m *Muxer) {
+		m.masterName = name
+	}
+}
+
+// Muxer segments H.264, H.265 and AAC streams for HLS. Segments start on a
+// video keyframe (any packet for audio-only output) once the current segment
+// has reached the target duration. The media playlist is rewritten after each
+// segment, or each part with WithPartDuration, and lists a sliding window of
+// the most recent segments; segments are removed from the FS once they have
+// been out of the window for as many segments as the window holds.
+//
+// It implements av.MuxCloser and av.CodecChanger, and serves its output as
+// an http.Handler. Packet methods must be called from a single goroutine;
+// ServeHTTP may be called concurrently with them.
+type Muxer struct {
+	fs           FS
+	format       Format
+	target       time.Duration
+	partTarget   time.Duration
+	window       int
+	playlistName string
+	masterName   string
+
+	streams   []av.Stream
+	streamIdx map[uint16]int
+	hasVideo  bool
+
+	buf      bytes.Buffer
+	fmp4     *fmp4.Muxer
+	ts       *ts.Muxer
+	initSeq  int
+	initName string
+
+	segOpen       bool
+	segStart      time.Duration
+	segEnd        time.Duration
+	discontinuity bool
+	partOpen      bool
+	partStart     time.Duration
+	partOffset    int
+	partVideo     bool
+	partIndep     bool
+	expired       []segment
+	bandwidth     int
+
+	// Guarded by mu; read by ServeHTTP.
+	mu       sync.Mutex
+	cur      segment // the open segment
+	segments []segment
+	nextSeq  uint64
+	discSeq  uint64
+	hint     string
+	ended    bool
+	playlist []byte
+	updated  chan struct{} // closed when the playlist changes
+
+	stage int
+}
+
+// NewMuxer returns a Muxer writing to fsys.
+func NewMuxer(fsys FS, opts ...Option) *Muxer {
+	m := &Muxer{
+		fs:           fsys,
+		target:       DefaultTargetDuration,
+		window:       DefaultWindowSize,
+		playlistName: DefaultPlaylistName,
+		masterName:   DefaultMasterPlaylistName,
+		streamIdx:    make(map[uint16]int),
+		updated:      make(chan struct{}),
+	}
+	for _, o := range opts {
+		o(m)
+	}
+
+	return m
+}
+
+// WriteHeader implements av.Muxer. For FormatFMP4 it writes the init segment.
+// Playlists are first written when the first segment (or part) is complete.
+func (m *Muxer) WriteHeader(ctx context.Context, streams []av.Stream) error {
+	if m.stage != 0 {
+		return ErrHeaderAlreadyWritten
+	}
+
+	if len(streams) == 0 {
+		return ErrNoStreams
+	}
+
+	if m.partTarget > 0 && m.format != FormatFMP4 {
+		return ErrPartsRequireFMP4
+	}
+
+	for i, stream := range streams {
+		if _, ok := stream.Codec.(tagger); !ok {
+			return ErrUnsupportedCodec
+		}
+
+		if stream.Codec.Type().IsVideo() {
+			m.hasVideo = true
+		}
+
+		m.streamIdx[stream.Idx] = i
+	}
+
+	m.streams = append([]av.Stream(nil), streams...)
+
+	if m.format == FormatTS {
+		m.ts = ts.NewMuxer(&m.buf)
+		if err := m.ts.WriteHeader(ctx, streams); err != nil {
+			return err
+		}
+	} else {
+		m.fmp4 = fmp4.NewMuxer(&m.buf)
+		if err := m.fmp4.WriteHeader(ctx, streams); err != nil {
+			return err
+		}
+
+		if err := m.writeInit(); err != nil {
+			return err
+		}
+	}
+
+	m.stage++
+
+	return nil
+}
+
+// WritePacket implements av.Muxer. Packets before the first video keyframe
+// are dropped.
+func (m *Muxer) WritePacket(ctx context.Context, pkt av.Packet) error {
+	if m.stage != 1 {
+		return ErrHeaderNotWritten
+	}
+
+	i, ok := m.streamIdx[pkt.Idx]
+	if !ok {
+		return ErrStreamNotFound
+	}
+
+	isVideo := m.streams[i].Codec.Type().IsVideo()
+	startsSegment := !m.hasVideo || (pkt.KeyFrame && isVideo)
+
+	switch {
+	case m.segOpen && startsSegment && pkt.DTS-m.segStart >= m.target:
+		if err := m.closeSegment(ctx, pkt.DTS, false); err != nil {
+			return err
+		}
+	case m.partOpen && (isVideo || !m.hasVideo) && pkt.DTS > m.partStart &&
+		pkt.DTS+pkt.Duration-m.partStart > m.partTarget:
+		// Cutting before the packet that would overrun the part target keeps
+		// every part within PART-TARGET.
+		if err := m.closePart(ctx, pkt.DTS); err != nil {
+			return err
+		}
+
+		if err := m.publish(); err != nil {
+			return err
+		}
+	}
+
+	if !m.segOpen {
+		if !startsSegment {
+			return nil
+		}
+
+		m.openSegment(pkt.DTS)
+	}
+
+	if m.partTarget > 0 && !m.partOpen {
+		m.partOpen = true
+		m.partStart = pkt.DTS
+		m.partVideo = false
+		m.partIndep = !m.hasVideo
+	}
+
+	if isVideo && !m.partVideo {
+		m.partVideo = true
+		m.partIndep = pkt.KeyFrame
+	}
+
+	m.segEnd = max(m.segEnd, pkt.DTS+pkt.Duration)
+
+	if m.ts != nil {
+		return m.ts.WritePacket(ctx, pkt)
+	}
+
+	return m.fmp4.WritePacket(ctx, pkt)
+}
+
+// WriteTrailer implements av.Muxer. It writes the last segment and ends the
+// media playlist with EXT-X-ENDLIST.
+func (m *Muxer) WriteTrailer(ctx context.Context) error {
+	switch m.stage {
+	case 0:
+		return ErrHeaderNotWritten
+	case 2:
+		return ErrTrailerAlreadyWritten
+	}
+
+	m.stage++
+
+	return m.closeSegment(ctx, -1, true)
+}
+
+// WriteCodecChange implements av.CodecChanger. The current segment is closed
+// and the next one is marked with EXT-X-DISCONTINUITY; for FormatFMP4 a new
+// init segment is written.
+func (m *Muxer) WriteCodecChange(ctx context.Context, changed []av.Stream) error {
+	if m.stage != 1 {
+		return ErrHeaderNotWritten
+	}
+
+	for _, stream := range changed {
+		i, ok := m.streamIdx[stream.Idx]
+		if !ok {
+			return ErrStreamNotFound
+		}
+
+		if _, ok := stream.Codec.(tagger); !ok {
+			return ErrUnsupportedCodec
+		}
+
+		m.streams[i] = stream
+	}
+
+	if m.segOpen {
+		if err := m.closeSegment(ctx, -1, false); err != nil {
+			return err
+		}
+	}
+
+	m.discontinuity = true
+	// The master playlist is rewritten with the new CODECS after the next segment.
+	m.bandwidth = 0
+
+	if m.ts != nil {
+		return m.ts.WriteCodecChange(ctx, changed)
+	}
+
+	if err := m.fmp4.WriteCodecChange(ctx, changed); err != nil {
+		return err
+	}
+
+	return m.writeInit()
+}
+
+// Close implements av.MuxCloser. The FS is left as it is; call WriteTrailer
+// first to end the playlist.
+func (m *Muxer) Close() error {
+	return nil
+}
+
+// writeInit stores the init segment the fmp4 muxer has just written to buf.
+func (m *Muxer) writeInit() error {
+	m.initName = fmt.Sprintf("init%d.mp4", m.initSeq)
+	m.initSeq++
+
+	err := fsutil.WriteFile(m.fs, m.initName, m.buf.Bytes())
+	m.buf.Reset()
+
+	return err
+}
+
+func (m *Muxer) openSegment(dts time.Duration) {
+	m.segOpen = true
+	m.segStart = dts
+	m.segEnd = dts
+	m.partOffset = 0
+
+	if m.ts != nil {
+		m.ts.RepeatPSI()
+	}
+
+	m.mu.Lock()
+	m.cur = segment{
+		seq:           m.nextSeq,
+		name:          fmt.Sprintf("segment%d%s", m.nextSeq, m.format.segmentExt()),
+		discontinuity: m.discontinuity,
+		open:          true,
+	}
+
+	if m.fmp4 != nil {
+		m.cur.initName = m.initName
+	}
+	m.mu.Unlock()
+
+	m.discontinuity = false
+}
+
+// closePart writes the samples buffered since the previous part as a part
+// ending at end.
+func (m *Muxer) closePart(ctx context.Context, end time.Duration) error {
+	m.partOpen = false
+
+	if err := m.fmp4.Flush(ctx); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	pt := part{
+		name:        fmt.Sprintf("segment%d.%d%s", m.cur.seq, len(m.cur.parts), m.format.segmentExt()),
+		duration:    end - m.partStart,
+		independent: m.partIndep,
+	}
+	m.mu.Unlock()
+
+	if err := fsutil.WriteFile(m.fs, pt.name, m.buf.Bytes()[m.partOffset:]); err != nil {
+		return err
+	}
+
+	m.partOffset = m.buf.Len()
+
+	m.mu.Lock()
+	m.cur.parts = append(m.cur.parts, pt)
+	m.mu.Unlock()
+
+	return nil
+}
+
+// closeSegment writes the open segment, if any, and the playlists. The
+// segment ends at next, the DTS of the packet starting the next segment, or
+// at the end of its last packet if next is negative.
+func (m *Muxer) closeSegment(ctx context.Context, next time.Duration, ended bool) error {
+	if m.segOpen {
+		m.segOpen = false
+
+		end := m.segEnd
+		if next >= 0 {
+			end = next
+		}
+
+		if m.partOpen {
+			if err := m.closePart(ctx, end); err != nil {
+				return err
+			}
+		}
+
+		if m.fmp4 != nil {
+			if err := m.fmp4.Flush(ctx); err != nil {
+				return err
+			}
+		}
+
+		err := fsutil.WriteFile(m.fs, m.cur.name, m.buf.Bytes())
+		size := m.buf.Len()
+		m.buf.Reset()
+
+		if err != nil {
+			return err
+		}
+
+		m.mu.Lock()
+		seg := m.cur
+		seg.duration = end - m.segStart
+		seg.size = size
+		seg.open = false
+		m.segments = append(m.segments, seg)
+		m.nextSeq++
+		m.cur = segment{}
+		m.mu.Unlock()
+
+		if err := m.slideWindow(); err != nil {
+			return err
+		}
+
+		if err := m.updateMaster(seg); err != nil {
+			return err
+		}
+	}
+
+	m.mu.Lock()
+	m.ended = ended
+	m.mu.Unlock()
+
+	return m.publish()
+}
+
+// slideWindow drops segments that no longer fit the window from the playlist
+// and removes those that left it a full window ago, with their parts.
+func (m *Muxer) slideWindow() error {
+	if m.window <= 0 {
+		return nil
+	}
+
+	m.mu.Lock()
+	for len(m.segments) > m.window {
+		if m.segments[0].discontinuity {
+			m.discSeq++
+		}
+
+		m.expired = append(m.expired, m.segments[0])
+		m.segments = m.segments[1:]
+	}
+	m.mu.Unlock()
+
+	for len(m.expired) > m.window {
+		seg := m.expired[0]
+		m.expired = m.expired[1:]
+
+		if err := m.fs.Remove(seg.name); err != nil {
+			return err
+		}
+
+		for _, pt := range seg.parts {
+			if err := m.fs.Remove(pt.name); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// publish renders the media playlist, writes it to the FS and wakes up
+// blocked playlist requests.
+func (m *Muxer) publish() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p := playlist{
+		format:     m.format,
+		target:     m.target,
+		partTarget: m.partTarget,
+		segments:   m.segments,
+		discSeq:    m.discSeq,
+		ended:      m.ended,
+	}
+
+	if m.partTarget > 0 {
+		if m.segOpen {
+			p.segments = append(p.segments[:len(p.segments):len(p.segments)], m.cur)
+			m.hint = fmt.Sprintf("segment%d.%d%s", m.cur.seq, len(m.cur.parts), m.format.segmentExt())
+		} else {
+			m.hint = fmt.Sprintf("segment%d.0%s", m.nextSeq, m.format.segmentExt())
+		}
+
+		p.hint = m.hint
+	}
+
+	if len(p.segments) == 0 {
+		return nil
+	}
+
+	m.playlist = p.marshal()
+	close(m.updated)
+	m.updated = make(chan struct{})
+
+	return fsutil.WriteFile(m.fs, m.playlistName, m.playlist)
+}
+
+// updateMaster rewrites the master playlist when seg raises the peak bitrate.
+func (m *Muxer) updateMaster(seg segment) error {
+	if m.masterName == "" || seg.duration <= 0 {
+		return nil
+	}
+
+	bandwidth := int(float64(seg.size*8) / seg.duration.Seconds())
+	if bandwidth <= m.bandwidth {
+		return nil
+	}
+
+	m.bandwidth = bandwidth
+
+	return fsutil.WriteFile(m.fs, m.masterName, masterPlaylist(m.streams, bandwidth, m.playlistName))
+}
@@ -0,0 +1,238 @@
+package hls_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/format/hls"
+	"github.com/vtpl1/avsdk/internal/avtest"
+)
+
+// writeStream writes seconds of 25 fps video with a keyframe every second,
+// interleaved with 20 ms audio packets. onVideo is called before each video packet.
+func writeStream(t *testing.T, mux av.Muxer, seconds int, onVideo func(i int)) {
+	t.Helper()
+
+	ctx := context.Background()
+	frame := 40 * time.Millisecond
+
+	for i := range seconds * 25 {
+		if onVideo != nil {
+			onVideo(i)
+		}
+
+		dts := time.Duration(i) * frame
+		data := []byte{0, 0, 0, 3, 0x41, 0x9a, byte(i)}
+
+		if i%25 == 0 {
+			data[4] = 0x65
+		}
+
+		if err := mux.WritePacket(ctx, av.Packet{Idx: 0, KeyFrame: i%25 == 0, DTS: dts, Duration: frame, Data: data}); err != nil {
+			t.Fatal(err)
+		}
+
+		for j := range 2 {
+			audio := av.Packet{Idx: 1, DTS: dts + time.Duration(j)*20*time.Millisecond, Duration: 20 * time.Millisecond, Data: []byte{0x21, byte(i)}}
+			if err := mux.WritePacket(ctx, audio); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func readFile(t *testing.T, fsys *hls.MemFS, name string) string {
+	t.Helper()
+
+	b, err := fsys.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return string(b)
+}
+
+func TestMuxerFMP4SlidingWindow(t *testing.T) {
+	ctx := context.Background()
+	fsys := hls.NewMemFS()
+
+	mux := hls.NewMuxer(fsys, hls.WithWindowSize(3))
+	if err := mux.WriteHeader(ctx, []av.Stream{{Idx: 0, Codec: avtest.H264(t, avtest.SPS320x192)}, {Idx: 1, Codec: avtest.AAC(t)}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if init := readFile(t, fsys, "init0.mp4"); init[4:8] != "ftyp" {
+		t.Fatalf("init segment starts with %q", init[4:8])
+	}
+
+	writeStream(t, mux, 20, nil)
+
+	live := readFile(t, fsys, hls.DefaultPlaylistName)
+	if strings.Contains(live, "#EXT-X-ENDLIST") || !strings.Contains(live, "#EXT-X-MEDIA-SEQUENCE:6\n") {
+		t.Fatalf("live playlist:\n%s", live)
+	}
+
+	if err := mux.WriteTrailer(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "#EXTM3U\n" +
+		"#EXT-X-VERSION:7\n" +
+		"#EXT-X-TARGETDURATION:2\n" +
+		"#EXT-X-MEDIA-SEQUENCE:7\n" +
+		"#EXT-X-INDEPENDENT-SEGMENTS\n" +
+		"#EXT-X-MAP:URI=\"init0.mp4\"\n" +
+		"#EXTINF:2.000,\nsegment7.m4s\n" +
+		"#EXTINF:2.000,\nsegment8.m4s\n" +
+		"#EXTINF:2.000,\nsegment9.m4s\n" +
+		"#EXT-X-ENDLIST\n"
+	if got := readFile(t, fsys, hls.DefaultPlaylistName); got != want {
+		t.Fatalf("playlist:\n%s\nwant:\n%s", got, want)
+	}
+
+	// Segments stay available for one window after leaving the playlist.
+	for i := range 10 {
+		name := fmt.Sprintf("segment%d.m4s", i)
+
+		_, err := fsys.ReadFile(name)
+		if removed := i < 4; removed != errors.Is(err, fs.ErrNotExist) {
+			t.Fatalf("%s: err = %v, want removed = %v", name, err, removed)
+		}
+	}
+
+	if seg := readFile(t, fsys, "segment9.m4s"); seg[4:8] != "moof" || strings.Count(seg, "moof") != 2 {
+		t.Fatalf("segment9.m4s does not hold two fragments")
+	}
+
+	master := readFile(t, fsys, hls.DefaultMasterPlaylistName)
+	if !strings.Contains(master, `CODECS="avc1.64000D,mp4a.40.2",RESOLUTION=320x180`) ||
+		!strings.HasSuffix(master, "\nindex.m3u8\n") {
+		t.Fatalf("master playlist:\n%s", master)
+	}
+}
+
+func TestMuxerCodecChange(t *testing.T) {
+	ctx := context.Background()
+	fsys := hls.NewMemFS()
+
+	mux := hls.NewMuxer(fsys, hls.WithWindowSize(0))
+	if err := mux.WriteHeader(ctx, []av.Stream{{Idx: 0, Codec: avtest.H264(t, avtest.SPS320x192)}, {Idx: 1, Codec: avtest.AAC(t)}}); err != nil {
+		t.Fatal(err)
+	}
+
+	writeStream(t, mux, 6, func(i int) {
+		if i == 75 {
+			if err := mux.WriteCodecChange(ctx, []av.Stream{{Idx: 0, Codec: avtest.H264(t, avtest.SPS1280x720)}}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	})
+
+	if err := mux.WriteTrailer(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	playlist := readFile(t, fsys, hls.DefaultPlaylistName)
+
+	want := "#EXTINF:2.000,\nsegment0.m4s\n" +
+		"#EXTINF:1.000,\nsegment1.m4s\n" +
+		"#EXT-X-DISCONTINUITY\n" +
+		"#EXT-X-MAP:URI=\"init1.mp4\"\n" +
+		"#EXTINF:2.000,\nsegment2.m4s\n"
+	if !strings.Contains(playlist, want) {
+		t.Fatalf("playlist:\n%s", playlist)
+	}
+
+	if master := readFile(t, fsys, hls.DefaultMasterPlaylistName); !strings.Contains(master, "RESOLUTION=1280x720") {
+		t.Fatalf("master playlist:\n%s", master)
+	}
+}
+
+func TestMuxerPacketsWithoutDuration(t *testing.T) {
+	ctx := context.Background()
+	fsys := hls.NewMemFS()
+
+	mux := hls.NewMuxer(fsys, hls.WithTargetDuration(2*time.Second))
+	if err := mux.WriteHeader(ctx, []av.Stream{{Idx: 0, Codec: avtest.H264(t, avtest.SPS320x192)}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Demuxers such as ts leave Packet.Duration at 0.
+	for i := range 100 {
+		pkt := av.Packet{Idx: 0, KeyFrame: i%50 == 0, DTS: time.Duration(i) * 40 * time.Millisecond, Data: []byte{0, 0, 0, 1, 0x65}}
+		if err := mux.WritePacket(ctx, pkt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := mux.WriteTrailer(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the last segment ends at its last packet.
+	want := "#EXTINF:2.000,\nsegment0.m4s\n#EXTINF:1.960,\nsegment1.m4s\n"
+	if playlist := readFile(t, fsys, hls.DefaultPlaylistName); !strings.Contains(playlist, want) {
+		t.Fatalf("playlist:\n%s", playlist)
+	}
+}
+
+func TestMuxerTS(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	mux := hls.NewMuxer(hls.DirFS(dir), hls.WithFormat(hls.FormatTS), hls.WithTargetDuration(time.Second), hls.WithMasterPlaylistName(""))
+	if err := mux.WriteHeader(ctx, []av.Stream{{Idx: 1, Codec: avtest.AAC(t)}}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range 150 {
+		pkt := av.Packet{Idx: 1, DTS: time.Duration(i) * 20 * time.Millisecond, Duration: 20 * time.Millisecond, Data: []byte{0x21, byte(i)}}
+		if err := mux.WritePacket(ctx, pkt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := mux.WriteTrailer(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	playlist, err := os.ReadFile(filepath.Join(dir, hls.DefaultPlaylistName))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Contains(playlist, []byte("#EXT-X-VERSION:3\n")) || bytes.Contains(playlist, []byte("EXT-X-MAP")) ||
+		!bytes.Contains(playlist, []byte("#EXTINF:1.000,\nsegment2.ts\n#EXT-X-ENDLIST\n")) {
+		t.Fatalf("playlist:\n%s", playlist)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 4 {
+		t.Fatalf("got %d files, want 3 segments and a playlist", len(entries))
+	}
+
+	// Audio-only segments are cut off the PSI cadence, so each must start with its own PAT.
+	for i := range 3 {
+		seg, err := os.ReadFile(filepath.Join(dir, fmt.Sprintf("segment%d.ts", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(seg)%188 != 0 || seg[0] != 0x47 || seg[1]&0x1f != 0 || seg[2] != 0 {
+			t.Fatalf("segment%d.ts does not start with a PAT: %x", i, seg[:4])
+		}
+	}
+}
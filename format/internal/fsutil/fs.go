@@ -0,0 +1,143 @@
+// Package fsutil holds the storage the hls and dash packagers write their
+// playlists, manifests and segments to.
+package fsutil
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// FS is the storage playlists and segments are written to. A file created
+// with Create must only become visible once the returned writer is closed, so
+// readers never see a partial playlist or segment.
+type FS interface {
+	Create(name string) (io.WriteCloser, error)
+	Remove(name string) error
+}
+
+// ReadFS is an FS whose files can be read back.
+type ReadFS interface {
+	FS
+	ReadFile(name string) ([]byte, error)
+}
+
+// WriteFile creates name in fsys with the contents b.
+func WriteFile(fsys FS, name string, b []byte) error {
+	w, err := fsys.Create(name)
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(b); err != nil {
+		_ = w.Close()
+
+		return err
+	}
+
+	return w.Close()
+}
+
+// DirFS returns a ReadFS that writes files into dir. Files are written under a
+// temporary name and renamed into place on Close.
+func DirFS(dir string) ReadFS {
+	return dirFS(dir)
+}
+
+type dirFS string
+
+func (d dirFS) Create(name string) (io.WriteCloser, error) {
+	path := filepath.Join(string(d), name)
+
+	f, err := os.CreateTemp(string(d), "."+name+".*")
+	if err != nil {
+		return nil, err
+	}
+
+	return &dirFile{File: f, path: path}, nil
+}
+
+func (d dirFS) Remove(name string) error {
+	return os.Remove(filepath.Join(string(d), name))
+}
+
+// ReadFile implements ReadFS.
+func (d dirFS) ReadFile(name string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(string(d), name))
+}
+
+type dirFile struct {
+	*os.File
+	path string
+}
+
+func (f *dirFile) Close() error {
+	if err := f.File.Close(); err != nil {
+		_ = os.Remove(f.Name())
+
+		return err
+	}
+
+	return os.Rename(f.Name(), f.path)
+}
+
+// MemFS is an in-memory FS. It is safe for concurrent use, so files can be
+// served while a Muxer writes them.
+type MemFS struct {
+	mu    sync.RWMutex
+	files map[string][]byte
+}
+
+// NewMemFS returns an empty MemFS.
+func NewMemFS() *MemFS {
+	return &MemFS{files: make(map[string][]byte)}
+}
+
+// Create implements FS.
+func (f *MemFS) Create(name string) (io.WriteCloser, error) {
+	return &memFile{fs: f, name: name}, nil
+}
+
+// Remove implements FS.
+func (f *MemFS) Remove(name string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, ok := f.files[name]; !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+
+	delete(f.files, name)
+
+	return nil
+}
+
+// ReadFile implements ReadFS.
+func (f *MemFS) ReadFile(name string) ([]byte, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	b, ok := f.files[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+
+	return b, nil
+}
+
+type memFile struct {
+	bytes.Buffer
+	fs   *MemFS
+	name string
+}
+
+func (w *memFile) Close() error {
+	w.fs.mu.Lock()
+	w.fs.files[w.name] = w.Bytes()
+	w.fs.mu.Unlock()
+
+	return nil
+}
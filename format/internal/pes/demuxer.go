@@ -0,0 +1,312 @@
+package pes
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/codec/aacparser"
+	"github.com/vtpl1/avsdk/codec/h264parser"
+	"github.com/vtpl1/avsdk/codec/h265parser"
+	"github.com/vtpl1/avsdk/codec/parser"
+	"github.com/vtpl1/avsdk/internal/ticks"
+)
+
+// Stream is one elementary stream of a program.
+type Stream struct {
+	Idx       uint16
+	CodecType av.CodecType // of the payload: H264, H265, AAC (ADTS), PCM_ALAW or PCM_MULAW
+	Codec     av.CodecData
+	Unwrap    Unwrapper
+	announced bool // codec reported through GetCodecs or Packet.NewCodecs
+	vps       []byte
+	sps       []byte
+	pps       []byte
+}
+
+// Demuxer queues the packets of the streams of a program. ReadUnit is set by
+// the container demuxer: it reads one unit of the container (a TS packet, or
+// a pack header or PES packet of a program stream), passes complete payloads
+// to Handle, and at end of input flushes partial payloads and returns io.EOF.
+type Demuxer struct {
+	ReadUnit      func() error
+	MaxProbeUnits int // units GetCodecs reads while waiting for codecs
+
+	// Program lists the streams of the current program table in order;
+	// HasProgram is set once the first table is read.
+	Program    []*Stream
+	HasProgram bool
+
+	pending []av.Packet
+	probed  bool
+	eof     bool
+	frameID int64
+}
+
+// GetCodecs reads until every stream of the program has produced its codec
+// configuration; packets read meanwhile are queued. It returns no streams,
+// and no error, if none has a codec.
+func (d *Demuxer) GetCodecs(ctx context.Context) ([]av.Stream, error) {
+	if !d.probed {
+		if err := d.probe(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	var streams []av.Stream
+
+	for _, s := range d.Program {
+		if s.Codec != nil {
+			streams = append(streams, av.Stream{Idx: s.Idx, Codec: s.Codec})
+		}
+	}
+
+	return streams, nil
+}
+
+// ReadPacket returns the next queued packet, reading units until one is
+// queued.
+func (d *Demuxer) ReadPacket(ctx context.Context) (av.Packet, error) {
+	if !d.probed {
+		if err := d.probe(ctx); err != nil {
+			return av.Packet{}, err
+		}
+	}
+
+	for len(d.pending) == 0 {
+		if err := ctx.Err(); err != nil {
+			return av.Packet{}, err
+		}
+
+		if d.eof {
+			return av.Packet{}, io.EOF
+		}
+
+		if err := d.readUnit(); err != nil {
+			return av.Packet{}, err
+		}
+	}
+
+	pkt := d.pending[0]
+	d.pending = d.pending[1:]
+
+	return pkt, nil
+}
+
+func (d *Demuxer) readUnit() error {
+	err := d.ReadUnit()
+	if errors.Is(err, io.EOF) {
+		d.eof = true
+
+		return nil
+	}
+
+	return err
+}
+
+func (d *Demuxer) probe(ctx context.Context) error {
+	for range d.MaxProbeUnits {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if d.eof || d.allCodecsKnown() {
+			break
+		}
+
+		if err := d.readUnit(); err != nil {
+			return err
+		}
+	}
+
+	d.probed = true
+
+	for _, s := range d.Program {
+		s.announced = s.Codec != nil
+	}
+
+	return nil
+}
+
+func (d *Demuxer) allCodecsKnown() bool {
+	if !d.HasProgram {
+		return false
+	}
+
+	for _, s := range d.Program {
+		if s.Codec == nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Handle queues the packets of a complete payload of s. For video the payload
+// is one access unit; dts and ptsOffset are in 90 kHz ticks, dts already
+// unwrapped.
+func (d *Demuxer) Handle(s *Stream, data []byte, dts, ptsOffset int64) {
+	switch s.CodecType {
+	case av.H264, av.H265:
+		d.handleVideo(s, data, dts, ptsOffset)
+	case av.AAC:
+		d.handleADTS(s, data, dts)
+	case av.PCM_ALAW, av.PCM_MULAW:
+		d.handleG711(s, data, dts)
+	}
+}
+
+//nolint:gocognit
+func (d *Demuxer) handleVideo(s *Stream, data []byte, dts, ptsOffset int64) {
+	nalus, _ := parser.SplitNALUs(data)
+
+	isH265 := s.CodecType == av.H265
+	keyFrame := false
+	paramSet := false
+
+	var avcc []byte
+
+	for _, nalu := range nalus {
+		if len(nalu) == 0 {
+			continue
+		}
+
+		if isH265 {
+			switch {
+			case av.H265NaluType(nalu[0]>>1)&av.H265NALTypeMask == av.HEVC_NAL_AUD:
+				continue
+			case h265parser.IsVPSNALU(nalu):
+				s.vps, paramSet = bytes.Clone(nalu), true
+			case h265parser.IsSPSNALU(nalu):
+				s.sps, paramSet = bytes.Clone(nalu), true
+			case h265parser.IsPPSNALU(nalu):
+				s.pps, paramSet = bytes.Clone(nalu), true
+			case h265parser.IsKeyFrame(nalu):
+				keyFrame = true
+			}
+		} else {
+			switch {
+			case av.H264NaluType(nalu[0])&av.H264NALTypeMask == av.H264_NAL_AUD:
+				continue
+			case h264parser.IsSPSNALU(nalu):
+				s.sps, paramSet = bytes.Clone(nalu), true
+			case h264parser.IsPPSNALU(nalu):
+				s.pps, paramSet = bytes.Clone(nalu), true
+			case h264parser.IsKeyFrame(nalu):
+				keyFrame = true
+			}
+		}
+
+		avcc = append(avcc, byte(len(nalu)>>24), byte(len(nalu)>>16), byte(len(nalu)>>8), byte(len(nalu)))
+		avcc = append(avcc, nalu...)
+	}
+
+	if paramSet {
+		s.updateVideoCodec()
+	}
+
+	if s.Codec == nil || len(avcc) == 0 {
+		return // not decodable before the first parameter sets
+	}
+
+	pkt := d.newPacket(s, avcc, dts)
+	pkt.PTSOffset = ticks.ToDuration(ptsOffset, ClockRate)
+	pkt.KeyFrame = keyFrame
+	pkt.IsParamSetNALU = paramSet
+	d.pending = append(d.pending, pkt)
+}
+
+func (s *Stream) updateVideoCodec() {
+	if s.sps == nil || s.pps == nil {
+		return
+	}
+
+	switch codec := s.Codec.(type) {
+	case h264parser.CodecData:
+		if bytes.Equal(codec.SPS(), s.sps) && bytes.Equal(codec.PPS(), s.pps) {
+			return
+		}
+	case h265parser.CodecData:
+		if bytes.Equal(codec.VPS(), s.vps) && bytes.Equal(codec.SPS(), s.sps) && bytes.Equal(codec.PPS(), s.pps) {
+			return
+		}
+	}
+
+	if s.CodecType == av.H265 {
+		if s.vps == nil {
+			return
+		}
+
+		if codec, err := h265parser.NewCodecDataFromVPSAndSPSAndPPS(s.vps, s.sps, s.pps); err == nil {
+			s.Codec, s.announced = codec, false
+		}
+
+		return
+	}
+
+	if codec, err := h264parser.NewCodecDataFromSPSAndPPS(s.sps, s.pps); err == nil {
+		s.Codec, s.announced = codec, false
+	}
+}
+
+// handleADTS splits a payload into ADTS frames, one av.Packet per frame.
+func (d *Demuxer) handleADTS(s *Stream, data []byte, dts int64) {
+	for len(data) >= aacparser.ADTSHeaderLength {
+		config, hdrLen, frameLen, samples, err := aacparser.ParseADTSHeader(data)
+		if errors.Is(err, aacparser.ErrAACparserSampleRateIndexInvalid) && frameLen <= len(data) {
+			data = data[frameLen:]
+
+			continue
+		}
+
+		if err != nil || frameLen > len(data) {
+			return
+		}
+
+		if codec, ok := s.Codec.(aacparser.CodecData); !ok || codec.Config != config {
+			if codec, err := aacparser.NewCodecDataFromMPEG4AudioConfig(config); err == nil {
+				s.Codec, s.announced = codec, false
+			}
+		}
+
+		if s.Codec == nil {
+			return
+		}
+
+		pkt := d.newPacket(s, data[hdrLen:frameLen], dts)
+		pkt.KeyFrame = true
+		pkt.Duration = ticks.ToDuration(int64(samples)*ClockRate/int64(config.SampleRate), ClockRate)
+		d.pending = append(d.pending, pkt)
+
+		dts += int64(samples) * ClockRate / int64(config.SampleRate)
+		data = data[frameLen:]
+	}
+}
+
+// handleG711 queues a payload of 8 kHz mono G.711 samples as one packet.
+func (d *Demuxer) handleG711(s *Stream, data []byte, dts int64) {
+	pkt := d.newPacket(s, data, dts)
+	pkt.KeyFrame = true
+	pkt.Duration, _ = s.Codec.(av.AudioCodecData).PacketDuration(data)
+	d.pending = append(d.pending, pkt)
+}
+
+func (d *Demuxer) newPacket(s *Stream, data []byte, dts int64) av.Packet {
+	pkt := av.Packet{
+		Idx:       s.Idx,
+		DTS:       ticks.ToDuration(dts, ClockRate),
+		Data:      append([]byte(nil), data...),
+		FrameID:   d.frameID,
+		CodecType: s.Codec.Type(),
+	}
+	d.frameID++
+
+	if d.probed && !s.announced {
+		pkt.NewCodecs = []av.Stream{{Idx: s.Idx, Codec: s.Codec}}
+		s.announced = true
+	}
+
+	return pkt
+}
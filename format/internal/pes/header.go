@@ -0,0 +1,54 @@
+package pes
+
+import "github.com/vtpl1/avsdk/utils/bits/pio"
+
+// Header holds the timestamps of a PES packet header; DTS equals PTS when the
+// packet carries no separate DTS.
+type Header struct {
+	PTS    int64
+	DTS    int64
+	HasPTS bool
+}
+
+// ParsePacket parses a PES packet with an MPEG-2 optional header (ISO/IEC
+// 13818-1 §2.4.3.6) and returns its header and payload. A non-zero
+// PES_packet_length bounds the payload within b. It reports false for a
+// packet that is truncated or whose length does not cover its header.
+func ParsePacket(b []byte) (Header, []byte, bool) {
+	if len(b) < 9 || b[0] != 0 || b[1] != 0 || b[2] != 1 || b[6]>>6 != 0x02 {
+		return Header{}, nil, false
+	}
+
+	flags := b[7] >> 6
+	end := 9 + int(b[8])
+
+	if end > len(b) {
+		return Header{}, nil, false
+	}
+
+	payload := b[end:]
+
+	if pesLen := int(pio.U16BE(b[4:])); pesLen > 0 {
+		if 6+pesLen < end {
+			return Header{}, nil, false
+		}
+
+		if 6+pesLen < len(b) {
+			payload = b[end : 6+pesLen]
+		}
+	}
+
+	var hdr Header
+
+	if flags&0x02 != 0 && end >= 14 {
+		hdr.PTS = ReadTimestamp(b[9:])
+		hdr.DTS = hdr.PTS
+		hdr.HasPTS = true
+
+		if flags == 0x03 && end >= 19 {
+			hdr.DTS = ReadTimestamp(b[14:])
+		}
+	}
+
+	return hdr, payload, true
+}
@@ -0,0 +1,50 @@
+// Package pes turns the PES payloads of MPEG-2 transport and program streams
+// into av.Packets. The ts and ps demuxers parse their own container layer and
+// hand complete payloads to a Demuxer.
+package pes
+
+// PTS/DTS clock (90 kHz, 33 bits).
+const (
+	ClockRate = 90000
+	tsBits    = 33
+	tsMask    = 1<<tsBits - 1
+)
+
+// ReadTimestamp decodes a 33-bit PTS/DTS field (ISO/IEC 13818-1 §2.4.3.7).
+func ReadTimestamp(b []byte) int64 {
+	return int64(b[0]>>1&0x07)<<30 | int64(b[1])<<22 | int64(b[2]>>1)<<15 | int64(b[3])<<7 | int64(b[4]>>1)
+}
+
+// WrapDiff returns a-b interpreted on the 33-bit circular timeline.
+func WrapDiff(a, b int64) int64 {
+	d := (a - b) & tsMask
+	if d >= 1<<(tsBits-1) {
+		d -= 1 << tsBits
+	}
+
+	return d
+}
+
+// Unwrapper extends 33-bit timestamps into a monotonic 64-bit timeline.
+type Unwrapper struct {
+	last int64
+	base int64
+	init bool
+}
+
+// Unwrap returns raw on the extended timeline.
+func (u *Unwrapper) Unwrap(raw int64) int64 {
+	if u.init {
+		switch d := raw - u.last; {
+		case d < -(1 << (tsBits - 1)):
+			u.base += 1 << tsBits
+		case d > 1<<(tsBits-1):
+			u.base -= 1 << tsBits
+		}
+	}
+
+	u.last = raw
+	u.init = true
+
+	return raw + u.base
+}
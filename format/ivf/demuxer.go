@@ -0,0 +1,190 @@
+package ivf
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/codec"
+	"github.com/vtpl1/avsdk/internal/ticks"
+	"github.com/vtpl1/avsdk/utils/bits/pio"
+)
+
+// maxFrameSize bounds the frame size read from a frame header.
+const maxFrameSize = 64 << 20
+
+type frame struct {
+	pts  int64
+	data []byte
+}
+
+// Demuxer reads the single video stream of an IVF file. Timestamps are
+// converted from the IVF timebase; the duration of a frame is the distance to
+// the next one. Stream.Idx is always 0.
+type Demuxer struct {
+	r        *bufio.Reader
+	codec    av.VideoCodecData
+	num      int64 // timebase numerator
+	den      int64 // timebase denominator
+	next     *frame
+	lastDur  time.Duration
+	frameID  int64
+	hasCodec bool
+}
+
+// NewDemuxer returns a Demuxer reading from r.
+func NewDemuxer(r io.Reader) *Demuxer {
+	return &Demuxer{
+		r: bufio.NewReaderSize(r, pio.RecommendBufioSize),
+	}
+}
+
+// GetCodecs implements av.Demuxer. The codec, size and timebase come from the
+// file header; AV1 codec data comes from the sequence header of the first
+// temporal unit when it has one.
+func (m *Demuxer) GetCodecs(ctx context.Context) ([]av.Stream, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := m.readHeader(); err != nil {
+		return nil, err
+	}
+
+	return []av.Stream{{Idx: 0, Codec: m.codec}}, nil
+}
+
+// ReadPacket implements av.Demuxer.
+func (m *Demuxer) ReadPacket(ctx context.Context) (av.Packet, error) {
+	if err := ctx.Err(); err != nil {
+		return av.Packet{}, err
+	}
+
+	if err := m.readHeader(); err != nil {
+		return av.Packet{}, err
+	}
+
+	cur := m.next
+	if cur == nil {
+		var err error
+		if cur, err = m.readFrame(); err != nil {
+			return av.Packet{}, err
+		}
+	}
+
+	next, err := m.readFrame()
+	if err != nil && !errors.Is(err, io.EOF) {
+		return av.Packet{}, err
+	}
+
+	m.next = next
+
+	dts := m.toDuration(cur.pts)
+	if next != nil {
+		m.lastDur = m.toDuration(next.pts) - dts
+	}
+
+	pkt := av.Packet{
+		KeyFrame:  isKeyFrame(m.codec.Type(), cur.data),
+		Idx:       0,
+		DTS:       dts,
+		Duration:  m.lastDur,
+		Data:      cur.data,
+		FrameID:   m.frameID,
+		CodecType: m.codec.Type(),
+	}
+
+	m.frameID++
+
+	return pkt, nil
+}
+
+func (m *Demuxer) readHeader() error {
+	if m.hasCodec {
+		return nil
+	}
+
+	var b [fileHeaderSize]byte
+	if _, err := io.ReadFull(m.r, b[:]); err != nil || !Probe(b[:]) {
+		return ErrNotIVF
+	}
+
+	le := binary.LittleEndian
+
+	headerLen := int(le.Uint16(b[6:8]))
+	if headerLen > fileHeaderSize {
+		if _, err := m.r.Discard(headerLen - fileHeaderSize); err != nil {
+			return ErrNotIVF
+		}
+	}
+
+	typ := codecType(string(b[8:12]))
+	if typ == av.UNKNOWN {
+		return ErrUnsupportedCodec
+	}
+
+	m.den = int64(le.Uint32(b[16:20]))
+	m.num = int64(le.Uint32(b[20:24]))
+
+	if m.den == 0 || m.num == 0 {
+		return ErrNotIVF
+	}
+
+	m.codec = codec.NewVideoCodecData(typ, int(le.Uint16(b[12:14])), int(le.Uint16(b[14:16])))
+	m.hasCodec = true
+
+	if typ != av.AV1 {
+		return nil
+	}
+
+	// The sequence header of the first temporal unit describes an AV1 stream.
+	next, err := m.readFrame()
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	m.next = next
+
+	if obu := codec.AV1SequenceHeaderOBU(next.data); obu != nil {
+		if av1, err := codec.NewAV1CodecDataFromSequenceHeader(obu); err == nil {
+			m.codec = av1
+		}
+	}
+
+	return nil
+}
+
+func (m *Demuxer) readFrame() (*frame, error) {
+	var b [frameHeaderSize]byte
+	if _, err := io.ReadFull(m.r, b[:]); err != nil {
+		return nil, io.EOF // a truncated header ends the file
+	}
+
+	size := binary.LittleEndian.Uint32(b[0:4])
+	if size > maxFrameSize {
+		return nil, ErrInvalidFrame
+	}
+
+	f := &frame{
+		pts:  int64(binary.LittleEndian.Uint64(b[4:12])),
+		data: make([]byte, size),
+	}
+
+	if _, err := io.ReadFull(m.r, f.data); err != nil {
+		return nil, io.EOF
+	}
+
+	return f, nil
+}
+
+func (m *Demuxer) toDuration(pts int64) time.Duration {
+	return ticks.ToDuration(pts*m.num, m.den)
+}
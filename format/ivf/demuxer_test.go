@@ -0,0 +1,117 @@
+package ivf_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/vtpl1/avsdk/codec"
+	"github.com/vtpl1/avsdk/format/ivf"
+	"github.com/vtpl1/avsdk/internal/avtest"
+)
+
+func ivfFile(fourcc string, den, num uint32, frames ...[]byte) []byte {
+	le := binary.LittleEndian
+	b := make([]byte, 32)
+	copy(b, "DKIF")
+	le.PutUint16(b[6:], 32)
+	copy(b[8:], fourcc)
+	le.PutUint16(b[12:], 320)
+	le.PutUint16(b[14:], 240)
+	le.PutUint32(b[16:], den)
+	le.PutUint32(b[20:], num)
+	le.PutUint32(b[24:], uint32(len(frames)))
+
+	for i, frame := range frames {
+		b = le.AppendUint32(b, uint32(len(frame)))
+		b = le.AppendUint64(b, uint64(i))
+		b = append(b, frame...)
+	}
+
+	return b
+}
+
+func TestDemuxerKeyFrames(t *testing.T) {
+	tests := []struct {
+		fourcc string
+		frames [][]byte
+		keys   []bool
+	}{
+		{"VP80", [][]byte{{0x10, 0x02}, {0x11, 0x02}}, []bool{true, false}},
+		// VP9: key frame, inter frame, show_existing_frame, profile 3 key frame.
+		{"VP90", [][]byte{{0x82}, {0x86}, {0x88}, {0xb0}}, []bool{true, false, false, true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.fourcc, func(t *testing.T) {
+			dmx := ivf.NewDemuxer(bytes.NewReader(ivfFile(tt.fourcc, 30, 1, tt.frames...)))
+
+			if _, err := dmx.GetCodecs(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			pkts := avtest.ReadAll(t, dmx)
+			if len(pkts) != len(tt.keys) {
+				t.Fatalf("got %d packets, want %d", len(pkts), len(tt.keys))
+			}
+
+			for i, pkt := range pkts {
+				if pkt.KeyFrame != tt.keys[i] {
+					t.Fatalf("packet %d: keyframe = %v", i, pkt.KeyFrame)
+				}
+
+				// Timebase 1/30: one tick per frame.
+				if want := time.Duration(i) * time.Second / 30; pkt.DTS != want {
+					t.Fatalf("packet %d: dts = %v, want %v", i, pkt.DTS, want)
+				}
+			}
+		})
+	}
+}
+
+func TestDemuxerLongTimestamps(t *testing.T) {
+	// 30 hours at 90 kHz overflows a nanosecond product.
+	const pts = 30 * 3600 * 90000
+
+	file := ivfFile("VP80", 90000, 1, []byte{0x10, 0x02})
+	binary.LittleEndian.PutUint64(file[36:], pts)
+
+	dmx := ivf.NewDemuxer(bytes.NewReader(file))
+	if _, err := dmx.GetCodecs(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	pkts := avtest.ReadAll(t, dmx)
+	if len(pkts) != 1 || pkts[0].DTS != 30*time.Hour {
+		t.Fatalf("packets = %v", pkts)
+	}
+}
+
+func TestDemuxerAV1SequenceHeader(t *testing.T) {
+	key := append([]byte{0x12, 0x00}, avtest.Unhex(avtest.AV1SequenceHeader)...)
+	dmx := ivf.NewDemuxer(bytes.NewReader(ivfFile("AV01", 30, 1, key, []byte{0x12, 0x00})))
+
+	streams, err := dmx.GetCodecs(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c, ok := streams[0].Codec.(codec.AV1CodecData); !ok || c.Width() != 1280 || c.Height() != 720 {
+		t.Fatalf("codec = %+v", streams[0].Codec)
+	}
+
+	if pkts := avtest.ReadAll(t, dmx); len(pkts) != 2 || !pkts[0].KeyFrame || pkts[1].KeyFrame {
+		t.Fatalf("packets = %v", pkts)
+	}
+}
+
+func TestDemuxerUnsupportedFourCC(t *testing.T) {
+	dmx := ivf.NewDemuxer(bytes.NewReader(ivfFile("H264", 30, 1)))
+
+	if _, err := dmx.GetCodecs(context.Background()); !errors.Is(err, ivf.ErrUnsupportedCodec) {
+		t.Fatalf("err = %v, want ErrUnsupportedCodec", err)
+	}
+}
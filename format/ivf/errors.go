@@ -0,0 +1,14 @@
+package ivf
+
+import "errors"
+
+var (
+	ErrNotIVF                = errors.New("ivf: not an IVF file")
+	ErrUnsupportedCodec      = errors.New("ivf: unsupported codec")
+	ErrInvalidFrame          = errors.New("ivf: invalid frame header")
+	ErrNoStreams             = errors.New("ivf: no VP8, VP9 or AV1 stream")
+	ErrHeaderNotWritten      = errors.New("ivf: WriteHeader not called")
+	ErrHeaderAlreadyWritten  = errors.New("ivf: WriteHeader already called")
+	ErrTrailerAlreadyWritten = errors.New("ivf: WriteTrailer already called")
+	ErrTooManyStreams        = errors.New("ivf: only one video stream is supported")
+)
@@ -0,0 +1,108 @@
+// Package ivf implements a demuxer and muxer for the IVF container (.ivf)
+// carrying VP8, VP9 or AV1.
+package ivf
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/av/avutil"
+	"github.com/vtpl1/avsdk/codec"
+)
+
+const (
+	fileHeaderSize  = 32
+	frameHeaderSize = 12
+	signature       = "DKIF"
+)
+
+// IVF FourCCs.
+const (
+	FourCCVP8 = "VP80"
+	FourCCVP9 = "VP90"
+	FourCCAV1 = "AV01"
+)
+
+// Probe reports whether b starts with an IVF file header.
+func Probe(b []byte) bool {
+	return len(b) >= 4 && bytes.Equal(b[:4], []byte(signature))
+}
+
+// Handler registers the ".ivf" extension with avutil.
+func Handler(h *avutil.RegisterHandler) {
+	h.Ext = ".ivf"
+	h.Probe = Probe
+	h.ReaderDemuxer = func(r io.Reader) av.Demuxer {
+		return NewDemuxer(r)
+	}
+	h.WriterMuxer = func(w io.Writer) av.Muxer {
+		return NewMuxer(w)
+	}
+	h.CodecTypes = []av.CodecType{av.VP8, av.VP9, av.AV1}
+}
+
+func codecType(fourcc string) av.CodecType {
+	switch fourcc {
+	case FourCCVP8:
+		return av.VP8
+	case FourCCVP9:
+		return av.VP9
+	case FourCCAV1:
+		return av.AV1
+	default:
+		return av.UNKNOWN
+	}
+}
+
+func fourCC(typ av.CodecType) string {
+	switch typ {
+	case av.VP8:
+		return FourCCVP8
+	case av.VP9:
+		return FourCCVP9
+	case av.AV1:
+		return FourCCAV1
+	default:
+		return ""
+	}
+}
+
+// isKeyFrame reports whether frame is a VP8/VP9 key frame or an AV1 temporal
+// unit starting with a sequence header.
+func isKeyFrame(typ av.CodecType, frame []byte) bool {
+	if len(frame) == 0 {
+		return false
+	}
+
+	switch typ {
+	case av.VP8:
+		// Frame tag bit 0: 0 for key frames (RFC 6386 §9.1).
+		return frame[0]&0x01 == 0
+	case av.VP9:
+		return vp9KeyFrame(frame[0])
+	case av.AV1:
+		return codec.AV1SequenceHeaderOBU(frame) != nil
+	default:
+		return false
+	}
+}
+
+// vp9KeyFrame inspects the first byte of the uncompressed header: frame_marker(2),
+// profile_low_bit, profile_high_bit, [reserved_zero if profile 3],
+// show_existing_frame, frame_type.
+func vp9KeyFrame(b byte) bool {
+	if b>>6 != 0b10 {
+		return false
+	}
+
+	shift := 3
+	if b&0x30 == 0x30 {
+		shift = 2
+	}
+
+	showExisting := b >> shift & 1
+	frameType := b >> (shift - 1) & 1
+
+	return showExisting == 0 && frameType == 0
+}
@@ -0,0 +1,162 @@
+package ivf
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/internal/ticks"
+)
+
+// DefaultTimeBase is the timebase denominator written by the Muxer: one tick per millisecond.
+const DefaultTimeBase = 1000
+
+// Muxer writes a single VP8, VP9 or AV1 stream as IVF. Other streams are
+// ignored. It implements av.Muxer.
+//
+// The frame count in the file header is patched in WriteTrailer when the
+// writer is an io.WriteSeeker.
+type Muxer struct {
+	w      io.Writer
+	ws     io.WriteSeeker // set if w supports seeking
+	base   int64          // offset of the file header in ws
+	idx    uint16
+	typ    av.CodecType
+	frames uint32
+	hdr    [frameHeaderSize]byte
+	stage  int
+}
+
+// NewMuxer returns a Muxer writing to w.
+func NewMuxer(w io.Writer) *Muxer {
+	return &Muxer{w: w}
+}
+
+// WriteHeader implements av.Muxer. Exactly one VP8, VP9 or AV1 stream is required.
+func (m *Muxer) WriteHeader(_ context.Context, streams []av.Stream) error {
+	if m.stage != 0 {
+		return ErrHeaderAlreadyWritten
+	}
+
+	var found *av.Stream
+
+	for i, stream := range streams {
+		if !stream.Codec.Type().IsVideo() {
+			continue
+		}
+
+		if found != nil {
+			return ErrTooManyStreams
+		}
+
+		if fourCC(stream.Codec.Type()) == "" {
+			return ErrUnsupportedCodec
+		}
+
+		found = &streams[i]
+	}
+
+	if found == nil {
+		return ErrNoStreams
+	}
+
+	m.idx = found.Idx
+	m.typ = found.Codec.Type()
+
+	var width, height int
+	if vc, ok := found.Codec.(av.VideoCodecData); ok {
+		width, height = vc.Width(), vc.Height()
+	}
+
+	le := binary.LittleEndian
+	b := make([]byte, fileHeaderSize)
+	copy(b, signature)
+	le.PutUint16(b[4:], 0) // version
+	le.PutUint16(b[6:], fileHeaderSize)
+	copy(b[8:], fourCC(m.typ))
+	le.PutUint16(b[12:], uint16(width))
+	le.PutUint16(b[14:], uint16(height))
+	le.PutUint32(b[16:], DefaultTimeBase)
+	le.PutUint32(b[20:], 1)
+	// frame count at 24 is patched in WriteTrailer
+
+	if ws, ok := m.w.(io.WriteSeeker); ok {
+		if base, err := ws.Seek(0, io.SeekCurrent); err == nil {
+			m.ws = ws
+			m.base = base
+		}
+	}
+
+	if _, err := m.w.Write(b); err != nil {
+		return err
+	}
+
+	m.stage++
+
+	return nil
+}
+
+// WritePacket implements av.Muxer. The frame timestamp is the packet PTS in milliseconds.
+func (m *Muxer) WritePacket(_ context.Context, pkt av.Packet) error {
+	if m.stage != 1 {
+		return ErrHeaderNotWritten
+	}
+
+	if pkt.Idx != m.idx {
+		return nil
+	}
+
+	pts := ticks.FromDuration(pkt.DTS+pkt.PTSOffset, DefaultTimeBase)
+
+	binary.LittleEndian.PutUint32(m.hdr[0:], uint32(len(pkt.Data)))
+	binary.LittleEndian.PutUint64(m.hdr[4:], uint64(pts))
+
+	if _, err := m.w.Write(m.hdr[:]); err != nil {
+		return err
+	}
+
+	if _, err := m.w.Write(pkt.Data); err != nil {
+		return err
+	}
+
+	m.frames++
+
+	return nil
+}
+
+// WriteTrailer implements av.Muxer.
+func (m *Muxer) WriteTrailer(_ context.Context) error {
+	switch m.stage {
+	case 0:
+		return ErrHeaderNotWritten
+	case 2:
+		return ErrTrailerAlreadyWritten
+	}
+
+	m.stage++
+
+	if m.ws == nil {
+		return nil
+	}
+
+	end, err := m.ws.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], m.frames)
+
+	if _, err := m.ws.Seek(m.base+24, io.SeekStart); err != nil {
+		return err
+	}
+
+	if _, err := m.ws.Write(b[:]); err != nil {
+		return err
+	}
+
+	_, err = m.ws.Seek(end, io.SeekStart)
+
+	return err
+}
@@ -0,0 +1,93 @@
+package ivf_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/codec"
+	"github.com/vtpl1/avsdk/codec/pcm"
+	"github.com/vtpl1/avsdk/format/ivf"
+	"github.com/vtpl1/avsdk/internal/avtest"
+)
+
+func TestAV1RoundTrip(t *testing.T) {
+	ctx := context.Background()
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "out.ivf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	streams := []av.Stream{
+		{Idx: 0, Codec: pcm.NewPCMMulawCodecData()},
+		{Idx: 1, Codec: codec.NewVideoCodecData(av.AV1, 1280, 720)},
+	}
+
+	mux := ivf.NewMuxer(f)
+	if err := mux.WriteHeader(ctx, streams); err != nil {
+		t.Fatal(err)
+	}
+
+	// Temporal delimiter + sequence header, then temporal delimiter + frame OBU.
+	key := []byte{0x12, 0x00, 0x0a, 0x01, 0x00}
+	inter := []byte{0x12, 0x00, 0x32, 0x01, 0xff}
+
+	for i := range 4 {
+		data := inter
+		if i == 0 {
+			data = key
+		}
+
+		pkt := av.Packet{Idx: 1, DTS: time.Duration(i) * 40 * time.Millisecond, Data: data}
+		if err := mux.WritePacket(ctx, pkt); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := mux.WritePacket(ctx, av.Packet{Idx: 0, Data: []byte{0xff}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := mux.WriteTrailer(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !ivf.Probe(b) || string(b[8:12]) != "AV01" || binary.LittleEndian.Uint32(b[24:]) != 4 {
+		t.Fatalf("file header = % x", b[:32])
+	}
+
+	dmx := ivf.NewDemuxer(bytes.NewReader(b))
+
+	got, err := dmx.GetCodecs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vc, ok := got[0].Codec.(av.VideoCodecData)
+	if !ok || vc.Type() != av.AV1 || vc.Width() != 1280 || vc.Height() != 720 {
+		t.Fatalf("codec = %+v", got[0].Codec)
+	}
+
+	pkts := avtest.ReadAll(t, dmx)
+	if len(pkts) != 4 {
+		t.Fatalf("got %d packets, want 4", len(pkts))
+	}
+
+	for i, pkt := range pkts {
+		if pkt.DTS != time.Duration(i)*40*time.Millisecond || pkt.Duration != 40*time.Millisecond || pkt.KeyFrame != (i == 0) {
+			t.Fatalf("packet %d: %v", i, pkt.String())
+		}
+	}
+}
@@ -0,0 +1,831 @@
+package mkv
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/codec"
+	"github.com/vtpl1/avsdk/codec/aacparser"
+	"github.com/vtpl1/avsdk/codec/h264parser"
+	"github.com/vtpl1/avsdk/codec/h265parser"
+	"github.com/vtpl1/avsdk/internal/ticks"
+	"github.com/vtpl1/avsdk/utils/bits/pio"
+)
+
+// Lacing modes of the Block header flags.
+const (
+	lacingNone  = 0
+	lacingXiph  = 1
+	lacingFixed = 2
+	lacingEBML  = 3
+)
+
+// maxGOPFrames bounds the frames held back to rebuild decoding timestamps
+// when keyframes are rare.
+const maxGOPFrames = 300
+
+type trackEntry struct {
+	number          uint64
+	codecID         string
+	private         []byte
+	defaultDuration time.Duration
+	width           uint64
+	height          uint64
+	codec           av.CodecData
+
+	// Decoding timestamp reconstruction for codecs with B-frames.
+	reorder bool
+	gop     []*queued     // frames of the current GOP, with their PTS as DTS
+	delay   time.Duration // largest lag of decoding behind presentation seen
+	lastDTS time.Duration
+	hasDTS  bool
+}
+
+// queued is a packet in the Demuxer queue. A held packet waits for the end
+// of its GOP to get its decoding timestamp.
+type queued struct {
+	pkt  av.Packet
+	held bool
+}
+
+type cue struct {
+	time     int64 // in TimestampScale units
+	track    uint64
+	position int64 // relative to the Segment data
+}
+
+// Demuxer reads H.264, H.265, VP8, VP9, AV1, AAC and Opus tracks from
+// Matroska and WebM. Stream.Idx is the TrackNumber minus one. Blocks of other
+// tracks are dropped. It implements av.Demuxer, and av.TimeSeeker when r is
+// an io.ReadSeeker and the file has Cues.
+//
+// Matroska stores presentation timestamps only. For H.264 and H.265, whose
+// frames may be stored out of presentation order, each GOP is held until the
+// next keyframe and its decoding timestamps are rebuilt from the sorted
+// presentation timestamps, delayed by the largest reordering seen so far;
+// PTSOffset carries the difference. Other packets have a zero PTSOffset.
+type Demuxer struct {
+	r             ebmlReader
+	rs            io.ReadSeeker
+	tracks        map[uint64]*trackEntry
+	streams       []av.Stream
+	scale         time.Duration // duration of one timestamp tick
+	segmentData   int64
+	segmentEnd    int64 // -1 if the Segment size is unknown
+	cuesPos       int64 // -1 if there is no SeekHead entry for Cues
+	cues          []cue
+	clusterTime   int64
+	pending       []*queued
+	parsed        bool
+	discontinuity bool
+	frameID       int64
+}
+
+// NewDemuxer returns a Demuxer reading from r.
+func NewDemuxer(r io.Reader) *Demuxer {
+	m := &Demuxer{
+		r:          ebmlReader{r: bufio.NewReaderSize(r, pio.RecommendBufioSize)},
+		tracks:     make(map[uint64]*trackEntry),
+		scale:      time.Millisecond,
+		segmentEnd: -1,
+		cuesPos:    -1,
+	}
+
+	if rs, ok := r.(io.ReadSeeker); ok {
+		if pos, err := rs.Seek(0, io.SeekCurrent); err == nil {
+			m.rs = rs
+			m.r.pos = pos
+		}
+	}
+
+	return m
+}
+
+// GetCodecs implements av.Demuxer. It reads the Segment up to the first Cluster.
+func (m *Demuxer) GetCodecs(ctx context.Context) ([]av.Stream, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := m.probe(); err != nil {
+		return nil, err
+	}
+
+	return m.streams, nil
+}
+
+// ReadPacket implements av.Demuxer. Laced blocks are split into one packet per frame.
+func (m *Demuxer) ReadPacket(ctx context.Context) (av.Packet, error) {
+	if err := ctx.Err(); err != nil {
+		return av.Packet{}, err
+	}
+
+	if err := m.probe(); err != nil {
+		return av.Packet{}, err
+	}
+
+	for len(m.pending) == 0 || m.pending[0].held {
+		if err := m.readElement(); err != nil && (!errors.Is(err, io.EOF) || !m.endGOPs()) {
+			return av.Packet{}, err
+		}
+	}
+
+	pkt := m.pending[0].pkt
+	m.pending = m.pending[1:]
+
+	return pkt, nil
+}
+
+// SeekToTime implements av.TimeSeeker. It jumps to the cue point at or before
+// pos, preferring cue points of the first video track.
+func (m *Demuxer) SeekToTime(_ context.Context, pos time.Duration) (time.Duration, error) {
+	if err := m.probe(); err != nil {
+		return 0, err
+	}
+
+	if m.rs == nil {
+		return 0, ErrNotSeekable
+	}
+
+	if m.cues == nil && m.cuesPos >= 0 {
+		if err := m.loadCues(); err != nil {
+			return 0, err
+		}
+	}
+
+	var videoTrack uint64
+
+	for _, stream := range m.streams {
+		if stream.Codec.Type().IsVideo() {
+			videoTrack = uint64(stream.Idx) + 1
+
+			break
+		}
+	}
+
+	target := int64(pos / m.scale)
+
+	found := m.findCue(target, videoTrack)
+	if found == nil {
+		found = m.findCue(target, 0)
+	}
+
+	if found == nil {
+		return 0, ErrNoCues
+	}
+
+	if err := m.seek(m.segmentData + found.position); err != nil {
+		return 0, err
+	}
+
+	m.pending = nil
+	m.clusterTime = found.time
+
+	for _, t := range m.tracks {
+		t.gop, t.hasDTS = nil, false
+	}
+
+	m.discontinuity = true
+
+	return time.Duration(found.time) * m.scale, nil
+}
+
+// findCue returns the last cue point of track (any track if 0) at or before
+// target, or the earliest one if they all follow target.
+func (m *Demuxer) findCue(target int64, track uint64) *cue {
+	var before, first *cue
+
+	for i := range m.cues {
+		c := &m.cues[i]
+		if track != 0 && c.track != track {
+			continue
+		}
+
+		if c.time <= target && (before == nil || c.time >= before.time) {
+			before = c
+		}
+
+		if first == nil || c.time < first.time {
+			first = c
+		}
+	}
+
+	if before != nil {
+		return before
+	}
+
+	return first
+}
+
+func (m *Demuxer) seek(pos int64) error {
+	if _, err := m.rs.Seek(pos, io.SeekStart); err != nil {
+		return err
+	}
+
+	m.r.r.Reset(m.rs)
+	m.r.pos = pos
+
+	return nil
+}
+
+func (m *Demuxer) probe() error {
+	if m.parsed {
+		if len(m.streams) == 0 {
+			return ErrNoStreams
+		}
+
+		return nil
+	}
+
+	m.parsed = true
+
+	if err := m.readEBMLHeader(); err != nil {
+		return err
+	}
+
+	for {
+		id, size, err := m.r.readHeader()
+		if err != nil {
+			if errors.Is(err, io.EOF) && len(m.streams) > 0 {
+				return nil
+			}
+
+			return ErrNoStreams
+		}
+
+		if id == idCluster {
+			// Blocks are read by ReadPacket from here on.
+			break
+		}
+
+		if err := m.readTopLevel(id, size); err != nil {
+			return err
+		}
+	}
+
+	if len(m.streams) == 0 {
+		return ErrNoStreams
+	}
+
+	return nil
+}
+
+func (m *Demuxer) readEBMLHeader() error {
+	id, size, err := m.r.readHeader()
+	if err != nil || id != idEBML {
+		return ErrNotMatroska
+	}
+
+	header, err := m.r.read(size)
+	if err != nil {
+		return ErrNotMatroska
+	}
+
+	var docType string
+
+	_ = children(header, func(id uint32, data []byte) error {
+		if id == idDocType {
+			docType = strings.TrimRight(string(data), "\x00")
+		}
+
+		return nil
+	})
+
+	if docType != "matroska" && docType != "webm" {
+		return ErrNotMatroska
+	}
+
+	id, size, err = m.r.readHeader()
+	if err != nil || id != idSegment {
+		return ErrNotMatroska
+	}
+
+	m.segmentData = m.r.pos
+	if size >= 0 {
+		m.segmentEnd = m.segmentData + size
+	}
+
+	return nil
+}
+
+// readTopLevel handles a Segment child other than Cluster.
+func (m *Demuxer) readTopLevel(id uint32, size int64) error {
+	switch id {
+	case idInfo, idTracks, idSeekHead, idCues:
+		b, err := m.r.read(size)
+		if err != nil {
+			return err
+		}
+
+		switch id {
+		case idInfo:
+			return m.parseInfo(b)
+		case idTracks:
+			return m.parseTracks(b)
+		case idSeekHead:
+			return m.parseSeekHead(b)
+		default:
+			return m.parseCues(b)
+		}
+	default:
+		return m.r.skip(size)
+	}
+}
+
+// readElement reads the next element inside or between Clusters and queues
+// the packets of any block it contains.
+func (m *Demuxer) readElement() error {
+	if m.segmentEnd >= 0 && m.r.pos >= m.segmentEnd {
+		return io.EOF
+	}
+
+	id, size, err := m.r.readHeader()
+	if err != nil {
+		return eof(err)
+	}
+
+	switch id {
+	case idCluster:
+		// Descend into the Cluster; its children follow.
+		return nil
+	case idTimestamp:
+		b, err := m.r.read(size)
+		if err != nil {
+			return err
+		}
+
+		m.clusterTime = int64(readUint(b))
+
+		return nil
+	case idSimpleBlock:
+		b, err := m.r.read(size)
+		if err != nil {
+			return err
+		}
+
+		return m.parseBlock(b, true, false, -1)
+	case idBlockGroup:
+		b, err := m.r.read(size)
+		if err != nil {
+			return err
+		}
+
+		return m.parseBlockGroup(b)
+	case idEBML, idSegment:
+		// A chained Segment follows; it is not supported.
+		return io.EOF
+	default:
+		return m.readTopLevel(id, size)
+	}
+}
+
+func (m *Demuxer) parseInfo(b []byte) error {
+	return children(b, func(id uint32, data []byte) error {
+		if id == idTimestampScale {
+			if scale := readUint(data); scale > 0 {
+				m.scale = time.Duration(scale)
+			}
+		}
+
+		return nil
+	})
+}
+
+func (m *Demuxer) parseTracks(b []byte) error {
+	return children(b, func(id uint32, data []byte) error {
+		if id != idTrackEntry {
+			return nil
+		}
+
+		t := &trackEntry{}
+		if err := children(data, t.parse); err != nil {
+			return err
+		}
+
+		if t.number == 0 || t.number > 1<<16 {
+			return nil
+		}
+
+		if _, ok := m.tracks[t.number]; ok {
+			return nil
+		}
+
+		codec, err := t.codecData()
+		if err != nil {
+			return err
+		}
+
+		t.codec = codec
+		t.reorder = t.codecID == CodecIDH264 || t.codecID == CodecIDH265
+		m.tracks[t.number] = t
+
+		if codec != nil {
+			m.streams = append(m.streams, av.Stream{Idx: uint16(t.number - 1), Codec: codec})
+		}
+
+		return nil
+	})
+}
+
+func (t *trackEntry) parse(id uint32, data []byte) error {
+	switch id {
+	case idTrackNumber:
+		t.number = readUint(data)
+	case idCodecID:
+		t.codecID = strings.TrimRight(string(data), "\x00")
+	case idCodecPrivate:
+		t.private = data
+	case idDefaultDuration:
+		t.defaultDuration = time.Duration(readUint(data))
+	case idVideo:
+		return children(data, func(id uint32, data []byte) error {
+			switch id {
+			case idPixelWidth:
+				t.width = readUint(data)
+			case idPixelHeight:
+				t.height = readUint(data)
+			}
+
+			return nil
+		})
+	}
+
+	return nil
+}
+
+// codecData maps the CodecID and CodecPrivate of t to codec data. It returns
+// nil for codecs the demuxer does not support.
+func (t *trackEntry) codecData() (av.CodecData, error) {
+	switch t.codecID {
+	case CodecIDH264:
+		return h264parser.NewCodecDataFromAVCDecoderConfRecord(t.private)
+	case CodecIDH265:
+		return h265parser.NewCodecDataFromAVCDecoderConfRecord(t.private)
+	case CodecIDVP8:
+		return codec.NewVideoCodecData(av.VP8, int(t.width), int(t.height)), nil
+	case CodecIDVP9:
+		return codec.NewVideoCodecData(av.VP9, int(t.width), int(t.height)), nil
+	case CodecIDAV1:
+		if len(t.private) <= 4 { // no config OBUs after the av1C header
+			return codec.NewVideoCodecData(av.AV1, int(t.width), int(t.height)), nil
+		}
+
+		return codec.ParseAV1CodecConfigurationRecord(t.private)
+	case CodecIDAAC:
+		return aacparser.NewCodecDataFromMPEG4AudioConfigBytes(t.private)
+	case CodecIDOpus:
+		return codec.ParseOpusHead(t.private)
+	default:
+		return nil, nil
+	}
+}
+
+// frameDuration is the duration of one frame of t when the block does not say.
+func (t *trackEntry) frameDuration() time.Duration {
+	if t.defaultDuration > 0 {
+		return t.defaultDuration
+	}
+
+	if aac, ok := t.codec.(aacparser.CodecData); ok && aac.SampleRate() > 0 {
+		return ticks.ToDuration(1024, int64(aac.SampleRate()))
+	}
+
+	return 0
+}
+
+func (m *Demuxer) parseSeekHead(b []byte) error {
+	return children(b, func(id uint32, data []byte) error {
+		if id != idSeek {
+			return nil
+		}
+
+		var (
+			seekID  uint64
+			seekPos int64 = -1
+		)
+
+		err := children(data, func(id uint32, data []byte) error {
+			switch id {
+			case idSeekID:
+				seekID = readUint(data)
+			case idSeekPosition:
+				seekPos = int64(readUint(data))
+			}
+
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if seekID == idCues && seekPos >= 0 {
+			m.cuesPos = seekPos
+		}
+
+		return nil
+	})
+}
+
+func (m *Demuxer) parseCues(b []byte) error {
+	var cues []cue
+
+	err := children(b, func(id uint32, data []byte) error {
+		if id != idCuePoint {
+			return nil
+		}
+
+		var cueTime int64
+
+		return children(data, func(id uint32, data []byte) error {
+			switch id {
+			case idCueTime:
+				cueTime = int64(readUint(data))
+			case idCueTrackPositions:
+				c := cue{time: cueTime, position: -1}
+
+				err := children(data, func(id uint32, data []byte) error {
+					switch id {
+					case idCueTrack:
+						c.track = readUint(data)
+					case idCueClusterPosition:
+						c.position = int64(readUint(data))
+					}
+
+					return nil
+				})
+				if err != nil {
+					return err
+				}
+
+				if c.position >= 0 {
+					cues = append(cues, c)
+				}
+			}
+
+			return nil
+		})
+	})
+	if err != nil {
+		return err
+	}
+
+	m.cues = cues
+
+	return nil
+}
+
+// loadCues reads the Cues element the SeekHead points to and restores the
+// read position.
+func (m *Demuxer) loadCues() error {
+	resume := m.r.pos
+
+	if err := m.seek(m.segmentData + m.cuesPos); err != nil {
+		return err
+	}
+
+	id, size, err := m.r.readHeader()
+	if err == nil && id != idCues {
+		err = ErrNoCues
+	}
+
+	if err == nil {
+		var b []byte
+		if b, err = m.r.read(size); err == nil {
+			err = m.parseCues(b)
+		}
+	}
+
+	if seekErr := m.seek(resume); seekErr != nil {
+		return seekErr
+	}
+
+	return err
+}
+
+func (m *Demuxer) parseBlockGroup(b []byte) error {
+	var (
+		block    []byte
+		duration int64 = -1
+		keyFrame       = true
+	)
+
+	err := children(b, func(id uint32, data []byte) error {
+		switch id {
+		case idBlock:
+			block = data
+		case idBlockDuration:
+			duration = int64(readUint(data))
+		case idReferenceBlock:
+			keyFrame = false
+		}
+
+		return nil
+	})
+	if err != nil || block == nil {
+		return err
+	}
+
+	return m.parseBlock(block, false, keyFrame, duration)
+}
+
+// parseBlock queues the frames of a SimpleBlock or Block. For a SimpleBlock
+// the keyframe flag is taken from the header; duration is in timestamp ticks
+// or -1 if the block does not carry one.
+func (m *Demuxer) parseBlock(b []byte, simple, keyFrame bool, duration int64) error {
+	number, n := vint(b)
+	if n == 0 || len(b) < n+3 {
+		return ErrInvalidElement
+	}
+
+	rel := int16(pio.U16BE(b[n:]))
+	flags := b[n+2]
+	b = b[n+3:]
+
+	t, ok := m.tracks[number]
+	if !ok || t.codec == nil {
+		return nil
+	}
+
+	if simple {
+		keyFrame = flags&0x80 != 0
+	}
+
+	frames, err := splitLaces(b, int(flags>>1)&3)
+	if err != nil {
+		return err
+	}
+
+	isAudio := t.codec.Type().IsAudio()
+	pts := time.Duration(m.clusterTime+int64(rel)) * m.scale
+	frameDur := t.frameDuration()
+
+	if len(frames) == 1 && duration >= 0 {
+		frameDur = time.Duration(duration) * m.scale
+	}
+
+	for i, frame := range frames {
+		q := &queued{pkt: av.Packet{
+			KeyFrame:        keyFrame || isAudio,
+			IsDiscontinuity: m.discontinuity,
+			Idx:             uint16(number - 1),
+			DTS:             pts + time.Duration(i)*frameDur,
+			Duration:        frameDur,
+			Data:            frame,
+			FrameID:         m.frameID,
+			CodecType:       t.codec.Type(),
+		}}
+
+		if t.reorder {
+			if (keyFrame && len(t.gop) > 0) || len(t.gop) >= maxGOPFrames {
+				t.endGOP()
+			}
+
+			q.held = true
+			t.gop = append(t.gop, q)
+		}
+
+		m.pending = append(m.pending, q)
+
+		m.discontinuity = false
+		m.frameID++
+	}
+
+	return nil
+}
+
+// splitLaces splits the payload of a laced block into frames.
+func splitLaces(b []byte, lacing int) ([][]byte, error) {
+	if lacing == lacingNone {
+		return [][]byte{b}, nil
+	}
+
+	if len(b) == 0 {
+		return nil, ErrInvalidElement
+	}
+
+	count := int(b[0]) + 1
+	b = b[1:]
+	sizes := make([]int, count)
+
+	switch lacing {
+	case lacingXiph:
+		for i := range count - 1 {
+			for {
+				if len(b) == 0 {
+					return nil, ErrInvalidElement
+				}
+
+				v := b[0]
+				b = b[1:]
+				sizes[i] += int(v)
+
+				if v != 0xff {
+					break
+				}
+			}
+		}
+	case lacingFixed:
+		if len(b)%count != 0 {
+			return nil, ErrInvalidElement
+		}
+
+		for i := range sizes {
+			sizes[i] = len(b) / count
+		}
+	case lacingEBML:
+		first, n := vint(b)
+		if n == 0 {
+			return nil, ErrInvalidElement
+		}
+
+		b = b[n:]
+		sizes[0] = int(first)
+
+		for i := 1; i < count-1; i++ {
+			raw, n := vint(b)
+			if n == 0 {
+				return nil, ErrInvalidElement
+			}
+
+			b = b[n:]
+			// Signed difference: subtract half the range of an n-byte vint.
+			sizes[i] = sizes[i-1] + int(int64(raw)-(1<<(7*n-1)-1))
+		}
+	}
+
+	if lacing != lacingFixed {
+		used := 0
+		for _, size := range sizes[:count-1] {
+			if size < 0 {
+				return nil, ErrInvalidElement
+			}
+
+			used += size
+		}
+
+		if used > len(b) {
+			return nil, ErrInvalidElement
+		}
+
+		sizes[count-1] = len(b) - used
+	}
+
+	frames := make([][]byte, count)
+	for i, size := range sizes {
+		frames[i] = b[:size]
+		b = b[size:]
+	}
+
+	return frames, nil
+}
+
+// endGOP gives the frames of the current GOP their decoding timestamps: the
+// presentation timestamps in increasing order, moved back by the largest
+// reordering delay seen so far and never decreasing.
+func (t *trackEntry) endGOP() {
+	pts := make([]time.Duration, len(t.gop))
+	for i, q := range t.gop {
+		pts[i] = q.pkt.DTS
+	}
+
+	sorted := slices.Clone(pts)
+	slices.Sort(sorted)
+
+	for i := range pts {
+		t.delay = max(t.delay, sorted[i]-pts[i])
+	}
+
+	for i, q := range t.gop {
+		dts := sorted[i] - t.delay
+		if t.hasDTS {
+			dts = max(dts, t.lastDTS)
+		}
+
+		q.pkt.DTS, q.pkt.PTSOffset = dts, max(pts[i]-dts, 0)
+		q.held = false
+		t.lastDTS, t.hasDTS = dts, true
+	}
+
+	t.gop = t.gop[:0]
+}
+
+// endGOPs ends the GOP of every track at the end of the file. It reports
+// whether any packet was released.
+func (m *Demuxer) endGOPs() bool {
+	released := false
+
+	for _, t := range m.tracks {
+		if len(t.gop) > 0 {
+			t.endGOP()
+			released = true
+		}
+	}
+
+	return released
+}
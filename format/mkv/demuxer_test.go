@@ -0,0 +1,410 @@
+package mkv_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/av/avutil"
+	"github.com/vtpl1/avsdk/codec"
+	"github.com/vtpl1/avsdk/codec/aacparser"
+	"github.com/vtpl1/avsdk/codec/h264parser"
+	"github.com/vtpl1/avsdk/format/mkv"
+	"github.com/vtpl1/avsdk/internal/avtest"
+)
+
+// el encodes an EBML element with an 8-byte size.
+func el(id uint32, payload ...[]byte) []byte {
+	var b []byte
+
+	for shift := 24; shift >= 0; shift -= 8 {
+		if c := byte(id >> shift); c != 0 || len(b) > 0 {
+			b = append(b, c)
+		}
+	}
+
+	body := bytes.Join(payload, nil)
+	b = binary.BigEndian.AppendUint64(b, 1<<56|uint64(len(body)))
+
+	return append(b, body...)
+}
+
+func writeTestFile(t *testing.T) (string, h264parser.CodecData) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	video := avtest.H264(t, avtest.SPS320x192)
+
+	audio := avtest.AAC(t)
+
+	name := filepath.Join(t.TempDir(), "in.mkv")
+
+	f, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	mux := mkv.NewMuxer(f)
+	if err := mux.WriteHeader(ctx, []av.Stream{{Idx: 0, Codec: video}, {Idx: 4, Codec: audio}}); err != nil {
+		t.Fatal(err)
+	}
+
+	frame := 40 * time.Millisecond
+
+	for i := range 9 {
+		pkt := av.Packet{Idx: 0, KeyFrame: i%3 == 0, DTS: time.Duration(i) * frame, Duration: frame, Data: []byte{0, 0, 0, 3, 0x41, 0x9a, byte(i)}}
+		if pkt.KeyFrame {
+			pkt.Data[4] = 0x65
+		}
+
+		if err := mux.WritePacket(ctx, pkt); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := mux.WritePacket(ctx, av.Packet{Idx: 4, DTS: pkt.DTS, Data: []byte{0x21, byte(i)}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := mux.WriteTrailer(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	return name, video
+}
+
+func TestDemuxerRoundTripAndSeek(t *testing.T) {
+	ctx := context.Background()
+	name, video := writeTestFile(t)
+
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	dmx := mkv.NewDemuxer(f)
+
+	streams, err := dmx.GetCodecs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(streams) != 2 || streams[0].Idx != 0 || streams[1].Idx != 4 {
+		t.Fatalf("streams = %+v", streams)
+	}
+
+	got, ok := streams[0].Codec.(h264parser.CodecData)
+	if !ok || !bytes.Equal(got.AVCDecoderConfRecordBytes(), video.AVCDecoderConfRecordBytes()) {
+		t.Fatalf("video codec = %+v", streams[0].Codec)
+	}
+
+	if aac, ok := streams[1].Codec.(aacparser.CodecData); !ok || aac.SampleRate() != 44100 {
+		t.Fatalf("audio codec = %+v", streams[1].Codec)
+	}
+
+	pkts := avtest.ReadAll(t, dmx)
+	if len(pkts) != 18 {
+		t.Fatalf("got %d packets, want 18", len(pkts))
+	}
+
+	for i, pkt := range pkts {
+		if pkt.DTS != time.Duration(i/2)*40*time.Millisecond || pkt.IsDiscontinuity {
+			t.Fatalf("packet %d: %v", i, pkt.String())
+		}
+
+		if pkt.Idx == 0 && pkt.KeyFrame != (i/2%3 == 0) {
+			t.Fatalf("packet %d: keyframe = %v", i, pkt.KeyFrame)
+		}
+	}
+
+	if pkts[1].Duration != 1024*time.Second/44100 {
+		t.Fatalf("AAC duration = %v", pkts[1].Duration)
+	}
+
+	landed, err := dmx.SeekToTime(ctx, 250*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if landed != 240*time.Millisecond {
+		t.Fatalf("landed = %v, want 240ms", landed)
+	}
+
+	pkt, err := dmx.ReadPacket(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !pkt.IsDiscontinuity || !pkt.KeyFrame || pkt.DTS != landed || !bytes.Equal(pkt.Data, []byte{0, 0, 0, 3, 0x65, 0x9a, 6}) {
+		t.Fatalf("first packet after seek: %v", pkt.String())
+	}
+
+	if _, err := dmx.SeekToTime(ctx, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if pkts := avtest.ReadAll(t, dmx); len(pkts) != 18 {
+		t.Fatalf("got %d packets after seeking to 0, want 18", len(pkts))
+	}
+}
+
+func TestDemuxerLacingAndBlockGroup(t *testing.T) {
+	ctx := context.Background()
+
+	video := avtest.H264(t, avtest.SPS320x192)
+
+	header := el(0x1a45dfa3, el(0x4282, []byte("matroska")))
+	tracks := el(0x1654ae6b,
+		el(0xae, el(0xd7, []byte{1}), el(0x86, []byte("V_MPEG4/ISO/AVC")), el(0x63a2, video.AVCDecoderConfRecordBytes())),
+		el(0xae, el(0xd7, []byte{2}), el(0x86, []byte("A_AAC")), el(0x63a2, []byte{0x11, 0x90})),
+		el(0xae, el(0xd7, []byte{3}), el(0x86, []byte("S_TEXT/UTF8"))),
+	)
+	info := el(0x1549a966, el(0x2ad7b1, []byte{0x0f, 0x42, 0x40})) // 1 ms
+
+	// Xiph lacing: three frames of 300, 2 and 1 bytes.
+	xiph := append([]byte{0x82, 0x00, 0x0a, 0x02, 2, 0xff, 45, 2}, bytes.Repeat([]byte{0xaa}, 300)...)
+	xiph = append(xiph, 0xbb, 0xbb, 0xcc)
+	// EBML lacing: two frames of 2 and 3 bytes.
+	ebml := []byte{0x82, 0x00, 0x14, 0x06, 1, 0x82, 1, 2, 3, 4, 5}
+	// Fixed lacing: two frames of 2 bytes.
+	fixed := []byte{0x82, 0x00, 0x1e, 0x04, 1, 6, 7, 8, 9}
+
+	cluster := el(0x1f43b675,
+		el(0xe7, []byte{0x03, 0xe8}),
+		el(0xa3, []byte{0x81, 0x00, 0x00, 0x80, 0, 0, 0, 1, 0x65}),
+		el(0xa3, xiph),
+		el(0xa3, []byte{0x83, 0x00, 0x00, 0x80, 'x'}),
+		el(0xa0, el(0xa1, []byte{0x81, 0x00, 0x28, 0x00, 0, 0, 0, 1, 0x41}), el(0x9b, []byte{40}), el(0xfb, []byte{0xd8})),
+		el(0xa3, ebml),
+		el(0xa3, fixed),
+	)
+
+	// An unknown-size Segment, as written by live muxers.
+	segment := []byte{0x18, 0x53, 0x80, 0x67, 0x01, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	file := bytes.Join([][]byte{header, segment, info, tracks, cluster}, nil)
+
+	if !mkv.Probe(file) {
+		t.Fatal("Probe rejected file")
+	}
+
+	dmx := mkv.NewDemuxer(bytes.NewReader(file))
+
+	streams, err := dmx.GetCodecs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(streams) != 2 {
+		t.Fatalf("got %d streams, want 2 (subtitles are skipped)", len(streams))
+	}
+
+	pkts := avtest.ReadAll(t, dmx)
+
+	frame := 1024 * time.Second / 48000
+	want := []struct {
+		idx      uint16
+		key      bool
+		dts      time.Duration
+		size     int
+		duration time.Duration
+	}{
+		{0, true, time.Second, 5, 0},
+		{1, true, time.Second + 10*time.Millisecond, 300, frame},
+		{1, true, time.Second + 10*time.Millisecond + frame, 2, frame},
+		{1, true, time.Second + 10*time.Millisecond + 2*frame, 1, frame},
+		{0, false, time.Second + 40*time.Millisecond, 5, 40 * time.Millisecond},
+		{1, true, time.Second + 20*time.Millisecond, 2, frame},
+		{1, true, time.Second + 20*time.Millisecond + frame, 3, frame},
+		{1, true, time.Second + 30*time.Millisecond, 2, frame},
+		{1, true, time.Second + 30*time.Millisecond + frame, 2, frame},
+	}
+
+	if len(pkts) != len(want) {
+		t.Fatalf("got %d packets, want %d", len(pkts), len(want))
+	}
+
+	for i, w := range want {
+		pkt := pkts[i]
+		if pkt.Idx != w.idx || pkt.KeyFrame != w.key || pkt.DTS != w.dts || len(pkt.Data) != w.size || pkt.Duration != w.duration {
+			t.Fatalf("packet %d: %v, want %+v", i, pkt.String(), w)
+		}
+	}
+
+	if !bytes.Equal(pkts[6].Data, []byte{3, 4, 5}) || !bytes.Equal(pkts[8].Data, []byte{8, 9}) {
+		t.Fatalf("laced frames = %x, %x", pkts[6].Data, pkts[8].Data)
+	}
+
+	if _, err := dmx.SeekToTime(ctx, 0); !errors.Is(err, mkv.ErrNoCues) {
+		t.Fatalf("SeekToTime err = %v, want ErrNoCues", err)
+	}
+}
+
+func TestDemuxerBFrames(t *testing.T) {
+	ctx := context.Background()
+
+	video := avtest.H264(t, avtest.SPS320x192)
+
+	var buf bytes.Buffer
+
+	mux := mkv.NewMuxer(&buf)
+	if err := mux.WriteHeader(ctx, []av.Stream{{Idx: 0, Codec: video}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Decoding order I P B B per GOP; Matroska keeps only DTS+PTSOffset.
+	frame := 40 * time.Millisecond
+	offsets := []time.Duration{frame, 3 * frame, 0, 0}
+
+	var in []av.Packet
+
+	for i := range 8 {
+		pkt := av.Packet{
+			Idx: 0, KeyFrame: i%4 == 0, DTS: time.Duration(i) * frame, PTSOffset: offsets[i%4],
+			Duration: frame, Data: []byte{0, 0, 0, 3, 0x41, 0x9a, byte(i)},
+		}
+		if pkt.KeyFrame {
+			pkt.Data[4] = 0x65
+		}
+
+		if err := mux.WritePacket(ctx, pkt); err != nil {
+			t.Fatal(err)
+		}
+
+		in = append(in, pkt)
+	}
+
+	if err := mux.WriteTrailer(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	pkts := avtest.ReadAll(t, mkv.NewDemuxer(&buf))
+	if len(pkts) != len(in) {
+		t.Fatalf("got %d packets, want %d", len(pkts), len(in))
+	}
+
+	for i, pkt := range pkts {
+		if pkt.DTS != in[i].DTS || pkt.PTSOffset != in[i].PTSOffset || pkt.Data[6] != byte(i) {
+			t.Fatalf("packet %d: %v, want DTS %v PTSOffset %v", i, pkt.String(), in[i].DTS, in[i].PTSOffset)
+		}
+	}
+}
+
+func TestDemuxerWebM(t *testing.T) {
+	ctx := context.Background()
+
+	var buf bytes.Buffer
+
+	opus := codec.OpusCodecData{SampleRt: 48000, ChLayout: av.ChStereo, PreSkip: 312}
+
+	mux := mkv.NewMuxer(&buf)
+	if err := mux.WriteHeader(ctx, []av.Stream{{Idx: 0, Codec: vp9CodecData{}}, {Idx: 1, Codec: opus}}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range 3 {
+		pkt := av.Packet{Idx: 0, KeyFrame: i == 0, DTS: time.Duration(i) * 40 * time.Millisecond, Data: []byte{0x82, 0x49, byte(i)}}
+		if err := mux.WritePacket(ctx, pkt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := mux.WriteTrailer(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	dmx := mkv.NewDemuxer(&buf)
+
+	streams, err := dmx.GetCodecs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vp9, ok := streams[0].Codec.(av.VideoCodecData)
+	if len(streams) != 2 || !ok || vp9.Type() != av.VP9 || vp9.Width() != 640 || vp9.Height() != 360 {
+		t.Fatalf("streams = %+v", streams)
+	}
+
+	if got, ok := streams[1].Codec.(codec.OpusCodecData); !ok || got.ChLayout != av.ChStereo || got.SampleRt != 48000 || got.PreSkip != 312 {
+		t.Fatalf("Opus codec = %+v", streams[1].Codec)
+	}
+
+	pkts := avtest.ReadAll(t, dmx)
+	if len(pkts) != 3 || !pkts[0].KeyFrame || pkts[2].DTS != 80*time.Millisecond || pkts[2].PTSOffset != 0 {
+		t.Fatalf("packets = %v", pkts)
+	}
+}
+
+func TestDemuxerAV1(t *testing.T) {
+	ctx := context.Background()
+
+	av1, err := codec.NewAV1CodecDataFromSequenceHeader(avtest.Unhex(avtest.AV1SequenceHeader))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+
+	mux := mkv.NewMuxer(&buf)
+	if err := mux.WriteHeader(ctx, []av.Stream{{Idx: 0, Codec: av1}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mux.WriteTrailer(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	streams, err := mkv.NewDemuxer(&buf).GetCodecs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, ok := streams[0].Codec.(codec.AV1CodecData)
+	if !ok || got.Width() != 1280 || got.Height() != 720 || !bytes.Equal(got.SequenceHeader, av1.SequenceHeader) {
+		t.Fatalf("AV1 codec = %+v", streams[0].Codec)
+	}
+}
+
+func TestDemuxerNotMatroska(t *testing.T) {
+	dmx := mkv.NewDemuxer(bytes.NewReader([]byte("FLV\x01\x05\x00\x00\x00\x09")))
+
+	if _, err := dmx.GetCodecs(context.Background()); !errors.Is(err, mkv.ErrNotMatroska) {
+		t.Fatalf("err = %v, want ErrNotMatroska", err)
+	}
+}
+
+func TestHandlers(t *testing.T) {
+	name, _ := writeTestFile(t)
+
+	handlers := &avutil.Handlers{}
+	for _, handler := range mkv.Handlers() {
+		handlers.Add(handler)
+	}
+
+	webm := filepath.Join(filepath.Dir(name), "in.webm")
+	if err := os.Rename(name, webm); err != nil {
+		t.Fatal(err)
+	}
+
+	dmx, err := handlers.Open(webm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dmx.Close()
+
+	if pkts := avtest.ReadAll(t, dmx); len(pkts) != 18 {
+		t.Fatalf("got %d packets, want 18", len(pkts))
+	}
+
+	if mkv.Probe([]byte{0x1a, 0x45, 0xdf, 0xa3, 0x84, 'a', 'b', 'c', 'd'}) {
+		t.Fatal("Probe accepted an EBML header without a Matroska DocType")
+	}
+}
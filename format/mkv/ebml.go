@@ -0,0 +1,340 @@
+package mkv
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"math/bits"
+
+	"github.com/vtpl1/avsdk/utils/bits/pio"
+)
+
+// Element IDs (RFC 8794 and the Matroska specification). IDs are stored with
+// their length marker bits, exactly as they appear in the file.
+const (
+	idEBML               = 0x1a45dfa3
+	idEBMLVersion        = 0x4286
+	idEBMLReadVersion    = 0x42f7
+	idEBMLMaxIDLength    = 0x42f2
+	idEBMLMaxSizeLength  = 0x42f3
+	idDocType            = 0x4282
+	idDocTypeVersion     = 0x4287
+	idDocTypeReadVersion = 0x4285
+	idVoid               = 0xec
+
+	idSegment      = 0x18538067
+	idSeekHead     = 0x114d9b74
+	idSeek         = 0x4dbb
+	idSeekID       = 0x53ab
+	idSeekPosition = 0x53ac
+
+	idInfo           = 0x1549a966
+	idTimestampScale = 0x2ad7b1
+	idDuration       = 0x4489
+	idMuxingApp      = 0x4d80
+	idWritingApp     = 0x5741
+
+	idTracks            = 0x1654ae6b
+	idTrackEntry        = 0xae
+	idTrackNumber       = 0xd7
+	idTrackUID          = 0x73c5
+	idTrackType         = 0x83
+	idFlagLacing        = 0x9c
+	idCodecID           = 0x86
+	idCodecPrivate      = 0x63a2
+	idDefaultDuration   = 0x23e383
+	idCodecDelay        = 0x56aa
+	idSeekPreRoll       = 0x56bb
+	idVideo             = 0xe0
+	idPixelWidth        = 0xb0
+	idPixelHeight       = 0xba
+	idAudio             = 0xe1
+	idSamplingFrequency = 0xb5
+	idChannels          = 0x9f
+
+	idCluster        = 0x1f43b675
+	idTimestamp      = 0xe7
+	idSimpleBlock    = 0xa3
+	idBlockGroup     = 0xa0
+	idBlock          = 0xa1
+	idBlockDuration  = 0x9b
+	idReferenceBlock = 0xfb
+
+	idCues               = 0x1c53bb6b
+	idCuePoint           = 0xbb
+	idCueTime            = 0xb3
+	idCueTrackPositions  = 0xb7
+	idCueTrack           = 0xf7
+	idCueClusterPosition = 0xf1
+)
+
+// Track types.
+const (
+	trackTypeVideo = 1
+	trackTypeAudio = 2
+)
+
+// unknownSize is the 8-byte encoding of an element size that is not known yet.
+const unknownSize = 0x01ffffffffffffff
+
+// ebmlWriter builds EBML elements into a growing byte slice.
+// start/end pairs reserve and back-patch an 8-byte element size so nested
+// master elements can be written without computing their lengths up front.
+type ebmlWriter struct {
+	buf []byte
+}
+
+func (b *ebmlWriter) id(id uint32) {
+	switch {
+	case id >= 1<<24:
+		b.buf = append(b.buf, byte(id>>24), byte(id>>16), byte(id>>8), byte(id))
+	case id >= 1<<16:
+		b.buf = append(b.buf, byte(id>>16), byte(id>>8), byte(id))
+	case id >= 1<<8:
+		b.buf = append(b.buf, byte(id>>8), byte(id))
+	default:
+		b.buf = append(b.buf, byte(id))
+	}
+}
+
+// vint writes v as a variable-length integer of the shortest length that
+// does not collide with the reserved all-ones value.
+func (b *ebmlWriter) vint(v uint64) {
+	n := vintLen(v)
+
+	v |= 1 << (7 * n)
+	for i := n - 1; i >= 0; i-- {
+		b.buf = append(b.buf, byte(v>>(8*i)))
+	}
+}
+
+func (b *ebmlWriter) start(id uint32) int {
+	b.id(id)
+	off := len(b.buf)
+	b.buf = binary.BigEndian.AppendUint64(b.buf, unknownSize)
+
+	return off
+}
+
+func (b *ebmlWriter) end(off int) {
+	pio.PutU64BE(b.buf[off:], 1<<56|uint64(len(b.buf)-off-8))
+}
+
+func (b *ebmlWriter) uint(id uint32, v uint64) {
+	n := 1
+	for n < 8 && v >= 1<<(8*n) {
+		n++
+	}
+
+	b.id(id)
+	b.vint(uint64(n))
+
+	for i := n - 1; i >= 0; i-- {
+		b.buf = append(b.buf, byte(v>>(8*i)))
+	}
+}
+
+func (b *ebmlWriter) float(id uint32, v float64) {
+	b.id(id)
+	b.vint(8)
+	b.buf = binary.BigEndian.AppendUint64(b.buf, math.Float64bits(v))
+}
+
+func (b *ebmlWriter) str(id uint32, s string) {
+	b.id(id)
+	b.vint(uint64(len(s)))
+	b.buf = append(b.buf, s...)
+}
+
+func (b *ebmlWriter) bytes(id uint32, p []byte) {
+	b.id(id)
+	b.vint(uint64(len(p)))
+	b.buf = append(b.buf, p...)
+}
+
+// void writes a Void element occupying exactly n bytes (n >= 2).
+func (b *ebmlWriter) void(n int) {
+	b.id(idVoid)
+
+	if n-2 < 127 {
+		b.vint(uint64(n - 2))
+		b.buf = append(b.buf, make([]byte, n-2)...)
+
+		return
+	}
+
+	b.buf = binary.BigEndian.AppendUint64(b.buf, 1<<56|uint64(n-9))
+	b.buf = append(b.buf, make([]byte, n-9)...)
+}
+
+// vintLen returns the number of bytes ebmlWriter.vint uses to encode v.
+func vintLen(v uint64) int {
+	n := 1
+	for n < 8 && v >= 1<<(7*n)-1 {
+		n++
+	}
+
+	return n
+}
+
+// maxElementSize bounds the elements read into memory, so a corrupt size
+// cannot trigger a huge allocation.
+const maxElementSize = 64 << 20
+
+// ebmlReader reads element headers and payloads while tracking the stream offset.
+type ebmlReader struct {
+	r   *bufio.Reader
+	pos int64
+}
+
+// readVint reads a variable-length integer. The marker bit is kept for IDs.
+// unknown reports the reserved all-ones value used for unknown sizes.
+func (r *ebmlReader) readVint(keepMarker bool) (uint64, bool, error) {
+	first, err := r.r.ReadByte()
+	if err != nil {
+		return 0, false, err
+	}
+
+	r.pos++
+
+	n := bits.LeadingZeros8(first) + 1
+	if n > 8 {
+		return 0, false, ErrInvalidElement
+	}
+
+	v := uint64(first)
+	if !keepMarker {
+		v &= 0xff >> n
+	}
+
+	allOnes := v == 0xff>>n
+
+	for range n - 1 {
+		c, err := r.r.ReadByte()
+		if err != nil {
+			return 0, false, eof(err)
+		}
+
+		r.pos++
+		v = v<<8 | uint64(c)
+		allOnes = allOnes && c == 0xff
+	}
+
+	return v, allOnes && !keepMarker, nil
+}
+
+// readHeader reads an element ID and size. size is -1 if it is unknown.
+func (r *ebmlReader) readHeader() (uint32, int64, error) {
+	id, _, err := r.readVint(true)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	size, unknown, err := r.readVint(false)
+	if err != nil {
+		return 0, 0, eof(err)
+	}
+
+	if unknown {
+		return uint32(id), -1, nil
+	}
+
+	return uint32(id), int64(size), nil
+}
+
+func (r *ebmlReader) read(size int64) ([]byte, error) {
+	if size < 0 || size > maxElementSize {
+		return nil, ErrInvalidElement
+	}
+
+	b := make([]byte, size)
+
+	n, err := io.ReadFull(r.r, b)
+	r.pos += int64(n)
+
+	return b, eof(err)
+}
+
+func (r *ebmlReader) skip(size int64) error {
+	if size < 0 {
+		return ErrInvalidElement
+	}
+
+	n, err := r.r.Discard(int(size))
+	r.pos += int64(n)
+
+	return eof(err)
+}
+
+// eof reports a truncated element as io.EOF so partial recordings end cleanly.
+func eof(err error) error {
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return io.EOF
+	}
+
+	return err
+}
+
+// vint decodes a variable-length integer without its marker from b and
+// returns the value and its length, or n == 0 if b is too short.
+func vint(b []byte) (uint64, int) {
+	if len(b) == 0 || b[0] == 0 {
+		return 0, 0
+	}
+
+	n := bits.LeadingZeros8(b[0]) + 1
+	if len(b) < n {
+		return 0, 0
+	}
+
+	v := uint64(b[0]) & (0xff >> n)
+	for _, c := range b[1:n] {
+		v = v<<8 | uint64(c)
+	}
+
+	return v, n
+}
+
+// children calls fn for every element in the payload of a master element.
+func children(b []byte, fn func(id uint32, data []byte) error) error {
+	for len(b) > 0 {
+		if b[0] == 0 || bits.LeadingZeros8(b[0]) >= 4 {
+			return ErrInvalidElement
+		}
+
+		idLen := bits.LeadingZeros8(b[0]) + 1
+		if len(b) < idLen {
+			return ErrInvalidElement
+		}
+
+		var id uint32
+		for _, c := range b[:idLen] {
+			id = id<<8 | uint32(c)
+		}
+
+		size, n := vint(b[idLen:])
+		if n == 0 || size > uint64(len(b)-idLen-n) {
+			return ErrInvalidElement
+		}
+
+		b = b[idLen+n:]
+		if err := fn(id, b[:size]); err != nil {
+			return err
+		}
+
+		b = b[size:]
+	}
+
+	return nil
+}
+
+func readUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+
+	return v
+}
@@ -0,0 +1,17 @@
+package mkv
+
+import "errors"
+
+var (
+	ErrHeaderNotWritten      = errors.New("mkv: WriteHeader not called")
+	ErrHeaderAlreadyWritten  = errors.New("mkv: WriteHeader already called")
+	ErrTrailerAlreadyWritten = errors.New("mkv: WriteTrailer already called")
+	ErrNoStreams             = errors.New("mkv: no streams")
+	ErrUnsupportedCodec      = errors.New("mkv: unsupported codec")
+	ErrStreamNotFound        = errors.New("mkv: stream not found")
+	ErrDuplicateStream       = errors.New("mkv: duplicate stream index")
+	ErrNotMatroska           = errors.New("mkv: not a Matroska or WebM file")
+	ErrInvalidElement        = errors.New("mkv: invalid EBML element")
+	ErrNotSeekable           = errors.New("mkv: reader is not seekable")
+	ErrNoCues                = errors.New("mkv: no cues")
+)
@@ -0,0 +1,97 @@
+// Package mkv implements a Matroska/WebM demuxer and muxer.
+//
+// The muxer writes an EBML header followed by a Segment holding Info, Tracks
+// and one Cluster per GOP. On seekable writers WriteTrailer adds Cues and a
+// SeekHead and fills in the Segment size and duration.
+package mkv
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/av/avutil"
+)
+
+// Extensions lists the file extensions registered by Handlers.
+//
+//nolint:gochecknoglobals
+var Extensions = []string{".mkv", ".webm"}
+
+// Matroska codec IDs.
+const (
+	CodecIDH264 = "V_MPEG4/ISO/AVC"
+	CodecIDH265 = "V_MPEGH/ISO/HEVC"
+	CodecIDVP8  = "V_VP8"
+	CodecIDVP9  = "V_VP9"
+	CodecIDAV1  = "V_AV1"
+	CodecIDAAC  = "A_AAC"
+	CodecIDOpus = "A_OPUS"
+)
+
+// Probe reports whether b starts with an EBML header declaring a Matroska or
+// WebM document.
+func Probe(b []byte) bool {
+	if len(b) < 4 || !bytes.Equal(b[:4], []byte{0x1a, 0x45, 0xdf, 0xa3}) {
+		return false
+	}
+
+	header := b[:min(len(b), 64)]
+
+	return bytes.Contains(header, []byte("matroska")) || bytes.Contains(header, []byte("webm"))
+}
+
+// Handlers returns one avutil handler per entry of Extensions. Register them
+// with avutil.Handlers.Add.
+func Handlers() []func(*avutil.RegisterHandler) {
+	handlers := make([]func(*avutil.RegisterHandler), 0, len(Extensions))
+
+	for _, ext := range Extensions {
+		handlers = append(handlers, func(h *avutil.RegisterHandler) {
+			h.Ext = ext
+			h.Probe = Probe
+			h.ReaderDemuxer = func(r io.Reader) av.Demuxer {
+				return NewDemuxer(r)
+			}
+			h.WriterMuxer = func(w io.Writer) av.Muxer {
+				return NewMuxer(w)
+			}
+			h.CodecTypes = []av.CodecType{av.H264, av.H265, av.VP8, av.VP9, av.AV1, av.AAC, av.OPUS}
+		})
+	}
+
+	return handlers
+}
+
+// codecID returns the Matroska codec ID of typ, or "" if the Muxer does not
+// support it.
+func codecID(typ av.CodecType) string {
+	switch typ {
+	case av.H264:
+		return CodecIDH264
+	case av.H265:
+		return CodecIDH265
+	case av.VP8:
+		return CodecIDVP8
+	case av.VP9:
+		return CodecIDVP9
+	case av.AV1:
+		return CodecIDAV1
+	case av.AAC:
+		return CodecIDAAC
+	case av.OPUS:
+		return CodecIDOpus
+	default:
+		return ""
+	}
+}
+
+// isWebM reports whether typ may be stored in a WebM file.
+func isWebM(typ av.CodecType) bool {
+	switch typ {
+	case av.VP8, av.VP9, av.AV1, av.OPUS:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,474 @@
+package mkv
+
+import (
+	"context"
+	"io"
+	"math"
+	"time"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/codec"
+	"github.com/vtpl1/avsdk/codec/aacparser"
+	"github.com/vtpl1/avsdk/codec/h264parser"
+	"github.com/vtpl1/avsdk/codec/h265parser"
+	"github.com/vtpl1/avsdk/codec/parser"
+	"github.com/vtpl1/avsdk/internal/ticks"
+	"github.com/vtpl1/avsdk/utils/bits/pio"
+)
+
+// maxAudioCluster bounds cluster length when there is no video track to
+// provide GOP boundaries.
+const maxAudioCluster = time.Second
+
+// maxBlockOffset is the largest SimpleBlock timestamp offset from its cluster (int16 ms).
+const maxBlockOffset = math.MaxInt16 * time.Millisecond
+
+// seekHeadReserve is the space kept after the Segment header for the SeekHead
+// written by WriteTrailer.
+const seekHeadReserve = 128
+
+// muxingApp is written to Info as MuxingApp and WritingApp.
+const muxingApp = "avsdk"
+
+type track struct {
+	number  uint64
+	stream  av.Stream
+	codecID string
+	private []byte
+	delay   time.Duration // CodecDelay, the Opus pre-skip
+}
+
+type cuePoint struct {
+	time     uint64
+	track    uint64
+	position uint64
+}
+
+// Muxer writes Matroska, or WebM when every stream is VP8, VP9, AV1 or Opus.
+// Stream.Idx maps to TrackNumber Idx+1. AV1 streams need a
+// codec.AV1CodecData, whose sequence header makes up the av1C CodecPrivate.
+// It implements av.Muxer.
+type Muxer struct {
+	w          io.Writer
+	ws         io.WriteSeeker
+	tracks     []*track
+	trackByIdx map[uint16]*track
+	hasVideo   bool
+
+	base          int64 // writer offset of the EBML header, valid if ws != nil
+	pos           int64 // bytes written
+	segmentSize   int64 // offset of the Segment size field
+	segmentData   int64 // offset of the first Segment child
+	infoPos       int64
+	tracksPos     int64
+	durationValue int64 // offset of the Duration float
+
+	cluster      ebmlWriter
+	clusterSize  int // offset of the Cluster size field in cluster
+	clusterStart time.Duration
+	clusterOpen  bool
+	clusterCue   uint64 // track number to index the open cluster under, 0 for none
+	cues         []cuePoint
+	firstTime    time.Duration
+	endTime      time.Duration
+	hasTime      bool
+
+	stage int
+}
+
+// NewMuxer returns a Muxer writing to w. Cues and a SeekHead are written when
+// w is an io.WriteSeeker that supports seeking.
+func NewMuxer(w io.Writer) *Muxer {
+	return &Muxer{
+		w:          w,
+		trackByIdx: make(map[uint16]*track),
+	}
+}
+
+// WriteHeader implements av.Muxer. It writes the EBML header, Info and Tracks.
+func (m *Muxer) WriteHeader(_ context.Context, streams []av.Stream) error {
+	if m.stage != 0 {
+		return ErrHeaderAlreadyWritten
+	}
+
+	if len(streams) == 0 {
+		return ErrNoStreams
+	}
+
+	webm := true
+
+	for _, stream := range streams {
+		if _, ok := m.trackByIdx[stream.Idx]; ok {
+			return ErrDuplicateStream
+		}
+
+		t := &track{number: uint64(stream.Idx) + 1, stream: stream}
+		if err := t.setCodec(); err != nil {
+			return err
+		}
+
+		if stream.Codec.Type().IsVideo() {
+			m.hasVideo = true
+		}
+
+		webm = webm && isWebM(stream.Codec.Type())
+		m.tracks = append(m.tracks, t)
+		m.trackByIdx[stream.Idx] = t
+	}
+
+	if ws, ok := m.w.(io.WriteSeeker); ok {
+		if base, err := ws.Seek(0, io.SeekCurrent); err == nil {
+			m.ws = ws
+			m.base = base
+		}
+	}
+
+	if err := m.write(m.marshalHeader(webm)); err != nil {
+		return err
+	}
+
+	m.stage++
+
+	return nil
+}
+
+// WritePacket implements av.Muxer. Packets are collected into a cluster that
+// is written when the next video keyframe (or maxAudioCluster for audio-only
+// output) starts a new one.
+func (m *Muxer) WritePacket(_ context.Context, pkt av.Packet) error {
+	if m.stage != 1 {
+		return ErrHeaderNotWritten
+	}
+
+	t, ok := m.trackByIdx[pkt.Idx]
+	if !ok {
+		return ErrStreamNotFound
+	}
+
+	isVideo := t.stream.Codec.Type().IsVideo()
+	pts := pkt.DTS + pkt.PTSOffset
+
+	if m.clusterOpen && m.cutBefore(isVideo, pkt.KeyFrame, pts) {
+		if err := m.flush(); err != nil {
+			return err
+		}
+	}
+
+	if !m.clusterOpen {
+		m.openCluster(pts)
+
+		if (isVideo && pkt.KeyFrame) || !m.hasVideo {
+			m.clusterCue = t.number
+		}
+	}
+
+	data := pkt.Data
+	if t.codecID == CodecIDH264 || t.codecID == CodecIDH265 {
+		if parser.IsAnnexBOrAVCC(data) == parser.NALUAnnexb {
+			data, _ = parser.AnnexBToAVCC(data)
+		}
+	}
+
+	flags := byte(0)
+	if pkt.KeyFrame || !isVideo {
+		flags |= 0x80
+	}
+
+	offset := (pts - m.clusterStart) / time.Millisecond
+
+	c := &m.cluster
+	c.id(idSimpleBlock)
+	c.vint(uint64(vintLen(t.number) + 3 + len(data)))
+	c.vint(t.number)
+	c.buf = append(c.buf, byte(int16(offset)>>8), byte(int16(offset)), flags)
+	c.buf = append(c.buf, data...)
+
+	if !m.hasTime || pts < m.firstTime {
+		m.firstTime = pts
+	}
+
+	m.endTime = max(m.endTime, pts+pkt.Duration)
+	m.hasTime = true
+
+	return nil
+}
+
+// WriteTrailer implements av.Muxer. It writes the last cluster and, on
+// seekable output, the Cues, SeekHead, Segment size and duration.
+func (m *Muxer) WriteTrailer(_ context.Context) error {
+	switch m.stage {
+	case 0:
+		return ErrHeaderNotWritten
+	case 2:
+		return ErrTrailerAlreadyWritten
+	}
+
+	m.stage++
+
+	if err := m.flush(); err != nil {
+		return err
+	}
+
+	if m.ws == nil {
+		return nil
+	}
+
+	cuesPos := m.pos - m.segmentData
+	if err := m.write(m.marshalCues()); err != nil {
+		return err
+	}
+
+	end := m.pos
+
+	if err := m.writeAt(m.segmentData, m.marshalSeekHead(cuesPos)); err != nil {
+		return err
+	}
+
+	size := make([]byte, 8)
+	pio.PutU64BE(size, 1<<56|uint64(end-m.segmentData))
+
+	if err := m.writeAt(m.segmentSize, size); err != nil {
+		return err
+	}
+
+	duration := ebmlWriter{}
+	duration.float(idDuration, float64(m.endTime-m.firstTime)/float64(time.Millisecond))
+
+	if err := m.writeAt(m.durationValue, duration.buf[len(duration.buf)-8:]); err != nil {
+		return err
+	}
+
+	_, err := m.ws.Seek(m.base+end, io.SeekStart)
+
+	return err
+}
+
+func (t *track) setCodec() error {
+	t.codecID = codecID(t.stream.Codec.Type())
+
+	switch c := t.stream.Codec.(type) {
+	case h264parser.CodecData:
+		t.private = c.AVCDecoderConfRecordBytes()
+	case h265parser.CodecData:
+		t.private = c.AVCDecoderConfRecordBytes()
+	case aacparser.CodecData:
+		t.private = c.MPEG4AudioConfigBytes()
+	case codec.AV1CodecData:
+		t.private = c.AV1CodecConfigurationRecord()
+	default:
+		if t.codecID == "" || t.codecID == CodecIDAV1 {
+			return ErrUnsupportedCodec
+		}
+
+		if t.codecID == CodecIDOpus {
+			ac, ok := c.(av.AudioCodecData)
+			if !ok || ac.ChannelLayout().Count() > 2 {
+				return ErrUnsupportedCodec
+			}
+
+			opus, ok := c.(codec.OpusCodecData)
+			if !ok {
+				opus = codec.OpusCodecData{SampleRt: ac.SampleRate(), ChLayout: ac.ChannelLayout()}
+			}
+
+			t.private = opus.OpusHead()
+			t.delay = ticks.ToDuration(int64(opus.PreSkip), 48000)
+		}
+	}
+
+	return nil
+}
+
+func (m *Muxer) cutBefore(isVideo, keyFrame bool, pts time.Duration) bool {
+	offset := pts - m.clusterStart
+	if offset > maxBlockOffset || offset < -maxBlockOffset {
+		return true
+	}
+
+	if m.hasVideo {
+		return isVideo && keyFrame
+	}
+
+	return offset >= maxAudioCluster
+}
+
+func (m *Muxer) openCluster(pts time.Duration) {
+	m.clusterStart = max(pts.Truncate(time.Millisecond), 0)
+	m.clusterOpen = true
+	m.clusterCue = 0
+
+	m.cluster.buf = m.cluster.buf[:0]
+	m.clusterSize = m.cluster.start(idCluster)
+	m.cluster.uint(idTimestamp, uint64(m.clusterStart/time.Millisecond))
+}
+
+func (m *Muxer) flush() error {
+	if !m.clusterOpen {
+		return nil
+	}
+
+	m.clusterOpen = false
+
+	if m.clusterCue != 0 {
+		m.cues = append(m.cues, cuePoint{
+			time:     uint64(m.clusterStart / time.Millisecond),
+			track:    m.clusterCue,
+			position: uint64(m.pos - m.segmentData),
+		})
+	}
+
+	m.cluster.end(m.clusterSize)
+
+	return m.write(m.cluster.buf)
+}
+
+func (m *Muxer) write(b []byte) error {
+	n, err := m.w.Write(b)
+	m.pos += int64(n)
+
+	return err
+}
+
+func (m *Muxer) writeAt(off int64, b []byte) error {
+	if _, err := m.ws.Seek(m.base+off, io.SeekStart); err != nil {
+		return err
+	}
+
+	_, err := m.ws.Write(b)
+
+	return err
+}
+
+func (m *Muxer) marshalHeader(webm bool) []byte {
+	b := &ebmlWriter{}
+
+	docType := "matroska"
+	if webm {
+		docType = "webm"
+	}
+
+	ebml := b.start(idEBML)
+	b.uint(idEBMLVersion, 1)
+	b.uint(idEBMLReadVersion, 1)
+	b.uint(idEBMLMaxIDLength, 4)
+	b.uint(idEBMLMaxSizeLength, 8)
+	b.str(idDocType, docType)
+	b.uint(idDocTypeVersion, 4)
+	b.uint(idDocTypeReadVersion, 2)
+	b.end(ebml)
+
+	// The Segment size stays unknown unless it is patched by WriteTrailer.
+	b.id(idSegment)
+	m.segmentSize = int64(len(b.buf))
+	b.buf = append(b.buf, 0x01, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff)
+	m.segmentData = int64(len(b.buf))
+
+	if m.ws != nil {
+		b.void(seekHeadReserve)
+	}
+
+	m.infoPos = int64(len(b.buf)) - m.segmentData
+	info := b.start(idInfo)
+	b.uint(idTimestampScale, uint64(time.Millisecond))
+	b.str(idMuxingApp, muxingApp)
+	b.str(idWritingApp, muxingApp)
+
+	if m.ws != nil {
+		b.float(idDuration, 0)
+		m.durationValue = int64(len(b.buf)) - 8
+	}
+
+	b.end(info)
+
+	m.tracksPos = int64(len(b.buf)) - m.segmentData
+	tracks := b.start(idTracks)
+
+	for _, t := range m.tracks {
+		t.marshal(b)
+	}
+
+	b.end(tracks)
+
+	return b.buf
+}
+
+func (t *track) marshal(b *ebmlWriter) {
+	entry := b.start(idTrackEntry)
+	b.uint(idTrackNumber, t.number)
+	b.uint(idTrackUID, t.number)
+
+	if t.stream.Codec.Type().IsVideo() {
+		b.uint(idTrackType, trackTypeVideo)
+	} else {
+		b.uint(idTrackType, trackTypeAudio)
+	}
+
+	b.uint(idFlagLacing, 0)
+	b.str(idCodecID, t.codecID)
+
+	if len(t.private) > 0 {
+		b.bytes(idCodecPrivate, t.private)
+	}
+
+	if t.codecID == CodecIDOpus {
+		b.uint(idCodecDelay, uint64(t.delay))
+		b.uint(idSeekPreRoll, uint64(80*time.Millisecond))
+	}
+
+	switch codec := t.stream.Codec.(type) {
+	case av.VideoCodecData:
+		video := b.start(idVideo)
+		b.uint(idPixelWidth, uint64(codec.Width()))
+		b.uint(idPixelHeight, uint64(codec.Height()))
+		b.end(video)
+	case av.AudioCodecData:
+		audio := b.start(idAudio)
+		b.float(idSamplingFrequency, float64(codec.SampleRate()))
+		b.uint(idChannels, uint64(max(codec.ChannelLayout().Count(), 1)))
+		b.end(audio)
+	}
+
+	b.end(entry)
+}
+
+func (m *Muxer) marshalCues() []byte {
+	b := &ebmlWriter{}
+	cues := b.start(idCues)
+
+	for _, cue := range m.cues {
+		point := b.start(idCuePoint)
+		b.uint(idCueTime, cue.time)
+		positions := b.start(idCueTrackPositions)
+		b.uint(idCueTrack, cue.track)
+		b.uint(idCueClusterPosition, cue.position)
+		b.end(positions)
+		b.end(point)
+	}
+
+	b.end(cues)
+
+	return b.buf
+}
+
+// marshalSeekHead returns a SeekHead padded with Void to seekHeadReserve bytes.
+func (m *Muxer) marshalSeekHead(cuesPos int64) []byte {
+	b := &ebmlWriter{}
+	head := b.start(idSeekHead)
+
+	for _, entry := range []struct {
+		id  uint32
+		pos int64
+	}{{idInfo, m.infoPos}, {idTracks, m.tracksPos}, {idCues, cuesPos}} {
+		seek := b.start(idSeek)
+		id := ebmlWriter{}
+		id.id(entry.id)
+		b.bytes(idSeekID, id.buf)
+		b.uint(idSeekPosition, uint64(entry.pos))
+		b.end(seek)
+	}
+
+	b.end(head)
+	b.void(seekHeadReserve - len(b.buf))
+
+	return b.buf
+}
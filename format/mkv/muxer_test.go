@@ -0,0 +1,301 @@
+package mkv_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/codec"
+	"github.com/vtpl1/avsdk/format/mkv"
+	"github.com/vtpl1/avsdk/internal/avtest"
+)
+
+type vp9CodecData struct{}
+
+func (vp9CodecData) Type() av.CodecType { return av.VP9 }
+func (vp9CodecData) Width() int         { return 640 }
+func (vp9CodecData) Height() int        { return 360 }
+func (vp9CodecData) TimeScale() uint32  { return 90000 }
+
+// element is a parsed EBML element; children are parsed on demand.
+type element struct {
+	id   uint32
+	data []byte
+}
+
+// elements splits b into EBML elements. An unknown size extends to the end of b.
+func elements(t *testing.T, b []byte) []element {
+	t.Helper()
+
+	var out []element
+
+	for len(b) > 0 {
+		idLen := bitsLen(b[0])
+		id := uint32(0)
+
+		for _, c := range b[:idLen] {
+			id = id<<8 | uint32(c)
+		}
+
+		b = b[idLen:]
+		sizeLen := bitsLen(b[0])
+		size := uint64(b[0]) & (0xff >> sizeLen)
+
+		for _, c := range b[1:sizeLen] {
+			size = size<<8 | uint64(c)
+		}
+
+		b = b[sizeLen:]
+		if size == 1<<(7*sizeLen)-1 || size > uint64(len(b)) {
+			if size != 1<<(7*sizeLen)-1 {
+				t.Fatalf("element %x: size %d exceeds %d bytes left", id, size, len(b))
+			}
+
+			size = uint64(len(b))
+		}
+
+		out = append(out, element{id: id, data: b[:size]})
+		b = b[size:]
+	}
+
+	return out
+}
+
+func bitsLen(c byte) int {
+	n := 1
+	for mask := byte(0x80); c&mask == 0 && n < 8; mask >>= 1 {
+		n++
+	}
+
+	return n
+}
+
+func find(t *testing.T, b []byte, path ...uint32) []element {
+	t.Helper()
+
+	var found []element
+
+	for _, el := range elements(t, b) {
+		if el.id != path[0] {
+			continue
+		}
+
+		if len(path) == 1 {
+			found = append(found, el)
+		} else {
+			found = append(found, find(t, el.data, path[1:]...)...)
+		}
+	}
+
+	return found
+}
+
+func TestMuxerH264AAC(t *testing.T) {
+	ctx := context.Background()
+
+	video := avtest.H264(t, avtest.SPS320x192)
+
+	audio := avtest.AAC(t)
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "out.mkv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	mux := mkv.NewMuxer(f)
+	if err := mux.WriteHeader(ctx, []av.Stream{{Idx: 0, Codec: video}, {Idx: 1, Codec: audio}}); err != nil {
+		t.Fatal(err)
+	}
+
+	frame := 40 * time.Millisecond
+
+	for i := range 6 {
+		dts := time.Duration(i) * frame
+		// Annex-B input is stored as AVCC.
+		data := []byte{0, 0, 0, 1, 0x41, 0x9a, byte(i)}
+		if i%3 == 0 {
+			data = []byte{0, 0, 0, 1, 0x65, 0x88, byte(i)}
+		}
+
+		if err := mux.WritePacket(ctx, av.Packet{Idx: 0, KeyFrame: i%3 == 0, DTS: dts, Duration: frame, Data: data}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := mux.WritePacket(ctx, av.Packet{Idx: 1, DTS: dts, Duration: frame, Data: []byte{0x21, byte(i)}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := mux.WriteTrailer(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if docType := find(t, b, 0x1a45dfa3, 0x4282); len(docType) != 1 || string(docType[0].data) != "matroska" {
+		t.Fatalf("DocType = %q", docType)
+	}
+
+	segment := find(t, b, 0x18538067)
+	if len(segment) != 1 {
+		t.Fatal("missing Segment")
+	}
+
+	// The Segment size is patched, so the Segment ends exactly at EOF.
+	if sizeField := b[bytes.Index(b, []byte{0x18, 0x53, 0x80, 0x67})+4:][:8]; binary.BigEndian.Uint64(sizeField) == 0x01ffffffffffffff {
+		t.Fatal("Segment size not patched")
+	}
+
+	seg := segment[0].data
+
+	private := find(t, seg, 0x1654ae6b, 0xae, 0x63a2)
+	if len(private) != 2 || !bytes.Equal(private[0].data, video.AVCDecoderConfRecordBytes()) || !bytes.Equal(private[1].data, []byte{0x12, 0x10}) {
+		t.Fatalf("CodecPrivate = %x", private)
+	}
+
+	clusters := find(t, seg, 0x1f43b675)
+	if len(clusters) != 2 {
+		t.Fatalf("got %d clusters, want one per GOP", len(clusters))
+	}
+
+	blocks := find(t, clusters[1].data, 0xa3)
+	if len(blocks) != 6 || blocks[0].data[0] != 0x81 || blocks[0].data[3] != 0x80 ||
+		!bytes.Equal(blocks[0].data[4:], []byte{0, 0, 0, 3, 0x65, 0x88, 3}) {
+		t.Fatalf("first block of second cluster = %x", blocks[0].data)
+	}
+
+	if ts := find(t, clusters[1].data, 0xe7); len(ts) != 1 || !bytes.Equal(ts[0].data, []byte{120}) {
+		t.Fatalf("cluster timestamp = %x", ts)
+	}
+
+	cuePositions := find(t, seg, 0x1c53bb6b, 0xbb, 0xb7, 0xf1)
+	if len(cuePositions) != 2 {
+		t.Fatalf("got %d cue points, want 2", len(cuePositions))
+	}
+
+	// CueClusterPosition is relative to the start of the Segment data.
+	var pos uint64
+	for _, c := range cuePositions[1].data {
+		pos = pos<<8 | uint64(c)
+	}
+
+	if got := elements(t, seg[pos:])[0]; got.id != 0x1f43b675 {
+		t.Fatalf("cue points at element %x, want Cluster", got.id)
+	}
+
+	if seeks := find(t, seg, 0x114d9b74, 0x4dbb); len(seeks) != 3 {
+		t.Fatalf("got %d SeekHead entries, want 3", len(seeks))
+	}
+
+	duration := find(t, seg, 0x1549a966, 0x4489)
+	if len(duration) != 1 || math.Float64frombits(binary.BigEndian.Uint64(duration[0].data)) != 240 {
+		t.Fatalf("Duration = %x", duration)
+	}
+}
+
+func TestMuxerWebMStream(t *testing.T) {
+	ctx := context.Background()
+
+	var buf bytes.Buffer
+
+	mux := mkv.NewMuxer(&buf)
+
+	opus := codec.OpusCodecData{SampleRt: 48000, ChLayout: av.ChStereo, PreSkip: 312}
+
+	streams := []av.Stream{{Idx: 0, Codec: vp9CodecData{}}, {Idx: 1, Codec: opus}}
+	if err := mux.WriteHeader(ctx, streams); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mux.WritePacket(ctx, av.Packet{Idx: 0, KeyFrame: true, Data: []byte{0x82, 0x49}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mux.WriteTrailer(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	b := buf.Bytes()
+	if docType := find(t, b, 0x1a45dfa3, 0x4282); string(docType[0].data) != "webm" {
+		t.Fatalf("DocType = %q", docType[0].data)
+	}
+
+	seg := find(t, b, 0x18538067)[0].data
+
+	ids := find(t, seg, 0x1654ae6b, 0xae, 0x86)
+	if len(ids) != 2 || string(ids[0].data) != "V_VP9" || string(ids[1].data) != "A_OPUS" {
+		t.Fatalf("CodecID = %q", ids)
+	}
+
+	if head := find(t, seg, 0x1654ae6b, 0xae, 0x63a2); len(head) != 1 || !bytes.HasPrefix(head[0].data, []byte("OpusHead\x01\x02\x38\x01")) {
+		t.Fatalf("Opus CodecPrivate = %x", head)
+	}
+
+	// CodecDelay is the 312-sample pre-skip in nanoseconds.
+	if delay := find(t, seg, 0x1654ae6b, 0xae, 0x56aa); len(delay) != 1 || !bytes.Equal(delay[0].data, []byte{0x63, 0x2e, 0xa0}) {
+		t.Fatalf("CodecDelay = %x", delay)
+	}
+
+	if width := find(t, seg, 0x1654ae6b, 0xae, 0xe0, 0xb0); !bytes.Equal(width[0].data, []byte{0x02, 0x80}) {
+		t.Fatalf("PixelWidth = %x", width[0].data)
+	}
+
+	// Without seeking there is no room for Cues or a SeekHead.
+	if len(find(t, seg, 0x1c53bb6b)) != 0 || len(find(t, seg, 0x114d9b74)) != 0 {
+		t.Fatal("Cues or SeekHead written to a non-seekable writer")
+	}
+
+	if len(find(t, seg, 0x1f43b675, 0xa3)) != 1 {
+		t.Fatal("missing SimpleBlock")
+	}
+}
+
+func TestMuxerAV1(t *testing.T) {
+	ctx := context.Background()
+
+	av1, err := codec.NewAV1CodecDataFromSequenceHeader(avtest.Unhex(avtest.AV1SequenceHeader))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+
+	mux := mkv.NewMuxer(&buf)
+	if err := mux.WriteHeader(ctx, []av.Stream{{Idx: 0, Codec: av1}}); err != nil {
+		t.Fatal(err)
+	}
+
+	key := append([]byte{0x12, 0x00}, av1.SequenceHeader...)
+	if err := mux.WritePacket(ctx, av.Packet{Idx: 0, KeyFrame: true, Data: key}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mux.WriteTrailer(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	b := buf.Bytes()
+	if docType := find(t, b, 0x1a45dfa3, 0x4282); string(docType[0].data) != "webm" {
+		t.Fatalf("DocType = %q", docType[0].data)
+	}
+
+	seg := find(t, b, 0x18538067)[0].data
+
+	if ids := find(t, seg, 0x1654ae6b, 0xae, 0x86); len(ids) != 1 || string(ids[0].data) != "V_AV1" {
+		t.Fatalf("CodecID = %q", ids)
+	}
+
+	if private := find(t, seg, 0x1654ae6b, 0xae, 0x63a2); len(private) != 1 || !bytes.Equal(private[0].data, av1.AV1CodecConfigurationRecord()) {
+		t.Fatalf("AV1 CodecPrivate = %x", private)
+	}
+}
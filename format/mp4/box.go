@@ -0,0 +1,110 @@
+package mp4
+
+import (
+	"io"
+
+	"github.com/vtpl1/avsdk/utils/bits/pio"
+)
+
+// boxHeader is the size/type prefix of an ISO BMFF box.
+type boxHeader struct {
+	typ    string
+	size   int64 // total box size including the header; 0 means "to end of file"
+	hdrLen int64
+}
+
+func readBoxHeader(r io.Reader) (boxHeader, error) {
+	var h boxHeader
+
+	var b [16]byte
+	if _, err := io.ReadFull(r, b[:8]); err != nil {
+		return h, err
+	}
+
+	h.size = int64(pio.U32BE(b[:]))
+	h.typ = string(b[4:8])
+	h.hdrLen = 8
+
+	if h.size == 1 {
+		if _, err := io.ReadFull(r, b[8:16]); err != nil {
+			return h, err
+		}
+
+		h.size = int64(pio.U64BE(b[8:]))
+		h.hdrLen = 16
+	}
+
+	if h.size != 0 && h.size < h.hdrLen {
+		return h, ErrInvalidBox
+	}
+
+	return h, nil
+}
+
+// forEachBox calls fn for every child box packed in b.
+func forEachBox(b []byte, fn func(typ string, payload []byte) error) error {
+	for len(b) >= 8 {
+		size := uint64(pio.U32BE(b))
+		typ := string(b[4:8])
+		hdrLen := uint64(8)
+
+		switch size {
+		case 0:
+			size = uint64(len(b))
+		case 1:
+			if len(b) < 16 {
+				return ErrInvalidBox
+			}
+
+			size = pio.U64BE(b[8:])
+			hdrLen = 16
+		}
+
+		if size < hdrLen || size > uint64(len(b)) {
+			return ErrInvalidBox
+		}
+
+		if err := fn(typ, b[hdrLen:size]); err != nil {
+			return err
+		}
+
+		b = b[size:]
+	}
+
+	return nil
+}
+
+// findBox returns the payload of the first child of type typ in b.
+func findBox(b []byte, typ string) []byte {
+	var found []byte
+
+	_ = forEachBox(b, func(t string, payload []byte) error {
+		if found == nil && t == typ {
+			found = payload
+		}
+
+		return nil
+	})
+
+	return found
+}
+
+// findPath descends through nested boxes following path.
+func findPath(b []byte, path ...string) []byte {
+	for _, typ := range path {
+		if b = findBox(b, typ); b == nil {
+			return nil
+		}
+	}
+
+	return b
+}
+
+// fullBox splits a full box payload into version, flags and the remaining body.
+func fullBox(b []byte) (uint8, uint32, []byte, error) {
+	if len(b) < 4 {
+		return 0, 0, nil, ErrInvalidBox
+	}
+
+	return b[0], pio.U24BE(b[1:]), b[4:], nil
+}
@@ -0,0 +1,377 @@
+// Package mp4 implements a demuxer for progressive and fragmented MP4 files.
+package mp4
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/av/avutil"
+	"github.com/vtpl1/avsdk/internal/ticks"
+	"github.com/vtpl1/avsdk/utils/bits/pio"
+)
+
+// Demuxer reads H.264, H.265 and AAC tracks from progressive (moov/stbl) and
+// fragmented (moof/traf/trun) MP4. It implements av.DemuxCloser and
+// av.TimeSeeker.
+//
+// The whole box structure is indexed on the first GetCodecs call; media data is
+// read on demand, so r must be seekable.
+type Demuxer struct {
+	r             io.ReadSeeker
+	size          int64 // of the file
+	tracks        []*track
+	trackByID     map[uint32]*track
+	streams       []av.Stream
+	parsed        bool
+	discontinuity bool
+	frameID       int64
+}
+
+// NewDemuxer returns a Demuxer reading from r. Close closes r if it is an
+// io.Closer.
+func NewDemuxer(r io.ReadSeeker) *Demuxer {
+	return &Demuxer{
+		r:         r,
+		trackByID: make(map[uint32]*track),
+	}
+}
+
+// GetCodecs implements av.Demuxer. Stream.Idx is the MP4 track_ID minus one.
+func (m *Demuxer) GetCodecs(_ context.Context) ([]av.Stream, error) {
+	if err := m.probe(); err != nil {
+		return nil, err
+	}
+
+	return m.streams, nil
+}
+
+// ReadPacket implements av.Demuxer. Samples of all tracks are returned in DTS order.
+func (m *Demuxer) ReadPacket(ctx context.Context) (av.Packet, error) {
+	if err := ctx.Err(); err != nil {
+		return av.Packet{}, err
+	}
+
+	if err := m.probe(); err != nil {
+		return av.Packet{}, err
+	}
+
+	for {
+		t := m.nextTrack()
+		if t == nil {
+			return av.Packet{}, io.EOF
+		}
+
+		s := t.samples[t.cur]
+		t.cur++
+
+		if s.codec >= len(t.codecs) || t.codecs[s.codec] == nil {
+			continue
+		}
+
+		data := make([]byte, s.size)
+		if _, err := m.r.Seek(s.offset, io.SeekStart); err != nil {
+			return av.Packet{}, err
+		}
+
+		if _, err := io.ReadFull(m.r, data); err != nil {
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				return av.Packet{}, io.EOF // truncated recording
+			}
+
+			return av.Packet{}, err
+		}
+
+		codec := t.codecs[s.codec]
+		pkt := av.Packet{
+			KeyFrame:        s.keyFrame,
+			IsDiscontinuity: m.discontinuity,
+			Idx:             uint16(t.id - 1),
+			DTS:             ticks.ToDuration(s.dts, int64(t.timeScale)),
+			PTSOffset:       ticks.ToDuration(int64(s.ctsOffset), int64(t.timeScale)),
+			Duration:        ticks.ToDuration(int64(s.duration), int64(t.timeScale)),
+			Data:            data,
+			FrameID:         m.frameID,
+			CodecType:       codec.Type(),
+		}
+
+		if s.codec != t.emitted {
+			// A new init segment repeats every track; only report those that differ.
+			changed := []av.Stream{{Idx: pkt.Idx, Codec: codec}}
+			if !avutil.Equal(changed, []av.Stream{{Idx: pkt.Idx, Codec: t.codecs[t.emitted]}}) {
+				pkt.NewCodecs = changed
+			}
+
+			t.emitted = s.codec
+		}
+
+		m.discontinuity = false
+		m.frameID++
+
+		return pkt, nil
+	}
+}
+
+// Close implements av.DemuxCloser. It releases the sample index.
+func (m *Demuxer) Close() error {
+	m.tracks, m.trackByID, m.streams = nil, nil, nil
+
+	if c, ok := m.r.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
+
+// SeekToTime implements av.TimeSeeker. Video tracks are positioned on the last
+// keyframe at or before pos; other tracks follow from the landed position.
+func (m *Demuxer) SeekToTime(_ context.Context, pos time.Duration) (time.Duration, error) {
+	if err := m.probe(); err != nil {
+		return 0, err
+	}
+
+	landed := pos
+	aligned := false
+
+	for _, t := range m.tracks {
+		if !t.isVideo() {
+			continue
+		}
+
+		key := 0
+
+		for i, s := range t.samples {
+			if ticks.ToDuration(s.dts, int64(t.timeScale)) > pos {
+				break
+			}
+
+			if s.keyFrame {
+				key = i
+			}
+		}
+
+		t.cur = key
+
+		if key < len(t.samples) {
+			at := ticks.ToDuration(t.samples[key].dts, int64(t.timeScale))
+			if !aligned || at < landed {
+				landed = at
+			}
+
+			aligned = true
+		}
+	}
+
+	for _, t := range m.tracks {
+		if t.isVideo() {
+			continue
+		}
+
+		t.cur = len(t.samples)
+
+		for i, s := range t.samples {
+			if ticks.ToDuration(s.dts, int64(t.timeScale)) >= landed {
+				t.cur = i
+
+				break
+			}
+		}
+	}
+
+	m.discontinuity = true
+
+	return landed, nil
+}
+
+func (t *track) isVideo() bool {
+	return len(t.codecs) > 0 && t.codecs[0] != nil && t.codecs[0].Type().IsVideo()
+}
+
+func (m *Demuxer) nextTrack() *track {
+	var next *track
+
+	var nextDTS time.Duration
+
+	for _, t := range m.tracks {
+		if t.cur >= len(t.samples) {
+			continue
+		}
+
+		dts := ticks.ToDuration(t.samples[t.cur].dts, int64(t.timeScale))
+		if next == nil || dts < nextDTS {
+			next = t
+			nextDTS = dts
+		}
+	}
+
+	return next
+}
+
+func (m *Demuxer) probe() error {
+	if m.parsed {
+		if len(m.streams) == 0 {
+			return ErrNoTracks
+		}
+
+		return nil
+	}
+
+	m.parsed = true
+
+	if err := m.parse(); err != nil {
+		return err
+	}
+
+	for _, t := range m.tracks {
+		t.emitted = 0
+		if len(t.samples) > 0 && t.samples[0].codec < len(t.codecs) && t.codecs[t.samples[0].codec] != nil {
+			t.emitted = t.samples[0].codec
+		}
+
+		m.streams = append(m.streams, av.Stream{Idx: uint16(t.id - 1), Codec: t.codecs[t.emitted]})
+	}
+
+	if len(m.streams) == 0 {
+		return ErrNoTracks
+	}
+
+	return nil
+}
+
+func (m *Demuxer) parse() error {
+	var err error
+
+	if m.size, err = m.r.Seek(0, io.SeekEnd); err != nil {
+		return err
+	}
+
+	pos, err := m.r.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	sawMoov := false
+
+	for {
+		h, err := readBoxHeader(m.r)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if h.size == 0 { // box extends to end of file
+			h.size = m.size - pos
+		}
+
+		if h.size > m.size-pos {
+			// A truncated box is the last one; its payload is not read, so
+			// a corrupt size cannot cause a huge allocation.
+			break
+		}
+
+		switch h.typ {
+		case "moov", "moof":
+			payload := make([]byte, h.size-h.hdrLen)
+			if _, err := io.ReadFull(m.r, payload); err != nil {
+				return err
+			}
+
+			if h.typ == "moov" {
+				sawMoov = true
+				err = m.parseMoov(payload)
+			} else {
+				err = m.parseMoof(payload, pos)
+			}
+
+			if err != nil {
+				return err
+			}
+		default:
+			if _, err := m.r.Seek(pos+h.size, io.SeekStart); err != nil {
+				return err
+			}
+		}
+
+		pos += h.size
+	}
+
+	if !sawMoov {
+		return ErrMoovNotFound
+	}
+
+	return nil
+}
+
+func (m *Demuxer) parseMoov(moov []byte) error {
+	trex := make(map[uint32]trexDefaults)
+
+	_ = forEachBox(findBox(moov, "mvex"), func(typ string, payload []byte) error {
+		if _, _, body, err := fullBox(payload); err == nil && typ == "trex" && len(body) >= 20 {
+			trex[pio.U32BE(body)] = trexDefaults{
+				descIndex: pio.U32BE(body[4:]),
+				duration:  pio.U32BE(body[8:]),
+				size:      pio.U32BE(body[12:]),
+				flags:     pio.U32BE(body[16:]),
+			}
+		}
+
+		return nil
+	})
+
+	return forEachBox(moov, func(typ string, payload []byte) error {
+		if typ != "trak" {
+			return nil
+		}
+
+		t, err := parseTrak(payload, m.size)
+		if err != nil || t == nil {
+			return err
+		}
+
+		t.trex = trex[t.id]
+
+		if prev, ok := m.trackByID[t.id]; ok {
+			// A later moov (new init segment) changes the codec of an existing track.
+			prev.codecBase = len(prev.codecs)
+			prev.codecs = append(prev.codecs, t.codecs...)
+			prev.trex = t.trex
+
+			for _, s := range t.samples {
+				s.codec += prev.codecBase
+				prev.samples = append(prev.samples, s)
+			}
+
+			return nil
+		}
+
+		m.tracks = append(m.tracks, t)
+		m.trackByID[t.id] = t
+
+		return nil
+	})
+}
+
+func (m *Demuxer) parseMoof(moof []byte, offset int64) error {
+	return forEachBox(moof, func(typ string, payload []byte) error {
+		if typ != "traf" {
+			return nil
+		}
+
+		_, _, body, err := fullBox(findBox(payload, "tfhd"))
+		if err != nil || len(body) < 4 {
+			return ErrInvalidBox
+		}
+
+		t, ok := m.trackByID[pio.U32BE(body)]
+		if !ok {
+			return nil
+		}
+
+		return t.appendTraf(payload, offset, m.size)
+	})
+}
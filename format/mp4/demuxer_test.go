@@ -0,0 +1,318 @@
+package mp4_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/av/avutil"
+	"github.com/vtpl1/avsdk/codec/aacparser"
+	"github.com/vtpl1/avsdk/codec/h264parser"
+	"github.com/vtpl1/avsdk/format/fmp4"
+	"github.com/vtpl1/avsdk/format/mp4"
+	"github.com/vtpl1/avsdk/internal/avtest"
+)
+
+func TestDemuxFragmented(t *testing.T) {
+	ctx := context.Background()
+	h264 := avtest.H264(t, avtest.SPS320x192)
+
+	aac := avtest.AAC(t)
+
+	streams := []av.Stream{{Idx: 0, Codec: h264}, {Idx: 3, Codec: aac}}
+
+	var buf bytes.Buffer
+
+	mux := fmp4.NewMuxer(&buf)
+	if err := mux.WriteHeader(ctx, streams); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range 9 {
+		if i == 6 {
+			changed := []av.Stream{{Idx: 0, Codec: avtest.H264(t, avtest.SPS1280x720)}}
+			if err := mux.WriteCodecChange(ctx, changed); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		video := av.Packet{
+			Idx:       0,
+			KeyFrame:  i%3 == 0,
+			DTS:       time.Duration(i) * 40 * time.Millisecond,
+			PTSOffset: 80 * time.Millisecond,
+			Data:      []byte{0, 0, 0, 2, 0x65, byte(i)},
+		}
+		if err := mux.WritePacket(ctx, video); err != nil {
+			t.Fatal(err)
+		}
+
+		audio := av.Packet{Idx: 3, DTS: video.DTS + time.Millisecond, Data: []byte{0x21, byte(i)}}
+		if err := mux.WritePacket(ctx, audio); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := mux.WriteTrailer(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	dmx := mp4.NewDemuxer(bytes.NewReader(buf.Bytes()))
+
+	got, err := dmx.GetCodecs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != 2 || got[0].Idx != 0 || got[1].Idx != 3 {
+		t.Fatalf("streams = %+v", got)
+	}
+
+	if got[1].Codec.(aacparser.CodecData).SampleRate() != 44100 { //nolint:forcetypeassert
+		t.Fatalf("unexpected audio codec %+v", got[1].Codec)
+	}
+
+	pkts := avtest.ReadAll(t, dmx)
+	if len(pkts) != 18 {
+		t.Fatalf("got %d packets, want 18", len(pkts))
+	}
+
+	var video []av.Packet
+
+	for _, pkt := range pkts {
+		if pkt.Idx == 0 {
+			video = append(video, pkt)
+		} else if pkt.NewCodecs != nil {
+			t.Fatalf("unexpected codec change on audio packet %v", pkt.String())
+		}
+	}
+
+	for i, pkt := range video {
+		if (pkt.NewCodecs != nil) != (i == 6) {
+			t.Fatalf("video %d: NewCodecs = %+v", i, pkt.NewCodecs)
+		}
+	}
+
+	if w := video[6].NewCodecs[0].Codec.(h264parser.CodecData).Width(); w != 1280 { //nolint:forcetypeassert
+		t.Fatalf("changed width = %d, want 1280", w)
+	}
+
+	for i, pkt := range video {
+		if pkt.DTS != time.Duration(i)*40*time.Millisecond || pkt.PTSOffset != 80*time.Millisecond {
+			t.Fatalf("video %d: dts=%v ptsOffset=%v", i, pkt.DTS, pkt.PTSOffset)
+		}
+
+		if pkt.KeyFrame != (i%3 == 0) || pkt.Data[5] != byte(i) {
+			t.Fatalf("video %d: unexpected packet %v", i, pkt.String())
+		}
+	}
+
+	landed, err := dmx.SeekToTime(ctx, 200*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if landed != 120*time.Millisecond {
+		t.Fatalf("landed = %v, want 120ms", landed)
+	}
+
+	pkt, err := dmx.ReadPacket(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !pkt.IsDiscontinuity || !pkt.KeyFrame || pkt.DTS != landed {
+		t.Fatalf("first packet after seek: %v", pkt.String())
+	}
+
+	pkt, err = dmx.ReadPacket(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pkt.IsDiscontinuity {
+		t.Fatal("discontinuity must only be set on the first packet after a seek")
+	}
+}
+
+func box(typ string, payload ...[]byte) []byte {
+	b := make([]byte, 8)
+	copy(b[4:], typ)
+
+	for _, p := range payload {
+		b = append(b, p...)
+	}
+
+	binary.BigEndian.PutUint32(b, uint32(len(b)))
+
+	return b
+}
+
+func u32s(vals ...uint32) []byte {
+	b := make([]byte, 4*len(vals))
+	for i, v := range vals {
+		binary.BigEndian.PutUint32(b[4*i:], v)
+	}
+
+	return b
+}
+
+// progressive returns an MP4 file with one H.264 track whose three samples
+// are in two chunks, described by the given stsc and stsz payloads.
+func progressive(t *testing.T, samples [][]byte, stsc, stsz []byte) []byte {
+	t.Helper()
+
+	h264 := avtest.H264(t, avtest.SPS320x192)
+
+	ftyp := box("ftyp", []byte("isom"), u32s(0))
+	mdat := box("mdat", bytes.Join(samples, nil))
+	mdatOffset := uint32(len(ftyp) + 8)
+
+	avc1 := box("avc1", make([]byte, 24), []byte{0x00, 0xa0, 0x00, 0x60}, make([]byte, 50), box("avcC", h264.AVCDecoderConfRecordBytes()))
+	stbl := box("stbl",
+		box("stsd", u32s(0, 1), avc1),
+		box("stts", u32s(0, 1, 3, 3000)),
+		box("ctts", u32s(0, 1, 3, 6000)),
+		box("stss", u32s(0, 1, 1)),
+		box("stsc", stsc),
+		box("stsz", stsz),
+		box("stco", u32s(0, 2, mdatOffset, mdatOffset+10)),
+	)
+	trak := box("trak",
+		box("tkhd", u32s(3, 0, 0, 1), make([]byte, 68)),
+		box("mdia",
+			box("mdhd", u32s(0, 0, 0, 90000, 0, 0)),
+			box("minf", stbl),
+		),
+	)
+
+	return bytes.Join([][]byte{ftyp, mdat, box("moov", trak)}, nil)
+}
+
+// fragmented appends to a progressive file a moof whose trun has the given
+// flags and sample count, with defaultSize in the tfhd.
+func fragmented(t *testing.T, samples [][]byte, defaultSize, trunFlags, count uint32) []byte {
+	t.Helper()
+
+	file := progressive(t, samples, u32s(0, 1, 1, 2, 1), u32s(0, 5, 3))
+	traf := box("traf", box("tfhd", u32s(0x10, 1, defaultSize)), box("trun", u32s(trunFlags, count)))
+
+	return append(file, box("moof", traf)...)
+}
+
+func TestDemuxProgressive(t *testing.T) {
+	ctx := context.Background()
+	samples := [][]byte{{0, 0, 0, 1, 0x65}, {0, 0, 0, 1, 0x41}, {0, 0, 0, 2, 0x41, 1}}
+	file := progressive(t, samples, u32s(0, 1, 1, 2, 1), u32s(0, 0, 3, 5, 5, 6))
+
+	dmx := mp4.NewDemuxer(bytes.NewReader(file))
+
+	streams, err := dmx.GetCodecs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(streams) != 1 || streams[0].Codec.Type() != av.H264 {
+		t.Fatalf("streams = %+v", streams)
+	}
+
+	pkts := avtest.ReadAll(t, dmx)
+	if len(pkts) != len(samples) {
+		t.Fatalf("got %d packets, want %d", len(pkts), len(samples))
+	}
+
+	for i, pkt := range pkts {
+		if !bytes.Equal(pkt.Data, samples[i]) {
+			t.Fatalf("packet %d data = %x, want %x", i, pkt.Data, samples[i])
+		}
+
+		if pkt.DTS != time.Duration(i)*time.Second/30 || pkt.PTSOffset != time.Second/15 {
+			t.Fatalf("packet %d: dts=%v ptsOffset=%v", i, pkt.DTS, pkt.PTSOffset)
+		}
+
+		if pkt.KeyFrame != (i == 0) {
+			t.Fatalf("packet %d: keyframe=%v", i, pkt.KeyFrame)
+		}
+	}
+}
+
+func TestDemuxCorrupt(t *testing.T) {
+	ctx := context.Background()
+	samples := [][]byte{{0, 0, 0, 1, 0x65}, {0, 0, 0, 1, 0x41}, {0, 0, 0, 1, 0x41}}
+	noTrackID := bytes.Replace(progressive(t, samples, u32s(0, 1, 1, 2, 1), u32s(0, 0, 3, 5, 5, 6)), u32s(3, 0, 0, 1), u32s(3, 0, 0, 0), 1)
+
+	for _, tt := range []struct {
+		name string
+		file []byte
+		want error
+	}{
+		{"sample count beyond stsz", progressive(t, samples, u32s(0, 1, 1, 2, 1), u32s(0, 0, 0xffffffff)), mp4.ErrInvalidSampleTable},
+		{"sample description 0", progressive(t, samples, u32s(0, 1, 1, 2, 0), u32s(0, 0, 3, 5, 5, 6)), mp4.ErrInvalidSampleTable},
+		{"first chunk 0", progressive(t, samples, u32s(0, 1, 0, 2, 1), u32s(0, 0, 3, 5, 5, 6)), mp4.ErrInvalidSampleTable},
+		{"first chunks not increasing", progressive(t, samples, u32s(0, 2, 2, 1, 1, 1, 1, 1), u32s(0, 0, 3, 5, 5, 6)), mp4.ErrInvalidSampleTable},
+		{"sample description 2", progressive(t, samples, u32s(0, 1, 1, 2, 2), u32s(0, 0, 3, 5, 5, 6)), mp4.ErrInvalidSampleTable},
+		{"track_ID 0", noTrackID, mp4.ErrInvalidBox},
+		{"moov beyond end of file", append(u32s(0xfffffff0), "moov"...), mp4.ErrMoovNotFound},
+		{"trun of zero-size samples", fragmented(t, samples, 0, 0, 0xffffffff), mp4.ErrInvalidBox},
+		{"trun sample sizes beyond the box", fragmented(t, samples, 0, 0x200, 0xffffffff), mp4.ErrInvalidBox},
+	} {
+		if _, err := mp4.NewDemuxer(bytes.NewReader(tt.file)).GetCodecs(ctx); !errors.Is(err, tt.want) {
+			t.Fatalf("%s: err = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	// Constant-size samples past the end of the file are dropped.
+	file := progressive(t, samples, u32s(0, 1, 1, 0xffffffff, 1), u32s(0, 5, 0xffffffff))
+
+	dmx := mp4.NewDemuxer(bytes.NewReader(file))
+	if _, err := dmx.GetCodecs(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if pkts := avtest.ReadAll(t, dmx); len(pkts) == 0 || len(pkts) > len(file)/5 {
+		t.Fatalf("got %d packets", len(pkts))
+	}
+
+	// So are default-size trun samples.
+	file = fragmented(t, samples, 1, 0, 0xffffffff)
+
+	dmx = mp4.NewDemuxer(bytes.NewReader(file))
+	if _, err := dmx.GetCodecs(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if pkts := avtest.ReadAll(t, dmx); len(pkts) > len(file) {
+		t.Fatalf("got %d packets", len(pkts))
+	}
+}
+
+func TestHandlers(t *testing.T) {
+	ctx := context.Background()
+	samples := [][]byte{{0, 0, 0, 1, 0x65}, {0, 0, 0, 1, 0x41}, {0, 0, 0, 1, 0x41}}
+	file := progressive(t, samples, u32s(0, 1, 1, 2, 1), u32s(0, 5, 3))
+
+	if !mp4.Probe(file) || mp4.Probe([]byte("FLV\x01\x05\x00\x00\x00\x09")) {
+		t.Fatal("Probe rejected MP4 or accepted FLV")
+	}
+
+	var h avutil.RegisterHandler
+	mp4.Handlers()[0](&h)
+
+	if _, err := h.ReaderDemuxer(bytes.NewReader(file)).GetCodecs(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := h.ReaderDemuxer(io.MultiReader(bytes.NewReader(file))).GetCodecs(ctx); !errors.Is(err, mp4.ErrNotSeekable) {
+		t.Fatalf("err = %v, want ErrNotSeekable", err)
+	}
+
+	dmx := mp4.NewDemuxer(bytes.NewReader(file))
+	if err := dmx.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,11 @@
+package mp4
+
+import "errors"
+
+var (
+	ErrInvalidBox         = errors.New("mp4: invalid box")
+	ErrMoovNotFound       = errors.New("mp4: moov box not found")
+	ErrNoTracks           = errors.New("mp4: no supported tracks")
+	ErrInvalidSampleTable = errors.New("mp4: invalid sample table")
+	ErrNotSeekable        = errors.New("mp4: reader is not seekable")
+)
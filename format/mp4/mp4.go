@@ -0,0 +1,65 @@
+package mp4
+
+import (
+	"context"
+	"io"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/av/avutil"
+)
+
+// Extensions lists the file extensions registered by Handlers.
+//
+//nolint:gochecknoglobals
+var Extensions = []string{".mp4", ".m4v", ".m4a", ".mov"}
+
+// Probe reports whether b starts with a box that opens an MP4 file or
+// fragment: ftyp, styp, moov or moof.
+func Probe(b []byte) bool {
+	if len(b) < 8 {
+		return false
+	}
+
+	switch string(b[4:8]) {
+	case "ftyp", "styp", "moov", "moof":
+		return true
+	default:
+		return false
+	}
+}
+
+// Handlers returns one avutil handler per entry of Extensions. Register them
+// with avutil.Handlers.Add. MP4 is only demuxed; the fmp4 package writes it.
+// The sample tables may follow the media data, so a reader that cannot seek
+// yields a Demuxer failing with ErrNotSeekable.
+func Handlers() []func(*avutil.RegisterHandler) {
+	handlers := make([]func(*avutil.RegisterHandler), 0, len(Extensions))
+
+	for _, ext := range Extensions {
+		handlers = append(handlers, func(h *avutil.RegisterHandler) {
+			h.Ext = ext
+			h.Probe = Probe
+			h.ReaderDemuxer = func(r io.Reader) av.Demuxer {
+				if rs, ok := r.(io.ReadSeeker); ok {
+					return NewDemuxer(rs)
+				}
+
+				return unseekable{}
+			}
+			h.CodecTypes = []av.CodecType{av.H264, av.H265, av.AAC}
+		})
+	}
+
+	return handlers
+}
+
+// unseekable is the Demuxer of a reader that cannot seek.
+type unseekable struct{}
+
+func (unseekable) GetCodecs(context.Context) ([]av.Stream, error) {
+	return nil, ErrNotSeekable
+}
+
+func (unseekable) ReadPacket(context.Context) (av.Packet, error) {
+	return av.Packet{}, ErrNotSeekable
+}
@@ -0,0 +1,613 @@
+package mp4
+
+import (
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/codec/aacparser"
+	"github.com/vtpl1/avsdk/codec/h264parser"
+	"github.com/vtpl1/avsdk/codec/h265parser"
+	"github.com/vtpl1/avsdk/utils/bits/pio"
+)
+
+// tfhd and trun flags (ISO/IEC 14496-12 §8.8.7, §8.8.8).
+const (
+	tfhdBaseDataOffset      = 0x000001
+	tfhdSampleDescIndex     = 0x000002
+	tfhdDefaultDuration     = 0x000008
+	tfhdDefaultSize         = 0x000010
+	tfhdDefaultFlags        = 0x000020
+	trunDataOffset          = 0x000001
+	trunFirstSampleFlags    = 0x000004
+	trunSampleDuration      = 0x000100
+	trunSampleSize          = 0x000200
+	trunSampleFlags         = 0x000400
+	trunSampleCTO           = 0x000800
+	sampleIsNonSyncSample   = 0x00010000
+	visualSampleEntryLength = 78
+	audioSampleEntryLength  = 28
+)
+
+type sampleEntry struct {
+	offset    int64
+	size      uint32
+	dts       int64 // decode time in track ticks
+	duration  uint32
+	ctsOffset int32
+	keyFrame  bool
+	codec     int // index into track.codecs
+}
+
+type trexDefaults struct {
+	descIndex uint32
+	duration  uint32
+	size      uint32
+	flags     uint32
+}
+
+type track struct {
+	id        uint32
+	timeScale uint32
+	codecs    []av.CodecData
+	// codecBase maps sample_description_index of the latest moov onto codecs.
+	codecBase int
+	trex      trexDefaults
+	samples   []sampleEntry
+	nextDTS   int64
+	cur       int
+	emitted   int // index of the codec last reported to the caller
+}
+
+// parseTrak returns the track described by a trak box, or nil if it carries no
+// supported sample entries.
+func parseTrak(trak []byte, fileSize int64) (*track, error) {
+	t := &track{}
+
+	version, _, body, err := fullBox(findBox(trak, "tkhd"))
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case version == 1 && len(body) >= 20:
+		t.id = pio.U32BE(body[16:])
+	case len(body) >= 12:
+		t.id = pio.U32BE(body[8:])
+	default:
+		return nil, ErrInvalidBox
+	}
+
+	// Stream.Idx is track_ID - 1; track_ID 0 is reserved.
+	if t.id == 0 || t.id > 1<<16 {
+		return nil, ErrInvalidBox
+	}
+
+	mdhd := findPath(trak, "mdia", "mdhd")
+	if mdhd == nil {
+		return nil, ErrInvalidBox
+	}
+
+	version, _, body, err = fullBox(mdhd)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case version == 1 && len(body) >= 20:
+		t.timeScale = pio.U32BE(body[16:])
+	case len(body) >= 12:
+		t.timeScale = pio.U32BE(body[8:])
+	default:
+		return nil, ErrInvalidBox
+	}
+
+	if t.timeScale == 0 {
+		return nil, ErrInvalidBox
+	}
+
+	stbl := findPath(trak, "mdia", "minf", "stbl")
+	if stbl == nil {
+		return nil, ErrInvalidBox
+	}
+
+	t.codecs = parseStsd(findBox(stbl, "stsd"))
+	if len(t.codecs) == 0 || t.codecs[0] == nil {
+		return nil, nil //nolint:nilnil // unsupported track, skipped by the caller
+	}
+
+	if err := t.parseStbl(stbl, fileSize); err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
+
+// parseStsd decodes every sample entry; unsupported entries are left nil so
+// sample_description_index keeps lining up with the slice.
+func parseStsd(stsd []byte) []av.CodecData {
+	_, _, body, err := fullBox(stsd)
+	if err != nil || len(body) < 4 {
+		return nil
+	}
+
+	var codecs []av.CodecData
+
+	_ = forEachBox(body[4:], func(typ string, entry []byte) error {
+		codecs = append(codecs, parseSampleEntry(typ, entry))
+
+		return nil
+	})
+
+	return codecs
+}
+
+func parseSampleEntry(typ string, entry []byte) av.CodecData {
+	switch typ {
+	case "avc1", "avc3":
+		if len(entry) < visualSampleEntryLength {
+			return nil
+		}
+
+		if conf := findBox(entry[visualSampleEntryLength:], "avcC"); conf != nil {
+			if codec, err := h264parser.NewCodecDataFromAVCDecoderConfRecord(conf); err == nil {
+				return codec
+			}
+		}
+	case "hvc1", "hev1":
+		if len(entry) < visualSampleEntryLength {
+			return nil
+		}
+
+		if conf := findBox(entry[visualSampleEntryLength:], "hvcC"); conf != nil {
+			if codec, err := h265parser.NewCodecDataFromAVCDecoderConfRecord(conf); err == nil {
+				return codec
+			}
+		}
+	case "mp4a":
+		if len(entry) < audioSampleEntryLength {
+			return nil
+		}
+
+		n := audioSampleEntryLength
+
+		switch pio.U16BE(entry[8:]) { // QuickTime sound sample description version
+		case 1:
+			n += 16
+		case 2:
+			n += 36
+		}
+
+		if len(entry) < n {
+			return nil
+		}
+
+		if config := parseEsds(findBox(entry[n:], "esds")); config != nil {
+			if codec, err := aacparser.NewCodecDataFromMPEG4AudioConfigBytes(config); err == nil {
+				return codec
+			}
+		}
+	}
+
+	return nil
+}
+
+// readDescriptor reads an MPEG-4 descriptor header and returns its tag and payload.
+func readDescriptor(b []byte) (uint8, []byte, []byte) {
+	if len(b) < 2 {
+		return 0, nil, nil
+	}
+
+	tag := b[0]
+	size := 0
+	n := 1
+
+	for n < len(b) && n <= 4 {
+		c := b[n]
+		n++
+		size = size<<7 | int(c&0x7f)
+
+		if c&0x80 == 0 {
+			break
+		}
+	}
+
+	if n+size > len(b) {
+		return 0, nil, nil
+	}
+
+	return tag, b[n : n+size], b[n+size:]
+}
+
+// parseEsds extracts the AudioSpecificConfig from an esds box.
+func parseEsds(esds []byte) []byte {
+	_, _, body, err := fullBox(esds)
+	if err != nil {
+		return nil
+	}
+
+	tag, es, _ := readDescriptor(body)
+	if tag != 0x03 || len(es) < 3 {
+		return nil
+	}
+
+	flags := es[2]
+	es = es[3:]
+
+	if flags&0x80 != 0 { // streamDependenceFlag
+		es = es[min(2, len(es)):]
+	}
+
+	if flags&0x40 != 0 && len(es) > 0 { // URL_Flag
+		es = es[min(1+int(es[0]), len(es)):]
+	}
+
+	if flags&0x20 != 0 { // OCRstreamFlag
+		es = es[min(2, len(es)):]
+	}
+
+	for len(es) > 0 {
+		var payload []byte
+
+		tag, payload, es = readDescriptor(es)
+		if tag == 0x04 && len(payload) > 13 {
+			if tag, config, _ := readDescriptor(payload[13:]); tag == 0x05 {
+				return config
+			}
+		}
+
+		if payload == nil {
+			break
+		}
+	}
+
+	return nil
+}
+
+// parseStbl builds the progressive sample list from the stbl child tables.
+// Samples of a constant size that would extend past fileSize are dropped.
+//
+//nolint:gocognit,gocyclo,cyclop,funlen
+func (t *track) parseStbl(stbl []byte, fileSize int64) error {
+	var (
+		sizes        []uint32
+		chunkOffsets []int64
+		syncSamples  map[int]bool
+	)
+
+	if _, _, body, err := fullBox(findBox(stbl, "stsz")); err == nil && len(body) >= 8 {
+		sampleSize := pio.U32BE(body)
+		count := int(pio.U32BE(body[4:]))
+
+		switch {
+		case sampleSize != 0:
+			count = int(min(int64(count), fileSize/int64(sampleSize)))
+		case len(body) < 8+4*count:
+			return ErrInvalidSampleTable
+		}
+
+		sizes = make([]uint32, count)
+
+		for i := range count {
+			if sampleSize != 0 {
+				sizes[i] = sampleSize
+
+				continue
+			}
+
+			sizes[i] = pio.U32BE(body[8+4*i:])
+		}
+	}
+
+	if len(sizes) == 0 {
+		return nil
+	}
+
+	if _, _, body, err := fullBox(findBox(stbl, "stco")); err == nil && len(body) >= 4 {
+		count := int(pio.U32BE(body))
+		if len(body) < 4+4*count {
+			return ErrInvalidSampleTable
+		}
+
+		for i := range count {
+			chunkOffsets = append(chunkOffsets, int64(pio.U32BE(body[4+4*i:])))
+		}
+	} else if _, _, body, err := fullBox(findBox(stbl, "co64")); err == nil && len(body) >= 4 {
+		count := int(pio.U32BE(body))
+		if len(body) < 4+8*count {
+			return ErrInvalidSampleTable
+		}
+
+		for i := range count {
+			chunkOffsets = append(chunkOffsets, int64(pio.U64BE(body[4+8*i:])))
+		}
+	}
+
+	if _, _, body, err := fullBox(findBox(stbl, "stss")); err == nil && len(body) >= 4 {
+		count := int(pio.U32BE(body))
+		if len(body) < 4+4*count {
+			return ErrInvalidSampleTable
+		}
+
+		syncSamples = make(map[int]bool, count)
+		for i := range count {
+			syncSamples[int(pio.U32BE(body[4+4*i:]))-1] = true
+		}
+	}
+
+	t.samples = make([]sampleEntry, len(sizes))
+
+	for i := range t.samples {
+		t.samples[i].size = sizes[i]
+		t.samples[i].keyFrame = syncSamples == nil || syncSamples[i]
+	}
+
+	// stts: decode deltas.
+	if _, _, body, err := fullBox(findBox(stbl, "stts")); err == nil && len(body) >= 4 {
+		count := int(pio.U32BE(body))
+		if len(body) < 4+8*count {
+			return ErrInvalidSampleTable
+		}
+
+		n := 0
+		dts := int64(0)
+
+		for i := range count {
+			sampleCount := int(pio.U32BE(body[4+8*i:]))
+			delta := pio.U32BE(body[8+8*i:])
+
+			for range sampleCount {
+				if n >= len(t.samples) {
+					break
+				}
+
+				t.samples[n].dts = dts
+				t.samples[n].duration = delta
+				dts += int64(delta)
+				n++
+			}
+		}
+
+		t.nextDTS = dts
+	}
+
+	// ctts: composition offsets.
+	if _, _, body, err := fullBox(findBox(stbl, "ctts")); err == nil && len(body) >= 4 {
+		count := int(pio.U32BE(body))
+		if len(body) < 4+8*count {
+			return ErrInvalidSampleTable
+		}
+
+		n := 0
+
+		for i := range count {
+			sampleCount := int(pio.U32BE(body[4+8*i:]))
+			offset := pio.I32BE(body[8+8*i:])
+
+			for range sampleCount {
+				if n >= len(t.samples) {
+					break
+				}
+
+				t.samples[n].ctsOffset = offset
+				n++
+			}
+		}
+	}
+
+	// stsc + chunk offsets: sample file positions and description indices.
+	_, _, body, err := fullBox(findBox(stbl, "stsc"))
+	if err != nil || len(body) < 4 {
+		return ErrInvalidSampleTable
+	}
+
+	count := int(pio.U32BE(body))
+	if len(body) < 4+12*count {
+		return ErrInvalidSampleTable
+	}
+
+	n := 0
+
+	for i := range count {
+		firstChunk := int(pio.U32BE(body[4+12*i:])) - 1
+		perChunk := int(pio.U32BE(body[8+12*i:]))
+		descIndex := int(pio.U32BE(body[12+12*i:])) - 1
+		if firstChunk < 0 || descIndex < 0 || descIndex >= len(t.codecs) {
+			return ErrInvalidSampleTable
+		}
+
+		lastChunk := len(chunkOffsets)
+		if i+1 < count {
+			// Entries must start at strictly increasing chunks.
+			lastChunk = int(pio.U32BE(body[4+12*(i+1):])) - 1
+			if lastChunk <= firstChunk {
+				return ErrInvalidSampleTable
+			}
+		}
+
+		for chunk := firstChunk; chunk < lastChunk && chunk < len(chunkOffsets); chunk++ {
+			offset := chunkOffsets[chunk]
+
+			for range perChunk {
+				if n >= len(t.samples) {
+					break
+				}
+
+				t.samples[n].offset = offset
+				t.samples[n].codec = descIndex
+				offset += int64(t.samples[n].size)
+				n++
+			}
+		}
+	}
+
+	if n != len(t.samples) {
+		return ErrInvalidSampleTable
+	}
+
+	return nil
+}
+
+// appendTraf adds the samples of one track fragment located in the moof at
+// moofOffset. Samples of the default size that would extend past fileSize are
+// dropped.
+//
+//nolint:gocognit,gocyclo,cyclop,funlen
+func (t *track) appendTraf(traf []byte, moofOffset, fileSize int64) error {
+	_, flags, body, err := fullBox(findBox(traf, "tfhd"))
+	if err != nil || len(body) < 4 {
+		return ErrInvalidBox
+	}
+
+	body = body[4:] // track_ID
+	baseOffset := moofOffset
+	defaults := t.trex
+
+	if flags&tfhdBaseDataOffset != 0 {
+		if len(body) < 8 {
+			return ErrInvalidBox
+		}
+
+		baseOffset = int64(pio.U64BE(body))
+		body = body[8:]
+	}
+
+	for _, field := range []struct {
+		flag uint32
+		dst  *uint32
+	}{
+		{tfhdSampleDescIndex, &defaults.descIndex},
+		{tfhdDefaultDuration, &defaults.duration},
+		{tfhdDefaultSize, &defaults.size},
+		{tfhdDefaultFlags, &defaults.flags},
+	} {
+		if flags&field.flag == 0 {
+			continue
+		}
+
+		if len(body) < 4 {
+			return ErrInvalidBox
+		}
+
+		*field.dst = pio.U32BE(body)
+		body = body[4:]
+	}
+
+	if version, _, body, err := fullBox(findBox(traf, "tfdt")); err == nil {
+		switch {
+		case version == 1 && len(body) >= 8:
+			t.nextDTS = int64(pio.U64BE(body))
+		case len(body) >= 4:
+			t.nextDTS = int64(pio.U32BE(body))
+		}
+	}
+
+	codec := t.codecBase
+	if defaults.descIndex > 0 {
+		codec += int(defaults.descIndex) - 1
+	}
+
+	dataPos := baseOffset
+
+	return forEachBox(traf, func(typ string, payload []byte) error {
+		if typ != "trun" {
+			return nil
+		}
+
+		_, flags, body, err := fullBox(payload)
+		if err != nil || len(body) < 4 {
+			return ErrInvalidBox
+		}
+
+		count := int(pio.U32BE(body))
+		body = body[4:]
+
+		if flags&trunDataOffset != 0 {
+			if len(body) < 4 {
+				return ErrInvalidBox
+			}
+
+			dataPos = baseOffset + int64(pio.I32BE(body))
+			body = body[4:]
+		}
+
+		firstFlags, hasFirstFlags := uint32(0), flags&trunFirstSampleFlags != 0
+		if hasFirstFlags {
+			if len(body) < 4 {
+				return ErrInvalidBox
+			}
+
+			firstFlags = pio.U32BE(body)
+			body = body[4:]
+		}
+
+		perSample := 0
+
+		for _, flag := range []uint32{trunSampleDuration, trunSampleSize, trunSampleFlags, trunSampleCTO} {
+			if flags&flag != 0 {
+				perSample += 4
+			}
+		}
+
+		if perSample > 0 && count > len(body)/perSample {
+			return ErrInvalidBox
+		}
+
+		if flags&trunSampleSize == 0 {
+			switch {
+			case defaults.size != 0:
+				count = int(min(int64(count), max(fileSize-dataPos, 0)/int64(defaults.size)))
+			case count > 1:
+				return ErrInvalidBox
+			}
+		}
+
+		for i := range count {
+			s := sampleEntry{
+				duration: defaults.duration,
+				size:     defaults.size,
+				codec:    codec,
+			}
+			sampleFlags := defaults.flags
+
+			if i == 0 && hasFirstFlags {
+				sampleFlags = firstFlags
+			}
+
+			for _, field := range []struct {
+				flag uint32
+				dst  *uint32
+			}{
+				{trunSampleDuration, &s.duration},
+				{trunSampleSize, &s.size},
+				{trunSampleFlags, &sampleFlags},
+			} {
+				if flags&field.flag == 0 {
+					continue
+				}
+
+				if len(body) < 4 {
+					return ErrInvalidBox
+				}
+
+				*field.dst = pio.U32BE(body)
+				body = body[4:]
+			}
+
+			if flags&trunSampleCTO != 0 {
+				if len(body) < 4 {
+					return ErrInvalidBox
+				}
+
+				s.ctsOffset = pio.I32BE(body)
+				body = body[4:]
+			}
+
+			s.keyFrame = sampleFlags&sampleIsNonSyncSample == 0
+			s.offset = dataPos
+			s.dts = t.nextDTS
+			dataPos += int64(s.size)
+			t.nextDTS += int64(s.duration)
+			t.samples = append(t.samples, s)
+		}
+
+		return nil
+	})
+}
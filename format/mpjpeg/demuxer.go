@@ -0,0 +1,312 @@
+package mpjpeg
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/codec/mjpeg"
+)
+
+// maxPreambleSize bounds the bytes skipped while looking for a boundary line.
+const maxPreambleSize = 64 << 10
+
+// Option configures a Demuxer.
+type Option func(*Demuxer)
+
+// WithBoundary sets the multipart boundary, as found in the Content-Type of
+// the HTTP response. Without it the boundary is taken from the first line
+// starting with "--". Leading dashes are ignored when matching, since many
+// cameras disagree on them between the header and the body.
+func WithBoundary(boundary string) Option {
+	return func(m *Demuxer) {
+		m.boundary = []byte(strings.TrimLeft(boundary, "-"))
+	}
+}
+
+// Demuxer reads a multipart/x-mixed-replace stream of JPEG images as a
+// single MJPEG stream. Parts without an image/jpeg Content-Type and images
+// without a readable SOF marker are skipped. Multipart streams carry no
+// timestamps, so DTS is the wallclock time since the first image was read.
+// It implements av.DemuxCloser.
+type Demuxer struct {
+	r          *bufio.Reader
+	closer     io.Closer
+	boundary   []byte
+	atBoundary bool
+	codec      mjpeg.CodecData
+	pending    *av.Packet
+	start      time.Time
+	now        func() time.Time
+	frameID    int64
+}
+
+// NewDemuxer returns a Demuxer reading from r. Close closes r if it is an
+// io.Closer.
+func NewDemuxer(r io.Reader, opts ...Option) *Demuxer {
+	m := &Demuxer{
+		r:   bufio.NewReader(r),
+		now: time.Now,
+	}
+
+	if c, ok := r.(io.Closer); ok {
+		m.closer = c
+	}
+
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	return m
+}
+
+// Open requests url with client and returns a Demuxer reading the response
+// body. A nil client means http.DefaultClient.
+func Open(ctx context.Context, client *http.Client, url string) (*Demuxer, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+
+		return nil, fmt.Errorf("%w: %s", ErrBadStatus, resp.Status)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		resp.Body.Close()
+
+		return nil, fmt.Errorf("%w: %q", ErrNotMultipart, resp.Header.Get("Content-Type"))
+	}
+
+	var opts []Option
+	if boundary := params["boundary"]; boundary != "" {
+		opts = append(opts, WithBoundary(boundary))
+	}
+
+	return NewDemuxer(resp.Body, opts...), nil
+}
+
+// GetCodecs implements av.Demuxer. It reads the first image to learn the
+// picture size.
+func (m *Demuxer) GetCodecs(ctx context.Context) ([]av.Stream, error) {
+	if m.pending == nil && m.start.IsZero() {
+		pkt, err := m.ReadPacket(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		pkt.NewCodecs = nil
+		m.pending = &pkt
+	}
+
+	return []av.Stream{{Idx: 0, Codec: m.codec}}, nil
+}
+
+// ReadPacket implements av.Demuxer. A change of picture size is reported
+// through NewCodecs.
+func (m *Demuxer) ReadPacket(ctx context.Context) (av.Packet, error) {
+	if m.pending != nil {
+		pkt := *m.pending
+		m.pending = nil
+
+		return pkt, nil
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return av.Packet{}, err
+		}
+
+		image, err := m.readPart()
+		if err != nil {
+			return av.Packet{}, err
+		}
+
+		if image == nil {
+			continue
+		}
+
+		codec, err := mjpeg.NewCodecDataFromJPEG(image)
+		if err != nil {
+			continue
+		}
+
+		now := m.now()
+		if m.start.IsZero() {
+			m.start = now
+		}
+
+		pkt := av.Packet{
+			KeyFrame:  true,
+			DTS:       now.Sub(m.start),
+			Data:      image,
+			FrameID:   m.frameID,
+			CodecType: av.MJPEG,
+		}
+
+		if codec != m.codec {
+			m.codec = codec
+			pkt.NewCodecs = []av.Stream{{Idx: 0, Codec: codec}}
+		}
+
+		m.frameID++
+
+		return pkt, nil
+	}
+}
+
+// Close implements av.DemuxCloser.
+func (m *Demuxer) Close() error {
+	if m.closer != nil {
+		return m.closer.Close()
+	}
+
+	return nil
+}
+
+// readPart returns the body of the next part, or nil if the part is not a
+// JPEG image.
+func (m *Demuxer) readPart() ([]byte, error) {
+	if !m.atBoundary {
+		if err := m.skipToBoundary(); err != nil {
+			return nil, err
+		}
+	}
+
+	m.atBoundary = false
+
+	header, err := textproto.NewReader(m.r).ReadMIMEHeader()
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+
+	var image []byte
+
+	if length := header.Get("Content-Length"); length != "" {
+		n, err := strconv.Atoi(strings.TrimSpace(length))
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("%w: %q", ErrInvalidContentLength, length)
+		}
+
+		if n > maxFrameSize {
+			return nil, ErrFrameTooLarge
+		}
+
+		image = make([]byte, n)
+		if _, err := io.ReadFull(m.r, image); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+	} else if image, err = m.readToBoundary(); err != nil {
+		return nil, err
+	}
+
+	if ct := header.Get("Content-Type"); ct != "" && !strings.HasPrefix(strings.ToLower(ct), "image/jpeg") {
+		return nil, nil
+	}
+
+	return image, nil
+}
+
+// skipToBoundary consumes lines up to and including the next boundary line.
+// The closing boundary ends the stream with io.EOF.
+func (m *Demuxer) skipToBoundary() error {
+	skipped := 0
+
+	for skipped < maxPreambleSize {
+		line, err := m.r.ReadSlice('\n')
+		skipped += len(line)
+
+		if err != nil && !errors.Is(err, bufio.ErrBufferFull) {
+			return err
+		}
+
+		line = bytes.TrimRight(line, "\r\n \t")
+		if len(m.boundary) == 0 && bytes.HasPrefix(line, []byte("--")) {
+			m.boundary = bytes.Clone(bytes.TrimLeft(line, "-"))
+		}
+
+		if ok, closing := m.isBoundary(line); ok {
+			if closing {
+				return io.EOF
+			}
+
+			return nil
+		}
+	}
+
+	return ErrNoBoundary
+}
+
+// readToBoundary reads a part without Content-Length up to the next boundary
+// line, which is consumed.
+func (m *Demuxer) readToBoundary() ([]byte, error) {
+	var image []byte
+
+	for {
+		line, err := m.r.ReadBytes('\n')
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+
+		if ok, closing := m.isBoundary(bytes.TrimRight(line, "\r\n \t")); ok {
+			// After the closing boundary the next read sees the end of the stream.
+			m.atBoundary = !closing
+			image = bytes.TrimSuffix(image, []byte("\n"))
+			image = bytes.TrimSuffix(image, []byte("\r"))
+
+			return image, nil
+		}
+
+		image = append(image, line...)
+		if len(image) > maxFrameSize {
+			return nil, ErrFrameTooLarge
+		}
+	}
+}
+
+// isBoundary reports whether line is a boundary line, and whether it is the
+// closing one.
+func (m *Demuxer) isBoundary(line []byte) (ok, closing bool) {
+	if len(m.boundary) == 0 || !bytes.HasPrefix(line, []byte("--")) {
+		return false, false
+	}
+
+	line = bytes.TrimLeft(line, "-")
+	if bytes.Equal(line, m.boundary) {
+		return true, false
+	}
+
+	closing = bytes.HasSuffix(line, []byte("--")) && bytes.Equal(line[:len(line)-2], m.boundary)
+
+	return closing, closing
+}
+
+func unexpectedEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
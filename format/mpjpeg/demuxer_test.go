@@ -0,0 +1,138 @@
+package mpjpeg_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"strings"
+	"testing"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/codec/mjpeg"
+	"github.com/vtpl1/avsdk/format/mpjpeg"
+	"github.com/vtpl1/avsdk/internal/avtest"
+)
+
+func encodeJPEG(t *testing.T, width, height int) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewGray(image.Rect(0, 0, width, height)), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestMuxerDemuxerRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	small, large := encodeJPEG(t, 64, 48), encodeJPEG(t, 128, 96)
+
+	var buf bytes.Buffer
+
+	mux := mpjpeg.NewMuxer(&buf)
+
+	streams := []av.Stream{{Idx: 1, Codec: mjpeg.CodecData{PicWidth: 64, PicHeight: 48}}}
+	if err := mux.WriteHeader(ctx, streams); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, image := range [][]byte{small, small, large} {
+		if err := mux.WritePacket(ctx, av.Packet{Idx: 1, KeyFrame: true, Data: image}); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := mux.WritePacket(ctx, av.Packet{Idx: 2, Data: []byte{1, 2}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := mux.WriteTrailer(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if !mpjpeg.Probe(buf.Bytes()) {
+		t.Fatal("Probe rejected muxer output")
+	}
+
+	dmx := mpjpeg.NewDemuxer(&buf)
+
+	got, err := dmx.GetCodecs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if codec, ok := got[0].Codec.(av.VideoCodecData); !ok || codec.Width() != 64 || codec.Height() != 48 {
+		t.Fatalf("codec = %+v", got[0].Codec)
+	}
+
+	pkts := avtest.ReadAll(t, dmx)
+	if len(pkts) != 3 {
+		t.Fatalf("got %d packets, want 3", len(pkts))
+	}
+
+	for i, pkt := range pkts {
+		if !pkt.KeyFrame || pkt.Idx != 0 || pkt.DTS < 0 || (i > 0 && pkt.DTS < pkts[i-1].DTS) {
+			t.Fatalf("packet %d: %v", i, pkt.String())
+		}
+	}
+
+	if !bytes.Equal(pkts[0].Data, small) || !bytes.Equal(pkts[2].Data, large) {
+		t.Fatal("images differ after round trip")
+	}
+
+	if pkts[0].NewCodecs != nil || pkts[1].NewCodecs != nil {
+		t.Fatal("NewCodecs set without a size change")
+	}
+
+	if len(pkts[2].NewCodecs) != 1 || pkts[2].NewCodecs[0].Codec.(mjpeg.CodecData).PicWidth != 128 {
+		t.Fatalf("NewCodecs = %+v", pkts[2].NewCodecs)
+	}
+}
+
+func TestDemuxerWithoutContentLength(t *testing.T) {
+	image := encodeJPEG(t, 32, 16)
+
+	var stream strings.Builder
+
+	// Cameras often disagree on the leading dashes and omit Content-Length.
+	stream.WriteString("\r\n--myboundary\r\nContent-Type: text/plain\r\n\r\nhello\r\n")
+	for range 2 {
+		fmt.Fprintf(&stream, "--myboundary\r\nContent-Type: image/jpeg\r\n\r\n%s\r\n", image)
+	}
+
+	stream.WriteString("--myboundary--\r\n")
+
+	dmx := mpjpeg.NewDemuxer(strings.NewReader(stream.String()), mpjpeg.WithBoundary("--myboundary"))
+
+	pkts := avtest.ReadAll(t, dmx)
+	if len(pkts) != 2 {
+		t.Fatalf("got %d packets, want 2", len(pkts))
+	}
+
+	for i, pkt := range pkts {
+		if !bytes.Equal(pkt.Data, image) {
+			t.Fatalf("packet %d: %d bytes, want %d", i, len(pkt.Data), len(image))
+		}
+	}
+}
+
+func TestDemuxerInvalidContentLength(t *testing.T) {
+	stream := "--myboundary\r\nContent-Type: image/jpeg\r\nContent-Length: -1\r\n\r\n"
+	dmx := mpjpeg.NewDemuxer(strings.NewReader(stream), mpjpeg.WithBoundary("--myboundary"))
+
+	if _, err := dmx.GetCodecs(context.Background()); !errors.Is(err, mpjpeg.ErrInvalidContentLength) {
+		t.Fatalf("err = %v, want ErrInvalidContentLength", err)
+	}
+}
+
+func TestDemuxerNoBoundary(t *testing.T) {
+	dmx := mpjpeg.NewDemuxer(bytes.NewReader(bytes.Repeat([]byte("x\n"), 40000)))
+
+	if _, err := dmx.GetCodecs(context.Background()); !errors.Is(err, mpjpeg.ErrNoBoundary) {
+		t.Fatalf("err = %v, want ErrNoBoundary", err)
+	}
+}
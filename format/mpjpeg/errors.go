@@ -0,0 +1,16 @@
+package mpjpeg
+
+import "errors"
+
+var (
+	ErrNoBoundary            = errors.New("mpjpeg: multipart boundary not found")
+	ErrFrameTooLarge         = errors.New("mpjpeg: frame too large")
+	ErrInvalidContentLength  = errors.New("mpjpeg: invalid Content-Length")
+	ErrNotMultipart          = errors.New("mpjpeg: response is not multipart")
+	ErrBadStatus             = errors.New("mpjpeg: unexpected HTTP status")
+	ErrNoStreams             = errors.New("mpjpeg: no MJPEG or JPEG stream")
+	ErrUnsupportedCodec      = errors.New("mpjpeg: unsupported codec")
+	ErrHeaderNotWritten      = errors.New("mpjpeg: WriteHeader not called")
+	ErrHeaderAlreadyWritten  = errors.New("mpjpeg: WriteHeader already called")
+	ErrTrailerAlreadyWritten = errors.New("mpjpeg: WriteTrailer already called")
+)
@@ -0,0 +1,99 @@
+// Package mpjpeg implements Motion JPEG over HTTP: a demuxer and muxer for
+// multipart/x-mixed-replace streams of JPEG images, and a Server that
+// broadcasts a stream to HTTP clients.
+package mpjpeg
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/av/avutil"
+)
+
+// DefaultBoundary separates the parts written by Muxer and Server.
+const DefaultBoundary = "avsdkboundary"
+
+// maxFrameSize bounds the size of a single JPEG image.
+const maxFrameSize = 16 << 20
+
+// Extensions lists the file extensions registered by Handlers.
+//
+//nolint:gochecknoglobals
+var Extensions = []string{".mjpg", ".mjpeg"}
+
+// ContentType returns the Content-Type of a stream whose parts are separated by boundary.
+func ContentType(boundary string) string {
+	return "multipart/x-mixed-replace; boundary=" + boundary
+}
+
+// Probe reports whether b starts with a multipart boundary line.
+func Probe(b []byte) bool {
+	b = bytes.TrimLeft(b, "\r\n")
+	line, _, ok := bytes.Cut(b, []byte("\n"))
+
+	return ok && len(line) > 2 && bytes.HasPrefix(line, []byte("--"))
+}
+
+// Handlers returns one avutil handler per entry of Extensions. Register them
+// with avutil.Handlers.Add.
+func Handlers() []func(*avutil.RegisterHandler) {
+	handlers := make([]func(*avutil.RegisterHandler), 0, len(Extensions))
+
+	for _, ext := range Extensions {
+		handlers = append(handlers, func(h *avutil.RegisterHandler) {
+			h.Ext = ext
+			h.Probe = Probe
+			h.ReaderDemuxer = func(r io.Reader) av.Demuxer {
+				return NewDemuxer(r)
+			}
+			h.WriterMuxer = func(w io.Writer) av.Muxer {
+				return NewMuxer(w)
+			}
+			h.CodecTypes = []av.CodecType{av.MJPEG, av.JPEG}
+		})
+	}
+
+	return handlers
+}
+
+// selectStream returns the index of the first MJPEG or JPEG stream. Audio
+// streams are ignored; other video codecs are rejected.
+func selectStream(streams []av.Stream) (uint16, error) {
+	for _, stream := range streams {
+		switch typ := stream.Codec.Type(); {
+		case typ == av.MJPEG || typ == av.JPEG:
+			return stream.Idx, nil
+		case typ.IsVideo():
+			return 0, ErrUnsupportedCodec
+		}
+	}
+
+	return 0, ErrNoStreams
+}
+
+// writePart writes one JPEG image as a multipart part and flushes w if it is
+// an http.Flusher.
+func writePart(w io.Writer, boundary string, image []byte) error {
+	header := fmt.Sprintf("--%s\r\nContent-Type: image/jpeg\r\nContent-Length: %d\r\n\r\n", boundary, len(image))
+
+	if _, err := io.WriteString(w, header); err != nil {
+		return err
+	}
+
+	if _, err := w.Write(image); err != nil {
+		return err
+	}
+
+	if _, err := io.WriteString(w, "\r\n"); err != nil {
+		return err
+	}
+
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+
+	return nil
+}
@@ -0,0 +1,78 @@
+package mpjpeg
+
+import (
+	"context"
+	"io"
+
+	"github.com/vtpl1/avsdk/av"
+)
+
+// Muxer writes the first MJPEG or JPEG stream as multipart/x-mixed-replace,
+// one part per packet. Parts are flushed immediately when w is an
+// http.Flusher, so w can be an http.ResponseWriter whose Content-Type is set
+// to ContentType(DefaultBoundary). It implements av.MuxCloser.
+type Muxer struct {
+	w     io.Writer
+	idx   uint16
+	stage int
+}
+
+// NewMuxer returns a Muxer writing to w. Close closes w if it is an io.Closer.
+func NewMuxer(w io.Writer) *Muxer {
+	return &Muxer{w: w}
+}
+
+// WriteHeader implements av.Muxer. Multipart streams have no header.
+func (m *Muxer) WriteHeader(_ context.Context, streams []av.Stream) error {
+	if m.stage != 0 {
+		return ErrHeaderAlreadyWritten
+	}
+
+	idx, err := selectStream(streams)
+	if err != nil {
+		return err
+	}
+
+	m.idx = idx
+	m.stage++
+
+	return nil
+}
+
+// WritePacket implements av.Muxer. Packets of other streams are ignored.
+func (m *Muxer) WritePacket(_ context.Context, pkt av.Packet) error {
+	if m.stage != 1 {
+		return ErrHeaderNotWritten
+	}
+
+	if pkt.Idx != m.idx {
+		return nil
+	}
+
+	return writePart(m.w, DefaultBoundary, pkt.Data)
+}
+
+// WriteTrailer implements av.Muxer. It writes the closing boundary.
+func (m *Muxer) WriteTrailer(_ context.Context) error {
+	switch m.stage {
+	case 0:
+		return ErrHeaderNotWritten
+	case 2:
+		return ErrTrailerAlreadyWritten
+	}
+
+	m.stage++
+
+	_, err := io.WriteString(m.w, "--"+DefaultBoundary+"--\r\n")
+
+	return err
+}
+
+// Close implements av.MuxCloser.
+func (m *Muxer) Close() error {
+	if c, ok := m.w.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
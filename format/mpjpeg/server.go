@@ -0,0 +1,163 @@
+package mpjpeg
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/vtpl1/avsdk/av"
+)
+
+// Server broadcasts the first MJPEG or JPEG stream written to it to any
+// number of HTTP clients as multipart/x-mixed-replace. Each client is sent the
+// latest image whenever a new one arrives, so slow clients skip images rather
+// than fall behind. It implements av.MuxCloser and http.Handler.
+type Server struct {
+	mu      sync.Mutex
+	idx     uint16
+	stage   int
+	image   []byte
+	seq     uint64
+	ended   bool
+	updated chan struct{} // closed when a new image arrives or the stream ends
+}
+
+// NewServer returns a Server with no image yet.
+func NewServer() *Server {
+	return &Server{updated: make(chan struct{})}
+}
+
+// WriteHeader implements av.Muxer.
+func (m *Server) WriteHeader(_ context.Context, streams []av.Stream) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.stage != 0 {
+		return ErrHeaderAlreadyWritten
+	}
+
+	idx, err := selectStream(streams)
+	if err != nil {
+		return err
+	}
+
+	m.idx = idx
+	m.stage++
+
+	return nil
+}
+
+// WritePacket implements av.Muxer. Packets of other streams are ignored.
+func (m *Server) WritePacket(_ context.Context, pkt av.Packet) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.stage != 1 {
+		return ErrHeaderNotWritten
+	}
+
+	if pkt.Idx != m.idx {
+		return nil
+	}
+
+	m.image = bytes.Clone(pkt.Data)
+	m.seq++
+	m.notify()
+
+	return nil
+}
+
+// WriteTrailer implements av.Muxer. Connected clients are sent the closing
+// boundary; later clients get the last image only.
+func (m *Server) WriteTrailer(_ context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	switch m.stage {
+	case 0:
+		return ErrHeaderNotWritten
+	case 2:
+		return ErrTrailerAlreadyWritten
+	}
+
+	m.stage++
+	m.end()
+
+	return nil
+}
+
+// Close implements av.MuxCloser. It ends the stream for connected clients.
+func (m *Server) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.end()
+
+	return nil
+}
+
+// ServeHTTP implements http.Handler.
+func (m *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+
+		return
+	}
+
+	w.Header().Set("Content-Type", ContentType(DefaultBoundary))
+	w.Header().Set("Cache-Control", "no-cache, no-store")
+
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	var sent uint64
+
+	for {
+		image, seq, ended, updated := m.latest()
+
+		if seq != sent {
+			if err := writePart(w, DefaultBoundary, image); err != nil {
+				return
+			}
+
+			sent = seq
+		}
+
+		if ended {
+			_, _ = w.Write([]byte("--" + DefaultBoundary + "--\r\n"))
+
+			return
+		}
+
+		select {
+		case <-updated:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// latest returns the current image and its sequence number, whether the
+// stream has ended and the channel closed on the next change.
+func (m *Server) latest() ([]byte, uint64, bool, <-chan struct{}) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.image, m.seq, m.ended, m.updated
+}
+
+func (m *Server) end() {
+	if m.ended {
+		return
+	}
+
+	m.ended = true
+	m.notify()
+}
+
+func (m *Server) notify() {
+	close(m.updated)
+	m.updated = make(chan struct{})
+}
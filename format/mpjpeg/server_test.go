@@ -0,0 +1,82 @@
+package mpjpeg_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/codec/mjpeg"
+	"github.com/vtpl1/avsdk/format/mpjpeg"
+	"github.com/vtpl1/avsdk/internal/avtest"
+)
+
+func TestServer(t *testing.T) {
+	ctx := context.Background()
+	first, second := encodeJPEG(t, 64, 48), encodeJPEG(t, 32, 32)
+
+	srv := mpjpeg.NewServer()
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	if err := srv.WriteHeader(ctx, []av.Stream{{Idx: 0, Codec: mjpeg.CodecData{PicWidth: 64, PicHeight: 48}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := srv.WritePacket(ctx, av.Packet{KeyFrame: true, Data: first}); err != nil {
+		t.Fatal(err)
+	}
+
+	dmx, err := mpjpeg.Open(ctx, ts.Client(), ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dmx.Close()
+
+	// The latest image is sent as soon as the client connects.
+	pkt, err := dmx.ReadPacket(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(pkt.Data, first) {
+		t.Fatal("first image differs")
+	}
+
+	if err := srv.WritePacket(ctx, av.Packet{KeyFrame: true, Data: second}); err != nil {
+		t.Fatal(err)
+	}
+
+	pkt, err = dmx.ReadPacket(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(pkt.Data, second) || len(pkt.NewCodecs) != 1 {
+		t.Fatalf("second packet: %v", pkt.String())
+	}
+
+	if err := srv.WriteTrailer(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := dmx.ReadPacket(ctx); !errors.Is(err, io.EOF) {
+		t.Fatalf("err = %v, want io.EOF after WriteTrailer", err)
+	}
+}
+
+func TestServerRejectsOtherVideo(t *testing.T) {
+	video := avtest.H264(t, avtest.SPS320x192)
+
+	err := mpjpeg.NewServer().WriteHeader(context.Background(), []av.Stream{{Idx: 0, Codec: video}})
+	if !errors.Is(err, mpjpeg.ErrUnsupportedCodec) {
+		t.Fatalf("err = %v, want ErrUnsupportedCodec", err)
+	}
+
+	if _, err := mpjpeg.Open(context.Background(), nil, "http://127.0.0.1:0/"); err == nil {
+		t.Fatal("Open succeeded without a server")
+	}
+}
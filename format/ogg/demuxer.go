@@ -0,0 +1,172 @@
+package ogg
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/internal/ticks"
+	"github.com/vtpl1/avsdk/utils/bits/pio"
+)
+
+// Demuxer reads the first logical stream of an Ogg file, which must be Opus
+// or FLAC. Packets of other logical streams are ignored. Stream.Idx is always 0.
+//
+// Timestamps start from the granule position of the first audio page and
+// advance by the duration of each packet.
+type Demuxer struct {
+	r       *bufio.Reader
+	serial  uint32
+	codec   av.AudioCodecData
+	rate    int
+	partial []byte
+	queue   []av.Packet
+	samples int64
+	timed   bool
+	eos     bool
+	frameID int64
+}
+
+// NewDemuxer returns a Demuxer reading from r.
+func NewDemuxer(r io.Reader) *Demuxer {
+	return &Demuxer{
+		r: bufio.NewReaderSize(r, pio.RecommendBufioSize),
+	}
+}
+
+// GetCodecs implements av.Demuxer. It reads pages until the codec is identified
+// and returns ErrNoStreams if the file ends first.
+func (m *Demuxer) GetCodecs(ctx context.Context) ([]av.Stream, error) {
+	for m.codec == nil {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		err := m.readPage()
+		if errors.Is(err, io.EOF) {
+			return nil, ErrNoStreams
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return []av.Stream{{Idx: 0, Codec: m.codec}}, nil
+}
+
+// ReadPacket implements av.Demuxer.
+func (m *Demuxer) ReadPacket(ctx context.Context) (av.Packet, error) {
+	if _, err := m.GetCodecs(ctx); err != nil {
+		return av.Packet{}, err
+	}
+
+	for len(m.queue) == 0 {
+		if m.eos {
+			return av.Packet{}, io.EOF
+		}
+
+		if err := ctx.Err(); err != nil {
+			return av.Packet{}, err
+		}
+
+		if err := m.readPage(); err != nil {
+			return av.Packet{}, err
+		}
+	}
+
+	pkt := m.queue[0]
+	m.queue = m.queue[1:]
+
+	return pkt, nil
+}
+
+func (m *Demuxer) readPage() error {
+	p, err := readPage(m.r)
+	if err != nil {
+		return err
+	}
+
+	if m.codec == nil && p.flags&flagBOS != 0 && len(m.partial) == 0 {
+		m.serial = p.serial
+	}
+
+	if p.serial != m.serial {
+		return nil
+	}
+
+	if p.flags&flagContinued == 0 {
+		// A packet left open by the previous page was lost.
+		m.partial = m.partial[:0]
+	}
+
+	var (
+		pkts    []av.Packet
+		samples int64
+		off     int
+	)
+
+	for _, seg := range p.segs {
+		m.partial = append(m.partial, p.data[off:off+int(seg)]...)
+		off += int(seg)
+
+		if seg == 255 {
+			continue
+		}
+
+		data := append([]byte{}, m.partial...)
+		m.partial = m.partial[:0]
+
+		if m.codec == nil {
+			codec, ok := parseHeader(data)
+			if !ok {
+				return ErrUnsupportedCodec
+			}
+
+			m.codec = codec
+			m.rate = granuleRate(codec)
+
+			continue
+		}
+
+		if isHeaderPacket(m.codec.Type(), data) {
+			continue
+		}
+
+		dur, err := m.codec.PacketDuration(data)
+		if err != nil {
+			continue
+		}
+
+		pkts = append(pkts, av.Packet{
+			KeyFrame:  true,
+			Idx:       0,
+			Duration:  dur,
+			Data:      data,
+			CodecType: m.codec.Type(),
+		})
+		samples += ticks.FromDuration(dur, int64(m.rate))
+	}
+
+	if !m.timed && len(pkts) > 0 && p.granule != noGranule {
+		m.samples = max(p.granule-samples, 0)
+		m.timed = true
+	}
+
+	for i := range pkts {
+		pkts[i].DTS = ticks.ToDuration(m.samples, int64(m.rate))
+		pkts[i].FrameID = m.frameID
+		m.samples += ticks.FromDuration(pkts[i].Duration, int64(m.rate))
+		m.frameID++
+	}
+
+	m.queue = append(m.queue, pkts...)
+
+	if p.flags&flagEOS != 0 {
+		m.eos = true
+	}
+
+	return nil
+}
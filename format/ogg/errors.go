@@ -0,0 +1,14 @@
+package ogg
+
+import "errors"
+
+var (
+	ErrNotOgg                = errors.New("ogg: capture pattern not found")
+	ErrInvalidPage           = errors.New("ogg: invalid page header")
+	ErrUnsupportedCodec      = errors.New("ogg: unsupported codec")
+	ErrNoStreams             = errors.New("ogg: no Opus or FLAC stream")
+	ErrHeaderNotWritten      = errors.New("ogg: WriteHeader not called")
+	ErrHeaderAlreadyWritten  = errors.New("ogg: WriteHeader already called")
+	ErrTrailerAlreadyWritten = errors.New("ogg: WriteTrailer already called")
+	ErrTooManyStreams        = errors.New("ogg: only one audio stream is supported")
+)
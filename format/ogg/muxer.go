@@ -0,0 +1,159 @@
+package ogg
+
+import (
+	"context"
+	"io"
+	"math/rand/v2"
+	"time"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/codec"
+	"github.com/vtpl1/avsdk/internal/ticks"
+)
+
+// maxPageDuration bounds the audio buffered in one page.
+const maxPageDuration = time.Second
+
+// Muxer writes a single Opus or FLAC stream as Ogg. Other streams are
+// ignored. It implements av.Muxer.
+type Muxer struct {
+	pages     pageWriter
+	idx       uint16
+	codec     av.AudioCodecData
+	rate      int
+	samples   int64
+	pageStart int64
+	stage     int
+}
+
+// NewMuxer returns a Muxer writing to w. The stream serial number is random.
+func NewMuxer(w io.Writer) *Muxer {
+	return &Muxer{
+		pages: pageWriter{w: w, serial: rand.Uint32(), granule: noGranule}, //nolint:gosec
+	}
+}
+
+// WriteHeader implements av.Muxer. It writes the identification and comment
+// header pages. Exactly one Opus or FLAC stream is required.
+func (m *Muxer) WriteHeader(_ context.Context, streams []av.Stream) error {
+	if m.stage != 0 {
+		return ErrHeaderAlreadyWritten
+	}
+
+	for _, stream := range streams {
+		if !stream.Codec.Type().IsAudio() {
+			continue
+		}
+
+		if m.codec != nil {
+			return ErrTooManyStreams
+		}
+
+		codec, ok := stream.Codec.(av.AudioCodecData)
+		if !ok || (codec.Type() != av.OPUS && codec.Type() != av.FLAC) {
+			return ErrUnsupportedCodec
+		}
+
+		m.idx = stream.Idx
+		m.codec = codec
+	}
+
+	if m.codec == nil {
+		return ErrNoStreams
+	}
+
+	channels := max(m.codec.ChannelLayout().Count(), 1)
+	if channels > 2 {
+		return ErrUnsupportedCodec
+	}
+
+	var headers [][]byte
+
+	if m.codec.Type() == av.OPUS {
+		opus, ok := m.codec.(codec.OpusCodecData)
+		if !ok {
+			opus = codec.OpusCodecData{SampleRt: m.codec.SampleRate(), ChLayout: m.codec.ChannelLayout()}
+		}
+
+		headers = [][]byte{opus.OpusHead(), commentHeader([]byte("OpusTags"))}
+	} else {
+		if m.codec.SampleRate() <= 0 {
+			return ErrUnsupportedCodec
+		}
+
+		headers = flacHeaders(m.codec.SampleRate(), channels)
+	}
+
+	m.rate = granuleRate(m.codec)
+
+	// The identification header is alone on the first page; audio starts on a fresh page.
+	for i, header := range headers {
+		if i == 0 {
+			m.pages.flags = flagBOS
+		}
+
+		if err := m.pages.packet(header, 0); err != nil {
+			return err
+		}
+
+		if i == 0 || i == len(headers)-1 {
+			if err := m.pages.flush(0); err != nil {
+				return err
+			}
+		}
+	}
+
+	m.stage++
+
+	return nil
+}
+
+// WritePacket implements av.Muxer. The granule position advances by the
+// packet duration read from the Opus TOC byte or FLAC frame header.
+func (m *Muxer) WritePacket(_ context.Context, pkt av.Packet) error {
+	if m.stage != 1 {
+		return ErrHeaderNotWritten
+	}
+
+	if pkt.Idx != m.idx {
+		return nil
+	}
+
+	dur, err := m.codec.PacketDuration(pkt.Data)
+	if err != nil {
+		dur = pkt.Duration
+	}
+
+	m.samples += ticks.FromDuration(dur, int64(m.rate))
+
+	if err := m.pages.packet(pkt.Data, m.samples); err != nil {
+		return err
+	}
+
+	if m.samples-m.pageStart >= ticks.FromDuration(maxPageDuration, int64(m.rate)) {
+		m.pageStart = m.samples
+
+		return m.pages.flush(0)
+	}
+
+	return nil
+}
+
+// WriteTrailer implements av.Muxer. It writes the last page with the end-of-stream flag.
+func (m *Muxer) WriteTrailer(_ context.Context) error {
+	switch m.stage {
+	case 0:
+		return ErrHeaderNotWritten
+	case 2:
+		return ErrTrailerAlreadyWritten
+	}
+
+	m.stage++
+
+	if len(m.pages.segs) == 0 {
+		// An empty last page still carries the final granule position.
+		m.pages.granule = m.samples
+	}
+
+	return m.pages.flush(flagEOS)
+}
@@ -0,0 +1,319 @@
+package ogg_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/codec"
+	"github.com/vtpl1/avsdk/codec/pcm"
+	"github.com/vtpl1/avsdk/format/ogg"
+)
+
+type oggPage struct {
+	flags   byte
+	granule int64
+	serial  uint32
+	seq     uint32
+	segs    []byte
+}
+
+// pages splits b into Ogg pages without checking CRCs.
+func pages(t *testing.T, b []byte) []oggPage {
+	t.Helper()
+
+	var out []oggPage
+
+	for len(b) > 0 {
+		if len(b) < 27 || string(b[:4]) != "OggS" {
+			t.Fatalf("bad page header %x", b[:min(len(b), 27)])
+		}
+
+		nsegs := int(b[26])
+		size := 27 + nsegs
+
+		for _, s := range b[27 : 27+nsegs] {
+			size += int(s)
+		}
+
+		out = append(out, oggPage{
+			flags:   b[5],
+			granule: int64(binary.LittleEndian.Uint64(b[6:])),
+			serial:  binary.LittleEndian.Uint32(b[14:]),
+			seq:     binary.LittleEndian.Uint32(b[18:]),
+			segs:    b[27 : 27+nsegs],
+		})
+		b = b[size:]
+	}
+
+	return out
+}
+
+func mux(t *testing.T, codecData av.CodecData, packets [][]byte) []byte {
+	t.Helper()
+
+	ctx := context.Background()
+
+	var buf bytes.Buffer
+
+	m := ogg.NewMuxer(&buf)
+
+	if err := m.WriteHeader(ctx, []av.Stream{{Idx: 1, Codec: codecData}}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, data := range packets {
+		if err := m.WritePacket(ctx, av.Packet{Idx: 1, Data: data}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Packets of other streams are ignored.
+	if err := m.WritePacket(ctx, av.Packet{Idx: 3, Data: []byte{0x21}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.WriteTrailer(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func demux(t *testing.T, b []byte) (av.AudioCodecData, []av.Packet) {
+	t.Helper()
+
+	ctx := context.Background()
+	dmx := ogg.NewDemuxer(bytes.NewReader(b))
+
+	streams, err := dmx.GetCodecs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(streams) != 1 || streams[0].Idx != 0 {
+		t.Fatalf("streams = %+v", streams)
+	}
+
+	var pkts []av.Packet
+
+	for {
+		pkt, err := dmx.ReadPacket(ctx)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pkts = append(pkts, pkt)
+	}
+
+	return streams[0].Codec.(av.AudioCodecData), pkts //nolint:forcetypeassert
+}
+
+func TestOpusRoundTrip(t *testing.T) {
+	var packets [][]byte
+
+	for i := range 80 {
+		switch {
+		case i == 5:
+			// Spans more than one page.
+			packets = append(packets, append([]byte{0xf8}, bytes.Repeat([]byte{byte(i)}, 70000)...))
+		case i%4 == 0:
+			packets = append(packets, []byte{0xf0, byte(i)}) // CELT 10 ms
+		default:
+			packets = append(packets, append([]byte{0xf8}, bytes.Repeat([]byte{byte(i)}, 255*(i%3))...)) // CELT 20 ms
+		}
+	}
+
+	opus := codec.NewOpusCodecData(48000, av.ChStereo).(codec.OpusCodecData) //nolint:forcetypeassert
+	opus.PreSkip = 312
+
+	b := mux(t, opus, packets)
+
+	if !ogg.Probe(b) {
+		t.Fatal("Probe rejected muxer output")
+	}
+
+	ps := pages(t, b)
+	if ps[0].flags != 0x02 || len(ps[0].segs) != 1 || !bytes.Contains(b[:64], []byte("OpusHead\x01\x02")) {
+		t.Fatalf("first page = %+v", ps[0])
+	}
+
+	total := int64(0)
+	for i := range packets {
+		if i%4 == 0 {
+			total += 480
+		} else {
+			total += 960
+		}
+	}
+
+	last := ps[len(ps)-1]
+	if last.flags&0x04 == 0 || last.granule != total {
+		t.Fatalf("last page flags = %x, granule = %d, want EOS and %d", last.flags, last.granule, total)
+	}
+
+	for i, p := range ps {
+		if p.seq != uint32(i) || p.serial != ps[0].serial {
+			t.Fatalf("page %d: seq = %d, serial = %x", i, p.seq, p.serial)
+		}
+	}
+
+	codecData, pkts := demux(t, b)
+	if codecData.Type() != av.OPUS || codecData.ChannelLayout() != av.ChStereo || codecData.SampleRate() != 48000 {
+		t.Fatalf("codec = %+v", codecData)
+	}
+
+	if head, ok := codecData.(codec.OpusCodecData); !ok || head.PreSkip != opus.PreSkip {
+		t.Fatalf("codec = %+v, want pre-skip %d", codecData, opus.PreSkip)
+	}
+
+	if len(pkts) != len(packets) {
+		t.Fatalf("got %d packets, want %d", len(pkts), len(packets))
+	}
+
+	var dts time.Duration
+
+	for i, pkt := range pkts {
+		if !bytes.Equal(pkt.Data, packets[i]) || pkt.DTS != dts || !pkt.KeyFrame {
+			t.Fatalf("packet %d: %v, want dts %v", i, pkt.String(), dts)
+		}
+
+		dts += pkt.Duration
+	}
+
+	if dts != time.Duration(total)*time.Second/48000 {
+		t.Fatalf("total duration = %v", dts)
+	}
+}
+
+func TestFLACRoundTrip(t *testing.T) {
+	encode := pcm.FLACEncoder(av.PCM_MULAW, 8000)
+
+	var packets [][]byte
+
+	for i := range 60 {
+		packets = append(packets, encode(bytes.Repeat([]byte{byte(i)}, 160)))
+	}
+
+	b := mux(t, pcm.NewFLACCodecData(8000), packets)
+
+	if !bytes.Contains(b[:128], []byte("\x7fFLAC\x01\x00\x00\x01fLaC")) {
+		t.Fatal("missing Ogg FLAC mapping header")
+	}
+
+	codecData, pkts := demux(t, b)
+	if codecData.Type() != av.FLAC || codecData.SampleRate() != 8000 || codecData.ChannelLayout() != av.ChMono {
+		t.Fatalf("codec = %+v", codecData)
+	}
+
+	if len(pkts) != len(packets) {
+		t.Fatalf("got %d packets, want %d", len(pkts), len(packets))
+	}
+
+	for i, pkt := range pkts {
+		if !bytes.Equal(pkt.Data, packets[i]) || pkt.DTS != time.Duration(i)*20*time.Millisecond || pkt.Duration != 20*time.Millisecond {
+			t.Fatalf("packet %d: %v", i, pkt.String())
+		}
+	}
+}
+
+// oggCRC is the Ogg page checksum (RFC 3533 §6).
+func oggCRC(b []byte) uint32 {
+	var crc uint32
+
+	for _, v := range b {
+		crc ^= uint32(v) << 24
+		for range 8 {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+
+	return crc
+}
+
+func TestDemuxerFLACChannels(t *testing.T) {
+	b := mux(t, pcm.NewFLACCodecData(8000), nil)
+
+	// Rewrite STREAMINFO to six channels and fix up the page CRC.
+	si := bytes.Index(b, []byte("fLaC")) + 8
+	b[si+12] = b[si+12]&^0x0e | 5<<1
+
+	first := pages(t, b)[0]
+	size := 27 + len(first.segs)
+
+	for _, seg := range first.segs {
+		size += int(seg)
+	}
+
+	binary.LittleEndian.PutUint32(b[22:], 0)
+	binary.LittleEndian.PutUint32(b[22:], oggCRC(b[:size]))
+
+	streams, err := ogg.NewDemuxer(bytes.NewReader(b)).GetCodecs(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := av.ChSurround | av.ChBackLeft | av.ChBackRight | av.ChLowFreq
+	if layout := streams[0].Codec.(av.AudioCodecData).ChannelLayout(); layout != want { //nolint:forcetypeassert
+		t.Fatalf("layout = %v, want %v", layout, want)
+	}
+}
+
+func TestDemuxerNotOgg(t *testing.T) {
+	_, err := ogg.NewDemuxer(bytes.NewReader(make([]byte, 128*1024))).GetCodecs(context.Background())
+	if !errors.Is(err, ogg.ErrNotOgg) {
+		t.Fatalf("err = %v, want ErrNotOgg", err)
+	}
+}
+
+func TestDemuxerSkipsCorruptPage(t *testing.T) {
+	var packets [][]byte
+
+	for i := range 150 {
+		packets = append(packets, []byte{0xf8, byte(i)})
+	}
+
+	b := mux(t, codec.NewOpusCodecData(48000, av.ChMono), packets)
+
+	// Corrupt a byte in the payload of the first audio page.
+	ps := pages(t, b)
+	off := 0
+
+	for _, p := range ps[:2] {
+		off += 27 + len(p.segs)
+		for _, s := range p.segs {
+			off += int(s)
+		}
+	}
+
+	b[off+27+len(ps[2].segs)+1] ^= 0xff
+
+	_, pkts := demux(t, b)
+	if want := 150 - len(ps[2].segs); len(pkts) != want {
+		t.Fatalf("got %d packets, want %d", len(pkts), want)
+	}
+
+	// Timing continues from the granule position of the next intact page.
+	if pkts[0].DTS != time.Duration(len(ps[2].segs))*20*time.Millisecond {
+		t.Fatalf("first packet dts = %v", pkts[0].DTS)
+	}
+}
+
+func TestProbe(t *testing.T) {
+	if ogg.Probe([]byte("FLV\x01\x05\x00\x00\x00\x09")) {
+		t.Fatal("Probe accepted FLV header")
+	}
+}
@@ -0,0 +1,136 @@
+// Package ogg implements a demuxer and muxer for single-stream Ogg files
+// carrying Opus (RFC 7845) or FLAC (the Ogg FLAC mapping).
+package ogg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/av/avutil"
+	"github.com/vtpl1/avsdk/codec"
+	"github.com/vtpl1/avsdk/codec/pcm"
+)
+
+// Extensions lists the file extensions registered by Handlers.
+//
+//nolint:gochecknoglobals
+var Extensions = []string{".opus", ".oga", ".ogg"}
+
+// vendor is written to the OpusTags and Vorbis comment headers.
+const vendor = "avsdk"
+
+// opusRate is the granule position rate of Opus streams, whatever the input rate.
+const opusRate = 48000
+
+// Probe reports whether b starts with an Ogg page.
+func Probe(b []byte) bool {
+	return len(b) >= 5 && bytes.Equal(b[:4], []byte(capturePattern)) && b[4] == 0
+}
+
+// Handlers returns one avutil handler per entry of Extensions. Register them
+// with avutil.Handlers.Add.
+func Handlers() []func(*avutil.RegisterHandler) {
+	handlers := make([]func(*avutil.RegisterHandler), 0, len(Extensions))
+
+	for _, ext := range Extensions {
+		handlers = append(handlers, func(h *avutil.RegisterHandler) {
+			h.Ext = ext
+			h.Probe = Probe
+			h.ReaderDemuxer = func(r io.Reader) av.Demuxer {
+				return NewDemuxer(r)
+			}
+			h.WriterMuxer = func(w io.Writer) av.Muxer {
+				return NewMuxer(w)
+			}
+			h.CodecTypes = []av.CodecType{av.OPUS, av.FLAC}
+		})
+	}
+
+	return handlers
+}
+
+// commentHeader builds a Vorbis comment structure with no user comments,
+// prefixed by magic.
+func commentHeader(magic []byte) []byte {
+	b := append([]byte{}, magic...)
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(vendor)))
+	b = append(b, vendor...)
+
+	return binary.LittleEndian.AppendUint32(b, 0)
+}
+
+// flacHeaders returns the Ogg FLAC header packets: the mapping header with
+// STREAMINFO, followed by a VORBIS_COMMENT metadata block.
+func flacHeaders(sampleRate, channels int) [][]byte {
+	streamInfo := pcm.FLACHeader(true, uint32(sampleRate))
+	streamInfo[4] = 0x00 // STREAMINFO is no longer the last metadata block
+	streamInfo[20] |= byte(channels-1) << 1
+
+	first := []byte{0x7f, 'F', 'L', 'A', 'C', 1, 0}
+	first = binary.BigEndian.AppendUint16(first, 1) // one more header packet
+	first = append(first, streamInfo...)
+
+	comment := commentHeader(nil)
+	block := []byte{0x84, byte(len(comment) >> 16), byte(len(comment) >> 8), byte(len(comment))}
+
+	return [][]byte{first, append(block, comment...)}
+}
+
+// flacLayouts are the channel layouts of the FLAC channel assignments for
+// independent channels, indexed by channel count - 1.
+//
+//nolint:gochecknoglobals
+var flacLayouts = [8]av.ChannelLayout{
+	av.ChMono,
+	av.ChStereo,
+	av.ChSurround,
+	av.ChStereo | av.ChBackLeft | av.ChBackRight,
+	av.ChSurround | av.ChBackLeft | av.ChBackRight,
+	av.ChSurround | av.ChBackLeft | av.ChBackRight | av.ChLowFreq,
+	av.ChSurround | av.ChLowFreq | av.ChBackCenter | av.ChSideLeft | av.ChSideRight,
+	av.ChSurround | av.ChLowFreq | av.ChBackLeft | av.ChBackRight | av.ChSideLeft | av.ChSideRight,
+}
+
+// parseHeader identifies the codec from the first packet of a stream.
+func parseHeader(pkt []byte) (av.AudioCodecData, bool) {
+	switch {
+	case bytes.HasPrefix(pkt, []byte("OpusHead")):
+		head, err := codec.ParseOpusHead(pkt)
+
+		return head, err == nil
+	case len(pkt) >= 51 && bytes.HasPrefix(pkt, []byte("\x7fFLAC")) && bytes.Equal(pkt[9:13], []byte("fLaC")):
+		si := pkt[17:]
+		rate := int(si[10])<<12 | int(si[11])<<4 | int(si[12])>>4
+		channels := int(si[12]>>1&7) + 1
+
+		if rate == 0 {
+			return nil, false
+		}
+
+		return pcm.FLACCodecData{Typ: av.FLAC, SmplFormat: av.S16, SmplRate: rate, ChLayout: flacLayouts[channels-1]}, true
+	default:
+		return nil, false
+	}
+}
+
+// isHeaderPacket reports whether a packet following the identification
+// header is another header rather than audio.
+func isHeaderPacket(typ av.CodecType, pkt []byte) bool {
+	if typ == av.OPUS {
+		return bytes.HasPrefix(pkt, []byte("OpusTags"))
+	}
+
+	// FLAC frames start with a sync code; metadata blocks never start with 0xff.
+	return len(pkt) == 0 || pkt[0] != 0xff
+}
+
+// granuleRate is the rate of the granule position of a stream of codec.
+func granuleRate(codec av.AudioCodecData) int {
+	if codec.Type() == av.OPUS {
+		return opusRate
+	}
+
+	return codec.SampleRate()
+}
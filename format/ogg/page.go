@@ -0,0 +1,206 @@
+package ogg
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+const (
+	capturePattern = "OggS"
+	pageHeaderSize = 27
+	maxSegments    = 255
+)
+
+// Page header_type flags.
+const (
+	flagContinued = 0x01
+	flagBOS       = 0x02
+	flagEOS       = 0x04
+)
+
+// noGranule marks pages on which no packet ends.
+const noGranule = -1
+
+// crcTable is the table of the Ogg CRC-32: polynomial 0x04c11db7, not
+// reflected, zero initial value.
+//
+//nolint:gochecknoglobals
+var crcTable = func() [256]uint32 {
+	var t [256]uint32
+
+	for i := range t {
+		c := uint32(i) << 24
+		for range 8 {
+			if c&0x80000000 != 0 {
+				c = c<<1 ^ 0x04c11db7
+			} else {
+				c <<= 1
+			}
+		}
+
+		t[i] = c
+	}
+
+	return t
+}()
+
+func crc32(b []byte) uint32 {
+	var c uint32
+	for _, v := range b {
+		c = c<<8 ^ crcTable[byte(c>>24)^v]
+	}
+
+	return c
+}
+
+// pageWriter laces packets into pages of a single logical stream.
+type pageWriter struct {
+	w         io.Writer
+	serial    uint32
+	seq       uint32
+	segs      []byte
+	data      []byte
+	granule   int64
+	continued bool
+	flags     byte
+}
+
+// packet appends a packet whose last sample has the given granule position.
+// Pages are written when the segment table fills up.
+func (p *pageWriter) packet(data []byte, granule int64) error {
+	started := false
+
+	for {
+		if len(p.segs) == maxSegments {
+			if err := p.flush(0); err != nil {
+				return err
+			}
+
+			p.continued = started
+		}
+
+		n := min(len(data), 255)
+		p.segs = append(p.segs, byte(n))
+		p.data = append(p.data, data[:n]...)
+		data = data[n:]
+		started = true
+
+		// A lacing value below 255 ends the packet.
+		if n < 255 {
+			break
+		}
+	}
+
+	p.granule = granule
+
+	return nil
+}
+
+// flush writes the buffered segments as one page. With flagEOS an empty page
+// is written if nothing is buffered.
+func (p *pageWriter) flush(flags byte) error {
+	if len(p.segs) == 0 && flags&flagEOS == 0 {
+		return nil
+	}
+
+	flags |= p.flags
+	if p.continued {
+		flags |= flagContinued
+	}
+
+	b := make([]byte, 0, pageHeaderSize+len(p.segs)+len(p.data))
+	b = append(b, capturePattern...)
+	b = append(b, 0, flags)
+	b = binary.LittleEndian.AppendUint64(b, uint64(p.granule))
+	b = binary.LittleEndian.AppendUint32(b, p.serial)
+	b = binary.LittleEndian.AppendUint32(b, p.seq)
+	b = binary.LittleEndian.AppendUint32(b, 0) // CRC
+	b = append(b, byte(len(p.segs)))
+	b = append(b, p.segs...)
+	b = append(b, p.data...)
+	binary.LittleEndian.PutUint32(b[22:], crc32(b))
+
+	p.seq++
+	p.segs = p.segs[:0]
+	p.data = p.data[:0]
+	p.granule = noGranule
+	p.continued = false
+	p.flags = 0
+
+	_, err := p.w.Write(b)
+
+	return err
+}
+
+type page struct {
+	flags   byte
+	granule int64
+	serial  uint32
+	segs    []byte
+	data    []byte
+}
+
+// maxResync bounds how far readPage scans for the next capture pattern.
+const maxResync = 64 * 1024
+
+// readPage reads the next page whose CRC matches, skipping garbage between pages.
+func readPage(r *bufio.Reader) (*page, error) {
+	for skipped := 0; skipped < maxResync; {
+		hdr, err := r.Peek(pageHeaderSize)
+		if err != nil {
+			return nil, io.EOF
+		}
+
+		if !bytes.Equal(hdr[:4], []byte(capturePattern)) || hdr[4] != 0 {
+			_, _ = r.Discard(1)
+			skipped++
+
+			continue
+		}
+
+		nsegs := int(hdr[26])
+
+		full, err := r.Peek(pageHeaderSize + nsegs)
+		if err != nil {
+			return nil, io.EOF
+		}
+
+		size := pageHeaderSize + nsegs
+		for _, s := range full[pageHeaderSize:] {
+			size += int(s)
+		}
+
+		b, err := r.Peek(size)
+		if err != nil {
+			return nil, io.EOF
+		}
+
+		want := binary.LittleEndian.Uint32(b[22:])
+		check := append([]byte{}, b...)
+		binary.LittleEndian.PutUint32(check[22:], 0)
+
+		if crc32(check) != want {
+			// Not a real page boundary, or a corrupt page: keep scanning.
+			_, _ = r.Discard(1)
+			skipped++
+
+			continue
+		}
+
+		p := &page{
+			flags:   b[5],
+			granule: int64(binary.LittleEndian.Uint64(b[6:])),
+			serial:  binary.LittleEndian.Uint32(b[14:]),
+			segs:    check[pageHeaderSize : pageHeaderSize+nsegs],
+			data:    check[pageHeaderSize+nsegs:],
+		}
+
+		_, _ = r.Discard(size)
+
+		return p, nil
+	}
+
+	return nil, ErrNotOgg
+}
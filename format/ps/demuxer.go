@@ -0,0 +1,301 @@
+package ps
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/codec/pcm"
+	"github.com/vtpl1/avsdk/format/internal/pes"
+	"github.com/vtpl1/avsdk/utils/bits/pio"
+)
+
+const (
+	// maxProbeUnits bounds how many packs, headers and PES packets GetCodecs
+	// reads while waiting for parameter sets of every stream in the PSM.
+	maxProbeUnits = 5000
+
+	// maxResyncBytes bounds how many bytes are skipped looking for a start code.
+	maxResyncBytes = 1 << 20
+)
+
+type elementaryStream struct {
+	pes.Stream
+
+	streamType uint8
+	au         []byte // video access unit spread over several PES packets
+	auPTS      int64
+	auDTS      int64
+	auStarted  bool
+}
+
+// Demuxer reads H.264, H.265, ADTS AAC and G.711 from an MPEG program stream.
+// Streams are announced by the program stream map (PSM); a PSM with a new
+// version replaces them, and streams whose codec changes are reported
+// through Packet.NewCodecs. Stream.Idx and Packet.Idx are the PES stream_ids.
+type Demuxer struct {
+	dmx        pes.Demuxer
+	r          *bufio.Reader
+	psmVersion int
+	streams    map[uint8]*elementaryStream
+	order      []uint8
+	buf        []byte
+}
+
+// NewDemuxer returns a Demuxer reading from r.
+func NewDemuxer(r io.Reader) *Demuxer {
+	m := &Demuxer{
+		r:          bufio.NewReaderSize(r, pio.RecommendBufioSize),
+		psmVersion: -1,
+		streams:    make(map[uint8]*elementaryStream),
+	}
+	m.dmx.ReadUnit = m.readUnit
+	m.dmx.MaxProbeUnits = maxProbeUnits
+
+	return m
+}
+
+// GetCodecs implements av.Demuxer. It reads until every PSM stream has
+// produced its codec configuration; packets read meanwhile are queued.
+func (m *Demuxer) GetCodecs(ctx context.Context) ([]av.Stream, error) {
+	streams, err := m.dmx.GetCodecs(ctx)
+	if err == nil && len(streams) == 0 {
+		return nil, ErrNoStreams
+	}
+
+	return streams, err
+}
+
+// ReadPacket implements av.Demuxer. A video access unit is returned once the
+// next one starts, since PS carries one across several PES packets.
+func (m *Demuxer) ReadPacket(ctx context.Context) (av.Packet, error) {
+	return m.dmx.ReadPacket(ctx)
+}
+
+// readUnit consumes one pack header, system header, PSM, PES packet or end
+// code. At end of input it flushes every partially assembled access unit and
+// returns io.EOF.
+func (m *Demuxer) readUnit() error {
+	b, err := m.findStartCode()
+	if err != nil {
+		return m.finish(err)
+	}
+
+	switch code := b[3]; {
+	case code == startCodePack:
+		b, err := m.r.Peek(14)
+		if err != nil {
+			return m.finish(err)
+		}
+
+		size := 12 // MPEG-1 pack header
+		if b[4]>>6 == 0x01 {
+			size = 14 + int(b[13]&0x07)
+		}
+
+		_, err = m.r.Discard(size)
+
+		return m.finish(err)
+	case code == startCodeEnd:
+		_, _ = m.r.Discard(4)
+		m.flushAll()
+
+		return nil
+	case code < startCodeEnd:
+		// A start code prefix inside garbage; resync past it.
+		_, _ = m.r.Discard(1)
+
+		return nil
+	}
+
+	b, err = m.r.Peek(6)
+	if err != nil {
+		return m.finish(err)
+	}
+
+	size := 6 + int(pio.U16BE(b[4:]))
+	if cap(m.buf) < size {
+		m.buf = make([]byte, size)
+	}
+
+	m.buf = m.buf[:size]
+	if _, err := io.ReadFull(m.r, m.buf); err != nil {
+		return m.finish(err)
+	}
+
+	switch m.buf[3] {
+	case startCodePSM:
+		m.handlePSM(m.buf)
+
+		return nil
+	case startCodeSystemHeader, startCodePadding, startCodePrivate2, startCodeDirectory:
+		return nil
+	}
+
+	m.handlePES(m.buf)
+
+	return nil
+}
+
+// findStartCode skips to the next 0x000001 prefix and returns the four bytes
+// of the start code without consuming them.
+func (m *Demuxer) findStartCode() ([]byte, error) {
+	for skipped := 0; ; skipped++ {
+		if skipped > maxResyncBytes {
+			return nil, ErrStartCodeNotFound
+		}
+
+		b, err := m.r.Peek(4)
+		if err != nil {
+			return nil, err
+		}
+
+		if b[0] == 0 && b[1] == 0 && b[2] == 1 {
+			return b, nil
+		}
+
+		_, _ = m.r.Discard(1)
+	}
+}
+
+func (m *Demuxer) finish(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+
+	m.flushAll()
+
+	return io.EOF
+}
+
+func (m *Demuxer) flushAll() {
+	for _, id := range m.order {
+		m.flushAU(m.streams[id])
+	}
+}
+
+// handlePSM parses a program stream map (ISO/IEC 13818-1 §2.5.4). Its CRC is
+// not checked: many cameras write a zero CRC. A malformed map is dropped and
+// leaves the current program in place.
+func (m *Demuxer) handlePSM(b []byte) {
+	section := b[6:]
+	if len(section) < 10 {
+		return
+	}
+
+	version := int(section[0] & 0x1f)
+	if version == m.psmVersion {
+		return
+	}
+
+	infoLen := int(pio.U16BE(section[2:]))
+	if 6+infoLen > len(section) {
+		return
+	}
+
+	mapLen := int(pio.U16BE(section[4+infoLen:]))
+	if 6+infoLen+mapLen > len(section) {
+		return
+	}
+
+	entries := section[6+infoLen : 6+infoLen+mapLen]
+	streams := make(map[uint8]*elementaryStream)
+	program := make([]*pes.Stream, 0, len(m.dmx.Program))
+
+	var order []uint8
+
+	for len(entries) >= 4 {
+		streamType := entries[0]
+		id := entries[1]
+		esInfoLen := int(pio.U16BE(entries[2:]))
+
+		if 4+esInfoLen > len(entries) {
+			return
+		}
+
+		entries = entries[4+esInfoLen:]
+
+		codecType, ok := codecTypes[streamType]
+		if !ok {
+			continue
+		}
+
+		es, ok := m.streams[id]
+		if !ok || es.streamType != streamType {
+			// New or retyped stream: its codec is re-derived from the
+			// elementary stream and reported through Packet.NewCodecs.
+			es = &elementaryStream{Stream: pes.Stream{Idx: uint16(id), CodecType: codecType}, streamType: streamType}
+
+			switch streamType {
+			case StreamTypeG711A:
+				es.Codec = pcm.NewPCMAlawCodecData()
+			case StreamTypeG711U:
+				es.Codec = pcm.NewPCMMulawCodecData()
+			}
+		}
+
+		streams[id] = es
+		order = append(order, id)
+		program = append(program, &es.Stream)
+	}
+
+	for _, id := range m.order {
+		if es := m.streams[id]; streams[id] != es {
+			m.flushAU(es)
+		}
+	}
+
+	m.psmVersion = version
+	m.streams = streams
+	m.order = order
+	m.dmx.Program, m.dmx.HasProgram = program, true
+}
+
+// handlePES parses one PES packet. Video payloads are gathered into access
+// units; audio payloads are queued at once.
+func (m *Demuxer) handlePES(b []byte) {
+	es, ok := m.streams[b[3]]
+	if !ok {
+		return
+	}
+
+	hdr, data, ok := pes.ParsePacket(b)
+	if !ok {
+		return
+	}
+
+	switch es.streamType {
+	case StreamTypeH264, StreamTypeH265:
+		if hdr.HasPTS && (!es.auStarted || hdr.PTS != es.auPTS) {
+			m.flushAU(es)
+			es.auPTS, es.auDTS, es.auStarted = hdr.PTS, hdr.DTS, true
+		}
+
+		if es.auStarted {
+			es.au = append(es.au, data...)
+		}
+	default:
+		if hdr.HasPTS && len(data) > 0 {
+			m.dmx.Handle(&es.Stream, data, es.Unwrap.Unwrap(hdr.DTS), 0)
+		}
+	}
+}
+
+func (m *Demuxer) flushAU(es *elementaryStream) {
+	if !es.auStarted {
+		return
+	}
+
+	if len(es.au) > 0 {
+		m.dmx.Handle(&es.Stream, es.au, es.Unwrap.Unwrap(es.auDTS), pes.WrapDiff(es.auPTS, es.auDTS))
+	}
+
+	es.au = es.au[:0]
+	es.auStarted = false
+}
@@ -0,0 +1,257 @@
+package ps_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/codec/aacparser"
+	"github.com/vtpl1/avsdk/codec/h264parser"
+	"github.com/vtpl1/avsdk/codec/h265parser"
+	"github.com/vtpl1/avsdk/format/ps"
+	"github.com/vtpl1/avsdk/internal/avtest"
+)
+
+const (
+	videoID = 0xe0
+	audioID = 0xc0
+)
+
+func annexB(nalus ...[]byte) []byte {
+	var b []byte
+	for _, nalu := range nalus {
+		b = append(b, 0, 0, 0, 1)
+		b = append(b, nalu...)
+	}
+
+	return b
+}
+
+// psWriter builds program stream units for hand-crafted test input.
+type psWriter struct {
+	buf bytes.Buffer
+}
+
+func (w *psWriter) pack() {
+	w.buf.Write([]byte{0, 0, 1, 0xba, 0x44, 0, 0x04, 0, 0x04, 0x01, 0x01, 0x89, 0xc3, 0xfa, 0xff, 0xff})
+}
+
+// psm writes a program stream map of (stream_type, stream_id) pairs with a zero CRC.
+func (w *psWriter) psm(version byte, entries ...[2]byte) {
+	var es []byte
+	for _, e := range entries {
+		es = append(es, e[0], e[1], 0, 0)
+	}
+
+	body := []byte{0x80 | version, 0x01, 0, 0}
+	body = binary.BigEndian.AppendUint16(body, uint16(len(es)))
+	body = append(body, es...)
+	body = append(body, 0, 0, 0, 0)
+
+	w.buf.Write([]byte{0, 0, 1, 0xbc})
+	w.buf.Write(binary.BigEndian.AppendUint16(nil, uint16(len(body))))
+	w.buf.Write(body)
+}
+
+func timestamp(prefix byte, ts int64) []byte {
+	return []byte{prefix<<4 | byte(ts>>29)&0x0e | 1, byte(ts >> 22), byte(ts>>14) | 1, byte(ts >> 7), byte(ts<<1) | 1}
+}
+
+// pes writes a PES packet; pts < 0 omits the timestamps.
+func (w *psWriter) pes(id byte, pts, dts int64, payload []byte) {
+	hdr := []byte{0x80, 0, 0}
+
+	switch {
+	case pts < 0:
+	case dts == pts:
+		hdr[1] = 0x80
+		hdr = append(hdr, timestamp(0x2, pts)...)
+	default:
+		hdr[1] = 0xc0
+		hdr = append(hdr, timestamp(0x3, pts)...)
+		hdr = append(hdr, timestamp(0x1, dts)...)
+	}
+
+	hdr[2] = byte(len(hdr) - 3)
+
+	w.buf.Write([]byte{0, 0, 1, id})
+	w.buf.Write(binary.BigEndian.AppendUint16(nil, uint16(len(hdr)+len(payload))))
+	w.buf.Write(hdr)
+	w.buf.Write(payload)
+}
+
+func TestDemuxerH264G711(t *testing.T) {
+	ctx := context.Background()
+
+	var w psWriter
+
+	for i := range 3 {
+		pts := int64(3600 * i)
+
+		w.pack()
+
+		frame := annexB([]byte{0x41, 0x9a, byte(i)})
+		if i == 0 {
+			w.psm(0, [2]byte{ps.StreamTypeH264, videoID}, [2]byte{ps.StreamTypeG711A, audioID})
+			frame = annexB(avtest.Unhex(avtest.SPS320x192), avtest.Unhex(avtest.PPS), []byte{0x65, 0x88, 0x80, 0x40})
+		}
+
+		// The frame is split over two PES packets; only the first has a PTS.
+		half := len(frame) / 2
+		w.pes(videoID, pts+3600, pts, frame[:half])
+		w.pes(videoID, -1, -1, frame[half:])
+		w.pes(audioID, pts, pts, bytes.Repeat([]byte{0xd5}, 320))
+	}
+
+	w.buf.Write([]byte{0, 0, 1, 0xb9})
+
+	dmx := ps.NewDemuxer(bytes.NewReader(w.buf.Bytes()))
+
+	streams, err := dmx.GetCodecs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(streams) != 2 || streams[0].Idx != videoID || streams[1].Idx != audioID {
+		t.Fatalf("streams = %+v", streams)
+	}
+
+	if video, ok := streams[0].Codec.(h264parser.CodecData); !ok || video.Width() != 320 {
+		t.Fatalf("video codec = %+v", streams[0].Codec)
+	}
+
+	if streams[1].Codec.Type() != av.PCM_ALAW {
+		t.Fatalf("audio codec = %v", streams[1].Codec.Type())
+	}
+
+	pkts := avtest.ReadAll(t, dmx)
+
+	var video, audio []av.Packet
+
+	for _, pkt := range pkts {
+		if pkt.NewCodecs != nil {
+			t.Fatalf("unexpected NewCodecs on %v", pkt.String())
+		}
+
+		if pkt.Idx == videoID {
+			video = append(video, pkt)
+		} else {
+			audio = append(audio, pkt)
+		}
+	}
+
+	if len(video) != 3 || len(audio) != 3 {
+		t.Fatalf("got %d video and %d audio packets, want 3 and 3", len(video), len(audio))
+	}
+
+	for i, pkt := range video {
+		if pkt.DTS != time.Duration(i)*40*time.Millisecond || pkt.PTSOffset != 40*time.Millisecond || pkt.KeyFrame != (i == 0) {
+			t.Fatalf("video packet %d: %v", i, pkt.String())
+		}
+	}
+
+	if !video[0].IsParamSetNALU || !bytes.Equal(video[1].Data, []byte{0, 0, 0, 3, 0x41, 0x9a, 1}) {
+		t.Fatalf("video packets = %v, %v", video[0].String(), video[1].String())
+	}
+
+	if audio[2].DTS != 80*time.Millisecond || audio[2].Duration != 40*time.Millisecond || len(audio[2].Data) != 320 {
+		t.Fatalf("audio packet = %v", audio[2].String())
+	}
+}
+
+func TestDemuxerPSMChange(t *testing.T) {
+	ctx := context.Background()
+
+	var w psWriter
+
+	w.pack()
+	w.psm(0, [2]byte{ps.StreamTypeH264, videoID})
+	w.pes(videoID, 0, 0, annexB(avtest.Unhex(avtest.SPS320x192), avtest.Unhex(avtest.PPS), []byte{0x65, 0x88}))
+	w.pack()
+	w.pes(videoID, 3600, 3600, annexB([]byte{0x41, 0x9a}))
+
+	// The camera switches to H.265 and adds ADTS AAC at 48 kHz, after a
+	// frame with a reserved sampling frequency index, which is skipped.
+	adts := []byte{
+		0xff, 0xf1, 0x74, 0x80, 0x01, 0x3f, 0xfc, 0x21, 0x00,
+		0xff, 0xf1, 0x4c, 0x80, 0x01, 0x3f, 0xfc, 0x21, 0x00,
+	}
+
+	w.pack()
+
+	// A PSM whose es_info_length overruns the map is dropped without
+	// claiming its version.
+	w.buf.Write([]byte{0, 0, 1, 0xbc, 0, 14, 0x81, 0x01, 0, 0, 0, 4, ps.StreamTypeH265, videoID, 0, 8, 0, 0, 0, 0})
+
+	w.psm(1, [2]byte{ps.StreamTypeH265, videoID}, [2]byte{ps.StreamTypeADTSAAC, audioID})
+	w.pes(videoID, 7200, 7200, annexB(avtest.Unhex(avtest.H265VPS), avtest.Unhex(avtest.H265SPS), avtest.Unhex(avtest.H265PPS), []byte{0x26, 0x01, 0xaf}))
+	w.pes(audioID, 7200, 7200, adts)
+	w.pack()
+	w.pes(videoID, 10800, 10800, annexB([]byte{0x02, 0x01, 0xd0}))
+
+	dmx := ps.NewDemuxer(bytes.NewReader(w.buf.Bytes()))
+
+	streams, err := dmx.GetCodecs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(streams) != 1 || streams[0].Codec.Type() != av.H264 {
+		t.Fatalf("streams = %+v", streams)
+	}
+
+	pkts := avtest.ReadAll(t, dmx)
+	if len(pkts) != 5 {
+		t.Fatalf("got %d packets, want 5", len(pkts))
+	}
+
+	if pkts[0].NewCodecs != nil || pkts[1].NewCodecs != nil {
+		t.Fatal("NewCodecs set before the PSM change")
+	}
+
+	var h265, aac bool
+
+	for _, pkt := range pkts[2:] {
+		for _, stream := range pkt.NewCodecs {
+			switch codec := stream.Codec.(type) {
+			case h265parser.CodecData:
+				h265 = stream.Idx == videoID && pkt.KeyFrame && pkt.CodecType == av.H265
+			case aacparser.CodecData:
+				aac = stream.Idx == audioID && codec.SampleRate() == 48000
+			}
+		}
+	}
+
+	if !h265 || !aac {
+		t.Fatalf("NewCodecs after the PSM change: h265 = %v, aac = %v", h265, aac)
+	}
+
+	if last := pkts[4]; last.Idx != videoID || last.DTS != 120*time.Millisecond || last.NewCodecs != nil {
+		t.Fatalf("last packet = %v", last.String())
+	}
+}
+
+func TestDemuxerResync(t *testing.T) {
+	var w psWriter
+
+	w.buf.Write([]byte{0x12, 0x34, 0, 0, 1, 0x05, 0xff})
+	w.pack()
+	w.psm(0, [2]byte{ps.StreamTypeG711U, audioID})
+	w.pes(audioID, 0, 0, bytes.Repeat([]byte{0xff}, 160))
+	w.pes(0xbe, -1, -1, make([]byte, 16)) // padding stream
+
+	dmx := ps.NewDemuxer(bytes.NewReader(w.buf.Bytes()))
+
+	pkts := avtest.ReadAll(t, dmx)
+	if len(pkts) != 1 || pkts[0].CodecType != av.PCM_MULAW || pkts[0].Duration != 20*time.Millisecond {
+		t.Fatalf("packets = %+v", pkts)
+	}
+
+	if _, err := ps.NewDemuxer(bytes.NewReader(nil)).GetCodecs(context.Background()); !errors.Is(err, ps.ErrNoStreams) {
+		t.Fatalf("err = %v, want ErrNoStreams", err)
+	}
+}
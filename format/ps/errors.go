@@ -0,0 +1,8 @@
+package ps
+
+import "errors"
+
+var (
+	ErrStartCodeNotFound = errors.New("ps: start code not found")
+	ErrNoStreams         = errors.New("ps: no supported streams found")
+)
@@ -0,0 +1,37 @@
+// Package ps implements an MPEG-2 program stream (ISO/IEC 13818-1) demuxer,
+// as delivered by GB28181 cameras.
+package ps
+
+import "github.com/vtpl1/avsdk/av"
+
+// Start codes, following the 0x000001 prefix (ISO/IEC 13818-1 Table 2-18).
+const (
+	startCodeEnd          = 0xb9
+	startCodePack         = 0xba
+	startCodeSystemHeader = 0xbb
+	startCodePSM          = 0xbc
+	startCodePadding      = 0xbe
+	startCodePrivate2     = 0xbf
+	startCodeDirectory    = 0xff
+)
+
+// Elementary stream types carried in the program stream map. The G.711 types
+// are the GB28181 assignments.
+const (
+	StreamTypeADTSAAC = 0x0f
+	StreamTypeH264    = 0x1b
+	StreamTypeH265    = 0x24
+	StreamTypeG711A   = 0x90
+	StreamTypeG711U   = 0x91
+)
+
+// codecTypes maps the supported stream types to the codec of their payload.
+//
+//nolint:gochecknoglobals
+var codecTypes = map[uint8]av.CodecType{
+	StreamTypeADTSAAC: av.AAC,
+	StreamTypeH264:    av.H264,
+	StreamTypeH265:    av.H265,
+	StreamTypeG711A:   av.PCM_ALAW,
+	StreamTypeG711U:   av.PCM_MULAW,
+}
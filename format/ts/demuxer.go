@@ -0,0 +1,339 @@
+package ts
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/format/internal/pes"
+	"github.com/vtpl1/avsdk/utils/bits/pio"
+)
+
+// maxProbePackets bounds how many TS packets GetCodecs reads while waiting for
+// parameter sets of every stream announced in the PMT.
+const maxProbePackets = 20000
+
+type elementaryStream struct {
+	pes.Stream
+
+	streamType uint8
+	buf        []byte // PES packet being assembled
+	cc         int
+}
+
+// Demuxer reads H.264, H.265 and ADTS AAC from an MPEG-TS byte stream.
+// Stream.Idx and Packet.Idx are the elementary stream PIDs.
+type Demuxer struct {
+	dmx        pes.Demuxer
+	r          *bufio.Reader
+	pmtPID     int
+	pmtVersion int
+	pat        psiBuffer
+	pmt        psiBuffer
+	streams    map[uint16]*elementaryStream
+	order      []uint16
+	buf        [PacketSize]byte
+}
+
+// NewDemuxer returns a Demuxer reading from r.
+func NewDemuxer(r io.Reader) *Demuxer {
+	m := &Demuxer{
+		r:          bufio.NewReaderSize(r, pio.RecommendBufioSize),
+		pmtPID:     -1,
+		pmtVersion: -1,
+		pat:        psiBuffer{cc: -1},
+		pmt:        psiBuffer{cc: -1},
+		streams:    make(map[uint16]*elementaryStream),
+	}
+	m.dmx.ReadUnit = m.readTSPacket
+	m.dmx.MaxProbeUnits = maxProbePackets
+
+	return m
+}
+
+// GetCodecs implements av.Demuxer. It reads until every PMT stream has
+// produced its codec configuration; packets read meanwhile are queued.
+func (m *Demuxer) GetCodecs(ctx context.Context) ([]av.Stream, error) {
+	streams, err := m.dmx.GetCodecs(ctx)
+	if err == nil && len(streams) == 0 {
+		return nil, ErrNoStreams
+	}
+
+	return streams, err
+}
+
+// ReadPacket implements av.Demuxer.
+func (m *Demuxer) ReadPacket(ctx context.Context) (av.Packet, error) {
+	return m.dmx.ReadPacket(ctx)
+}
+
+// readTSPacket consumes one 188-byte packet. At end of input it flushes every
+// partially assembled PES and returns io.EOF.
+func (m *Demuxer) readTSPacket() error {
+	b := m.buf[:]
+
+	if _, err := io.ReadFull(m.r, b[:1]); err != nil {
+		return m.finish(err)
+	}
+
+	for skipped := 0; b[0] != syncByte; skipped++ {
+		if skipped > 10*PacketSize {
+			return ErrSyncByteNotFound
+		}
+
+		if _, err := io.ReadFull(m.r, b[:1]); err != nil {
+			return m.finish(err)
+		}
+	}
+
+	if _, err := io.ReadFull(m.r, b[1:]); err != nil {
+		return m.finish(err)
+	}
+
+	pusi := b[1]&0x40 != 0
+	pid := uint16(b[1]&0x1f)<<8 | uint16(b[2])
+	afc := b[3] >> 4 & 0x03
+	cc := int(b[3] & 0x0f)
+
+	payload := b[4:]
+
+	if afc&0x02 != 0 {
+		afLen := int(payload[0])
+		if afLen+1 > len(payload) {
+			return nil
+		}
+
+		payload = payload[1+afLen:]
+	}
+
+	if afc&0x01 == 0 {
+		return nil
+	}
+
+	switch {
+	case pid == pidPAT:
+		m.pat.push(payload, pusi, cc, m.handlePAT)
+
+		return nil
+	case int(pid) == m.pmtPID:
+		m.pmt.push(payload, pusi, cc, m.handlePMT)
+
+		return nil
+	}
+
+	es, ok := m.streams[pid]
+	if !ok {
+		return nil
+	}
+
+	if cc == es.cc {
+		return nil // duplicate packet
+	}
+
+	if es.cc >= 0 && cc != (es.cc+1)&0x0f {
+		es.buf = es.buf[:0] // lost data; drop the partial PES
+		if !pusi {
+			es.cc = cc
+
+			return nil
+		}
+	}
+
+	es.cc = cc
+
+	if pusi {
+		if len(es.buf) > 0 {
+			m.handlePES(es)
+		}
+
+		es.buf = append(es.buf[:0], payload...)
+	} else if len(es.buf) > 0 {
+		es.buf = append(es.buf, payload...)
+	}
+
+	return nil
+}
+
+func (m *Demuxer) finish(err error) error {
+	if !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+
+	for _, pid := range m.order {
+		if es := m.streams[pid]; len(es.buf) > 0 {
+			m.handlePES(es)
+			es.buf = es.buf[:0]
+		}
+	}
+
+	return io.EOF
+}
+
+// maxSectionLength is the largest section_length of a PAT or PMT
+// (ISO/IEC 13818-1 §2.4.4.10).
+const maxSectionLength = 1021
+
+// psiBuffer assembles the PSI sections of one PID across TS packets.
+type psiBuffer struct {
+	buf []byte
+	cc  int
+}
+
+// push adds the payload of a TS packet and calls handle with every complete
+// section whose CRC matches. A section that loses a packet is dropped.
+func (p *psiBuffer) push(payload []byte, pusi bool, cc int, handle func(section []byte)) {
+	if cc == p.cc {
+		return // duplicate packet
+	}
+
+	if p.cc >= 0 && cc != (p.cc+1)&0x0f {
+		p.buf = p.buf[:0]
+	}
+
+	p.cc = cc
+
+	if !pusi {
+		if len(p.buf) > 0 {
+			p.buf = append(p.buf, payload...)
+			p.flush(handle)
+		}
+
+		return
+	}
+
+	pointer := int(payload[0])
+	if 1+pointer > len(payload) {
+		p.buf = p.buf[:0]
+
+		return
+	}
+
+	// The bytes before the pointed-to section end the previous one.
+	if len(p.buf) > 0 {
+		p.buf = append(p.buf, payload[1:1+pointer]...)
+		p.flush(handle)
+	}
+
+	p.buf = append(p.buf[:0], payload[1+pointer:]...)
+	p.flush(handle)
+}
+
+// flush hands the complete sections at the start of p.buf to handle and
+// keeps a partial one. The 0xff stuffing after the last section is dropped.
+func (p *psiBuffer) flush(handle func(section []byte)) {
+	for len(p.buf) >= 3 && p.buf[0] != 0xff {
+		length := int(pio.U16BE(p.buf[1:]) & 0x0fff)
+		if length > maxSectionLength {
+			p.buf = p.buf[:0]
+
+			return
+		}
+
+		if 3+length > len(p.buf) {
+			return
+		}
+
+		section := p.buf[:3+length]
+		if length >= 9 && crc32MPEG(section[:len(section)-4]) == pio.U32BE(section[len(section)-4:]) {
+			handle(section)
+		}
+
+		p.buf = p.buf[3+length:]
+	}
+
+	if len(p.buf) > 0 && p.buf[0] == 0xff {
+		p.buf = p.buf[:0]
+	}
+}
+
+func (m *Demuxer) handlePAT(section []byte) {
+	if section[0] != tableIDPAT {
+		return
+	}
+
+	entries := section[8 : len(section)-4]
+	for len(entries) >= 4 {
+		program := pio.U16BE(entries)
+		pid := int(pio.U16BE(entries[2:]) & 0x1fff)
+
+		if program != 0 { // program 0 is the network PID
+			if pid != m.pmtPID {
+				m.pmtPID = pid
+				m.pmt = psiBuffer{cc: -1}
+			}
+
+			break
+		}
+
+		entries = entries[4:]
+	}
+}
+
+// handlePMT replaces the program with the streams of a new PMT version. A
+// malformed PMT leaves the current program in place.
+func (m *Demuxer) handlePMT(section []byte) {
+	if section[0] != tableIDPMT || len(section) < 16 {
+		return
+	}
+
+	version := int(section[5] >> 1 & 0x1f)
+	if version == m.pmtVersion {
+		return
+	}
+
+	infoLen := int(pio.U16BE(section[10:]) & 0x0fff)
+	if 12+infoLen > len(section)-4 {
+		return
+	}
+
+	entries := section[12+infoLen : len(section)-4]
+	streams := make(map[uint16]*elementaryStream)
+	order := make([]uint16, 0, len(m.order))
+	program := make([]*pes.Stream, 0, len(m.dmx.Program))
+
+	for len(entries) >= 5 {
+		streamType := entries[0]
+		pid := pio.U16BE(entries[1:]) & 0x1fff
+		esInfoLen := int(pio.U16BE(entries[3:]) & 0x0fff)
+
+		if 5+esInfoLen > len(entries) {
+			return
+		}
+
+		entries = entries[5+esInfoLen:]
+
+		codecType, ok := codecTypes[streamType]
+		if !ok {
+			continue
+		}
+
+		es, ok := m.streams[pid]
+		if !ok || es.streamType != streamType {
+			// New or retyped stream: its codec is re-derived from the
+			// elementary stream and reported through Packet.NewCodecs.
+			es = &elementaryStream{Stream: pes.Stream{Idx: pid, CodecType: codecType}, streamType: streamType, cc: -1}
+		}
+
+		streams[pid] = es
+		order = append(order, pid)
+		program = append(program, &es.Stream)
+	}
+
+	m.pmtVersion = version
+	m.streams = streams
+	m.order = order
+	m.dmx.Program, m.dmx.HasProgram = program, true
+}
+
+// handlePES parses one complete PES packet and queues the resulting av.Packets.
+// Packets without a PTS or with a malformed header are dropped.
+func (m *Demuxer) handlePES(es *elementaryStream) {
+	hdr, data, ok := pes.ParsePacket(es.buf)
+	if !ok || !hdr.HasPTS {
+		return
+	}
+
+	m.dmx.Handle(&es.Stream, data, es.Unwrap.Unwrap(hdr.DTS), pes.WrapDiff(hdr.PTS, hdr.DTS))
+}
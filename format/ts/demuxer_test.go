@@ -0,0 +1,355 @@
+package ts_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/codec/aacparser"
+	"github.com/vtpl1/avsdk/codec/h264parser"
+	"github.com/vtpl1/avsdk/format/ts"
+	"github.com/vtpl1/avsdk/internal/avtest"
+)
+
+const (
+	pmtPID   = 0x1000
+	videoPID = 0x100
+	audioPID = 0x101
+)
+
+func mpegCRC(b []byte) uint32 {
+	crc := uint32(0xffffffff)
+
+	for _, v := range b {
+		crc ^= uint32(v) << 24
+		for range 8 {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+
+	return crc
+}
+
+// tsWriter builds transport stream packets for hand-crafted test input.
+type tsWriter struct {
+	buf bytes.Buffer
+	cc  map[uint16]byte
+}
+
+func (w *tsWriter) write(pid uint16, payload []byte) {
+	first := true
+
+	for len(payload) > 0 {
+		pkt := make([]byte, ts.PacketSize)
+		pkt[0] = 0x47
+		pkt[1] = byte(pid >> 8)
+		pkt[2] = byte(pid)
+
+		if first {
+			pkt[1] |= 0x40
+		}
+
+		first = false
+		pkt[3] = 0x10 | w.cc[pid]&0x0f
+		w.cc[pid]++
+
+		n := copy(pkt[4:], payload)
+		if n < len(payload) || n == ts.PacketSize-4 {
+			payload = payload[n:]
+			w.buf.Write(pkt)
+
+			continue
+		}
+
+		// Last packet: move the payload behind adaptation field stuffing.
+		stuff := ts.PacketSize - 4 - len(payload)
+		pkt[3] |= 0x20
+		pkt[4] = byte(stuff - 1)
+
+		if stuff > 1 {
+			pkt[5] = 0
+			for i := 6; i < 4+stuff; i++ {
+				pkt[i] = 0xff
+			}
+		}
+
+		copy(pkt[4+stuff:], payload)
+		w.buf.Write(pkt)
+
+		payload = nil
+	}
+}
+
+// psiSection returns a PSI section with its CRC.
+func psiSection(tableID byte, ext uint16, version byte, body []byte) []byte {
+	s := []byte{tableID, 0xb0, 0, byte(ext >> 8), byte(ext), 0xc1 | version<<1, 0, 0}
+	s = append(s, body...)
+	binary.BigEndian.PutUint16(s[1:], 0xb000|uint16(len(s)+4-3))
+
+	return binary.BigEndian.AppendUint32(s, mpegCRC(s))
+}
+
+func (w *tsWriter) section(pid uint16, tableID byte, ext uint16, version byte, body []byte) {
+	s := append([]byte{0}, psiSection(tableID, ext, version, body)...) // pointer field
+
+	// PSI sections are padded with 0xff rather than adaptation stuffing.
+	pkt := make([]byte, ts.PacketSize-4)
+	copy(pkt, s)
+
+	for i := len(s); i < len(pkt); i++ {
+		pkt[i] = 0xff
+	}
+
+	w.write(pid, pkt)
+}
+
+func (w *tsWriter) pat() {
+	w.section(0, 0x00, 1, 0, []byte{0, 1, 0xe0 | pmtPID>>8, pmtPID & 0xff})
+}
+
+func (w *tsWriter) pmt(version byte, withAudio bool) {
+	body := []byte{0xe0 | videoPID>>8, videoPID & 0xff, 0xf0, 0}
+	body = append(body, ts.StreamTypeH264, 0xe0|videoPID>>8, videoPID&0xff, 0xf0, 0)
+
+	if withAudio {
+		body = append(body, ts.StreamTypeADTSAAC, 0xe0|audioPID>>8, audioPID&0xff, 0xf0, 0)
+	}
+
+	w.section(pmtPID, 0x02, 1, version, body)
+}
+
+func timestamp(prefix byte, t int64) []byte {
+	t &= 1<<33 - 1
+
+	return []byte{
+		prefix<<4 | byte(t>>29)&0x0e | 1,
+		byte(t >> 22),
+		byte(t>>14) | 1,
+		byte(t >> 7),
+		byte(t<<1) | 1,
+	}
+}
+
+func (w *tsWriter) pes(pid uint16, streamID byte, pts, dts int64, data []byte) {
+	hdr := []byte{0, 0, 1, streamID, 0, 0, 0x80, 0xc0, 10}
+	hdr = append(hdr, timestamp(3, pts)...)
+	hdr = append(hdr, timestamp(1, dts)...)
+
+	if pid == audioPID {
+		binary.BigEndian.PutUint16(hdr[4:], uint16(len(hdr)-6+len(data)))
+	}
+
+	w.write(pid, append(hdr, data...))
+}
+
+func TestDemuxer(t *testing.T) {
+	ctx := context.Background()
+	sps := avtest.Unhex(avtest.SPS320x192)
+	pps := avtest.Unhex(avtest.PPS)
+	startCode := []byte{0, 0, 0, 1}
+
+	w := &tsWriter{cc: make(map[uint16]byte)}
+	w.pat()
+	w.pmt(0, false)
+
+	// Start close to the 33-bit limit so the timeline wraps at frame 2.
+	base := int64(1<<33 - 6000)
+
+	for i := range 4 {
+		var au []byte
+
+		au = append(au, startCode...)
+		au = append(au, 0x09, 0xf0) // AUD, dropped by the demuxer
+
+		if i == 0 {
+			au = append(append(au, startCode...), sps...)
+			au = append(append(au, startCode...), pps...)
+			au = append(append(au, startCode...), 0x65, 0x88, byte(i))
+		} else {
+			au = append(append(au, startCode...), 0x41, 0x9a, byte(i))
+		}
+
+		// Pad the first access unit over several TS packets.
+		if i == 0 {
+			au = append(au, bytes.Repeat([]byte{0xab}, 400)...)
+		}
+
+		dts := base + int64(i)*3000
+		w.pes(videoPID, 0xe0, dts+6000, dts, au)
+
+		if i == 1 {
+			w.pmt(1, true)
+
+			config := aacparser.MPEG4AudioConfig{ObjectType: 2, SampleRateIndex: 4, ChannelConfig: 2}
+
+			// A frame with a reserved sampling frequency index is skipped.
+			reserved := make([]byte, 7, 9)
+			aacparser.FillADTSHeader(reserved, aacparser.MPEG4AudioConfig{ObjectType: 2, SampleRateIndex: 13, ChannelConfig: 2}, 1024, 2)
+			frames := append(reserved, 0x21, 0xff)
+
+			for j := range 2 {
+				frame := make([]byte, 7, 9)
+				aacparser.FillADTSHeader(frame, config, 1024, 2)
+				frames = append(frames, append(frame, 0x21, byte(j))...)
+			}
+
+			w.pes(audioPID, 0xc0, base+3000, base+3000, frames)
+		}
+	}
+
+	dmx := ts.NewDemuxer(bytes.NewReader(w.buf.Bytes()))
+
+	streams, err := dmx.GetCodecs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(streams) != 1 || streams[0].Idx != videoPID || streams[0].Codec.Type() != av.H264 {
+		t.Fatalf("streams = %+v", streams)
+	}
+
+	if width := streams[0].Codec.(h264parser.CodecData).Width(); width != 320 { //nolint:forcetypeassert
+		t.Fatalf("width = %d, want 320", width)
+	}
+
+	var video, audio []av.Packet
+
+	for {
+		pkt, err := dmx.ReadPacket(ctx)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if pkt.Idx == audioPID {
+			audio = append(audio, pkt)
+		} else {
+			video = append(video, pkt)
+		}
+	}
+
+	if len(video) != 4 || len(audio) != 2 {
+		t.Fatalf("got %d video and %d audio packets", len(video), len(audio))
+	}
+
+	start := time.Duration(base) * time.Second / 90000
+
+	for i, pkt := range video {
+		want := start + time.Duration(i)*time.Second/30
+		if pkt.DTS != want || pkt.PTSOffset != time.Second/15 {
+			t.Fatalf("video %d: dts=%v ptsOffset=%v, want dts=%v", i, pkt.DTS, pkt.PTSOffset, want)
+		}
+
+		if pkt.KeyFrame != (i == 0) || pkt.IsParamSetNALU != (i == 0) || pkt.NewCodecs != nil {
+			t.Fatalf("video %d: unexpected packet %v", i, pkt.String())
+		}
+	}
+
+	nalus := video[0].Data
+	if n := binary.BigEndian.Uint32(nalus); int(n) != len(sps) || !bytes.Equal(nalus[4:4+n], sps) {
+		t.Fatalf("first NALU = %x, want SPS in AVCC framing", nalus[:4+n])
+	}
+
+	if want := []byte{0, 0, 0, 3, 0x41, 0x9a, 3}; !bytes.Equal(video[3].Data, want) {
+		t.Fatalf("video 3 data = %x, want %x", video[3].Data, want)
+	}
+
+	if len(audio[0].NewCodecs) != 1 || audio[0].NewCodecs[0].Idx != audioPID || audio[1].NewCodecs != nil {
+		t.Fatalf("audio NewCodecs = %+v, %+v", audio[0].NewCodecs, audio[1].NewCodecs)
+	}
+
+	if rate := audio[0].NewCodecs[0].Codec.(aacparser.CodecData).SampleRate(); rate != 44100 { //nolint:forcetypeassert
+		t.Fatalf("sample rate = %d, want 44100", rate)
+	}
+
+	if !bytes.Equal(audio[1].Data, []byte{0x21, 1}) || audio[1].DTS-audio[0].DTS != audio[0].Duration {
+		t.Fatalf("audio packets: %v, %v", audio[0].String(), audio[1].String())
+	}
+
+	// The parameter sets of the codec must not alias reused PES buffers.
+	codec := streams[0].Codec.(h264parser.CodecData) //nolint:forcetypeassert
+	if !bytes.Equal(codec.SPS(), sps) || !bytes.Equal(codec.PPS(), pps) {
+		t.Fatalf("codec parameter sets changed: SPS %x, PPS %x", codec.SPS(), codec.PPS())
+	}
+}
+
+func TestDemuxerPESLengthShorterThanHeader(t *testing.T) {
+	startCode := []byte{0, 0, 0, 1}
+
+	var key []byte
+
+	key = append(append(key, startCode...), avtest.Unhex(avtest.SPS320x192)...)
+	key = append(append(key, startCode...), avtest.Unhex(avtest.PPS)...)
+	key = append(append(key, startCode...), 0x65, 0x88, 0)
+
+	w := &tsWriter{cc: make(map[uint16]byte)}
+	w.pat()
+	w.pmt(0, false)
+	w.pes(videoPID, 0xe0, 3000, 3000, key)
+
+	// PES_packet_length 3 covers the flags but not the 10-byte header.
+	bad := []byte{0, 0, 1, 0xe0, 0, 3, 0x80, 0xc0, 10}
+	bad = append(bad, timestamp(3, 6000)...)
+	bad = append(bad, timestamp(1, 6000)...)
+	w.write(videoPID, append(bad, 0, 0, 0, 1, 0x41, 0x9a, 1))
+
+	w.pes(videoPID, 0xe0, 9000, 9000, []byte{0, 0, 0, 1, 0x41, 0x9a, 2})
+
+	pkts := avtest.ReadAll(t, ts.NewDemuxer(bytes.NewReader(w.buf.Bytes())))
+	if len(pkts) != 2 {
+		t.Fatalf("got %d packets, want 2", len(pkts))
+	}
+
+	if want := 100 * time.Millisecond; pkts[1].DTS != want {
+		t.Fatalf("second packet DTS = %v, want %v", pkts[1].DTS, want)
+	}
+}
+
+func TestDemuxerPSISections(t *testing.T) {
+	var key []byte
+
+	key = append(append(key, 0, 0, 0, 1), avtest.Unhex(avtest.SPS320x192)...)
+	key = append(append(key, 0, 0, 0, 1), avtest.Unhex(avtest.PPS)...)
+	key = append(append(key, 0, 0, 0, 1), 0x65, 0x88, 0)
+
+	w := &tsWriter{cc: make(map[uint16]byte)}
+	w.pat()
+
+	// A PMT whose CRC does not match is skipped.
+	bad := psiSection(0x02, 1, 1, []byte{0xe0 | videoPID>>8, videoPID & 0xff, 0xf0, 0})
+	bad[len(bad)-1] ^= 0xff
+	w.write(pmtPID, append([]byte{0}, bad...))
+
+	// A PMT with a long program descriptor loop spans two TS packets.
+	descriptors := make([]byte, 250)
+	descriptors[0], descriptors[1] = 0x05, byte(len(descriptors)-2) // registration descriptor
+
+	body := []byte{0xe0 | videoPID>>8, videoPID & 0xff, 0xf0 | byte(len(descriptors)>>8), byte(len(descriptors))}
+	body = append(body, descriptors...)
+	body = append(body, ts.StreamTypeH264, 0xe0|videoPID>>8, videoPID&0xff, 0xf0, 0)
+	w.write(pmtPID, append([]byte{0}, psiSection(0x02, 1, 0, body)...))
+
+	w.pes(videoPID, 0xe0, 3000, 3000, key)
+	w.pes(videoPID, 0xe0, 6000, 6000, []byte{0, 0, 0, 1, 0x41, 0x9a, 1})
+
+	pkts := avtest.ReadAll(t, ts.NewDemuxer(bytes.NewReader(w.buf.Bytes())))
+	if len(pkts) != 2 {
+		t.Fatalf("got %d packets, want 2", len(pkts))
+	}
+
+	if !pkts[0].KeyFrame {
+		t.Fatal("first packet is not a key frame")
+	}
+}
@@ -0,0 +1,15 @@
+package ts
+
+import "errors"
+
+var (
+	ErrSyncByteNotFound      = errors.New("ts: sync byte not found")
+	ErrNoStreams             = errors.New("ts: no supported streams found")
+	ErrHeaderNotWritten      = errors.New("ts: WriteHeader not called")
+	ErrHeaderAlreadyWritten  = errors.New("ts: WriteHeader already called")
+	ErrTrailerAlreadyWritten = errors.New("ts: WriteTrailer already called")
+	ErrUnsupportedCodec      = errors.New("ts: unsupported codec")
+	ErrStreamNotFound        = errors.New("ts: stream not found")
+	ErrDuplicateStream       = errors.New("ts: duplicate stream index")
+	ErrInvalidPID            = errors.New("ts: reserved or duplicate PID")
+)
@@ -0,0 +1,472 @@
+package ts
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/codec/aacparser"
+	"github.com/vtpl1/avsdk/codec/h264parser"
+	"github.com/vtpl1/avsdk/codec/h265parser"
+	"github.com/vtpl1/avsdk/codec/parser"
+	"github.com/vtpl1/avsdk/internal/ticks"
+)
+
+// Default PIDs. Elementary streams are numbered from DefaultFirstStreamPID in
+// the order given to WriteHeader.
+const (
+	DefaultPMTPID         = 0x1000
+	DefaultFirstStreamPID = 0x0100
+)
+
+const (
+	programNumber     = 1
+	transportStreamID = 1
+
+	streamIDVideo = 0xe0
+	streamIDAudio = 0xc0
+
+	// pcrDelay keeps the PCR ahead of the DTS it announces so decoders have time to buffer.
+	pcrDelay = 100 * time.Millisecond
+	// psiInterval bounds the gap between PAT/PMT repetitions when no keyframe
+	// on the PCR stream triggers one.
+	psiInterval = 500 * time.Millisecond
+)
+
+//nolint:gochecknoglobals
+var (
+	h264AUD = []byte{0, 0, 0, 1, 0x09, 0xf0}
+	h265AUD = []byte{0, 0, 0, 1, 0x46, 0x01, 0x50}
+)
+
+type muxStream struct {
+	pid        uint16
+	streamType uint8
+	stream     av.Stream
+	cc         byte
+}
+
+// Option configures a Muxer.
+type Option func(*Muxer)
+
+// WithPMTPID sets the PID carrying the program map table.
+func WithPMTPID(pid uint16) Option {
+	return func(m *Muxer) {
+		m.pmtPID = pid
+	}
+}
+
+// WithStreamPID sets the PID of the stream with the given Stream.Idx. PIDs
+// must be in 0x0010-0x1ffe and differ from each other and from the PMT PID;
+// WriteHeader returns ErrInvalidPID otherwise.
+func WithStreamPID(idx, pid uint16) Option {
+	return func(m *Muxer) {
+		m.pidByIdx[idx] = pid
+	}
+}
+
+// Muxer writes H.264, H.265 and AAC as an MPEG-TS single program stream.
+// It implements av.MuxCloser and av.CodecChanger.
+type Muxer struct {
+	w          io.Writer
+	pmtPID     uint16
+	pidByIdx   map[uint16]uint16
+	streams    []*muxStream
+	streamIdx  map[uint16]*muxStream
+	pcr        *muxStream
+	patCC      byte
+	pmtCC      byte
+	pmtVersion byte
+	lastPSI    time.Duration
+	psiPending bool
+	buf        []byte
+	stage      int
+}
+
+// NewMuxer returns a Muxer writing to w. Close closes w if it is an io.Closer.
+func NewMuxer(w io.Writer, opts ...Option) *Muxer {
+	m := &Muxer{
+		w:         w,
+		pmtPID:    DefaultPMTPID,
+		pidByIdx:  make(map[uint16]uint16),
+		streamIdx: make(map[uint16]*muxStream),
+	}
+	for _, o := range opts {
+		o(m)
+	}
+
+	return m
+}
+
+// WriteHeader implements av.Muxer. It writes the PAT and PMT.
+func (m *Muxer) WriteHeader(_ context.Context, streams []av.Stream) error {
+	if m.stage != 0 {
+		return ErrHeaderAlreadyWritten
+	}
+
+	if len(streams) == 0 {
+		return ErrNoStreams
+	}
+
+	if !validPID(m.pmtPID) {
+		return ErrInvalidPID
+	}
+
+	used := map[uint16]bool{m.pmtPID: true}
+
+	for i, stream := range streams {
+		if _, ok := m.streamIdx[stream.Idx]; ok {
+			return ErrDuplicateStream
+		}
+
+		pid, ok := m.pidByIdx[stream.Idx]
+		if !ok {
+			pid = DefaultFirstStreamPID + uint16(i)
+		}
+
+		if !validPID(pid) || used[pid] {
+			return ErrInvalidPID
+		}
+
+		used[pid] = true
+
+		s := &muxStream{pid: pid}
+		if err := s.setCodec(stream); err != nil {
+			return err
+		}
+
+		if m.pcr == nil && stream.Codec.Type().IsVideo() {
+			m.pcr = s
+		}
+
+		m.streams = append(m.streams, s)
+		m.streamIdx[stream.Idx] = s
+	}
+
+	if m.pcr == nil {
+		m.pcr = m.streams[0]
+	}
+
+	m.psiPending = true
+	m.stage++
+
+	return nil
+}
+
+// WritePacket implements av.Muxer. Each packet becomes one PES packet; the PAT
+// and PMT are repeated before every keyframe on the PCR stream.
+func (m *Muxer) WritePacket(_ context.Context, pkt av.Packet) error {
+	if m.stage != 1 {
+		return ErrHeaderNotWritten
+	}
+
+	s, ok := m.streamIdx[pkt.Idx]
+	if !ok {
+		return ErrStreamNotFound
+	}
+
+	m.buf = m.buf[:0]
+
+	if m.psiPending || (s == m.pcr && (pkt.KeyFrame || pkt.DTS-m.lastPSI >= psiInterval)) {
+		m.appendPSI()
+		m.lastPSI = pkt.DTS
+		m.psiPending = false
+	}
+
+	payload, err := s.payload(pkt)
+	if err != nil {
+		return err
+	}
+
+	dts := ticks.FromDuration(pkt.DTS, clockRate)
+	pts := dts + ticks.FromDuration(pkt.PTSOffset, clockRate)
+
+	streamID := byte(streamIDAudio)
+	if s.stream.Codec.Type().IsVideo() {
+		streamID = streamIDVideo
+	}
+
+	pes := make([]byte, 0, 19+len(payload))
+	pes = append(pes, 0, 0, 1, streamID, 0, 0, 0x80)
+
+	if pts != dts {
+		pes = append(pes, 0xc0, 10, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0)
+		writeTimestamp(pes[9:], 0x03, pts)
+		writeTimestamp(pes[14:], 0x01, dts)
+	} else {
+		pes = append(pes, 0x80, 5, 0, 0, 0, 0, 0)
+		writeTimestamp(pes[9:], 0x02, pts)
+	}
+
+	// PES_packet_length 0 (unbounded) is only allowed for video.
+	if n := len(pes) - 6 + len(payload); n <= 0xffff {
+		pes[4], pes[5] = byte(n>>8), byte(n)
+	}
+
+	pes = append(pes, payload...)
+
+	var pcr int64 = -1
+	if s == m.pcr {
+		pcr = ticks.FromDuration(max(pkt.DTS-pcrDelay, 0), clockRate)
+	}
+
+	m.appendPES(s, pes, pcr, pkt.KeyFrame, pkt.IsDiscontinuity)
+
+	_, err = m.w.Write(m.buf)
+
+	return err
+}
+
+// WriteTrailer implements av.Muxer. Transport streams have no trailer; it only
+// finalizes the muxer state.
+func (m *Muxer) WriteTrailer(_ context.Context) error {
+	switch m.stage {
+	case 0:
+		return ErrHeaderNotWritten
+	case 2:
+		return ErrTrailerAlreadyWritten
+	}
+
+	m.stage++
+
+	return nil
+}
+
+// WriteCodecChange implements av.CodecChanger. The PMT version is incremented
+// and the new PAT/PMT is written before the next packet.
+func (m *Muxer) WriteCodecChange(_ context.Context, changed []av.Stream) error {
+	if m.stage != 1 {
+		return ErrHeaderNotWritten
+	}
+
+	for _, stream := range changed {
+		s, ok := m.streamIdx[stream.Idx]
+		if !ok {
+			return ErrStreamNotFound
+		}
+
+		if err := s.setCodec(stream); err != nil {
+			return err
+		}
+	}
+
+	m.pmtVersion = (m.pmtVersion + 1) & 0x1f
+	m.psiPending = true
+
+	return nil
+}
+
+// RepeatPSI makes the next packet start with a PAT and PMT. Packagers call it
+// before the first packet of each segment so segments can be decoded on their own.
+func (m *Muxer) RepeatPSI() {
+	m.psiPending = true
+}
+
+// Close implements av.MuxCloser.
+func (m *Muxer) Close() error {
+	if c, ok := m.w.(io.Closer); ok {
+		return c.Close()
+	}
+
+	return nil
+}
+
+// validPID reports whether pid may carry a PMT or an elementary stream.
+func validPID(pid uint16) bool {
+	return pid >= pidFirstFree && pid < pidNull
+}
+
+func (s *muxStream) setCodec(stream av.Stream) error {
+	switch stream.Codec.(type) {
+	case h264parser.CodecData:
+		s.streamType = StreamTypeH264
+	case h265parser.CodecData:
+		s.streamType = StreamTypeH265
+	case aacparser.CodecData:
+		s.streamType = StreamTypeADTSAAC
+	default:
+		return ErrUnsupportedCodec
+	}
+
+	s.stream = stream
+
+	return nil
+}
+
+// payload returns the elementary stream bytes of pkt: Annex-B with an access
+// unit delimiter for video (parameter sets prepended to keyframes), ADTS for AAC.
+func (s *muxStream) payload(pkt av.Packet) ([]byte, error) {
+	switch codec := s.stream.Codec.(type) {
+	case aacparser.CodecData:
+		if len(pkt.Data) >= aacparser.ADTSHeaderLength {
+			if _, _, n, _, err := aacparser.ParseADTSHeader(pkt.Data); err == nil && n == len(pkt.Data) {
+				return pkt.Data, nil // already ADTS framed
+			}
+		}
+
+		b := make([]byte, aacparser.ADTSHeaderLength+len(pkt.Data))
+		aacparser.FillADTSHeader(b, codec.Config, 1024, len(pkt.Data))
+		copy(b[aacparser.ADTSHeaderLength:], pkt.Data)
+
+		return b, nil
+	case h264parser.CodecData:
+		return videoPayload(pkt, h264AUD, [][]byte{codec.SPS(), codec.PPS()}, h264parser.IsSPSNALU)
+	case h265parser.CodecData:
+		return videoPayload(pkt, h265AUD, [][]byte{codec.VPS(), codec.SPS(), codec.PPS()}, h265parser.IsSPSNALU)
+	}
+
+	return nil, ErrUnsupportedCodec
+}
+
+func videoPayload(pkt av.Packet, aud []byte, paramSets [][]byte, isSPS func([]byte) bool) ([]byte, error) {
+	data := pkt.Data
+	if parser.IsAnnexBOrAVCC(data) == parser.NALUAvcc {
+		var err error
+		if data, err = parser.AVCCToAnnexB(data); err != nil {
+			return nil, err
+		}
+	}
+
+	out := append([]byte(nil), aud...)
+
+	if pkt.KeyFrame {
+		hasSPS := false
+
+		nalus, _ := parser.SplitNALUs(data)
+		for _, nalu := range nalus {
+			if isSPS(nalu) {
+				hasSPS = true
+
+				break
+			}
+		}
+
+		if !hasSPS {
+			for _, ps := range paramSets {
+				out = append(out, parser.StartCode4...)
+				out = append(out, ps...)
+			}
+		}
+	}
+
+	return append(out, data...), nil
+}
+
+func (m *Muxer) appendPSI() {
+	pat := []byte{
+		tableIDPAT, 0, 0, transportStreamID >> 8, transportStreamID & 0xff, 0xc1, 0, 0,
+		programNumber >> 8, programNumber & 0xff, 0xe0 | byte(m.pmtPID>>8), byte(m.pmtPID),
+	}
+	m.appendSection(pidPAT, &m.patCC, pat)
+
+	pmt := []byte{
+		tableIDPMT, 0, 0, programNumber >> 8, programNumber & 0xff, 0xc1 | m.pmtVersion<<1, 0, 0,
+		0xe0 | byte(m.pcr.pid>>8), byte(m.pcr.pid), 0xf0, 0,
+	}
+	for _, s := range m.streams {
+		pmt = append(pmt, s.streamType, 0xe0|byte(s.pid>>8), byte(s.pid), 0xf0, 0)
+	}
+
+	m.appendSection(m.pmtPID, &m.pmtCC, pmt)
+}
+
+// appendSection completes a PSI section (length and CRC) and writes it in one
+// TS packet padded with 0xff.
+func (m *Muxer) appendSection(pid uint16, cc *byte, section []byte) {
+	length := len(section) + 4 - 3
+	section[1] = 0xb0 | byte(length>>8)
+	section[2] = byte(length)
+
+	crc := crc32MPEG(section)
+	section = append(section, byte(crc>>24), byte(crc>>16), byte(crc>>8), byte(crc))
+
+	pkt := make([]byte, PacketSize)
+	pkt[0] = syncByte
+	pkt[1] = 0x40 | byte(pid>>8)
+	pkt[2] = byte(pid)
+	pkt[3] = 0x10 | *cc&0x0f
+	pkt[4] = 0 // pointer_field
+	n := copy(pkt[5:], section)
+
+	for i := 5 + n; i < PacketSize; i++ {
+		pkt[i] = 0xff
+	}
+
+	*cc++
+	m.buf = append(m.buf, pkt...)
+}
+
+// appendPES splits a PES packet into TS packets. The first one carries the
+// PCR (when pcr >= 0) and the random access / discontinuity indicators; the
+// last one is padded with adaptation field stuffing.
+func (m *Muxer) appendPES(s *muxStream, pes []byte, pcr int64, keyFrame, discontinuity bool) {
+	first := true
+
+	for len(pes) > 0 {
+		var af []byte
+
+		if first && (pcr >= 0 || keyFrame || discontinuity) {
+			af = append(af, 0)
+
+			if discontinuity {
+				af[0] |= 0x80
+			}
+
+			if keyFrame {
+				af[0] |= 0x40
+			}
+
+			if pcr >= 0 {
+				af[0] |= 0x10
+				base := pcr & tsMask
+				af = append(af, byte(base>>25), byte(base>>17), byte(base>>9), byte(base>>1), byte(base<<7)|0x7e, 0)
+			}
+		}
+
+		room := PacketSize - 4
+		if af != nil {
+			room -= 1 + len(af)
+		}
+
+		if len(pes) < room {
+			// Stuff the final packet through the adaptation field.
+			stuff := room - len(pes)
+			if af == nil {
+				stuff--
+
+				if stuff == 0 {
+					af = []byte{} // adaptation_field_length 0 is one byte of stuffing
+				} else {
+					af = append(af, 0)
+					stuff--
+				}
+			}
+
+			for range stuff {
+				af = append(af, 0xff)
+			}
+		}
+
+		hdr := []byte{syncByte, byte(s.pid >> 8), byte(s.pid), s.cc & 0x0f}
+		if first {
+			hdr[1] |= 0x40
+		}
+
+		s.cc++
+
+		if af != nil {
+			hdr[3] |= 0x30
+			hdr = append(hdr, byte(len(af)))
+			hdr = append(hdr, af...)
+		} else {
+			hdr[3] |= 0x10
+		}
+
+		n := PacketSize - len(hdr)
+		m.buf = append(m.buf, hdr...)
+		m.buf = append(m.buf, pes[:n]...)
+		pes = pes[n:]
+		first = false
+	}
+}
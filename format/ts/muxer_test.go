@@ -0,0 +1,174 @@
+package ts_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/codec/h264parser"
+	"github.com/vtpl1/avsdk/format/ts"
+	"github.com/vtpl1/avsdk/internal/avtest"
+)
+
+func TestMuxer(t *testing.T) {
+	ctx := context.Background()
+
+	aac := avtest.AAC(t)
+
+	streams := []av.Stream{{Idx: 0, Codec: avtest.H264(t, avtest.SPS320x192)}, {Idx: 1, Codec: aac}}
+
+	var buf bytes.Buffer
+
+	mux := ts.NewMuxer(&buf, ts.WithStreamPID(0, videoPID), ts.WithStreamPID(1, audioPID))
+	if err := mux.WriteHeader(ctx, streams); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range 6 {
+		if i == 3 {
+			changed := []av.Stream{{Idx: 0, Codec: avtest.H264(t, avtest.SPS1280x720)}}
+			if err := mux.WriteCodecChange(ctx, changed); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// Large enough to span several TS packets.
+		payload := append([]byte{0x41, byte(i)}, bytes.Repeat([]byte{0xab}, 598)...)
+		if i%3 == 0 {
+			payload[0] = 0x65
+		}
+
+		avcc := append([]byte{0, 0, byte(len(payload) >> 8), byte(len(payload))}, payload...)
+
+		video := av.Packet{
+			Idx:       0,
+			KeyFrame:  i%3 == 0,
+			DTS:       time.Duration(i) * 40 * time.Millisecond,
+			PTSOffset: 80 * time.Millisecond,
+			Data:      avcc,
+		}
+		if err := mux.WritePacket(ctx, video); err != nil {
+			t.Fatal(err)
+		}
+
+		audio := av.Packet{Idx: 1, DTS: video.DTS, Data: []byte{0x21, byte(i)}}
+		if err := mux.WritePacket(ctx, audio); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := mux.WriteTrailer(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if !errors.Is(mux.WriteTrailer(ctx), ts.ErrTrailerAlreadyWritten) {
+		t.Fatal("second WriteTrailer must fail")
+	}
+
+	out := buf.Bytes()
+	if len(out)%ts.PacketSize != 0 {
+		t.Fatalf("output size %d is not a multiple of %d", len(out), ts.PacketSize)
+	}
+
+	pcrs := 0
+
+	for off := 0; off < len(out); off += ts.PacketSize {
+		pkt := out[off : off+ts.PacketSize]
+		if pkt[0] != 0x47 {
+			t.Fatalf("packet at %d: bad sync byte", off)
+		}
+
+		pid := uint16(pkt[1]&0x1f)<<8 | uint16(pkt[2])
+		if pkt[3]&0x20 != 0 && pkt[4] > 0 && pkt[5]&0x10 != 0 {
+			if pid != videoPID {
+				t.Fatalf("PCR on PID %#x, want video PID", pid)
+			}
+
+			pcrs++
+		}
+	}
+
+	if pcrs != 6 {
+		t.Fatalf("got %d PCRs, want 6", pcrs)
+	}
+
+	dmx := ts.NewDemuxer(bytes.NewReader(out))
+
+	got, err := dmx.GetCodecs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != 2 || got[0].Idx != videoPID || got[1].Idx != audioPID {
+		t.Fatalf("streams = %+v", got)
+	}
+
+	var video, audio []av.Packet
+
+	for {
+		pkt, err := dmx.ReadPacket(ctx)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if pkt.Idx == videoPID {
+			video = append(video, pkt)
+		} else {
+			audio = append(audio, pkt)
+		}
+	}
+
+	if len(video) != 6 || len(audio) != 6 {
+		t.Fatalf("got %d video and %d audio packets", len(video), len(audio))
+	}
+
+	for i, pkt := range video {
+		if pkt.DTS != time.Duration(i)*40*time.Millisecond || pkt.PTSOffset != 80*time.Millisecond {
+			t.Fatalf("video %d: dts=%v ptsOffset=%v", i, pkt.DTS, pkt.PTSOffset)
+		}
+
+		if pkt.KeyFrame != (i%3 == 0) || pkt.IsParamSetNALU != (i%3 == 0) {
+			t.Fatalf("video %d: unexpected packet %v", i, pkt.String())
+		}
+
+		if (pkt.NewCodecs != nil) != (i == 3) {
+			t.Fatalf("video %d: NewCodecs = %+v", i, pkt.NewCodecs)
+		}
+	}
+
+	if w := video[3].NewCodecs[0].Codec.(h264parser.CodecData).Width(); w != 1280 { //nolint:forcetypeassert
+		t.Fatalf("changed width = %d, want 1280", w)
+	}
+
+	for i, pkt := range audio {
+		if pkt.DTS != time.Duration(i)*40*time.Millisecond || !bytes.Equal(pkt.Data, []byte{0x21, byte(i)}) {
+			t.Fatalf("audio %d: unexpected packet %v", i, pkt.String())
+		}
+	}
+}
+
+func TestMuxerInvalidPID(t *testing.T) {
+	streams := []av.Stream{{Idx: 0, Codec: avtest.H264(t, avtest.SPS320x192)}, {Idx: 1, Codec: avtest.H264(t, avtest.SPS1280x720)}}
+
+	for name, opts := range map[string][]ts.Option{
+		"PAT PID":         {ts.WithStreamPID(0, 0x0000)},
+		"null PID":        {ts.WithStreamPID(1, 0x1fff)},
+		"PMT PID":         {ts.WithStreamPID(0, ts.DefaultPMTPID)},
+		"reserved PMT":    {ts.WithPMTPID(0x0001)},
+		"default of next": {ts.WithStreamPID(0, ts.DefaultFirstStreamPID+1)},
+	} {
+		mux := ts.NewMuxer(io.Discard, opts...)
+
+		if err := mux.WriteHeader(context.Background(), streams); !errors.Is(err, ts.ErrInvalidPID) {
+			t.Fatalf("%s: err = %v, want ErrInvalidPID", name, err)
+		}
+	}
+}
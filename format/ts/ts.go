@@ -0,0 +1,83 @@
+// Package ts implements an MPEG-2 transport stream (ISO/IEC 13818-1) demuxer and muxer.
+package ts
+
+import "github.com/vtpl1/avsdk/av"
+
+// PacketSize is the size of a transport stream packet.
+const PacketSize = 188
+
+const (
+	syncByte = 0x47
+
+	pidPAT = 0x0000
+	// PIDs 0x0000-0x000f are reserved for PSI tables and 0x1fff for null
+	// packets; programs use the PIDs in between.
+	pidFirstFree = 0x0010
+	pidNull      = 0x1fff
+
+	tableIDPAT = 0x00
+	tableIDPMT = 0x02
+)
+
+// Elementary stream types carried in the PMT (ISO/IEC 13818-1 Table 2-34).
+const (
+	StreamTypeADTSAAC = 0x0f
+	StreamTypeH264    = 0x1b
+	StreamTypeH265    = 0x24
+)
+
+// PTS/DTS clock (90 kHz, 33 bits) and PCR base.
+const (
+	clockRate = 90000
+	tsBits    = 33
+	tsMask    = 1<<tsBits - 1
+)
+
+// writeTimestamp encodes a 33-bit PTS/DTS field with the given 4-bit prefix.
+func writeTimestamp(b []byte, prefix uint8, ts int64) {
+	ts &= tsMask
+	b[0] = prefix<<4 | byte(ts>>29)&0x0e | 1
+	b[1] = byte(ts >> 22)
+	b[2] = byte(ts>>14) | 1
+	b[3] = byte(ts >> 7)
+	b[4] = byte(ts<<1) | 1
+}
+
+// codecTypes maps the supported stream types to the codec of their payload.
+//
+//nolint:gochecknoglobals
+var codecTypes = map[uint8]av.CodecType{
+	StreamTypeADTSAAC: av.AAC,
+	StreamTypeH264:    av.H264,
+	StreamTypeH265:    av.H265,
+}
+
+//nolint:gochecknoglobals
+var crcTable = func() [256]uint32 {
+	var table [256]uint32
+
+	for i := range table {
+		crc := uint32(i) << 24
+		for range 8 {
+			if crc&0x80000000 != 0 {
+				crc = crc<<1 ^ 0x04c11db7
+			} else {
+				crc <<= 1
+			}
+		}
+
+		table[i] = crc
+	}
+
+	return table
+}()
+
+// crc32MPEG computes the CRC used by PSI sections (ISO/IEC 13818-1 Annex A).
+func crc32MPEG(b []byte) uint32 {
+	crc := uint32(0xffffffff)
+	for _, v := range b {
+		crc = crc<<8 ^ crcTable[byte(crc>>24)^v]
+	}
+
+	return crc
+}
@@ -0,0 +1,217 @@
+package wav
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/codec/pcm"
+	"github.com/vtpl1/avsdk/internal/ticks"
+	"github.com/vtpl1/avsdk/utils/bits/pio"
+)
+
+// Option configures a Demuxer.
+type Option func(*Demuxer)
+
+// WithPacketDuration sets the duration of the packets produced by the Demuxer.
+func WithPacketDuration(d time.Duration) Option {
+	return func(m *Demuxer) {
+		if d > 0 {
+			m.packetDuration = d
+		}
+	}
+}
+
+// Demuxer reads a RIFF/WAVE file and splits the data chunk into packets of a
+// fixed duration. Linear PCM is returned as little-endian av.PCML.
+// Stream.Idx is always 0.
+type Demuxer struct {
+	r              *bufio.Reader
+	packetDuration time.Duration
+	codec          av.AudioCodecData
+	sampleRate     int
+	blockAlign     int
+	remaining      int64 // bytes left in the data chunk, -1 if unknown
+	samples        int64
+	frameID        int64
+}
+
+// NewDemuxer returns a Demuxer reading from r.
+func NewDemuxer(r io.Reader, opts ...Option) *Demuxer {
+	m := &Demuxer{
+		r:              bufio.NewReaderSize(r, pio.RecommendBufioSize),
+		packetDuration: DefaultPacketDuration,
+	}
+
+	for _, opt := range opts {
+		opt(m)
+	}
+
+	return m
+}
+
+// GetCodecs implements av.Demuxer. It reads the chunks up to the start of the data chunk.
+func (m *Demuxer) GetCodecs(ctx context.Context) ([]av.Stream, error) {
+	if m.codec == nil {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		if err := m.readHeader(); err != nil {
+			return nil, err
+		}
+	}
+
+	return []av.Stream{{Idx: 0, Codec: m.codec}}, nil
+}
+
+// ReadPacket implements av.Demuxer.
+func (m *Demuxer) ReadPacket(ctx context.Context) (av.Packet, error) {
+	if m.codec == nil {
+		if _, err := m.GetCodecs(ctx); err != nil {
+			return av.Packet{}, err
+		}
+	}
+
+	if err := ctx.Err(); err != nil {
+		return av.Packet{}, err
+	}
+
+	samples := int64(m.packetDuration) * int64(m.sampleRate) / int64(time.Second)
+	size := max(samples, 1) * int64(m.blockAlign)
+
+	if m.remaining >= 0 {
+		size = min(size, m.remaining)
+	}
+
+	data := make([]byte, size)
+
+	n, err := io.ReadFull(m.r, data)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return av.Packet{}, err
+	}
+
+	// Drop a trailing partial sample block.
+	n -= n % m.blockAlign
+	if n == 0 {
+		return av.Packet{}, io.EOF
+	}
+
+	if m.remaining >= 0 {
+		m.remaining -= int64(n)
+	}
+
+	samples = int64(n / m.blockAlign)
+	pkt := av.Packet{
+		KeyFrame:  true,
+		Idx:       0,
+		DTS:       ticks.ToDuration(m.samples, int64(m.sampleRate)),
+		Duration:  ticks.ToDuration(samples, int64(m.sampleRate)),
+		Data:      data[:n],
+		FrameID:   m.frameID,
+		CodecType: m.codec.Type(),
+	}
+
+	m.samples += samples
+	m.frameID++
+
+	return pkt, nil
+}
+
+func (m *Demuxer) readHeader() error {
+	var hdr [12]byte
+	if _, err := io.ReadFull(m.r, hdr[:]); err != nil {
+		return ErrNotWAVE
+	}
+
+	if !Probe(hdr[:]) {
+		return ErrNotWAVE
+	}
+
+	// Writers that cannot seek back leave the sizes as 0 or 0xFFFFFFFF. A
+	// data size of 0 only means "unknown" when the RIFF size is unknown too.
+	riffSize := binary.LittleEndian.Uint32(hdr[4:8])
+	unknownRIFF := riffSize == 0 || riffSize == unknownSize
+
+	for {
+		if _, err := io.ReadFull(m.r, hdr[:8]); err != nil {
+			return ErrNoStreams
+		}
+
+		id := string(hdr[:4])
+		size := int64(binary.LittleEndian.Uint32(hdr[4:8]))
+
+		switch id {
+		case "fmt ":
+			if size < 16 || size > 1024 {
+				return ErrInvalidFormat
+			}
+
+			b := make([]byte, size+size&1)
+			if _, err := io.ReadFull(m.r, b); err != nil {
+				return ErrInvalidFormat
+			}
+
+			if err := m.parseFormat(b[:size]); err != nil {
+				return err
+			}
+		case "data":
+			if m.codec == nil {
+				return ErrMissingFormat
+			}
+
+			m.remaining = size
+			if size == unknownSize || (size == 0 && unknownRIFF) {
+				m.remaining = -1
+			}
+
+			return nil
+		default:
+			if _, err := m.r.Discard(int(size + size&1)); err != nil {
+				return ErrNoStreams
+			}
+		}
+	}
+}
+
+func (m *Demuxer) parseFormat(b []byte) error {
+	le := binary.LittleEndian
+	tag := le.Uint16(b[0:2])
+	channels := int(le.Uint16(b[2:4]))
+	rate := int(le.Uint32(b[4:8]))
+	blockAlign := int(le.Uint16(b[12:14]))
+	bits := int(le.Uint16(b[14:16]))
+
+	// WAVE_FORMAT_EXTENSIBLE stores the real tag at the start of the sub-format GUID.
+	if tag == formatExtensible && len(b) >= 26 {
+		tag = le.Uint16(b[24:26])
+	}
+
+	if channels == 0 || rate == 0 || blockAlign == 0 {
+		return ErrInvalidFormat
+	}
+
+	layout := channelLayout(channels)
+
+	switch {
+	case tag == FormatPCM && bits == 16:
+		m.codec = pcm.PCMLCodecData{Typ: av.PCML, SmplFormat: av.S16, SmplRate: rate, ChLayout: layout}
+	case tag == FormatPCM && bits == 8:
+		m.codec = pcm.PCMLCodecData{Typ: av.PCML, SmplFormat: av.U8, SmplRate: rate, ChLayout: layout}
+	case tag == FormatALaw && bits == 8:
+		m.codec = pcm.PCMAlawCodecData{Typ: av.PCM_ALAW, SmplFormat: av.S16, SmplRate: rate, ChLayout: layout}
+	case tag == FormatMuLaw && bits == 8:
+		m.codec = pcm.PCMMulawCodecData{Typ: av.PCM_MULAW, SmplFormat: av.S16, SmplRate: rate, ChLayout: layout}
+	default:
+		return ErrUnsupportedCodec
+	}
+
+	m.sampleRate = rate
+	m.blockAlign = blockAlign
+
+	return nil
+}
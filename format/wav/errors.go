@@ -0,0 +1,16 @@
+package wav
+
+import "errors"
+
+var (
+	ErrNotWAVE               = errors.New("wav: not a RIFF/WAVE file")
+	ErrMissingFormat         = errors.New("wav: data chunk before fmt chunk")
+	ErrInvalidFormat         = errors.New("wav: invalid fmt chunk")
+	ErrNoStreams             = errors.New("wav: no supported audio stream")
+	ErrHeaderNotWritten      = errors.New("wav: WriteHeader not called")
+	ErrHeaderAlreadyWritten  = errors.New("wav: WriteHeader already called")
+	ErrTrailerAlreadyWritten = errors.New("wav: WriteTrailer already called")
+	ErrUnsupportedCodec      = errors.New("wav: unsupported codec")
+	ErrTooManyStreams        = errors.New("wav: only one audio stream is supported")
+	ErrPartialBlock          = errors.New("wav: packet size is not a multiple of the block size")
+)
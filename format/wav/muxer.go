@@ -0,0 +1,244 @@
+package wav
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/codec/pcm"
+)
+
+// Muxer writes a single PCM, A-law or µ-law stream as a RIFF/WAVE file.
+// Big-endian av.PCM samples are converted to the little-endian order of WAVE.
+// Other streams are ignored. It implements av.Muxer.
+//
+// When the writer is an io.WriteSeeker the RIFF, fact and data sizes are
+// patched in WriteTrailer; otherwise they are left as 0xFFFFFFFF, which
+// readers treat as "until end of file".
+type Muxer struct {
+	w          io.Writer
+	ws         io.WriteSeeker // set if w supports seeking
+	base       int64          // offset of the RIFF header in ws
+	idx        uint16
+	codec      av.AudioCodecData
+	blockAlign int
+	header     []byte
+	factOffset int
+	dataSize   int64
+	stage      int
+}
+
+// NewMuxer returns a Muxer writing to w.
+func NewMuxer(w io.Writer) *Muxer {
+	return &Muxer{w: w}
+}
+
+// WriteHeader implements av.Muxer. Exactly one supported audio stream is required.
+func (m *Muxer) WriteHeader(_ context.Context, streams []av.Stream) error {
+	if m.stage != 0 {
+		return ErrHeaderAlreadyWritten
+	}
+
+	for _, stream := range streams {
+		if !stream.Codec.Type().IsAudio() {
+			continue
+		}
+
+		if m.codec != nil {
+			return ErrTooManyStreams
+		}
+
+		codec, ok := stream.Codec.(av.AudioCodecData)
+		if !ok {
+			return ErrUnsupportedCodec
+		}
+
+		m.idx = stream.Idx
+		m.codec = codec
+	}
+
+	if m.codec == nil {
+		return ErrNoStreams
+	}
+
+	if err := m.buildHeader(); err != nil {
+		return err
+	}
+
+	if ws, ok := m.w.(io.WriteSeeker); ok {
+		if base, err := ws.Seek(0, io.SeekCurrent); err == nil {
+			m.ws = ws
+			m.base = base
+		}
+	}
+
+	if _, err := m.w.Write(m.header); err != nil {
+		return err
+	}
+
+	m.stage++
+
+	return nil
+}
+
+// WritePacket implements av.Muxer.
+func (m *Muxer) WritePacket(_ context.Context, pkt av.Packet) error {
+	if m.stage != 1 {
+		return ErrHeaderNotWritten
+	}
+
+	if pkt.Idx != m.idx {
+		return nil
+	}
+
+	if len(pkt.Data)%m.blockAlign != 0 {
+		return ErrPartialBlock
+	}
+
+	data := pkt.Data
+	if m.codec.Type() == av.PCM && m.blockAlign > m.codec.ChannelLayout().Count() {
+		data = pcm.FlipEndian(data)
+	}
+
+	n, err := m.w.Write(data)
+	m.dataSize += int64(n)
+
+	return err
+}
+
+// WriteTrailer implements av.Muxer. It pads the data chunk to an even size
+// and, if the writer is seekable, patches the chunk sizes.
+func (m *Muxer) WriteTrailer(_ context.Context) error {
+	switch m.stage {
+	case 0:
+		return ErrHeaderNotWritten
+	case 2:
+		return ErrTrailerAlreadyWritten
+	}
+
+	m.stage++
+
+	pad := m.dataSize & 1
+	if pad != 0 {
+		if _, err := m.w.Write([]byte{0}); err != nil {
+			return err
+		}
+	}
+
+	if m.ws == nil {
+		return nil
+	}
+
+	end, err := m.ws.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	riffSize := int64(len(m.header)) - 8 + m.dataSize + pad
+	if err := m.patchU32(4, riffSize); err != nil {
+		return err
+	}
+
+	if m.factOffset != 0 {
+		if err := m.patchU32(int64(m.factOffset), m.dataSize/int64(m.blockAlign)); err != nil {
+			return err
+		}
+	}
+
+	if err := m.patchU32(int64(len(m.header))-4, m.dataSize); err != nil {
+		return err
+	}
+
+	_, err = m.ws.Seek(end, io.SeekStart)
+
+	return err
+}
+
+func (m *Muxer) buildHeader() error {
+	var (
+		tag  uint16
+		bits int
+	)
+
+	switch m.codec.Type() {
+	case av.PCM, av.PCML:
+		tag = FormatPCM
+
+		switch m.codec.SampleFormat() {
+		case av.U8:
+			bits = 8
+		case av.S16:
+			bits = 16
+		default:
+			return ErrUnsupportedCodec
+		}
+	case av.PCM_ALAW:
+		tag, bits = FormatALaw, 8
+	case av.PCM_MULAW:
+		tag, bits = FormatMuLaw, 8
+	default:
+		return ErrUnsupportedCodec
+	}
+
+	channels := m.codec.ChannelLayout().Count()
+	rate := m.codec.SampleRate()
+
+	if channels == 0 || rate <= 0 {
+		return ErrUnsupportedCodec
+	}
+
+	m.blockAlign = channels * bits / 8
+
+	le := binary.LittleEndian
+	b := make([]byte, 0, 58)
+	b = append(b, "RIFF"...)
+	b = le.AppendUint32(b, unknownSize)
+	b = append(b, "WAVE"...)
+
+	b = append(b, "fmt "...)
+	if tag == FormatPCM {
+		b = le.AppendUint32(b, 16)
+	} else {
+		b = le.AppendUint32(b, 18)
+	}
+
+	b = le.AppendUint16(b, tag)
+	b = le.AppendUint16(b, uint16(channels))
+	b = le.AppendUint32(b, uint32(rate))
+	b = le.AppendUint32(b, uint32(rate*m.blockAlign))
+	b = le.AppendUint16(b, uint16(m.blockAlign))
+	b = le.AppendUint16(b, uint16(bits))
+
+	// Non-PCM formats carry an (empty) extension size and a fact chunk.
+	if tag != FormatPCM {
+		b = le.AppendUint16(b, 0)
+		b = append(b, "fact"...)
+		b = le.AppendUint32(b, 4)
+		m.factOffset = len(b)
+		b = le.AppendUint32(b, unknownSize)
+	}
+
+	b = append(b, "data"...)
+	b = le.AppendUint32(b, unknownSize)
+	m.header = b
+
+	return nil
+}
+
+// patchU32 overwrites the 32-bit size at offset from the start of the header.
+func (m *Muxer) patchU32(offset, value int64) error {
+	if _, err := m.ws.Seek(m.base+offset, io.SeekStart); err != nil {
+		return err
+	}
+
+	if value > unknownSize {
+		value = unknownSize
+	}
+
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], uint32(value))
+	_, err := m.ws.Write(b[:])
+
+	return err
+}
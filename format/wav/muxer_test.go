@@ -0,0 +1,235 @@
+package wav_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/codec/pcm"
+	"github.com/vtpl1/avsdk/format/wav"
+	"github.com/vtpl1/avsdk/internal/avtest"
+)
+
+func TestMulawSeekable(t *testing.T) {
+	ctx := context.Background()
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "out.wav"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	mux := wav.NewMuxer(f)
+	if err := mux.WriteHeader(ctx, []av.Stream{{Idx: 3, Codec: pcm.NewPCMMulawCodecData()}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 1 s of audio in 30 ms packets, plus an odd trailing byte.
+	for i := range 33 {
+		if err := mux.WritePacket(ctx, av.Packet{Idx: 3, Data: bytes.Repeat([]byte{byte(i)}, 240)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := mux.WritePacket(ctx, av.Packet{Idx: 3, Data: bytes.Repeat([]byte{0xaa}, 81)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mux.WriteTrailer(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	le := binary.LittleEndian
+	if len(b) != 58+8001+1 {
+		t.Fatalf("file size = %d", len(b))
+	}
+
+	if tag := le.Uint16(b[20:]); tag != wav.FormatMuLaw {
+		t.Fatalf("format tag = %d", tag)
+	}
+
+	if riff, fact, data := le.Uint32(b[4:]), le.Uint32(b[46:]), le.Uint32(b[54:]); riff != 58-8+8002 || fact != 8001 || data != 8001 {
+		t.Fatalf("riff = %d, fact = %d, data = %d", riff, fact, data)
+	}
+
+	dmx := wav.NewDemuxer(bytes.NewReader(b))
+
+	streams, err := dmx.GetCodecs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if streams[0].Codec.Type() != av.PCM_MULAW || streams[0].Codec.(av.AudioCodecData).SampleRate() != 8000 { //nolint:forcetypeassert
+		t.Fatalf("codec = %+v", streams[0].Codec)
+	}
+
+	pkts := avtest.ReadAll(t, dmx)
+	if len(pkts) != 51 {
+		t.Fatalf("got %d packets, want 51", len(pkts))
+	}
+
+	for i, pkt := range pkts[:50] {
+		if len(pkt.Data) != 160 || pkt.DTS != time.Duration(i)*20*time.Millisecond || pkt.Duration != 20*time.Millisecond {
+			t.Fatalf("packet %d: %v", i, pkt.String())
+		}
+	}
+
+	// The pad byte is not part of the data chunk.
+	if last := pkts[50]; len(last.Data) != 1 || last.DTS != time.Second || last.Data[0] != 0xaa {
+		t.Fatalf("last packet: %v", last.String())
+	}
+}
+
+func TestMuxerAtOffset(t *testing.T) {
+	ctx := context.Background()
+
+	f, err := os.Create(filepath.Join(t.TempDir(), "out.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	prefix := bytes.Repeat([]byte{0xee}, 16)
+	if _, err := f.Write(prefix); err != nil {
+		t.Fatal(err)
+	}
+
+	mux := wav.NewMuxer(f)
+	if err := mux.WriteHeader(ctx, []av.Stream{{Idx: 0, Codec: pcm.NewPCMAlawCodecData()}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mux.WritePacket(ctx, av.Packet{Idx: 0, Data: make([]byte, 160)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mux.WriteTrailer(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(b) != 16+58+160 || !bytes.Equal(b[:16], prefix) {
+		t.Fatalf("file = % x", b[:min(len(b), 32)])
+	}
+
+	le := binary.LittleEndian
+	if riff, fact, data := le.Uint32(b[16+4:]), le.Uint32(b[16+46:]), le.Uint32(b[16+54:]); riff != 58-8+160 || fact != 160 || data != 160 {
+		t.Fatalf("riff = %d, fact = %d, data = %d", riff, fact, data)
+	}
+}
+
+func TestPCMStream(t *testing.T) {
+	ctx := context.Background()
+	codec := pcm.PCMCodecData{Typ: av.PCM, SmplFormat: av.S16, SmplRate: 16000, ChLayout: av.ChStereo}
+
+	var buf bytes.Buffer
+
+	mux := wav.NewMuxer(&buf)
+	if err := mux.WriteHeader(ctx, []av.Stream{{Idx: 0, Codec: codec}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Big-endian samples 0x0102, 0x0304, ...
+	var data []byte
+	for i := range 1000 {
+		data = append(data, byte(2*i+1), byte(2*i+2))
+	}
+
+	if err := mux.WritePacket(ctx, av.Packet{Data: data}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Half a sample cannot be byte-swapped.
+	if err := mux.WritePacket(ctx, av.Packet{Data: data[:3]}); !errors.Is(err, wav.ErrPartialBlock) {
+		t.Fatalf("err = %v, want ErrPartialBlock", err)
+	}
+
+	if err := mux.WriteTrailer(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	b := buf.Bytes()
+	if size := binary.LittleEndian.Uint32(b[40:]); size != 0xffffffff {
+		t.Fatalf("data size = %#x on a non-seekable writer", size)
+	}
+
+	if b[44] != 0x02 || b[45] != 0x01 {
+		t.Fatalf("first sample = % x, want little-endian", b[44:46])
+	}
+
+	dmx := wav.NewDemuxer(bytes.NewReader(b), wav.WithPacketDuration(10*time.Millisecond))
+
+	streams, err := dmx.GetCodecs(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, ok := streams[0].Codec.(pcm.PCMLCodecData)
+	if !ok || got.SmplRate != 16000 || got.ChLayout != av.ChStereo || got.SmplFormat != av.S16 {
+		t.Fatalf("codec = %+v", streams[0].Codec)
+	}
+
+	// 10 ms at 16 kHz stereo S16 is 640 bytes; 2000 bytes give 3 full packets and 80 bytes.
+	pkts := avtest.ReadAll(t, dmx)
+	if len(pkts) != 4 || len(pkts[3].Data) != 80 || pkts[3].DTS != 30*time.Millisecond || pkts[3].Duration != 1250*time.Microsecond {
+		t.Fatalf("packets: %d, last %v", len(pkts), pkts[len(pkts)-1].String())
+	}
+}
+
+func TestDemuxerEmptyDataChunk(t *testing.T) {
+	ctx := context.Background()
+
+	var buf bytes.Buffer
+
+	mux := wav.NewMuxer(&buf)
+	if err := mux.WriteHeader(ctx, []av.Stream{{Idx: 0, Codec: pcm.NewPCMMulawCodecData()}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A LIST chunk follows an empty data chunk.
+	list := append([]byte("LIST\x04\x00\x00\x00"), "INFO"...)
+	header := buf.Bytes()
+	le := binary.LittleEndian
+
+	for _, tt := range []struct {
+		name     string
+		riffSize uint32
+		want     int
+	}{
+		{"known RIFF size", uint32(len(header) - 8 + len(list)), 0},
+		{"unknown RIFF size", 0, 1}, // the LIST chunk is read as samples
+	} {
+		b := append(bytes.Clone(header), list...)
+		le.PutUint32(b[4:], tt.riffSize)
+		le.PutUint32(b[len(header)-4:], 0)
+
+		if pkts := avtest.ReadAll(t, wav.NewDemuxer(bytes.NewReader(b))); len(pkts) != tt.want {
+			t.Fatalf("%s: got %d packets, want %d", tt.name, len(pkts), tt.want)
+		}
+	}
+}
+
+func TestProbe(t *testing.T) {
+	if !wav.Probe([]byte("RIFF\x24\x00\x00\x00WAVEfmt ")) {
+		t.Fatal("Probe rejected WAVE header")
+	}
+
+	if wav.Probe([]byte("RIFF\x24\x00\x00\x00AVI LIST")) {
+		t.Fatal("Probe accepted AVI header")
+	}
+}
@@ -0,0 +1,56 @@
+// Package wav implements a demuxer and muxer for RIFF/WAVE files carrying
+// linear PCM, A-law or µ-law audio (.wav).
+package wav
+
+import (
+	"bytes"
+	"io"
+	"time"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/av/avutil"
+)
+
+// WAVE format tags.
+const (
+	FormatPCM        = 0x0001
+	FormatALaw       = 0x0006
+	FormatMuLaw      = 0x0007
+	formatExtensible = 0xfffe
+)
+
+// DefaultPacketDuration is the duration of the packets produced by the Demuxer.
+const DefaultPacketDuration = 20 * time.Millisecond
+
+// unknownSize is written to size fields that cannot be patched afterwards.
+const unknownSize = 0xffffffff
+
+// Probe reports whether b starts with a RIFF/WAVE header.
+func Probe(b []byte) bool {
+	return len(b) >= 12 && bytes.Equal(b[0:4], []byte("RIFF")) && bytes.Equal(b[8:12], []byte("WAVE"))
+}
+
+// Handler registers the ".wav" extension with avutil.
+func Handler(h *avutil.RegisterHandler) {
+	h.Ext = ".wav"
+	h.Probe = Probe
+	h.ReaderDemuxer = func(r io.Reader) av.Demuxer {
+		return NewDemuxer(r)
+	}
+	h.WriterMuxer = func(w io.Writer) av.Muxer {
+		return NewMuxer(w)
+	}
+	h.CodecTypes = []av.CodecType{av.PCM, av.PCML, av.PCM_ALAW, av.PCM_MULAW}
+}
+
+// channelLayout returns a layout with the given number of channels.
+func channelLayout(channels int) av.ChannelLayout {
+	switch channels {
+	case 1:
+		return av.ChMono
+	case 2:
+		return av.ChStereo
+	default:
+		return av.ChannelLayout(1<<channels - 1)
+	}
+}
@@ -0,0 +1,101 @@
+// Package avtest provides the codec fixtures and helpers shared by the muxer
+// and demuxer tests.
+package avtest
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/codec/aacparser"
+	"github.com/vtpl1/avsdk/codec/h264parser"
+	"github.com/vtpl1/avsdk/codec/h265parser"
+)
+
+// Hex-encoded H.264 High profile parameter sets: SPS320x192 (level 1.3,
+// cropped to 320x180) and SPS1280x720 (level 3.2) both pair with PPS.
+const (
+	SPS320x192  = "6764000dacd941419f9e10000003001000000303c0f1429960"
+	SPS1280x720 = "67640020accac05005bb0169e0000003002000000c9c4c000432380008647c12401cb1c31380"
+	PPS         = "68ebe3cb22c0"
+)
+
+// Hex-encoded H.265 Main profile parameter sets of a 2560x1920 stream.
+const (
+	H265VPS = "40010c01ffff016000000300800000030000030096ac09"
+	H265SPS = "420101016000000300800000030000030096a00140200781fe36bbb5377725d602dc0404041000003e800002710721dee51d88"
+	H265PPS = "4401c172b09c1b0de240"
+)
+
+// AV1SequenceHeader is the hex-encoded sequence header OBU of a 1280x720
+// 8-bit 4:2:0 Main profile AV1 stream at level 4.0.
+const AV1SequenceHeader = "0a0b00000042a67fd9e013cc02"
+
+// Unhex decodes a hex string, panicking on invalid input.
+func Unhex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+
+	return b
+}
+
+// H264 returns the codec data of the hex-encoded SPS spsHex with PPS.
+func H264(tb testing.TB, spsHex string) h264parser.CodecData {
+	tb.Helper()
+
+	codec, err := h264parser.NewCodecDataFromSPSAndPPS(Unhex(spsHex), Unhex(PPS))
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return codec
+}
+
+// H265 returns the codec data of H265VPS, H265SPS and H265PPS.
+func H265(tb testing.TB) h265parser.CodecData {
+	tb.Helper()
+
+	codec, err := h265parser.NewCodecDataFromVPSAndSPSAndPPS(Unhex(H265VPS), Unhex(H265SPS), Unhex(H265PPS))
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return codec
+}
+
+// AAC returns the codec data of 44.1 kHz stereo AAC-LC.
+func AAC(tb testing.TB) aacparser.CodecData {
+	tb.Helper()
+
+	codec, err := aacparser.NewCodecDataFromMPEG4AudioConfigBytes([]byte{0x12, 0x10})
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return codec
+}
+
+// ReadAll reads packets from dmx up to io.EOF.
+func ReadAll(tb testing.TB, dmx av.Demuxer) []av.Packet {
+	tb.Helper()
+
+	var pkts []av.Packet
+
+	for {
+		pkt, err := dmx.ReadPacket(context.Background())
+		if errors.Is(err, io.EOF) {
+			return pkts
+		}
+
+		if err != nil {
+			tb.Fatal(err)
+		}
+
+		pkts = append(pkts, pkt)
+	}
+}
@@ -0,0 +1,22 @@
+// Package ticks converts between time.Duration and the tick counts of media
+// clocks. Whole seconds are converted apart from the remainder so that the
+// products cannot overflow on long-running streams.
+package ticks
+
+import "time"
+
+// FromDuration converts d to ticks of a clock counting rate ticks per second,
+// rounded to the nearest tick.
+func FromDuration(d time.Duration, rate int64) int64 {
+	if d < 0 {
+		return -FromDuration(-d, rate)
+	}
+
+	return int64(d/time.Second)*rate + (int64(d%time.Second)*rate+int64(time.Second)/2)/int64(time.Second)
+}
+
+// ToDuration converts ticks of a clock counting rate ticks per second to a
+// duration, truncated to the nanosecond.
+func ToDuration(ticks, rate int64) time.Duration {
+	return time.Duration(ticks/rate)*time.Second + time.Duration(ticks%rate)*time.Second/time.Duration(rate)
+}
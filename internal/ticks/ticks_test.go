@@ -0,0 +1,30 @@
+package ticks_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/vtpl1/avsdk/internal/ticks"
+)
+
+func TestConversions(t *testing.T) {
+	for _, tt := range []struct {
+		d     time.Duration
+		rate  int64
+		ticks int64
+	}{
+		{time.Second / 30, 90000, 3000},
+		{-time.Second / 30, 90000, -3000},
+		{20 * time.Millisecond, 48000, 960},
+		{1000 * time.Hour, 90000, 1000 * 3600 * 90000},
+		{1000*time.Hour + time.Second/3, 48000, 1000*3600*48000 + 16000},
+	} {
+		if got := ticks.FromDuration(tt.d, tt.rate); got != tt.ticks {
+			t.Fatalf("FromDuration(%v, %d) = %d, want %d", tt.d, tt.rate, got, tt.ticks)
+		}
+
+		if got := ticks.FromDuration(ticks.ToDuration(tt.ticks, tt.rate), tt.rate); got != tt.ticks {
+			t.Fatalf("round trip of %d ticks at %d Hz = %d", tt.ticks, tt.rate, got)
+		}
+	}
+}
@@ -0,0 +1,327 @@
+// Package aac converts between MPEG-4 AAC frames and RTP packets, in the
+// AAC-hbr mode of RFC 3640 (mpeg4-generic) and the LATM format of RFC 6416
+// (MP4A-LATM).
+package aac
+
+import (
+	"encoding/hex"
+	"strconv"
+	"strings"
+
+	"github.com/vtpl1/avsdk/codec/aacparser"
+)
+
+// RTP encoding names, as found in the rtpmap attribute.
+const (
+	EncodingGeneric = "mpeg4-generic"
+	EncodingLATM    = "MP4A-LATM"
+)
+
+// AAC-hbr AU header field sizes, in bits, mandated by RFC 3640 §3.3.6.
+const (
+	hbrSizeLength       = 13
+	hbrIndexLength      = 3
+	hbrIndexDeltaLength = 3
+)
+
+// samplesPerFrame is the number of samples in an AAC frame.
+const samplesPerFrame = 1024
+
+// parseRTPMap splits an rtpmap value such as "mpeg4-generic/48000/2" into
+// its encoding name and clock rate. A leading payload type is skipped.
+func parseRTPMap(rtpmap string) (string, int, error) {
+	if _, after, ok := strings.Cut(rtpmap, " "); ok {
+		rtpmap = after
+	}
+
+	fields := strings.Split(strings.TrimSpace(rtpmap), "/")
+	if len(fields) < 2 {
+		return "", 0, ErrInvalidFMTP
+	}
+
+	rate, err := strconv.Atoi(fields[1])
+	if err != nil || rate <= 0 {
+		return "", 0, ErrInvalidFMTP
+	}
+
+	return fields[0], rate, nil
+}
+
+// parseFMTP returns the parameters of an fmtp value such as
+// "96 mode=AAC-hbr;config=1210", with lower-case names. A leading payload
+// type is skipped.
+func parseFMTP(fmtp string) map[string]string {
+	params := make(map[string]string)
+
+	if before, after, ok := strings.Cut(strings.TrimSpace(fmtp), " "); ok && !strings.Contains(before, "=") {
+		fmtp = after
+	}
+
+	for _, param := range strings.Split(fmtp, ";") {
+		key, value, ok := strings.Cut(param, "=")
+		if ok {
+			params[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(value)
+		}
+	}
+
+	return params
+}
+
+// intParam returns the integer value of an fmtp parameter, or def if absent.
+func intParam(params map[string]string, key string, def int) (int, error) {
+	value, ok := params[key]
+	if !ok {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 || n > 32 {
+		return 0, ErrInvalidFMTP
+	}
+
+	return n, nil
+}
+
+// streamMuxConfig holds the parts of a LATM StreamMuxConfig this package supports.
+type streamMuxConfig struct {
+	numSubFrames int
+	config       aacparser.MPEG4AudioConfig
+}
+
+// ParseStreamMuxConfig parses a LATM StreamMuxConfig, as carried hex-encoded
+// in the config parameter of an MP4A-LATM fmtp, and returns its
+// AudioSpecificConfig. Only audioMuxVersion 0 with a single program and
+// layer is supported.
+func ParseStreamMuxConfig(b []byte) (aacparser.MPEG4AudioConfig, error) {
+	smc, err := readStreamMuxConfig(&bitReader{b: b})
+
+	return smc.config, err
+}
+
+// readStreamMuxConfig parses a StreamMuxConfig (ISO/IEC 14496-3 §1.7.3).
+//
+//nolint:gocognit,cyclop
+func readStreamMuxConfig(r *bitReader) (streamMuxConfig, error) {
+	var smc streamMuxConfig
+
+	fields := func(sizes ...int) ([]uint32, error) {
+		values := make([]uint32, len(sizes))
+
+		for i, n := range sizes {
+			v, err := r.readBits(n)
+			if err != nil {
+				return nil, err
+			}
+
+			values[i] = v
+		}
+
+		return values, nil
+	}
+
+	// audioMuxVersion, allStreamsSameTimeFraming, numSubFrames, numProgram, numLayer
+	v, err := fields(1, 1, 6, 4, 3)
+	if err != nil {
+		return smc, err
+	}
+
+	if v[0] != 0 || v[1] != 1 || v[3] != 0 || v[4] != 0 {
+		return smc, ErrUnsupportedStreamMuxConfig
+	}
+
+	smc.numSubFrames = int(v[2])
+
+	config, err := readAudioSpecificConfig(r)
+	if err != nil {
+		return smc, err
+	}
+
+	smc.config = config
+
+	// frameLengthType, latmBufferFullness
+	if v, err = fields(3, 8); err != nil {
+		return smc, err
+	}
+
+	if v[0] != 0 {
+		return smc, ErrUnsupportedStreamMuxConfig
+	}
+
+	otherDataPresent, err := r.readBits(1)
+	if err != nil {
+		return smc, err
+	}
+
+	if otherDataPresent == 1 {
+		for {
+			// otherDataLenEsc, otherDataLenTmp
+			if v, err = fields(1, 8); err != nil {
+				return smc, err
+			}
+
+			if v[0] == 0 {
+				break
+			}
+		}
+	}
+
+	crcCheckPresent, err := r.readBits(1)
+	if err != nil {
+		return smc, err
+	}
+
+	if crcCheckPresent == 1 {
+		if _, err := r.readBits(8); err != nil {
+			return smc, err
+		}
+	}
+
+	return smc, nil
+}
+
+// readAudioSpecificConfig parses an AudioSpecificConfig (ISO/IEC 14496-3
+// §1.6.2.1) up to the end of its GASpecificConfig.
+//
+//nolint:gocognit,cyclop
+func readAudioSpecificConfig(r *bitReader) (aacparser.MPEG4AudioConfig, error) {
+	var config aacparser.MPEG4AudioConfig
+
+	objectType := func() (uint, error) {
+		typ, err := r.readBits(5)
+		if err == nil && typ == aacparser.AOT_ESCAPE {
+			var ext uint32
+
+			ext, err = r.readBits(6)
+			typ = 32 + ext
+		}
+
+		return uint(typ), err
+	}
+
+	sampleRateIndex := func() (uint, error) {
+		index, err := r.readBits(4)
+		if err == nil && index >= 0xd {
+			// Indexes 13 and 14 are reserved, and an explicit sampling
+			// frequency (15) is not representable.
+			return 0, ErrUnsupportedStreamMuxConfig
+		}
+
+		return uint(index), err
+	}
+
+	var err error
+
+	if config.ObjectType, err = objectType(); err != nil {
+		return config, err
+	}
+
+	if config.SampleRateIndex, err = sampleRateIndex(); err != nil {
+		return config, err
+	}
+
+	channelConfig, err := r.readBits(4)
+	if err != nil {
+		return config, err
+	}
+
+	config.ChannelConfig = uint(channelConfig)
+
+	if config.ObjectType == aacparser.AOT_SBR || config.ObjectType == aacparser.AOT_PS {
+		// Explicit SBR signalling: the core configuration follows.
+		if _, err = sampleRateIndex(); err != nil {
+			return config, err
+		}
+
+		if config.ObjectType, err = objectType(); err != nil {
+			return config, err
+		}
+	}
+
+	switch config.ObjectType {
+	case 1, 2, 3, 4, 6, 7, 17, 19, 20, 21, 22, 23:
+	default:
+		return config, ErrUnsupportedStreamMuxConfig
+	}
+
+	if channelConfig == 0 {
+		// A program_config_element would follow.
+		return config, ErrUnsupportedStreamMuxConfig
+	}
+
+	// GASpecificConfig: frameLengthFlag, dependsOnCoreCoder
+	flags, err := r.readBits(2)
+	if err != nil {
+		return config, err
+	}
+
+	if flags&1 != 0 {
+		if _, err = r.readBits(14); err != nil { // coreCoderDelay
+			return config, err
+		}
+	}
+
+	extensionFlag, err := r.readBits(1)
+	if err != nil {
+		return config, err
+	}
+
+	if config.ObjectType == 6 || config.ObjectType == 20 {
+		if _, err = r.readBits(3); err != nil { // layerNr
+			return config, err
+		}
+	}
+
+	if extensionFlag == 1 {
+		switch config.ObjectType {
+		case 22:
+			_, err = r.readBits(16) // numOfSubFrame, layer_length
+		case 17, 19, 20, 23:
+			_, err = r.readBits(3) // resilience flags
+		}
+
+		if err != nil {
+			return config, err
+		}
+
+		if _, err = r.readBits(1); err != nil { // extensionFlag3
+			return config, err
+		}
+	}
+
+	config.Complete()
+
+	return config, nil
+}
+
+// streamMuxConfigBytes returns a StreamMuxConfig with one subframe per
+// AudioMuxElement for config, as written in the config parameter of an
+// MP4A-LATM fmtp.
+func streamMuxConfigBytes(config aacparser.MPEG4AudioConfig) []byte {
+	w := &bitWriter{}
+
+	w.writeBits(0, 1) // audioMuxVersion
+	w.writeBits(1, 1) // allStreamsSameTimeFraming
+	w.writeBits(0, 6) // numSubFrames
+	w.writeBits(0, 4) // numProgram
+	w.writeBits(0, 3) // numLayer
+	w.writeBits(uint32(config.ObjectType), 5)
+	w.writeBits(uint32(config.SampleRateIndex), 4)
+	w.writeBits(uint32(config.ChannelConfig), 4)
+	w.writeBits(0, 3)    // GASpecificConfig: frameLengthFlag, dependsOnCoreCoder, extensionFlag
+	w.writeBits(0, 3)    // frameLengthType
+	w.writeBits(0xff, 8) // latmBufferFullness
+	w.writeBits(0, 1)    // otherDataPresent
+	w.writeBits(0, 1)    // crcCheckPresent
+
+	return w.b
+}
+
+// hexParam decodes a hex-encoded fmtp parameter.
+func hexParam(params map[string]string, key string) ([]byte, error) {
+	b, err := hex.DecodeString(params[key])
+	if err != nil || len(b) == 0 {
+		return nil, ErrInvalidFMTP
+	}
+
+	return b, nil
+}
@@ -0,0 +1,73 @@
+package aac
+
+// bitReader reads big-endian bit fields from a byte slice.
+type bitReader struct {
+	b   []byte
+	pos int // in bits
+}
+
+func (r *bitReader) readBits(n int) (uint32, error) {
+	if r.pos+n > 8*len(r.b) {
+		return 0, ErrInvalidPayload
+	}
+
+	var v uint32
+
+	for range n {
+		v = v<<1 | uint32(r.b[r.pos/8]>>(7-r.pos%8)&1)
+		r.pos++
+	}
+
+	return v, nil
+}
+
+func (r *bitReader) readBytes(n int) ([]byte, error) {
+	if r.pos%8 == 0 {
+		if r.pos/8+n > len(r.b) {
+			return nil, ErrInvalidPayload
+		}
+
+		b := r.b[r.pos/8 : r.pos/8+n]
+		r.pos += 8 * n
+
+		return b, nil
+	}
+
+	b := make([]byte, n)
+
+	for i := range b {
+		v, err := r.readBits(8)
+		if err != nil {
+			return nil, err
+		}
+
+		b[i] = byte(v)
+	}
+
+	return b, nil
+}
+
+func (r *bitReader) align() {
+	r.pos = (r.pos + 7) &^ 7
+}
+
+func (r *bitReader) bitsLeft() int {
+	return 8*len(r.b) - r.pos
+}
+
+// bitWriter appends big-endian bit fields to a byte slice.
+type bitWriter struct {
+	b   []byte
+	pos int // in bits
+}
+
+func (w *bitWriter) writeBits(v uint32, n int) {
+	for i := n - 1; i >= 0; i-- {
+		if w.pos%8 == 0 {
+			w.b = append(w.b, 0)
+		}
+
+		w.b[len(w.b)-1] |= byte(v>>i&1) << (7 - w.pos%8)
+		w.pos++
+	}
+}
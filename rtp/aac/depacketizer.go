@@ -0,0 +1,415 @@
+package aac
+
+import (
+	"bytes"
+	"cmp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/codec/aacparser"
+	"github.com/vtpl1/avsdk/rtp"
+	"github.com/vtpl1/avsdk/utils/bits/pio"
+)
+
+// maxInterleave bounds how many access units are held back to restore the
+// order of an interleaved AAC-hbr stream.
+const maxInterleave = 64
+
+// accessUnit is a depacketized AAC frame waiting to be returned.
+type accessUnit struct {
+	dts  time.Duration
+	data []byte
+}
+
+// Depacketizer extracts AAC frames from AAC-hbr or LATM RTP packets.
+//
+// In AAC-hbr mode, AU headers give the size of every frame; a frame larger
+// than a packet is reassembled from fragments, and interleaved frames are
+// returned in decoding order once the frames before them have arrived. In
+// LATM mode, AudioMuxElements spread over several packets are reassembled up
+// to the marker bit; with cpresent=1 the StreamMuxConfig is read in-band and
+// a change is reported through Packet.NewCodecs. Fragments that lost packets
+// are dropped. It implements rtp.Depacketizer.
+type Depacketizer struct {
+	idx              uint16
+	latm             bool
+	cpresent         bool
+	sizeLength       int
+	indexLength      int
+	indexDeltaLength int
+	interleaved      bool // AU-index or AU-index-delta values seen non-zero
+	numSubFrames     int
+	codec            aacparser.CodecData
+	announce         bool
+	clockRate        int
+	clock            *rtp.Clock
+	loss             rtp.LossDetector
+	frag             []byte
+	fragSize         int
+	fragTS           uint32
+	broken           bool
+	pending          []accessUnit
+	lastDTS          time.Duration
+	emitted          bool
+	frameID          int64
+}
+
+// NewDepacketizer returns a Depacketizer producing packets for stream idx
+// from the rtpmap and fmtp attribute values of its SDP media description,
+// for example "mpeg4-generic/48000/2" and
+// "96 streamtype=5;mode=AAC-hbr;sizelength=13;indexlength=3;indexdeltalength=3;config=1190".
+func NewDepacketizer(idx uint16, rtpmap, fmtp string) (*Depacketizer, error) {
+	encoding, clockRate, err := parseRTPMap(rtpmap)
+	if err != nil {
+		return nil, err
+	}
+
+	params := parseFMTP(fmtp)
+	d := &Depacketizer{
+		idx:       idx,
+		clockRate: clockRate,
+		clock:     rtp.NewClock(clockRate),
+	}
+
+	switch {
+	case strings.EqualFold(encoding, EncodingGeneric):
+		if err := d.initGeneric(params); err != nil {
+			return nil, err
+		}
+	case strings.EqualFold(encoding, EncodingLATM):
+		if err := d.initLATM(params); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, ErrUnsupportedEncoding
+	}
+
+	return d, nil
+}
+
+func (d *Depacketizer) initGeneric(params map[string]string) error {
+	if mode := params["mode"]; mode != "" && !strings.EqualFold(mode, "AAC-hbr") && !strings.EqualFold(mode, "AAC-lbr") {
+		return ErrUnsupportedEncoding
+	}
+
+	var err error
+
+	if d.sizeLength, err = intParam(params, "sizelength", hbrSizeLength); err != nil {
+		return err
+	}
+
+	if d.indexLength, err = intParam(params, "indexlength", hbrIndexLength); err != nil {
+		return err
+	}
+
+	if d.indexDeltaLength, err = intParam(params, "indexdeltalength", hbrIndexDeltaLength); err != nil {
+		return err
+	}
+
+	if d.sizeLength == 0 {
+		return ErrInvalidFMTP
+	}
+
+	config, err := hexParam(params, "config")
+	if err != nil {
+		return err
+	}
+
+	if d.codec, err = aacparser.NewCodecDataFromMPEG4AudioConfigBytes(config); err != nil {
+		return ErrInvalidFMTP
+	}
+
+	return nil
+}
+
+func (d *Depacketizer) initLATM(params map[string]string) error {
+	d.latm = true
+	d.cpresent = params["cpresent"] != "0"
+
+	if d.cpresent {
+		return nil
+	}
+
+	config, err := hexParam(params, "config")
+	if err != nil {
+		return err
+	}
+
+	smc, err := readStreamMuxConfig(&bitReader{b: config})
+	if err != nil {
+		return err
+	}
+
+	return d.setStreamMuxConfig(smc)
+}
+
+func (d *Depacketizer) setStreamMuxConfig(smc streamMuxConfig) error {
+	d.numSubFrames = smc.numSubFrames
+
+	if d.codec.Config == smc.config && d.codec.ConfigBytes != nil {
+		return nil
+	}
+
+	codec, err := aacparser.NewCodecDataFromMPEG4AudioConfig(smc.config)
+	if err != nil {
+		return ErrUnsupportedStreamMuxConfig
+	}
+
+	d.announce = d.codec.ConfigBytes != nil || d.cpresent
+	d.codec = codec
+
+	return nil
+}
+
+// Codec returns the stream codec, or nil before an in-band StreamMuxConfig
+// has been received.
+func (d *Depacketizer) Codec() av.CodecData {
+	if d.codec.ConfigBytes == nil {
+		return nil
+	}
+
+	return d.codec
+}
+
+// Depacketize implements rtp.Depacketizer.
+func (d *Depacketizer) Depacketize(pkt *rtp.Packet) ([]av.Packet, error) {
+	if d.loss.Lost(pkt.SequenceNumber) {
+		// The lost packets may have started a fragmented frame, and may have
+		// held the frames an interleaved stream is waiting for.
+		d.frag, d.broken, d.emitted = d.frag[:0], true, false
+	}
+
+	if len(d.frag) > 0 && pkt.Timestamp != d.fragTS {
+		// The rest of the fragmented frame never came.
+		d.frag = d.frag[:0]
+	}
+
+	var err error
+
+	if d.latm {
+		err = d.depacketizeLATM(pkt)
+	} else {
+		err = d.depacketizeHBR(pkt)
+	}
+
+	return d.drain(), err
+}
+
+func (d *Depacketizer) depacketizeHBR(pkt *rtp.Packet) error {
+	payload := pkt.Payload
+	if len(payload) < 2 {
+		return ErrInvalidPayload
+	}
+
+	headersLen := int(pio.U16BE(payload))
+	if 2+(headersLen+7)/8 > len(payload) {
+		return ErrInvalidPayload
+	}
+
+	r := &bitReader{b: payload[2 : 2+(headersLen+7)/8]}
+	data := payload[2+(headersLen+7)/8:]
+	ts := pkt.Timestamp
+
+	for i := 0; r.pos < headersLen; i++ {
+		size, err := r.readBits(d.sizeLength)
+		if err != nil {
+			return err
+		}
+
+		indexLength := d.indexDeltaLength
+		if i == 0 {
+			indexLength = d.indexLength
+		}
+
+		delta, err := r.readBits(indexLength)
+		if err != nil {
+			return err
+		}
+
+		d.interleaved = d.interleaved || delta != 0
+
+		if i > 0 {
+			// AU-index-delta counts the frames interleaved in between.
+			ts += (delta + 1) * d.frameTicks()
+		}
+
+		if int(size) > len(data) {
+			// A fragment of a frame spread over several packets.
+			if i > 0 || r.pos < headersLen {
+				return ErrInvalidPayload
+			}
+
+			return d.addFragment(data, int(size), pkt)
+		}
+
+		d.queue(ts, data[:size])
+		d.broken = false
+		data = data[size:]
+	}
+
+	return nil
+}
+
+func (d *Depacketizer) addFragment(data []byte, size int, pkt *rtp.Packet) error {
+	if len(d.frag) == 0 {
+		if d.broken {
+			// This may be the rest of a frame whose start was lost.
+			d.broken = !pkt.Marker
+
+			return nil
+		}
+
+		d.fragTS, d.fragSize = pkt.Timestamp, size
+	}
+
+	d.frag = append(d.frag, data...)
+
+	switch {
+	case len(d.frag) > d.fragSize:
+		d.frag = d.frag[:0]
+
+		return ErrInvalidPayload
+	case len(d.frag) == d.fragSize:
+		d.queue(d.fragTS, d.frag)
+		d.frag = d.frag[:0]
+	}
+
+	return nil
+}
+
+func (d *Depacketizer) depacketizeLATM(pkt *rtp.Packet) error {
+	if d.broken {
+		// Skip the rest of an AudioMuxElement whose start was lost.
+		d.broken = !pkt.Marker
+
+		return nil
+	}
+
+	if len(d.frag) == 0 {
+		d.fragTS = pkt.Timestamp
+	}
+
+	d.frag = append(d.frag, pkt.Payload...)
+
+	if !pkt.Marker {
+		return nil
+	}
+
+	defer func() { d.frag = d.frag[:0] }()
+
+	r := &bitReader{b: d.frag}
+	ts := d.fragTS
+
+	for r.bitsLeft() >= 8 {
+		if d.cpresent {
+			useSameStreamMux, err := r.readBits(1)
+			if err != nil {
+				return err
+			}
+
+			if useSameStreamMux == 0 {
+				smc, err := readStreamMuxConfig(r)
+				if err != nil {
+					return err
+				}
+
+				if err := d.setStreamMuxConfig(smc); err != nil {
+					return err
+				}
+			} else if d.codec.ConfigBytes == nil {
+				return nil // no StreamMuxConfig received yet
+			}
+		}
+
+		for range d.numSubFrames + 1 {
+			size := 0
+
+			for {
+				v, err := r.readBits(8)
+				if err != nil {
+					return err
+				}
+
+				size += int(v)
+				if v != 0xff {
+					break
+				}
+			}
+
+			frame, err := r.readBytes(size)
+			if err != nil {
+				return err
+			}
+
+			d.queue(ts, frame)
+			ts += d.frameTicks()
+		}
+
+		r.align()
+	}
+
+	return nil
+}
+
+// frameTicks returns the duration of one AAC frame in RTP clock ticks, which
+// need not run at the sample rate.
+func (d *Depacketizer) frameTicks() uint32 {
+	return uint32(samplesPerFrame * int64(d.clockRate) / int64(max(d.codec.SampleRate(), 1)))
+}
+
+// queue adds a frame with RTP timestamp ts to the frames waiting to be returned.
+func (d *Depacketizer) queue(ts uint32, frame []byte) {
+	au := accessUnit{dts: d.clock.Duration(ts), data: bytes.Clone(frame)}
+
+	i, _ := slices.BinarySearchFunc(d.pending, au.dts, func(au accessUnit, dts time.Duration) int {
+		return cmp.Compare(au.dts, dts)
+	})
+	d.pending = slices.Insert(d.pending, i, au)
+}
+
+// drain returns the pending frames that are in decoding order: all of them
+// unless the stream is interleaved (AAC-hbr with non-zero AU-index or
+// AU-index-delta values), in which case a frame is held back until its
+// predecessor has been returned or too many frames are waiting.
+func (d *Depacketizer) drain() []av.Packet {
+	if len(d.pending) == 0 {
+		return nil
+	}
+
+	var out []av.Packet
+
+	duration, _ := d.codec.PacketDuration(nil)
+
+	for len(d.pending) > 0 {
+		au := d.pending[0]
+
+		if d.interleaved && d.emitted && au.dts > d.lastDTS+duration+duration/2 && len(d.pending) <= maxInterleave {
+			break
+		}
+
+		d.pending = d.pending[1:]
+
+		pkt := av.Packet{
+			KeyFrame:  true,
+			Idx:       d.idx,
+			DTS:       au.dts,
+			Duration:  duration,
+			Data:      au.data,
+			FrameID:   d.frameID,
+			CodecType: av.AAC,
+		}
+		d.frameID++
+
+		if d.announce {
+			pkt.NewCodecs = []av.Stream{{Idx: d.idx, Codec: d.codec}}
+			d.announce = false
+		}
+
+		d.lastDTS, d.emitted = au.dts, true
+		out = append(out, pkt)
+	}
+
+	return out
+}
@@ -0,0 +1,263 @@
+package aac_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/codec/aacparser"
+	"github.com/vtpl1/avsdk/rtp"
+	"github.com/vtpl1/avsdk/rtp/aac"
+)
+
+// auHeaders builds an AAC-hbr payload with 13-bit sizes and 3-bit indexes.
+func auHeaders(indexes []uint16, frames ...[]byte) []byte {
+	b := binary.BigEndian.AppendUint16(nil, uint16(16*len(frames)))
+	for i, frame := range frames {
+		b = binary.BigEndian.AppendUint16(b, uint16(len(frame))<<3|indexes[i])
+	}
+
+	for _, frame := range frames {
+		b = append(b, frame...)
+	}
+
+	return b
+}
+
+func frame(n int, fill byte) []byte {
+	return bytes.Repeat([]byte{fill}, n)
+}
+
+// sender numbers the RTP packets it feeds to a Depacketizer.
+type sender struct {
+	t    *testing.T
+	d    *aac.Depacketizer
+	seq  uint16
+	pkts []av.Packet
+}
+
+func (s *sender) send(ts uint32, marker bool, payloads ...[]byte) {
+	s.t.Helper()
+
+	for i, payload := range payloads {
+		pkt := &rtp.Packet{
+			Marker:         marker && i == len(payloads)-1,
+			PayloadType:    97,
+			SequenceNumber: s.seq,
+			Timestamp:      ts,
+			Payload:        payload,
+		}
+		s.seq++
+
+		out, err := s.d.Depacketize(pkt)
+		if err != nil {
+			s.t.Fatal(err)
+		}
+
+		s.pkts = append(s.pkts, out...)
+	}
+}
+
+func newSender(t *testing.T, rtpmap, fmtp string) *sender {
+	t.Helper()
+
+	d, err := aac.NewDepacketizer(1, rtpmap, fmtp)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &sender{t: t, d: d}
+}
+
+func TestDepacketizerHBR(t *testing.T) {
+	s := newSender(t, "mpeg4-generic/48000/2",
+		"96 streamtype=5; profile-level-id=1; mode=AAC-hbr; SizeLength=13; IndexLength=3; IndexDeltaLength=3; config=1190")
+
+	codec, ok := s.d.Codec().(aacparser.CodecData)
+	if !ok || codec.SampleRate() != 48000 || codec.ChannelLayout() != av.ChStereo {
+		t.Fatalf("codec = %+v", s.d.Codec())
+	}
+
+	// Three frames in one packet, then one fragmented over three packets.
+	s.send(1000, true, auHeaders([]uint16{0, 0, 0}, frame(100, 1), frame(200, 2), frame(300, 3)))
+
+	big := frame(2500, 4)
+	header := auHeaders([]uint16{0}, big)[:4]
+
+	for i := 0; i < len(big); i += 1000 {
+		chunk := big[i:min(i+1000, len(big))]
+		s.send(1000+3*1024, i+1000 >= len(big), append(bytes.Clone(header), chunk...))
+	}
+
+	if len(s.pkts) != 4 {
+		t.Fatalf("got %d packets, want 4", len(s.pkts))
+	}
+
+	for i, want := range []int{100, 200, 300, 2500} {
+		pkt := s.pkts[i]
+		if pkt.Idx != 1 || !pkt.KeyFrame || pkt.CodecType != av.AAC || len(pkt.Data) != want ||
+			pkt.DTS != time.Duration(i*1024)*time.Second/48000 || pkt.Duration != 1024*time.Second/48000 {
+			t.Fatalf("packet %d: %v", i, pkt.String())
+		}
+	}
+}
+
+func TestDepacketizerInterleaved(t *testing.T) {
+	s := newSender(t, "mpeg4-generic/44100", "mode=AAC-hbr;sizelength=13;indexlength=3;indexdeltalength=3;config=1210")
+
+	// Frames 0, 2, 4 in the first packet and 1, 3, 5 in the second.
+	s.send(0, true, auHeaders([]uint16{0, 1, 1}, frame(10, 0), frame(10, 2), frame(10, 4)))
+
+	if len(s.pkts) != 1 {
+		t.Fatalf("got %d packets before the second group, want 1", len(s.pkts))
+	}
+
+	s.send(1024, true, auHeaders([]uint16{0, 1, 1}, frame(10, 1), frame(10, 3), frame(10, 5)))
+
+	if len(s.pkts) != 6 {
+		t.Fatalf("got %d packets, want 6", len(s.pkts))
+	}
+
+	for i, pkt := range s.pkts {
+		if pkt.Data[0] != byte(i) || pkt.DTS != time.Duration(i*1024)*time.Second/44100 {
+			t.Fatalf("packet %d: %v data %x", i, pkt.String(), pkt.Data[0])
+		}
+	}
+}
+
+func TestDepacketizerClockRate(t *testing.T) {
+	// 24 kHz AAC on a 90 kHz RTP clock: a frame lasts 3840 ticks.
+	s := newSender(t, "mpeg4-generic/90000", "mode=AAC-hbr;sizelength=13;indexlength=3;indexdeltalength=3;config=1310")
+
+	s.send(0, true, auHeaders([]uint16{0, 0}, frame(10, 0), frame(10, 1)))
+
+	// A gap in the timestamps of a stream that is not interleaved must not
+	// hold the next frame back.
+	s.send(4*3840, true, auHeaders([]uint16{0}, frame(10, 4)))
+
+	if len(s.pkts) != 3 {
+		t.Fatalf("got %d packets, want 3", len(s.pkts))
+	}
+
+	for i, want := range []time.Duration{0, 1024 * time.Second / 24000, 4 * 1024 * time.Second / 24000} {
+		if pkt := s.pkts[i]; pkt.DTS != want || pkt.Duration != 1024*time.Second/24000 {
+			t.Fatalf("packet %d: %v, want DTS %v", i, pkt.String(), want)
+		}
+	}
+}
+
+func TestDepacketizerHBRLoss(t *testing.T) {
+	s := newSender(t, "mpeg4-generic/48000/2", "mode=AAC-hbr;sizelength=13;indexlength=3;indexdeltalength=3;config=1190")
+
+	big := frame(1500, 7)
+	header := auHeaders([]uint16{0}, big)[:4]
+
+	// The first fragment is lost: the rest of the frame is dropped.
+	s.seq++
+	s.send(0, true, append(bytes.Clone(header), big[1000:]...))
+	s.send(1024, true, auHeaders([]uint16{0}, frame(50, 8)))
+
+	if len(s.pkts) != 1 || len(s.pkts[0].Data) != 50 {
+		t.Fatalf("packets = %+v", s.pkts)
+	}
+
+	if _, err := s.d.Depacketize(&rtp.Packet{SequenceNumber: s.seq, Payload: []byte{0, 16, 0xff}}); !errors.Is(err, aac.ErrInvalidPayload) {
+		t.Fatalf("err = %v, want ErrInvalidPayload", err)
+	}
+}
+
+// bitString packs a string of '0' and '1' into bytes, zero-padded.
+func bitString(bits string) []byte {
+	b := make([]byte, (len(bits)+7)/8)
+	for i, c := range bits {
+		if c == '1' {
+			b[i/8] |= 0x80 >> (i % 8)
+		}
+	}
+
+	return b
+}
+
+func byteBits(b ...byte) string {
+	var s string
+	for _, c := range b {
+		s += fmt.Sprintf("%08b", c)
+	}
+
+	return s
+}
+
+// streamMuxConfig48k is a StreamMuxConfig for 48 kHz stereo AAC-LC.
+const streamMuxConfig48k = "0" + "1" + "000000" + "0000" + "000" + // version, same framing, subframes, program, layer
+	"00010" + "0011" + "0010" + "000" + // AudioSpecificConfig
+	"000" + "11111111" + "0" + "0" // frameLengthType, fullness, otherData, CRC
+
+func TestDepacketizerLATM(t *testing.T) {
+	first, second := frame(300, 0x11), frame(10, 0x22)
+
+	// An in-band StreamMuxConfig with a 300-byte frame, split over two
+	// packets, then a frame reusing the configuration.
+	element := bitString("0" + streamMuxConfig48k + byteBits(0xff, 300-255) + byteBits(first...))
+	next := bitString("1" + byteBits(byte(len(second))) + byteBits(second...))
+
+	s := newSender(t, "MP4A-LATM/48000/2", "96 profile-level-id=1;object=2;cpresent=1")
+
+	if s.d.Codec() != nil {
+		t.Fatal("codec known before the StreamMuxConfig")
+	}
+
+	s.send(0, false, element[:200])
+	s.send(0, true, element[200:])
+	s.send(1024, true, next)
+
+	if len(s.pkts) != 2 {
+		t.Fatalf("got %d packets, want 2", len(s.pkts))
+	}
+
+	if pkt := s.pkts[0]; !bytes.Equal(pkt.Data, first) || len(pkt.NewCodecs) != 1 || pkt.DTS != 0 {
+		t.Fatalf("first packet: %v", pkt.String())
+	}
+
+	codec, _ := s.d.Codec().(aacparser.CodecData)
+	if codec.SampleRate() != 48000 || codec.ChannelLayout() != av.ChStereo {
+		t.Fatalf("codec = %+v", codec.Config)
+	}
+
+	if pkt := s.pkts[1]; !bytes.Equal(pkt.Data, second) || pkt.NewCodecs != nil || pkt.DTS != 1024*time.Second/48000 {
+		t.Fatalf("second packet: %v", pkt.String())
+	}
+}
+
+func TestParseStreamMuxConfig(t *testing.T) {
+	config, err := aac.ParseStreamMuxConfig(bitString(streamMuxConfig48k))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.ObjectType != 2 || config.SampleRate != 48000 || config.ChannelLayout != av.ChStereo {
+		t.Fatalf("config = %+v", config)
+	}
+
+	if _, err := aac.ParseStreamMuxConfig([]byte{0x80, 0x00}); !errors.Is(err, aac.ErrUnsupportedStreamMuxConfig) {
+		t.Fatalf("err = %v, want ErrUnsupportedStreamMuxConfig", err)
+	}
+
+	// A reserved sampling frequency index (13).
+	reserved := strings.Replace(streamMuxConfig48k, "00010"+"0011", "00010"+"1101", 1)
+	if _, err := aac.ParseStreamMuxConfig(bitString(reserved)); !errors.Is(err, aac.ErrUnsupportedStreamMuxConfig) {
+		t.Fatalf("err = %v, want ErrUnsupportedStreamMuxConfig", err)
+	}
+
+	if _, err := aac.NewDepacketizer(0, "mpeg4-generic/48000/2", "mode=AAC-hbr;sizelength=13;config=1688"); !errors.Is(err, aac.ErrInvalidFMTP) {
+		t.Fatalf("err = %v, want ErrInvalidFMTP", err)
+	}
+
+	if _, err := aac.NewDepacketizer(0, "L16/48000", ""); !errors.Is(err, aac.ErrUnsupportedEncoding) {
+		t.Fatalf("err = %v, want ErrUnsupportedEncoding", err)
+	}
+}
@@ -0,0 +1,11 @@
+package aac
+
+import "errors"
+
+var (
+	ErrUnsupportedEncoding        = errors.New("aac: unsupported RTP encoding")
+	ErrInvalidFMTP                = errors.New("aac: invalid fmtp parameters")
+	ErrInvalidPayload             = errors.New("aac: invalid RTP payload")
+	ErrUnsupportedStreamMuxConfig = errors.New("aac: unsupported StreamMuxConfig")
+	ErrMTUTooSmall                = errors.New("aac: MTU too small")
+)
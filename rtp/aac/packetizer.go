@@ -0,0 +1,172 @@
+package aac
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/codec/aacparser"
+	"github.com/vtpl1/avsdk/rtp"
+)
+
+// PacketizerOption configures a Packetizer.
+type PacketizerOption func(*Packetizer)
+
+// WithMTU sets the maximum size of an RTP packet, header included. The
+// default is rtp.DefaultMTU.
+func WithMTU(mtu int) PacketizerOption {
+	return func(p *Packetizer) {
+		p.mtu = mtu
+	}
+}
+
+// WithLATM makes the Packetizer produce MP4A-LATM packets, with the
+// StreamMuxConfig carried out-of-band (cpresent=0), instead of AAC-hbr.
+func WithLATM() PacketizerOption {
+	return func(p *Packetizer) {
+		p.latm = true
+	}
+}
+
+// Packetizer puts each AAC frame in its own AAC-hbr or LATM payload,
+// fragmented over several RTP packets if it does not fit the MTU. The marker
+// bit is set on the last packet of each frame, and the RTP clock runs at the
+// sample rate. It implements rtp.Packetizer.
+type Packetizer struct {
+	seq   *rtp.Sequencer
+	codec aacparser.CodecData
+	mtu   int
+	latm  bool
+}
+
+// NewPacketizer returns a Packetizer for a stream described by codec.
+func NewPacketizer(payloadType uint8, ssrc uint32, codec aacparser.CodecData, opts ...PacketizerOption) *Packetizer {
+	p := &Packetizer{
+		codec: codec,
+		mtu:   rtp.DefaultMTU,
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	p.seq = rtp.NewSequencer(payloadType, ssrc, codec.SampleRate())
+
+	return p
+}
+
+// RTPMap returns the rtpmap attribute value describing the packets, such as
+// "mpeg4-generic/48000/2".
+func (p *Packetizer) RTPMap() string {
+	encoding := EncodingGeneric
+	if p.latm {
+		encoding = EncodingLATM
+	}
+
+	return fmt.Sprintf("%s/%d/%d", encoding, p.codec.SampleRate(), p.codec.ChannelLayout().Count())
+}
+
+// FMTP returns the fmtp attribute parameters describing the packets,
+// without the payload type.
+func (p *Packetizer) FMTP() string {
+	if p.latm {
+		return "profile-level-id=1;object=" + fmt.Sprint(p.codec.Config.ObjectType) +
+			";cpresent=0;config=" + hex.EncodeToString(streamMuxConfigBytes(p.codec.Config))
+	}
+
+	return "streamtype=5;profile-level-id=1;mode=AAC-hbr;sizelength=13;indexlength=3;indexdeltalength=3;config=" +
+		hex.EncodeToString(p.codec.MPEG4AudioConfigBytes())
+}
+
+// Packetize implements rtp.Packetizer. pkt.Data is a raw AAC frame without
+// ADTS header. A codec in pkt.NewCodecs for pkt.Idx replaces the current one,
+// but the RTP clock keeps its rate.
+func (p *Packetizer) Packetize(pkt av.Packet) ([]*rtp.Packet, error) {
+	for _, stream := range pkt.NewCodecs {
+		if codec, ok := stream.Codec.(aacparser.CodecData); ok && stream.Idx == pkt.Idx {
+			p.codec = codec
+		}
+	}
+
+	var payloads [][]byte
+
+	if p.latm {
+		payloads = p.latmPayloads(pkt.Data)
+	} else {
+		payloads = p.hbrPayloads(pkt.Data)
+	}
+
+	if payloads == nil {
+		return nil, ErrMTUTooSmall
+	}
+
+	pkts := make([]*rtp.Packet, len(payloads))
+	for i, payload := range payloads {
+		pkts[i] = p.seq.Packet(payload, pkt.DTS+pkt.PTSOffset, i == len(payloads)-1)
+	}
+
+	return pkts, nil
+}
+
+// hbrPayloads returns the AAC-hbr payloads of frame: a 16-bit AU-headers-length
+// and a single AU header, repeated in front of every fragment.
+func (p *Packetizer) hbrPayloads(frame []byte) [][]byte {
+	const headerSize = 4
+
+	if len(frame) >= 1<<hbrSizeLength {
+		return nil
+	}
+
+	maxData := p.mtu - rtp.HeaderSize - headerSize
+	if maxData < 1 {
+		return nil
+	}
+
+	var payloads [][]byte
+
+	for data := frame; len(payloads) == 0 || len(data) > 0; {
+		n := min(maxData, len(data))
+
+		payload := make([]byte, headerSize, headerSize+n)
+		binary.BigEndian.PutUint16(payload, hbrSizeLength+hbrIndexLength)
+		binary.BigEndian.PutUint16(payload[2:], uint16(len(frame))<<hbrIndexLength)
+
+		payloads = append(payloads, append(payload, data[:n]...))
+		data = data[n:]
+	}
+
+	return payloads
+}
+
+// latmPayloads returns the AudioMuxElement of frame, split into payloads
+// that fit the MTU.
+func (p *Packetizer) latmPayloads(frame []byte) [][]byte {
+	maxData := p.mtu - rtp.HeaderSize
+	if maxData < 1 {
+		return nil
+	}
+
+	element := make([]byte, 0, len(frame)/255+1+len(frame))
+	for n := len(frame); ; n -= 255 {
+		if n < 255 {
+			element = append(element, byte(n))
+
+			break
+		}
+
+		element = append(element, 0xff)
+	}
+
+	element = append(element, frame...)
+
+	var payloads [][]byte
+
+	for len(element) > 0 {
+		n := min(maxData, len(element))
+		payloads = append(payloads, element[:n])
+		element = element[n:]
+	}
+
+	return payloads
+}
@@ -0,0 +1,80 @@
+package aac_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/codec/aacparser"
+	"github.com/vtpl1/avsdk/internal/avtest"
+	"github.com/vtpl1/avsdk/rtp"
+	"github.com/vtpl1/avsdk/rtp/aac"
+)
+
+func TestPacketizerRoundTrip(t *testing.T) {
+	codec, err := aacparser.NewCodecDataFromMPEG4AudioConfigBytes([]byte{0x11, 0x90})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	frames := [][]byte{frame(200, 1), frame(1500, 2), frame(600, 3)}
+
+	for _, opts := range [][]aac.PacketizerOption{
+		{aac.WithMTU(500)},
+		{aac.WithMTU(500), aac.WithLATM()},
+	} {
+		p := aac.NewPacketizer(97, 0x1234, codec, opts...)
+
+		d, err := aac.NewDepacketizer(3, p.RTPMap(), "97 "+p.FMTP())
+		if err != nil {
+			t.Fatalf("%s %s: %v", p.RTPMap(), p.FMTP(), err)
+		}
+
+		if got := d.Codec().(aacparser.CodecData); got.SampleRate() != 48000 || got.ChannelLayout() != av.ChStereo {
+			t.Fatalf("%s: codec = %+v", p.RTPMap(), got.Config)
+		}
+
+		var out []av.Packet
+
+		for i, data := range frames {
+			pkts, err := p.Packetize(av.Packet{DTS: time.Duration(i*1024) * time.Second / 48000, Data: data})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for j, pkt := range pkts {
+				if len(pkt.Payload)+rtp.HeaderSize > 500 || pkt.Marker != (j == len(pkts)-1) {
+					t.Fatalf("%s: packet %d of frame %d: %d bytes, marker %v", p.RTPMap(), j, i, len(pkt.Payload), pkt.Marker)
+				}
+
+				got, err := d.Depacketize(pkt)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				out = append(out, got...)
+			}
+		}
+
+		if len(out) != len(frames) {
+			t.Fatalf("%s: got %d frames, want %d", p.RTPMap(), len(out), len(frames))
+		}
+
+		for i, pkt := range out {
+			if !bytes.Equal(pkt.Data, frames[i]) || pkt.DTS != time.Duration(i*1024)*time.Second/48000 {
+				t.Fatalf("%s: frame %d: %v", p.RTPMap(), i, pkt.String())
+			}
+		}
+	}
+}
+
+func TestPacketizerMTUTooSmall(t *testing.T) {
+	codec := avtest.AAC(t)
+
+	p := aac.NewPacketizer(97, 1, codec, aac.WithMTU(rtp.HeaderSize+4))
+	if _, err := p.Packetize(av.Packet{Data: frame(10, 0)}); !errors.Is(err, aac.ErrMTUTooSmall) {
+		t.Fatalf("err = %v, want ErrMTUTooSmall", err)
+	}
+}
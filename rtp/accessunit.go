@@ -0,0 +1,81 @@
+package rtp
+
+// AccessUnit assembles the video access units of one H.264 or H.265 stream
+// from RTP packets, as AVCC data. An access unit ends at the marker bit, or
+// when the timestamp changes; one that lost packets is broken and is not
+// emitted. Senders set the marker bit on the last packet of every access
+// unit, so a sequence gap before a timestamp change breaks both the access
+// unit left without its marker and the next one. The payload format packages
+// parse the packets and add the NAL units.
+type AccessUnit struct {
+	Data     []byte // length-prefixed NAL units
+	FU       []byte // NAL unit being reassembled from fragments, nil if none
+	TS       uint32
+	KeyFrame bool
+	ParamSet bool
+	Broken   bool
+	loss     LossDetector
+	started  bool // an access unit is open: not yet ended by its marker
+}
+
+// Assemble adds pkt to the access unit. parse adds the NAL units of its
+// payload; emit is called for each completed access unit that has data and
+// did not lose packets, before the access unit is reset. A parse error
+// drops the access unit.
+func (a *AccessUnit) Assemble(pkt *Packet, parse func(payload []byte) error, emit func()) error {
+	lost := a.loss.Lost(pkt.SequenceNumber)
+
+	if a.started && pkt.Timestamp != a.TS {
+		if lost {
+			// The lost packets held at least the marker of the previous
+			// access unit.
+			a.reset()
+		} else {
+			a.end(emit)
+		}
+	}
+
+	if lost {
+		a.Drop()
+	}
+
+	a.TS, a.started = pkt.Timestamp, true
+
+	if err := parse(pkt.Payload); err != nil {
+		a.Drop()
+
+		return err
+	}
+
+	if pkt.Marker {
+		a.end(emit)
+	}
+
+	return nil
+}
+
+// AddNALU appends nalu with a 4-byte length prefix.
+func (a *AccessUnit) AddNALU(nalu []byte) {
+	a.Data = append(a.Data, byte(len(nalu)>>24), byte(len(nalu)>>16), byte(len(nalu)>>8), byte(len(nalu)))
+	a.Data = append(a.Data, nalu...)
+}
+
+// Drop discards the access unit being assembled and marks it broken.
+func (a *AccessUnit) Drop() {
+	a.reset()
+	a.Broken = true
+}
+
+func (a *AccessUnit) end(emit func()) {
+	if !a.Broken && len(a.Data) > 0 {
+		emit()
+	}
+
+	a.reset()
+	a.started = false
+}
+
+func (a *AccessUnit) reset() {
+	a.Data, a.FU = a.Data[:0], nil
+	a.KeyFrame, a.ParamSet, a.Broken = false, false, false
+}
@@ -0,0 +1,139 @@
+// Package audio converts between av.Packets and RTP packets for the audio
+// payload formats that carry codec data as is: G.711 μ-law and A-law
+// (RFC 3551 PCMU and PCMA), big-endian 16-bit linear PCM (L16) and Opus
+// (RFC 7587).
+package audio
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/codec"
+	"github.com/vtpl1/avsdk/codec/pcm"
+)
+
+// Static payload types assigned by RFC 3551.
+const (
+	PayloadTypePCMU      = 0
+	PayloadTypePCMA      = 8
+	PayloadTypeL16Stereo = 10
+	PayloadTypeL16Mono   = 11
+)
+
+// OpusClockRate is the RTP clock rate of Opus, whatever the sample rate of
+// the encoder.
+const OpusClockRate = 48000
+
+// NewCodecData returns the codec of an RTP audio stream from its payload
+// type and rtpmap attribute value, such as "PCMA/8000" or "L16/16000/1". The
+// rtpmap may be empty for a static payload type.
+func NewCodecData(payloadType uint8, rtpmap string) (av.AudioCodecData, error) {
+	if rtpmap == "" {
+		switch payloadType {
+		case PayloadTypePCMU:
+			rtpmap = "PCMU/8000"
+		case PayloadTypePCMA:
+			rtpmap = "PCMA/8000"
+		case PayloadTypeL16Stereo:
+			rtpmap = "L16/44100/2"
+		case PayloadTypeL16Mono:
+			rtpmap = "L16/44100/1"
+		default:
+			return nil, ErrUnsupportedEncoding
+		}
+	}
+
+	if _, after, ok := strings.Cut(rtpmap, " "); ok {
+		rtpmap = after
+	}
+
+	fields := strings.Split(strings.TrimSpace(rtpmap), "/")
+	if len(fields) < 2 {
+		return nil, ErrUnsupportedEncoding
+	}
+
+	rate, err := strconv.Atoi(fields[1])
+	if err != nil || rate <= 0 {
+		return nil, ErrUnsupportedEncoding
+	}
+
+	channels := 1
+	if len(fields) > 2 {
+		if channels, err = strconv.Atoi(fields[2]); err != nil {
+			return nil, ErrUnsupportedEncoding
+		}
+	}
+
+	switch strings.ToUpper(fields[0]) {
+	case "PCMU":
+		if rate == 8000 && channels == 1 {
+			return pcm.NewPCMMulawCodecData(), nil
+		}
+	case "PCMA":
+		if rate == 8000 && channels == 1 {
+			return pcm.NewPCMAlawCodecData(), nil
+		}
+	case "L16":
+		if channels == 1 || channels == 2 {
+			return pcm.PCMCodecData{
+				Typ:        av.PCM,
+				SmplFormat: av.S16,
+				SmplRate:   rate,
+				ChLayout:   [...]av.ChannelLayout{av.ChMono, av.ChStereo}[channels-1],
+			}, nil
+		}
+	case "OPUS":
+		if rate == OpusClockRate {
+			return codec.NewOpusCodecData(OpusClockRate, av.ChStereo), nil
+		}
+	}
+
+	return nil, ErrUnsupportedEncoding
+}
+
+// RTPMap returns the rtpmap attribute value describing an RTP stream of
+// codecData, without the payload type, such as "PCMA/8000".
+func RTPMap(codecData av.AudioCodecData) (string, error) {
+	switch codecData.Type() {
+	case av.PCM_MULAW:
+		return "PCMU/8000", nil
+	case av.PCM_ALAW:
+		return "PCMA/8000", nil
+	case av.PCM:
+		return fmt.Sprintf("L16/%d/%d", codecData.SampleRate(), codecData.ChannelLayout().Count()), nil
+	case av.OPUS:
+		return "opus/48000/2", nil
+	}
+
+	return "", ErrUnsupportedCodec
+}
+
+// sampleSize returns the number of bytes taken by one sample of all
+// channels of codecData, or 0 for Opus, whose packets are not sample-based.
+func sampleSize(codecData av.AudioCodecData) (int, error) {
+	switch codecData.Type() {
+	case av.PCM_MULAW, av.PCM_ALAW:
+		if codecData.SampleRate() > 0 {
+			return codecData.ChannelLayout().Count(), nil
+		}
+	case av.PCM:
+		if codecData.SampleRate() > 0 && codecData.SampleFormat() == av.S16 {
+			return 2 * codecData.ChannelLayout().Count(), nil
+		}
+	case av.OPUS:
+		return 0, nil
+	}
+
+	return 0, ErrUnsupportedCodec
+}
+
+// clockRate returns the RTP clock rate of codecData.
+func clockRate(codecData av.AudioCodecData) int {
+	if codecData.Type() == av.OPUS {
+		return OpusClockRate
+	}
+
+	return codecData.SampleRate()
+}
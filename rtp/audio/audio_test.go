@@ -0,0 +1,178 @@
+package audio_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/codec"
+	"github.com/vtpl1/avsdk/codec/aacparser"
+	"github.com/vtpl1/avsdk/rtp"
+	"github.com/vtpl1/avsdk/rtp/audio"
+)
+
+func TestNewCodecData(t *testing.T) {
+	for _, tt := range []struct {
+		pt       uint8
+		rtpmap   string
+		typ      av.CodecType
+		rate     int
+		channels int
+	}{
+		{0, "", av.PCM_MULAW, 8000, 1},
+		{8, "", av.PCM_ALAW, 8000, 1},
+		{11, "", av.PCM, 44100, 1},
+		{96, "96 L16/16000/2", av.PCM, 16000, 2},
+		{97, "pcma/8000", av.PCM_ALAW, 8000, 1},
+		{111, "opus/48000/2", av.OPUS, 48000, 2},
+	} {
+		codecData, err := audio.NewCodecData(tt.pt, tt.rtpmap)
+		if err != nil {
+			t.Fatalf("%d %q: %v", tt.pt, tt.rtpmap, err)
+		}
+
+		if codecData.Type() != tt.typ || codecData.SampleRate() != tt.rate || codecData.ChannelLayout().Count() != tt.channels {
+			t.Fatalf("%d %q: codec = %+v", tt.pt, tt.rtpmap, codecData)
+		}
+
+		if tt.rtpmap != "" {
+			continue
+		}
+
+		rtpmap, err := audio.RTPMap(codecData)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if again, err := audio.NewCodecData(96, rtpmap); err != nil || again != codecData {
+			t.Fatalf("%q: codec = %+v, %v", rtpmap, again, err)
+		}
+	}
+
+	if _, err := audio.NewCodecData(96, "G726-32/8000"); !errors.Is(err, audio.ErrUnsupportedEncoding) {
+		t.Fatalf("err = %v, want ErrUnsupportedEncoding", err)
+	}
+
+	if _, err := audio.NewDepacketizer(0, aacparser.CodecData{}); !errors.Is(err, audio.ErrUnsupportedCodec) {
+		t.Fatalf("err = %v, want ErrUnsupportedCodec", err)
+	}
+}
+
+// roundTrip packetizes pkts and depacketizes the result.
+func roundTrip(t *testing.T, codecData av.AudioCodecData, pkts []av.Packet, opts ...audio.PacketizerOption) ([]*rtp.Packet, []av.Packet) {
+	t.Helper()
+
+	p, err := audio.NewPacketizer(96, 0xcafe, codecData, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := audio.NewDepacketizer(4, codecData)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rtpPkts []*rtp.Packet
+
+	for _, pkt := range pkts {
+		out, err := p.Packetize(pkt)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rtpPkts = append(rtpPkts, out...)
+	}
+
+	rtpPkts = append(rtpPkts, p.Flush()...)
+
+	var out []av.Packet
+
+	for _, pkt := range rtpPkts {
+		got, err := d.Depacketize(pkt)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		out = append(out, got...)
+	}
+
+	return rtpPkts, out
+}
+
+func TestG711PTime(t *testing.T) {
+	codecData, _ := audio.NewCodecData(audio.PayloadTypePCMA, "")
+
+	// 100 ms of talkback audio in 64 ms chunks, sent 40 ms per packet.
+	samples := make([]byte, 800)
+	for i := range samples {
+		samples[i] = byte(i)
+	}
+
+	rtpPkts, out := roundTrip(t, codecData, []av.Packet{
+		{DTS: time.Second, Data: samples[:512]},
+		{DTS: time.Second + 64*time.Millisecond, Data: samples[512:]},
+	}, audio.WithPTime(40*time.Millisecond))
+
+	if len(rtpPkts) != 3 || !rtpPkts[0].Marker || rtpPkts[1].Marker || rtpPkts[1].Timestamp-rtpPkts[0].Timestamp != 320 {
+		t.Fatalf("got %d RTP packets", len(rtpPkts))
+	}
+
+	var data []byte
+
+	for i, pkt := range out {
+		want := []time.Duration{40, 40, 20}[i] * time.Millisecond
+		if pkt.Idx != 4 || pkt.CodecType != av.PCM_ALAW || pkt.Duration != want || pkt.DTS != time.Duration(i)*40*time.Millisecond {
+			t.Fatalf("packet %d: %v", i, pkt.String())
+		}
+
+		data = append(data, pkt.Data...)
+	}
+
+	if !bytes.Equal(data, samples) {
+		t.Fatal("samples changed")
+	}
+}
+
+func TestL16MTU(t *testing.T) {
+	codecData, _ := audio.NewCodecData(96, "L16/48000/2")
+
+	// 20 ms of 48 kHz stereo takes 3840 bytes, more than fits in 1200.
+	rtpPkts, out := roundTrip(t, codecData, []av.Packet{{Data: make([]byte, 3840)}})
+
+	if len(rtpPkts) != 4 || len(rtpPkts[0].Payload) != 1188 || len(rtpPkts[3].Payload) != 3840-3*1188 {
+		t.Fatalf("got %d RTP packets", len(rtpPkts))
+	}
+
+	if out[1].DTS != 297*time.Second/48000 || out[3].Duration != 69*time.Second/48000 {
+		t.Fatalf("packets = %v, %v", out[1].String(), out[3].String())
+	}
+
+	p, _ := audio.NewPacketizer(96, 1, codecData)
+	if _, err := p.Packetize(av.Packet{Data: make([]byte, 3)}); !errors.Is(err, audio.ErrInvalidPayload) {
+		t.Fatalf("err = %v, want ErrInvalidPayload", err)
+	}
+}
+
+func TestOpus(t *testing.T) {
+	codecData := codec.NewOpusCodecData(48000, av.ChStereo)
+
+	// A 20 ms CELT frame, then two 10 ms SILK frames in one packet.
+	frames := [][]byte{{0xfc, 1, 2, 3}, {0x01, 4, 5}}
+
+	rtpPkts, out := roundTrip(t, codecData, []av.Packet{
+		{DTS: 0, Data: frames[0]},
+		{DTS: 20 * time.Millisecond, Data: frames[1]},
+	}, audio.WithPTime(60*time.Millisecond))
+
+	if len(rtpPkts) != 2 || rtpPkts[1].Timestamp-rtpPkts[0].Timestamp != 960 {
+		t.Fatalf("got %d RTP packets", len(rtpPkts))
+	}
+
+	for i, pkt := range out {
+		if !bytes.Equal(pkt.Data, frames[i]) || pkt.Duration != 20*time.Millisecond || pkt.DTS != time.Duration(i)*20*time.Millisecond {
+			t.Fatalf("packet %d: %v", i, pkt.String())
+		}
+	}
+}
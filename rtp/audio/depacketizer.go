@@ -0,0 +1,86 @@
+package audio
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/internal/ticks"
+	"github.com/vtpl1/avsdk/rtp"
+)
+
+// Depacketizer returns the payload of every RTP packet as an av.Packet. The
+// duration of G.711 and L16 packets follows from their sample count, that of
+// Opus packets from their TOC byte. It implements rtp.Depacketizer.
+type Depacketizer struct {
+	idx        uint16
+	codec      av.AudioCodecData
+	sampleSize int
+	clock      *rtp.Clock
+	frameID    int64
+}
+
+// NewDepacketizer returns a Depacketizer producing packets for stream idx,
+// whose codec is typically obtained from NewCodecData.
+func NewDepacketizer(idx uint16, codecData av.AudioCodecData) (*Depacketizer, error) {
+	size, err := sampleSize(codecData)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Depacketizer{
+		idx:        idx,
+		codec:      codecData,
+		sampleSize: size,
+		clock:      rtp.NewClock(clockRate(codecData)),
+	}, nil
+}
+
+// Codec returns the stream codec.
+func (d *Depacketizer) Codec() av.CodecData {
+	return d.codec
+}
+
+// Depacketize implements rtp.Depacketizer.
+func (d *Depacketizer) Depacketize(pkt *rtp.Packet) ([]av.Packet, error) {
+	if len(pkt.Payload) == 0 {
+		return nil, nil
+	}
+
+	var (
+		duration time.Duration
+		err      error
+	)
+
+	if d.sampleSize == 0 {
+		duration, err = d.codec.PacketDuration(pkt.Payload)
+	} else {
+		duration, err = samplesDuration(len(pkt.Payload), d.sampleSize, d.codec.SampleRate())
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	out := av.Packet{
+		KeyFrame:  true,
+		Idx:       d.idx,
+		DTS:       d.clock.Duration(pkt.Timestamp),
+		Duration:  duration,
+		Data:      bytes.Clone(pkt.Payload),
+		FrameID:   d.frameID,
+		CodecType: d.codec.Type(),
+	}
+	d.frameID++
+
+	return []av.Packet{out}, nil
+}
+
+// samplesDuration returns the duration of size bytes of samples.
+func samplesDuration(size, sampleSize, sampleRate int) (time.Duration, error) {
+	if size%sampleSize != 0 {
+		return 0, ErrInvalidPayload
+	}
+
+	return ticks.ToDuration(int64(size/sampleSize), int64(sampleRate)), nil
+}
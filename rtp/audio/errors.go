@@ -0,0 +1,10 @@
+package audio
+
+import "errors"
+
+var (
+	ErrUnsupportedCodec    = errors.New("audio: unsupported codec")
+	ErrUnsupportedEncoding = errors.New("audio: unsupported RTP encoding")
+	ErrInvalidPayload      = errors.New("audio: invalid RTP payload")
+	ErrMTUTooSmall         = errors.New("audio: MTU too small")
+)
@@ -0,0 +1,138 @@
+package audio
+
+import (
+	"time"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/internal/ticks"
+	"github.com/vtpl1/avsdk/rtp"
+)
+
+// DefaultPTime is the default duration of the audio in a G.711 or L16 RTP
+// packet.
+const DefaultPTime = 20 * time.Millisecond
+
+// PacketizerOption configures a Packetizer.
+type PacketizerOption func(*Packetizer)
+
+// WithMTU sets the maximum size of an RTP packet, header included. The
+// default is rtp.DefaultMTU.
+func WithMTU(mtu int) PacketizerOption {
+	return func(p *Packetizer) {
+		p.mtu = mtu
+	}
+}
+
+// WithPTime sets the duration of the audio in a G.711 or L16 RTP packet, as
+// announced by the ptime attribute. The default is DefaultPTime. Packets are
+// shortened to fit the MTU.
+func WithPTime(ptime time.Duration) PacketizerOption {
+	return func(p *Packetizer) {
+		p.ptime = ptime
+	}
+}
+
+// Packetizer converts audio packets into RTP packets. G.711 and L16 samples
+// are buffered and sent ptime at a time, whatever the size of the packets
+// they came in; the timestamp of each RTP packet is derived from the samples
+// sent before it. Opus packets are sent one per RTP packet. The marker bit
+// is set on the first packet. It implements rtp.Packetizer.
+type Packetizer struct {
+	seq        *rtp.Sequencer
+	codec      av.AudioCodecData
+	sampleSize int
+	mtu        int
+	ptime      time.Duration
+	buf        []byte
+	bufDTS     time.Duration // of the first sample buffered since the buffer was last empty
+	sent       int           // samples sent since then
+	started    bool
+}
+
+// NewPacketizer returns a Packetizer for a stream of codecData.
+func NewPacketizer(payloadType uint8, ssrc uint32, codecData av.AudioCodecData, opts ...PacketizerOption) (*Packetizer, error) {
+	size, err := sampleSize(codecData)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Packetizer{
+		seq:        rtp.NewSequencer(payloadType, ssrc, clockRate(codecData)),
+		codec:      codecData,
+		sampleSize: size,
+		mtu:        rtp.DefaultMTU,
+		ptime:      DefaultPTime,
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p, nil
+}
+
+// Packetize implements rtp.Packetizer. Samples that do not fill a packet
+// are kept for the next call, or for Flush.
+func (p *Packetizer) Packetize(pkt av.Packet) ([]*rtp.Packet, error) {
+	maxPayload := p.mtu - rtp.HeaderSize
+
+	if p.sampleSize == 0 {
+		if len(pkt.Data) > maxPayload {
+			return nil, ErrMTUTooSmall
+		}
+
+		return []*rtp.Packet{p.packet(pkt.Data, pkt.DTS+pkt.PTSOffset)}, nil
+	}
+
+	if len(pkt.Data)%p.sampleSize != 0 {
+		return nil, ErrInvalidPayload
+	}
+
+	samples := min(int(ticks.FromDuration(p.ptime, int64(p.codec.SampleRate()))), maxPayload/p.sampleSize)
+	if samples < 1 {
+		return nil, ErrMTUTooSmall
+	}
+
+	if len(p.buf) == 0 {
+		p.bufDTS, p.sent = pkt.DTS+pkt.PTSOffset, 0
+	}
+
+	p.buf = append(p.buf, pkt.Data...)
+
+	var pkts []*rtp.Packet
+
+	for len(p.buf) >= samples*p.sampleSize {
+		pkts = append(pkts, p.next(samples*p.sampleSize))
+	}
+
+	return pkts, nil
+}
+
+// Flush returns a packet holding the samples kept by Packetize, if any.
+func (p *Packetizer) Flush() []*rtp.Packet {
+	if len(p.buf) == 0 {
+		return nil
+	}
+
+	return []*rtp.Packet{p.next(len(p.buf))}
+}
+
+// next returns a packet of the first size bytes of buffered samples.
+func (p *Packetizer) next(size int) *rtp.Packet {
+	offset := ticks.ToDuration(int64(p.sent), int64(p.codec.SampleRate()))
+	pkt := p.packet(p.buf[:size:size], p.bufDTS+offset)
+	p.sent += size / p.sampleSize
+
+	if p.buf = p.buf[size:]; len(p.buf) == 0 {
+		p.buf = nil
+	}
+
+	return pkt
+}
+
+func (p *Packetizer) packet(payload []byte, pts time.Duration) *rtp.Packet {
+	pkt := p.seq.Packet(payload, pts, !p.started)
+	p.started = true
+
+	return pkt
+}
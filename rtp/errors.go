@@ -0,0 +1,8 @@
+package rtp
+
+import "errors"
+
+var (
+	ErrPacketTooShort = errors.New("rtp: packet too short")
+	ErrInvalidVersion = errors.New("rtp: unsupported version")
+)
@@ -0,0 +1,169 @@
+package h264
+
+import (
+	"bytes"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/codec/h264parser"
+	"github.com/vtpl1/avsdk/rtp"
+	"github.com/vtpl1/avsdk/utils/bits/pio"
+)
+
+// Depacketizer assembles access units from single NAL unit, STAP-A and FU-A
+// packets. An access unit ends at the marker bit, or when the timestamp
+// changes. Access units that lost packets are dropped. Packets carry AVCC
+// data; in-band SPS and PPS that differ from the current codec are reported
+// through Packet.NewCodecs. It implements rtp.Depacketizer.
+type Depacketizer struct {
+	idx     uint16
+	codec   *h264parser.CodecData
+	sps     []byte
+	pps     []byte
+	clock   *rtp.Clock
+	au      rtp.AccessUnit
+	frameID int64
+}
+
+// NewDepacketizer returns a Depacketizer producing packets for stream idx.
+// codec, usually from codec.SdpToCodecs, may be nil when the parameter sets
+// are only sent in-band.
+func NewDepacketizer(idx uint16, codec av.CodecData) *Depacketizer {
+	d := &Depacketizer{
+		idx:   idx,
+		clock: rtp.NewClock(ClockRate),
+	}
+
+	if codec, ok := codec.(h264parser.CodecData); ok {
+		d.codec = &codec
+		d.sps, d.pps = codec.SPS(), codec.PPS()
+	}
+
+	return d
+}
+
+// Codec returns the current codec, or nil before the first SPS and PPS.
+func (d *Depacketizer) Codec() av.CodecData {
+	if d.codec == nil {
+		return nil
+	}
+
+	return *d.codec
+}
+
+// Depacketize implements rtp.Depacketizer.
+func (d *Depacketizer) Depacketize(pkt *rtp.Packet) ([]av.Packet, error) {
+	var out []av.Packet
+
+	err := d.au.Assemble(pkt, d.parse, func() {
+		out = d.appendPacket(out)
+	})
+
+	return out, err
+}
+
+func (d *Depacketizer) parse(payload []byte) error {
+	if len(payload) < 1 {
+		return ErrInvalidPayload
+	}
+
+	switch typ := payload[0] & 0x1f; {
+	case typ >= 1 && typ <= 23:
+		d.addNALU(payload)
+	case typ == naluTypeSTAPA:
+		for b := payload[1:]; len(b) > 0; {
+			if len(b) < 2 {
+				return ErrInvalidPayload
+			}
+
+			size := int(pio.U16BE(b))
+			if size == 0 || 2+size > len(b) {
+				return ErrInvalidPayload
+			}
+
+			d.addNALU(b[2 : 2+size])
+			b = b[2+size:]
+		}
+	case typ == naluTypeFUA:
+		if len(payload) < 3 {
+			return ErrInvalidPayload
+		}
+
+		start, end := payload[1]&0x80 != 0, payload[1]&0x40 != 0
+
+		switch {
+		case start:
+			d.au.FU = append(d.au.FU[:0], payload[0]&0xe0|payload[1]&0x1f)
+		case d.au.FU == nil:
+			// The start fragment was lost.
+			d.au.Broken = true
+
+			return nil
+		}
+
+		d.au.FU = append(d.au.FU, payload[2:]...)
+
+		if end {
+			d.addNALU(d.au.FU)
+			d.au.FU = nil
+		}
+	default:
+		return ErrUnsupportedPayload
+	}
+
+	return nil
+}
+
+func (d *Depacketizer) addNALU(nalu []byte) {
+	switch {
+	case av.H264NaluType(nalu[0])&av.H264NALTypeMask == av.H264_NAL_AUD:
+		return
+	case h264parser.IsSPSNALU(nalu):
+		d.sps, d.au.ParamSet = bytes.Clone(nalu), true
+	case h264parser.IsPPSNALU(nalu):
+		d.pps, d.au.ParamSet = bytes.Clone(nalu), true
+	case h264parser.IsKeyFrame(nalu):
+		d.au.KeyFrame = true
+	}
+
+	d.au.AddNALU(nalu)
+}
+
+// appendPacket appends the completed access unit to out, unless the codec
+// is not known yet.
+func (d *Depacketizer) appendPacket(out []av.Packet) []av.Packet {
+	var newCodecs []av.Stream
+
+	if d.au.ParamSet && d.codecChanged() {
+		codec, err := h264parser.NewCodecDataFromSPSAndPPS(d.sps, d.pps)
+		if err == nil {
+			d.codec = &codec
+			newCodecs = []av.Stream{{Idx: d.idx, Codec: codec}}
+		}
+	}
+
+	if d.codec == nil {
+		return out
+	}
+
+	pkt := av.Packet{
+		KeyFrame:       d.au.KeyFrame,
+		IsParamSetNALU: d.au.ParamSet,
+		Idx:            d.idx,
+		DTS:            d.clock.Duration(d.au.TS),
+		Data:           bytes.Clone(d.au.Data),
+		FrameID:        d.frameID,
+		CodecType:      av.H264,
+		NewCodecs:      newCodecs,
+	}
+	d.frameID++
+
+	return append(out, pkt)
+}
+
+func (d *Depacketizer) codecChanged() bool {
+	if d.sps == nil || d.pps == nil {
+		return false
+	}
+
+	return d.codec == nil || !bytes.Equal(d.codec.SPS(), d.sps) || !bytes.Equal(d.codec.PPS(), d.pps)
+}
@@ -0,0 +1,204 @@
+package h264_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/codec/h264parser"
+	"github.com/vtpl1/avsdk/internal/avtest"
+	"github.com/vtpl1/avsdk/rtp"
+	"github.com/vtpl1/avsdk/rtp/h264"
+)
+
+const (
+	naluTypeSTAPA = 24
+)
+
+func avcc(nalus ...[]byte) []byte {
+	var b []byte
+	for _, nalu := range nalus {
+		b = binary.BigEndian.AppendUint32(b, uint32(len(nalu)))
+		b = append(b, nalu...)
+	}
+
+	return b
+}
+
+func stapA(nalus ...[]byte) []byte {
+	b := []byte{naluTypeSTAPA}
+	for _, nalu := range nalus {
+		b = binary.BigEndian.AppendUint16(b, uint16(len(nalu)))
+		b = append(b, nalu...)
+	}
+
+	return b
+}
+
+// fuA splits nalu into FU-A payloads of at most size bytes of NAL unit data.
+func fuA(nalu []byte, size int) [][]byte {
+	var out [][]byte
+
+	for data := nalu[1:]; len(data) > 0; {
+		n := min(size, len(data))
+		header := nalu[0] & 0x1f
+
+		if len(out) == 0 {
+			header |= 0x80
+		}
+
+		if n == len(data) {
+			header |= 0x40
+		}
+
+		out = append(out, append([]byte{nalu[0]&0xe0 | 28, header}, data[:n]...))
+		data = data[n:]
+	}
+
+	return out
+}
+
+// sender numbers the RTP packets it feeds to a Depacketizer.
+type sender struct {
+	t    *testing.T
+	d    *h264.Depacketizer
+	seq  uint16
+	pkts []av.Packet
+}
+
+func (s *sender) send(ts uint32, marker bool, payloads ...[]byte) {
+	s.t.Helper()
+
+	for i, payload := range payloads {
+		pkt := &rtp.Packet{
+			Marker:         marker && i == len(payloads)-1,
+			PayloadType:    96,
+			SequenceNumber: s.seq,
+			Timestamp:      ts,
+			Payload:        payload,
+		}
+		s.seq++
+
+		out, err := s.d.Depacketize(pkt)
+		if err != nil {
+			s.t.Fatal(err)
+		}
+
+		s.pkts = append(s.pkts, out...)
+	}
+}
+
+func TestDepacketizer(t *testing.T) {
+	codec := avtest.H264(t, avtest.SPS320x192)
+
+	s := &sender{t: t, d: h264.NewDepacketizer(2, codec), seq: 65530}
+
+	idr := append([]byte{0x65, 0x88}, bytes.Repeat([]byte{0xab}, 2500)...)
+	base := uint32(0xffff0000)
+
+	// Parameter sets in a STAP-A, then a fragmented IDR slice.
+	s.send(base, true, append([][]byte{stapA(avtest.Unhex(avtest.SPS320x192), avtest.Unhex(avtest.PPS))}, fuA(idr, 1000)...)...)
+	s.send(base+3600, true, []byte{0x41, 0x9a, 0x01})
+
+	// A fragment of the third access unit is lost.
+	fragments := fuA(append([]byte{0x41, 0x9a}, bytes.Repeat([]byte{0xcd}, 1500)...), 500)
+	s.send(base+7200, false, fragments[0])
+	s.seq++
+	s.send(base+7200, true, fragments[2:]...)
+
+	// Without a marker bit the access unit ends when the timestamp changes.
+	s.send(base+10800, false, []byte{0x09, 0xf0}, []byte{0x41, 0x9a, 0x03})
+
+	// The camera switches resolution in-band.
+	s.send(base+14400, true, avtest.Unhex(avtest.SPS1280x720), avtest.Unhex(avtest.PPS), []byte{0x65, 0x88, 0x04})
+
+	if len(s.pkts) != 4 {
+		t.Fatalf("got %d packets, want 4", len(s.pkts))
+	}
+
+	first := s.pkts[0]
+	if !first.KeyFrame || !first.IsParamSetNALU || first.Idx != 2 || first.DTS != 0 || first.NewCodecs != nil ||
+		!bytes.Equal(first.Data, avcc(avtest.Unhex(avtest.SPS320x192), avtest.Unhex(avtest.PPS), idr)) {
+		t.Fatalf("first packet: %v", first.String())
+	}
+
+	for i, want := range []struct {
+		dts  time.Duration
+		key  bool
+		data []byte
+	}{
+		{40 * time.Millisecond, false, avcc([]byte{0x41, 0x9a, 0x01})},
+		{120 * time.Millisecond, false, avcc([]byte{0x41, 0x9a, 0x03})},
+	} {
+		pkt := s.pkts[i+1]
+		if pkt.DTS != want.dts || pkt.KeyFrame != want.key || pkt.IsParamSetNALU || !bytes.Equal(pkt.Data, want.data) {
+			t.Fatalf("packet %d: %v", i+1, pkt.String())
+		}
+	}
+
+	last := s.pkts[3]
+	if last.DTS != 160*time.Millisecond || !last.KeyFrame || len(last.NewCodecs) != 1 {
+		t.Fatalf("last packet: %v", last.String())
+	}
+
+	if video := last.NewCodecs[0].Codec.(h264parser.CodecData); video.Width() != 1280 || video.Height() != 720 {
+		t.Fatalf("new codec = %dx%d", video.Width(), video.Height())
+	}
+
+	if s.d.Codec().(h264parser.CodecData).Width() != 1280 {
+		t.Fatal("Codec not updated")
+	}
+}
+
+func TestDepacketizerLostMarker(t *testing.T) {
+	codec := avtest.H264(t, avtest.SPS320x192)
+
+	s := &sender{t: t, d: h264.NewDepacketizer(0, codec)}
+
+	// The packet holding the marker of the second access unit is lost, and
+	// so is the first packet of the fifth. Only complete units are emitted.
+	s.send(0, true, []byte{0x41, 0x9a, 0x00})
+	s.send(3600, false, []byte{0x41, 0x9a, 0x01})
+	s.seq++
+	s.send(7200, true, []byte{0x41, 0x9a, 0x02})
+	s.send(10800, true, []byte{0x41, 0x9a, 0x03})
+	s.seq++
+	s.send(14400, true, []byte{0x41, 0x9a, 0x04})
+	s.send(18000, true, []byte{0x41, 0x9a, 0x05})
+
+	if len(s.pkts) != 3 {
+		t.Fatalf("got %d packets, want 3", len(s.pkts))
+	}
+
+	for i, want := range []struct {
+		dts  time.Duration
+		data []byte
+	}{
+		{0, avcc([]byte{0x41, 0x9a, 0x00})},
+		{10800 * time.Second / 90000, avcc([]byte{0x41, 0x9a, 0x03})},
+		{18000 * time.Second / 90000, avcc([]byte{0x41, 0x9a, 0x05})},
+	} {
+		if pkt := s.pkts[i]; pkt.DTS != want.dts || !bytes.Equal(pkt.Data, want.data) {
+			t.Fatalf("packet %d: %v", i, pkt.String())
+		}
+	}
+}
+
+func TestDepacketizerInBandOnly(t *testing.T) {
+	s := &sender{t: t, d: h264.NewDepacketizer(0, nil)}
+
+	s.send(0, true, []byte{0x41, 0x9a, 0x00})
+	s.send(3000, true, stapA(avtest.Unhex(avtest.SPS320x192), avtest.Unhex(avtest.PPS), []byte{0x65, 0x88}))
+
+	if len(s.pkts) != 1 || !s.pkts[0].KeyFrame || len(s.pkts[0].NewCodecs) != 1 {
+		t.Fatalf("packets = %+v", s.pkts)
+	}
+
+	_, err := s.d.Depacketize(&rtp.Packet{SequenceNumber: s.seq, Payload: []byte{25, 0, 0}})
+	if !errors.Is(err, h264.ErrUnsupportedPayload) {
+		t.Fatalf("err = %v, want ErrUnsupportedPayload", err)
+	}
+}
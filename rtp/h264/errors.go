@@ -0,0 +1,9 @@
+package h264
+
+import "errors"
+
+var (
+	ErrInvalidPayload     = errors.New("h264: invalid RTP payload")
+	ErrUnsupportedPayload = errors.New("h264: unsupported NAL unit type")
+	ErrMTUTooSmall        = errors.New("h264: MTU too small")
+)
@@ -0,0 +1,11 @@
+// Package h264 converts between H.264 access units and RTP packets (RFC 6184).
+package h264
+
+// NAL unit types used by the RTP payload format.
+const (
+	naluTypeSTAPA = 24
+	naluTypeFUA   = 28
+)
+
+// ClockRate is the RTP clock rate of H.264.
+const ClockRate = 90000
@@ -0,0 +1,188 @@
+package h264
+
+import (
+	"encoding/binary"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/codec/h264parser"
+	"github.com/vtpl1/avsdk/codec/parser"
+	"github.com/vtpl1/avsdk/rtp"
+)
+
+// PacketizerOption configures a Packetizer.
+type PacketizerOption func(*Packetizer)
+
+// WithMTU sets the maximum size of an RTP packet, header included. The
+// default is rtp.DefaultMTU.
+func WithMTU(mtu int) PacketizerOption {
+	return func(p *Packetizer) {
+		p.mtu = mtu
+	}
+}
+
+// Packetizer splits AVCC or Annex-B access units into single NAL unit,
+// STAP-A and FU-A packets that fit the MTU. The SPS and PPS of the codec are
+// sent before every keyframe that lacks them, and the marker bit is set on
+// the last packet of each access unit. It implements rtp.Packetizer.
+type Packetizer struct {
+	seq   *rtp.Sequencer
+	codec h264parser.CodecData
+	mtu   int
+}
+
+// NewPacketizer returns a Packetizer for a stream described by codec.
+func NewPacketizer(payloadType uint8, ssrc uint32, codec h264parser.CodecData, opts ...PacketizerOption) *Packetizer {
+	p := &Packetizer{
+		seq:   rtp.NewSequencer(payloadType, ssrc, ClockRate),
+		codec: codec,
+		mtu:   rtp.DefaultMTU,
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+// Packetize implements rtp.Packetizer. A codec in pkt.NewCodecs for pkt.Idx
+// replaces the parameter sets sent before keyframes. The RTP timestamp is
+// the presentation time.
+func (p *Packetizer) Packetize(pkt av.Packet) ([]*rtp.Packet, error) {
+	for _, stream := range pkt.NewCodecs {
+		if codec, ok := stream.Codec.(h264parser.CodecData); ok && stream.Idx == pkt.Idx {
+			p.codec = codec
+		}
+	}
+
+	maxPayload := p.mtu - rtp.HeaderSize
+	if maxPayload < 3 {
+		return nil, ErrMTUTooSmall
+	}
+
+	payloads := aggregate(p.accessUnit(pkt), maxPayload)
+
+	pkts := make([]*rtp.Packet, len(payloads))
+	for i, payload := range payloads {
+		pkts[i] = p.seq.Packet(payload, pkt.DTS+pkt.PTSOffset, i == len(payloads)-1)
+	}
+
+	return pkts, nil
+}
+
+// accessUnit returns the NAL units of pkt without access unit delimiters,
+// preceded by the codec parameter sets if pkt is a keyframe without them.
+func (p *Packetizer) accessUnit(pkt av.Packet) [][]byte {
+	nalus, _ := parser.SplitNALUs(pkt.Data)
+	keyFrame, hasSPS, hasPPS := pkt.KeyFrame, false, false
+
+	au := make([][]byte, 0, len(nalus)+2)
+
+	for _, nalu := range nalus {
+		switch {
+		case len(nalu) == 0, av.H264NaluType(nalu[0])&av.H264NALTypeMask == av.H264_NAL_AUD:
+			continue
+		case h264parser.IsSPSNALU(nalu):
+			hasSPS = true
+		case h264parser.IsPPSNALU(nalu):
+			hasPPS = true
+		case h264parser.IsKeyFrame(nalu):
+			keyFrame = true
+		}
+
+		au = append(au, nalu)
+	}
+
+	if !keyFrame || (hasSPS && hasPPS) || len(p.codec.RecordInfo.SPS) == 0 || len(p.codec.RecordInfo.PPS) == 0 {
+		return au
+	}
+
+	return append([][]byte{p.codec.SPS(), p.codec.PPS()}, au...)
+}
+
+// aggregate packs consecutive NAL units that fit together into STAP-A
+// payloads and fragments those larger than maxPayload into FU-A payloads.
+func aggregate(nalus [][]byte, maxPayload int) [][]byte {
+	var (
+		payloads [][]byte
+		group    [][]byte
+		size     = 1 // STAP-A header
+	)
+
+	flush := func() {
+		switch len(group) {
+		case 0:
+		case 1:
+			payloads = append(payloads, group[0])
+		default:
+			payloads = append(payloads, stapA(group, size))
+		}
+
+		group, size = nil, 1
+	}
+
+	for _, nalu := range nalus {
+		if len(nalu) > maxPayload {
+			flush()
+			payloads = append(payloads, fuA(nalu, maxPayload)...)
+
+			continue
+		}
+
+		if size+2+len(nalu) > maxPayload {
+			flush()
+		}
+
+		group = append(group, nalu)
+		size += 2 + len(nalu)
+	}
+
+	flush()
+
+	return payloads
+}
+
+// stapA aggregates nalus into a STAP-A payload of size bytes. Its F bit is
+// set if any NAL unit has it, and its NRI is the highest of the NAL units.
+func stapA(nalus [][]byte, size int) []byte {
+	b := make([]byte, 1, size)
+
+	var forbidden, nri byte
+
+	for _, nalu := range nalus {
+		forbidden |= nalu[0] & 0x80
+		nri = max(nri, nalu[0]&0x60)
+
+		b = binary.BigEndian.AppendUint16(b, uint16(len(nalu)))
+		b = append(b, nalu...)
+	}
+
+	b[0] = forbidden | nri | naluTypeSTAPA
+
+	return b
+}
+
+// fuA fragments nalu into FU-A payloads of at most maxPayload bytes.
+func fuA(nalu []byte, maxPayload int) [][]byte {
+	var payloads [][]byte
+
+	indicator := nalu[0]&0xe0 | naluTypeFUA
+
+	for data := nalu[1:]; len(data) > 0; {
+		n := min(maxPayload-2, len(data))
+		header := nalu[0] & 0x1f
+
+		if len(payloads) == 0 {
+			header |= 0x80
+		}
+
+		if n == len(data) {
+			header |= 0x40
+		}
+
+		payloads = append(payloads, append([]byte{indicator, header}, data[:n]...))
+		data = data[n:]
+	}
+
+	return payloads
+}
@@ -0,0 +1,73 @@
+package h264_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/internal/avtest"
+	"github.com/vtpl1/avsdk/rtp"
+	"github.com/vtpl1/avsdk/rtp/h264"
+)
+
+func TestPacketizerRoundTrip(t *testing.T) {
+	codec := avtest.H264(t, avtest.SPS320x192)
+
+	p := h264.NewPacketizer(96, 0x1234, codec, h264.WithMTU(500))
+	d := h264.NewDepacketizer(0, codec)
+
+	idr := append([]byte{0x65, 0x88}, bytes.Repeat([]byte{0x77}, 1400)...)
+	in := []av.Packet{
+		// Annex-B keyframe without parameter sets.
+		{KeyFrame: true, Data: append([]byte{0, 0, 0, 1, 0x09, 0xf0, 0, 0, 1}, idr...)},
+		// AVCC frame with two small slices, sent as one STAP-A.
+		{DTS: 40 * time.Millisecond, PTSOffset: 40 * time.Millisecond, Data: avcc([]byte{0x41, 0x9a, 1}, []byte{0x41, 0x9b, 2})},
+	}
+
+	var (
+		rtpPkts []*rtp.Packet
+		out     []av.Packet
+	)
+
+	for _, pkt := range in {
+		pkts, err := p.Packetize(pkt)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i, rp := range pkts {
+			if len(rp.Marshal()) > 500 || rp.Marker != (i == len(pkts)-1) || rp.PayloadType != 96 || rp.SSRC != 0x1234 {
+				t.Fatalf("RTP packet %d: %d bytes, marker %v", i, len(rp.Marshal()), rp.Marker)
+			}
+
+			got, err := d.Depacketize(rp)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			out = append(out, got...)
+		}
+
+		rtpPkts = append(rtpPkts, pkts...)
+	}
+
+	// STAP-A (SPS, PPS), three FU-A fragments, one STAP-A.
+	if len(rtpPkts) != 5 || rtpPkts[0].Payload[0]&0x1f != 24 || rtpPkts[1].Payload[0]&0x1f != 28 || rtpPkts[4].Payload[0]&0x1f != 24 {
+		t.Fatalf("got %d RTP packets", len(rtpPkts))
+	}
+
+	if rtpPkts[4].Timestamp-rtpPkts[0].Timestamp != 7200 || rtpPkts[1].SequenceNumber != rtpPkts[0].SequenceNumber+1 {
+		t.Fatalf("timestamps %d, %d", rtpPkts[0].Timestamp, rtpPkts[4].Timestamp)
+	}
+
+	if len(out) != 2 || !out[0].KeyFrame || !out[0].IsParamSetNALU ||
+		!bytes.Equal(out[0].Data, avcc(avtest.Unhex(avtest.SPS320x192), avtest.Unhex(avtest.PPS), idr)) || !bytes.Equal(out[1].Data, in[1].Data) {
+		t.Fatalf("depacketized = %+v", out)
+	}
+
+	if _, err := h264.NewPacketizer(96, 0, codec, h264.WithMTU(14)).Packetize(in[1]); !errors.Is(err, h264.ErrMTUTooSmall) {
+		t.Fatalf("err = %v, want ErrMTUTooSmall", err)
+	}
+}
@@ -0,0 +1,213 @@
+package h265
+
+import (
+	"bytes"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/codec/h265parser"
+	"github.com/vtpl1/avsdk/rtp"
+	"github.com/vtpl1/avsdk/utils/bits/pio"
+)
+
+// Option configures a Depacketizer.
+type Option func(*Depacketizer)
+
+// WithDONL declares that payloads carry decoding order numbers, as signalled
+// by a non-zero sprop-max-don-diff in the SDP. The numbers are skipped;
+// NAL units are used in transmission order.
+func WithDONL() Option {
+	return func(d *Depacketizer) {
+		d.donl = true
+	}
+}
+
+// Depacketizer assembles access units from single NAL unit, aggregation (AP)
+// and fragmentation (FU) packets. An access unit ends at the marker bit, or
+// when the timestamp changes. Access units that lost packets are dropped.
+// Packets carry AVCC data; in-band VPS, SPS and PPS that differ from the
+// current codec are reported through Packet.NewCodecs. It implements
+// rtp.Depacketizer.
+type Depacketizer struct {
+	idx     uint16
+	donl    bool
+	codec   *h265parser.CodecData
+	vps     []byte
+	sps     []byte
+	pps     []byte
+	clock   *rtp.Clock
+	au      rtp.AccessUnit
+	frameID int64
+}
+
+// NewDepacketizer returns a Depacketizer producing packets for stream idx.
+// codec, usually from codec.SdpToCodecs, may be nil when the parameter sets
+// are only sent in-band.
+func NewDepacketizer(idx uint16, codec av.CodecData, opts ...Option) *Depacketizer {
+	d := &Depacketizer{
+		idx:   idx,
+		clock: rtp.NewClock(ClockRate),
+	}
+
+	if codec, ok := codec.(h265parser.CodecData); ok {
+		d.codec = &codec
+		d.vps, d.sps, d.pps = codec.VPS(), codec.SPS(), codec.PPS()
+	}
+
+	for _, opt := range opts {
+		opt(d)
+	}
+
+	return d
+}
+
+// Codec returns the current codec, or nil before the first VPS, SPS and PPS.
+func (d *Depacketizer) Codec() av.CodecData {
+	if d.codec == nil {
+		return nil
+	}
+
+	return *d.codec
+}
+
+// Depacketize implements rtp.Depacketizer.
+func (d *Depacketizer) Depacketize(pkt *rtp.Packet) ([]av.Packet, error) {
+	var out []av.Packet
+
+	err := d.au.Assemble(pkt, d.parse, func() {
+		out = d.appendPacket(out)
+	})
+
+	return out, err
+}
+
+func (d *Depacketizer) parse(payload []byte) error {
+	if len(payload) < 3 {
+		return ErrInvalidPayload
+	}
+
+	donl := 0
+	if d.donl {
+		donl = 2
+	}
+
+	switch typ := payload[0] >> 1 & 0x3f; {
+	case typ < naluTypeAP:
+		if len(payload) < 3+donl {
+			return ErrInvalidPayload
+		}
+
+		if donl == 0 {
+			d.addNALU(payload)
+		} else {
+			d.addNALU(append(payload[:2:2], payload[2+donl:]...))
+		}
+	case typ == naluTypeAP:
+		for b, first := payload[2:], true; len(b) > 0; first = false {
+			// DONL precedes the first NAL unit, a one-byte DOND the others.
+			skip := donl
+			if !first && donl > 0 {
+				skip = 1
+			}
+
+			if len(b) < skip+2 {
+				return ErrInvalidPayload
+			}
+
+			size := int(pio.U16BE(b[skip:]))
+			if size < 2 || skip+2+size > len(b) {
+				return ErrInvalidPayload
+			}
+
+			d.addNALU(b[skip+2 : skip+2+size])
+			b = b[skip+2+size:]
+		}
+	case typ == naluTypeFU:
+		start, end := payload[2]&0x80 != 0, payload[2]&0x40 != 0
+		data := payload[3:]
+
+		switch {
+		case start:
+			// DONL is only present in the first fragment.
+			if len(data) < donl {
+				return ErrInvalidPayload
+			}
+
+			data = data[donl:]
+			d.au.FU = append(d.au.FU[:0], payload[0]&0x81|(payload[2]&0x3f)<<1, payload[1])
+		case d.au.FU == nil:
+			// The start fragment was lost.
+			d.au.Broken = true
+
+			return nil
+		}
+
+		d.au.FU = append(d.au.FU, data...)
+
+		if end {
+			d.addNALU(d.au.FU)
+			d.au.FU = nil
+		}
+	default:
+		return ErrUnsupportedPayload
+	}
+
+	return nil
+}
+
+func (d *Depacketizer) addNALU(nalu []byte) {
+	switch {
+	case av.H265NaluType(nalu[0]>>1)&av.H265NALTypeMask == av.HEVC_NAL_AUD:
+		return
+	case h265parser.IsVPSNALU(nalu):
+		d.vps, d.au.ParamSet = bytes.Clone(nalu), true
+	case h265parser.IsSPSNALU(nalu):
+		d.sps, d.au.ParamSet = bytes.Clone(nalu), true
+	case h265parser.IsPPSNALU(nalu):
+		d.pps, d.au.ParamSet = bytes.Clone(nalu), true
+	case h265parser.IsKeyFrame(nalu):
+		d.au.KeyFrame = true
+	}
+
+	d.au.AddNALU(nalu)
+}
+
+// appendPacket appends the completed access unit to out, unless the codec
+// is not known yet.
+func (d *Depacketizer) appendPacket(out []av.Packet) []av.Packet {
+	var newCodecs []av.Stream
+
+	if d.au.ParamSet && d.codecChanged() {
+		codec, err := h265parser.NewCodecDataFromVPSAndSPSAndPPS(d.vps, d.sps, d.pps)
+		if err == nil {
+			d.codec = &codec
+			newCodecs = []av.Stream{{Idx: d.idx, Codec: codec}}
+		}
+	}
+
+	if d.codec == nil {
+		return out
+	}
+
+	pkt := av.Packet{
+		KeyFrame:       d.au.KeyFrame,
+		IsParamSetNALU: d.au.ParamSet,
+		Idx:            d.idx,
+		DTS:            d.clock.Duration(d.au.TS),
+		Data:           bytes.Clone(d.au.Data),
+		FrameID:        d.frameID,
+		CodecType:      av.H265,
+		NewCodecs:      newCodecs,
+	}
+	d.frameID++
+
+	return append(out, pkt)
+}
+
+func (d *Depacketizer) codecChanged() bool {
+	if d.vps == nil || d.sps == nil || d.pps == nil {
+		return false
+	}
+
+	return d.codec == nil || !bytes.Equal(d.codec.VPS(), d.vps) ||
+		!bytes.Equal(d.codec.SPS(), d.sps) || !bytes.Equal(d.codec.PPS(), d.pps)
+}
@@ -0,0 +1,206 @@
+package h265_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/codec/h265parser"
+	"github.com/vtpl1/avsdk/internal/avtest"
+	"github.com/vtpl1/avsdk/rtp"
+	"github.com/vtpl1/avsdk/rtp/h265"
+)
+
+func avcc(nalus ...[]byte) []byte {
+	var b []byte
+	for _, nalu := range nalus {
+		b = binary.BigEndian.AppendUint32(b, uint32(len(nalu)))
+		b = append(b, nalu...)
+	}
+
+	return b
+}
+
+// ap builds an aggregation packet; with donl set every NAL unit is preceded
+// by a decoding order number field.
+func ap(donl bool, nalus ...[]byte) []byte {
+	b := []byte{48 << 1, 1}
+
+	for i, nalu := range nalus {
+		switch {
+		case donl && i == 0:
+			b = append(b, 0, 7)
+		case donl:
+			b = append(b, 0)
+		}
+
+		b = binary.BigEndian.AppendUint16(b, uint16(len(nalu)))
+		b = append(b, nalu...)
+	}
+
+	return b
+}
+
+// fu splits nalu into fragmentation units of at most size bytes of data.
+func fu(donl bool, nalu []byte, size int) [][]byte {
+	var out [][]byte
+
+	typ := nalu[0] >> 1 & 0x3f
+
+	for data := nalu[2:]; len(data) > 0; {
+		n := min(size, len(data))
+		payload := []byte{49<<1 | nalu[0]&0x81, nalu[1], typ}
+
+		if len(out) == 0 {
+			payload[2] |= 0x80
+			if donl {
+				payload = append(payload, 0, 8)
+			}
+		}
+
+		if n == len(data) {
+			payload[2] |= 0x40
+		}
+
+		out = append(out, append(payload, data[:n]...))
+		data = data[n:]
+	}
+
+	return out
+}
+
+// sender numbers the RTP packets it feeds to a Depacketizer.
+type sender struct {
+	t    *testing.T
+	d    *h265.Depacketizer
+	seq  uint16
+	pkts []av.Packet
+}
+
+func (s *sender) send(ts uint32, marker bool, payloads ...[]byte) {
+	s.t.Helper()
+
+	for i, payload := range payloads {
+		pkt := &rtp.Packet{
+			Marker:         marker && i == len(payloads)-1,
+			PayloadType:    96,
+			SequenceNumber: s.seq,
+			Timestamp:      ts,
+			Payload:        payload,
+		}
+		s.seq++
+
+		out, err := s.d.Depacketize(pkt)
+		if err != nil {
+			s.t.Fatal(err)
+		}
+
+		s.pkts = append(s.pkts, out...)
+	}
+}
+
+func TestDepacketizerDONL(t *testing.T) {
+	s := &sender{t: t, d: h265.NewDepacketizer(1, nil, h265.WithDONL())}
+
+	idr := append([]byte{0x26, 0x01, 0xaf}, bytes.Repeat([]byte{0x5a}, 3000)...)
+
+	s.send(1000, false, ap(true, avtest.Unhex(avtest.H265VPS), avtest.Unhex(avtest.H265SPS), avtest.Unhex(avtest.H265PPS)))
+	s.send(1000, true, fu(true, idr, 1200)...)
+	s.send(4000, true, []byte{0x02, 0x01, 0, 9, 0xd0, 0x01})
+
+	if len(s.pkts) != 2 {
+		t.Fatalf("got %d packets, want 2", len(s.pkts))
+	}
+
+	first := s.pkts[0]
+	if !first.KeyFrame || !first.IsParamSetNALU || first.Idx != 1 || first.CodecType != av.H265 || len(first.NewCodecs) != 1 ||
+		!bytes.Equal(first.Data, avcc(avtest.Unhex(avtest.H265VPS), avtest.Unhex(avtest.H265SPS), avtest.Unhex(avtest.H265PPS), idr)) {
+		t.Fatalf("first packet: %v", first.String())
+	}
+
+	if codec, ok := first.NewCodecs[0].Codec.(h265parser.CodecData); !ok || codec.Width() == 0 {
+		t.Fatalf("new codec = %+v", first.NewCodecs[0].Codec)
+	}
+
+	second := s.pkts[1]
+	if second.KeyFrame || second.DTS != 3000*time.Second/90000 || !bytes.Equal(second.Data, avcc([]byte{0x02, 0x01, 0xd0, 0x01})) {
+		t.Fatalf("second packet: %v", second.String())
+	}
+}
+
+func TestDepacketizerParameterSetChange(t *testing.T) {
+	codec := avtest.H265(t)
+
+	s := &sender{t: t, d: h265.NewDepacketizer(0, codec)}
+
+	// Repeating the SDP parameter sets is not a change.
+	s.send(0, true, ap(false, avtest.Unhex(avtest.H265VPS), avtest.Unhex(avtest.H265SPS), avtest.Unhex(avtest.H265PPS)), []byte{0x26, 0x01, 0xaf, 0x00})
+
+	// The second access unit loses its middle fragment.
+	fragments := fu(false, append([]byte{0x02, 0x01}, bytes.Repeat([]byte{0x11}, 300)...), 100)
+	s.send(3000, false, fragments[0])
+	s.seq++
+	s.send(3000, true, fragments[2])
+
+	newPPS := avtest.Unhex("4401c172b09c1b0de241")
+	s.send(6000, true, ap(false, avtest.Unhex(avtest.H265VPS), avtest.Unhex(avtest.H265SPS), newPPS), []byte{0x26, 0x01, 0xaf, 0x01})
+
+	if len(s.pkts) != 2 {
+		t.Fatalf("got %d packets, want 2", len(s.pkts))
+	}
+
+	if s.pkts[0].NewCodecs != nil || !s.pkts[0].KeyFrame {
+		t.Fatalf("first packet: %v", s.pkts[0].String())
+	}
+
+	last := s.pkts[1]
+	if last.DTS != 6000*time.Second/90000 || len(last.NewCodecs) != 1 {
+		t.Fatalf("last packet: %v", last.String())
+	}
+
+	if got := last.NewCodecs[0].Codec.(h265parser.CodecData).PPS(); !bytes.Equal(got, newPPS) {
+		t.Fatalf("new PPS = %x", got)
+	}
+
+	_, err := s.d.Depacketize(&rtp.Packet{SequenceNumber: s.seq, Payload: []byte{50 << 1, 1, 0, 0}})
+	if !errors.Is(err, h265.ErrUnsupportedPayload) {
+		t.Fatalf("err = %v, want ErrUnsupportedPayload", err)
+	}
+}
+
+func TestDepacketizerLostMarker(t *testing.T) {
+	codec := avtest.H265(t)
+
+	s := &sender{t: t, d: h265.NewDepacketizer(0, codec)}
+
+	// The packet holding the marker of the second access unit is lost, and
+	// so is the first packet of the fifth. Only complete units are emitted.
+	s.send(0, true, []byte{0x02, 0x01, 0xd0, 0x00})
+	s.send(3000, false, []byte{0x02, 0x01, 0xd0, 0x01})
+	s.seq++
+	s.send(6000, true, []byte{0x02, 0x01, 0xd0, 0x02})
+	s.send(9000, true, []byte{0x02, 0x01, 0xd0, 0x03})
+	s.seq++
+	s.send(12000, true, []byte{0x02, 0x01, 0xd0, 0x04})
+	s.send(15000, true, []byte{0x02, 0x01, 0xd0, 0x05})
+
+	if len(s.pkts) != 3 {
+		t.Fatalf("got %d packets, want 3", len(s.pkts))
+	}
+
+	for i, want := range []struct {
+		dts  time.Duration
+		data []byte
+	}{
+		{0, avcc([]byte{0x02, 0x01, 0xd0, 0x00})},
+		{9000 * time.Second / 90000, avcc([]byte{0x02, 0x01, 0xd0, 0x03})},
+		{15000 * time.Second / 90000, avcc([]byte{0x02, 0x01, 0xd0, 0x05})},
+	} {
+		if pkt := s.pkts[i]; pkt.DTS != want.dts || !bytes.Equal(pkt.Data, want.data) {
+			t.Fatalf("packet %d: %v", i, pkt.String())
+		}
+	}
+}
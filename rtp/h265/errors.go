@@ -0,0 +1,9 @@
+package h265
+
+import "errors"
+
+var (
+	ErrInvalidPayload     = errors.New("h265: invalid RTP payload")
+	ErrUnsupportedPayload = errors.New("h265: unsupported NAL unit type")
+	ErrMTUTooSmall        = errors.New("h265: MTU too small")
+)
@@ -0,0 +1,11 @@
+// Package h265 converts between H.265 access units and RTP packets (RFC 7798).
+package h265
+
+// NAL unit types used by the RTP payload format.
+const (
+	naluTypeAP = 48
+	naluTypeFU = 49
+)
+
+// ClockRate is the RTP clock rate of H.265.
+const ClockRate = 90000
@@ -0,0 +1,193 @@
+package h265
+
+import (
+	"encoding/binary"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/codec/h265parser"
+	"github.com/vtpl1/avsdk/codec/parser"
+	"github.com/vtpl1/avsdk/rtp"
+)
+
+// PacketizerOption configures a Packetizer.
+type PacketizerOption func(*Packetizer)
+
+// WithMTU sets the maximum size of an RTP packet, header included. The
+// default is rtp.DefaultMTU.
+func WithMTU(mtu int) PacketizerOption {
+	return func(p *Packetizer) {
+		p.mtu = mtu
+	}
+}
+
+// Packetizer splits AVCC or Annex-B access units into single NAL unit,
+// aggregation (AP) and fragmentation (FU) packets that fit the MTU, without
+// DONL. The VPS, SPS and PPS of the codec are sent before every keyframe
+// that lacks them, and the marker bit is set on the last packet of each
+// access unit. It implements rtp.Packetizer.
+type Packetizer struct {
+	seq   *rtp.Sequencer
+	codec h265parser.CodecData
+	mtu   int
+}
+
+// NewPacketizer returns a Packetizer for a stream described by codec.
+func NewPacketizer(payloadType uint8, ssrc uint32, codec h265parser.CodecData, opts ...PacketizerOption) *Packetizer {
+	p := &Packetizer{
+		seq:   rtp.NewSequencer(payloadType, ssrc, ClockRate),
+		codec: codec,
+		mtu:   rtp.DefaultMTU,
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+// Packetize implements rtp.Packetizer. A codec in pkt.NewCodecs for pkt.Idx
+// replaces the parameter sets sent before keyframes. The RTP timestamp is
+// the presentation time.
+func (p *Packetizer) Packetize(pkt av.Packet) ([]*rtp.Packet, error) {
+	for _, stream := range pkt.NewCodecs {
+		if codec, ok := stream.Codec.(h265parser.CodecData); ok && stream.Idx == pkt.Idx {
+			p.codec = codec
+		}
+	}
+
+	maxPayload := p.mtu - rtp.HeaderSize
+	if maxPayload < 4 {
+		return nil, ErrMTUTooSmall
+	}
+
+	payloads := aggregate(p.accessUnit(pkt), maxPayload)
+
+	pkts := make([]*rtp.Packet, len(payloads))
+	for i, payload := range payloads {
+		pkts[i] = p.seq.Packet(payload, pkt.DTS+pkt.PTSOffset, i == len(payloads)-1)
+	}
+
+	return pkts, nil
+}
+
+// accessUnit returns the NAL units of pkt without access unit delimiters,
+// preceded by the codec parameter sets if pkt is a keyframe without them.
+func (p *Packetizer) accessUnit(pkt av.Packet) [][]byte {
+	nalus, _ := parser.SplitNALUs(pkt.Data)
+	keyFrame, hasVPS, hasSPS, hasPPS := pkt.KeyFrame, false, false, false
+
+	au := make([][]byte, 0, len(nalus)+3)
+
+	for _, nalu := range nalus {
+		switch {
+		case len(nalu) < 2, av.H265NaluType(nalu[0]>>1)&av.H265NALTypeMask == av.HEVC_NAL_AUD:
+			continue
+		case h265parser.IsVPSNALU(nalu):
+			hasVPS = true
+		case h265parser.IsSPSNALU(nalu):
+			hasSPS = true
+		case h265parser.IsPPSNALU(nalu):
+			hasPPS = true
+		case h265parser.IsKeyFrame(nalu):
+			keyFrame = true
+		}
+
+		au = append(au, nalu)
+	}
+
+	info := p.codec.RecordInfo
+	if !keyFrame || (hasVPS && hasSPS && hasPPS) || len(info.VPS) == 0 || len(info.SPS) == 0 || len(info.PPS) == 0 {
+		return au
+	}
+
+	return append([][]byte{p.codec.VPS(), p.codec.SPS(), p.codec.PPS()}, au...)
+}
+
+// aggregate packs consecutive NAL units that fit together into AP payloads
+// and fragments those larger than maxPayload into FU payloads.
+func aggregate(nalus [][]byte, maxPayload int) [][]byte {
+	var (
+		payloads [][]byte
+		group    [][]byte
+		size     = 2 // AP header
+	)
+
+	flush := func() {
+		switch len(group) {
+		case 0:
+		case 1:
+			payloads = append(payloads, group[0])
+		default:
+			payloads = append(payloads, ap(group, size))
+		}
+
+		group, size = nil, 2
+	}
+
+	for _, nalu := range nalus {
+		if len(nalu) > maxPayload {
+			flush()
+			payloads = append(payloads, fu(nalu, maxPayload)...)
+
+			continue
+		}
+
+		if size+2+len(nalu) > maxPayload {
+			flush()
+		}
+
+		group = append(group, nalu)
+		size += 2 + len(nalu)
+	}
+
+	flush()
+
+	return payloads
+}
+
+// ap aggregates nalus into an AP payload of size bytes. Its F bit is set if
+// any NAL unit has it; its layer ID and temporal ID are the lowest of the
+// NAL units.
+func ap(nalus [][]byte, size int) []byte {
+	b := make([]byte, 2, size)
+
+	forbidden, layerAndTID := byte(0), uint16(0xffff)
+
+	for _, nalu := range nalus {
+		forbidden |= nalu[0] & 0x80
+		layerAndTID = min(layerAndTID, binary.BigEndian.Uint16(nalu)&0x01ff)
+
+		b = binary.BigEndian.AppendUint16(b, uint16(len(nalu)))
+		b = append(b, nalu...)
+	}
+
+	binary.BigEndian.PutUint16(b, uint16(forbidden)<<8|naluTypeAP<<9|layerAndTID)
+
+	return b
+}
+
+// fu fragments nalu into FU payloads of at most maxPayload bytes.
+func fu(nalu []byte, maxPayload int) [][]byte {
+	var payloads [][]byte
+
+	typ := nalu[0] >> 1 & 0x3f
+
+	for data := nalu[2:]; len(data) > 0; {
+		n := min(maxPayload-3, len(data))
+		header := typ
+
+		if len(payloads) == 0 {
+			header |= 0x80
+		}
+
+		if n == len(data) {
+			header |= 0x40
+		}
+
+		payloads = append(payloads, append([]byte{nalu[0]&0x81 | naluTypeFU<<1, nalu[1], header}, data[:n]...))
+		data = data[n:]
+	}
+
+	return payloads
+}
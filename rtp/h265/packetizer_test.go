@@ -0,0 +1,54 @@
+package h265_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/internal/avtest"
+	"github.com/vtpl1/avsdk/rtp/h265"
+)
+
+func TestPacketizerRoundTrip(t *testing.T) {
+	codec := avtest.H265(t)
+
+	p := h265.NewPacketizer(97, 1, codec, h265.WithMTU(300))
+	d := h265.NewDepacketizer(0, nil)
+
+	idr := append([]byte{0x26, 0x01, 0xaf}, bytes.Repeat([]byte{0x42}, 1000)...)
+
+	var out []av.Packet
+
+	for _, pkt := range []av.Packet{
+		{KeyFrame: true, Data: avcc(idr)},
+		{Data: append([]byte{0, 0, 1}, 0x02, 0x01, 0xd0)},
+	} {
+		pkts, err := p.Packetize(pkt)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i, rp := range pkts {
+			if len(rp.Marshal()) > 300 || rp.Marker != (i == len(pkts)-1) {
+				t.Fatalf("RTP packet %d: %d bytes, marker %v", i, len(rp.Marshal()), rp.Marker)
+			}
+
+			if i == 0 && pkt.KeyFrame && rp.Payload[0]>>1 != 48 {
+				t.Fatalf("parameter sets not aggregated: type %d", rp.Payload[0]>>1)
+			}
+
+			got, err := d.Depacketize(rp)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			out = append(out, got...)
+		}
+	}
+
+	if len(out) != 2 || !out[0].KeyFrame || len(out[0].NewCodecs) != 1 ||
+		!bytes.Equal(out[0].Data, avcc(avtest.Unhex(avtest.H265VPS), avtest.Unhex(avtest.H265SPS), avtest.Unhex(avtest.H265PPS), idr)) ||
+		!bytes.Equal(out[1].Data, avcc([]byte{0x02, 0x01, 0xd0})) {
+		t.Fatalf("depacketized = %+v", out)
+	}
+}
@@ -0,0 +1,201 @@
+package jpeg
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/codec/mjpeg"
+	"github.com/vtpl1/avsdk/rtp"
+	"github.com/vtpl1/avsdk/utils/bits/pio"
+)
+
+// Depacketizer rebuilds JPEG images from RTP/JPEG packets. Fragments are
+// placed by their fragment offset and a frame ends at the marker bit; frames
+// that lost packets are dropped. Quantization tables come from the packets,
+// from earlier frames with the same Q value, or are computed for Q values
+// from 1 to 99. A change of picture size is reported through
+// Packet.NewCodecs. It implements rtp.Depacketizer.
+type Depacketizer struct {
+	idx     uint16
+	codec   *mjpeg.CodecData
+	clock   *rtp.Clock
+	loss    rtp.LossDetector
+	tables  map[byte]quantization // by Q value
+	header  []byte
+	scan    []byte
+	width   int
+	height  int
+	ts      uint32
+	dts     time.Duration
+	started bool
+	frameID int64
+}
+
+// NewDepacketizer returns a Depacketizer producing packets for stream idx.
+func NewDepacketizer(idx uint16) *Depacketizer {
+	return &Depacketizer{
+		idx:    idx,
+		clock:  rtp.NewClock(ClockRate),
+		tables: make(map[byte]quantization),
+	}
+}
+
+// Codec returns the current codec, or nil before the first frame.
+func (d *Depacketizer) Codec() av.CodecData {
+	if d.codec == nil {
+		return nil
+	}
+
+	return *d.codec
+}
+
+// Depacketize implements rtp.Depacketizer.
+func (d *Depacketizer) Depacketize(pkt *rtp.Packet) ([]av.Packet, error) {
+	if d.loss.Lost(pkt.SequenceNumber) || (d.started && pkt.Timestamp != d.ts) {
+		d.started = false
+	}
+
+	b := pkt.Payload
+	if len(b) < mainHeaderSize {
+		return nil, ErrInvalidPayload
+	}
+
+	offset := int(pio.U24BE(b[1:]))
+	h := frameHeader{
+		typ:    b[4],
+		width:  int(b[6]) * 8,
+		height: int(b[7]) * 8,
+	}
+	q := b[5]
+	b = b[mainHeaderSize:]
+
+	if h.typ >= typeRestart && h.typ < 2*typeRestart {
+		if len(b) < restartHeaderSize {
+			return nil, ErrInvalidPayload
+		}
+
+		h.typ -= typeRestart
+		h.restartInterval = pio.U16BE(b)
+		b = b[restartHeaderSize:]
+	}
+
+	if h.typ != type422 && h.typ != type420 {
+		return nil, ErrUnsupportedType
+	}
+
+	if offset == 0 {
+		var err error
+
+		if b, err = d.startFrame(&h, q, b); err != nil {
+			d.started = false
+
+			return nil, err
+		}
+
+		d.ts, d.dts, d.started = pkt.Timestamp, d.clock.Duration(pkt.Timestamp), true
+	}
+
+	if !d.started {
+		return nil, nil
+	}
+
+	if offset != len(d.scan) {
+		// Fragments were lost or reordered.
+		d.started = false
+
+		return nil, nil
+	}
+
+	d.scan = append(d.scan, b...)
+
+	if !pkt.Marker {
+		return nil, nil
+	}
+
+	d.started = false
+
+	return []av.Packet{d.frame()}, nil
+}
+
+// quantization holds the quantization tables of a Q value.
+type quantization struct {
+	precision byte
+	tables    []byte
+}
+
+// startFrame rebuilds the JPEG headers of a frame from its first packet and
+// returns the scan data of the packet.
+func (d *Depacketizer) startFrame(h *frameHeader, q byte, b []byte) ([]byte, error) {
+	if h.width == 0 || h.height == 0 || q == 0 {
+		return nil, ErrInvalidPayload
+	}
+
+	quant, ok := d.tables[q]
+
+	switch {
+	case q < 128:
+		if !ok {
+			quant = quantization{tables: makeTables(int(q))}
+			d.tables[q] = quant
+		}
+	case len(b) < quantHeaderSize:
+		return nil, ErrInvalidPayload
+	default:
+		size := int(pio.U16BE(b[2:]))
+		if len(b) < quantHeaderSize+size {
+			return nil, ErrInvalidPayload
+		}
+
+		if size > 0 || q == qDynamic {
+			quant = quantization{precision: b[1], tables: bytes.Clone(b[quantHeaderSize : quantHeaderSize+size])}
+		}
+
+		if q != qDynamic {
+			// Later frames may omit the tables of a static Q value.
+			d.tables[q] = quant
+		}
+
+		b = b[quantHeaderSize+size:]
+	}
+
+	h.precision, h.tables = quant.precision, quant.tables
+
+	header, err := h.appendHeaders(d.header[:0])
+	if err != nil {
+		return nil, err
+	}
+
+	d.header, d.scan = header, d.scan[:0]
+	d.width, d.height = h.width, h.height
+
+	return b, nil
+}
+
+// frame returns the image reassembled from the headers and scan data.
+func (d *Depacketizer) frame() av.Packet {
+	image := make([]byte, 0, len(d.header)+len(d.scan)+2)
+	image = append(image, d.header...)
+	image = append(image, d.scan...)
+
+	if !bytes.HasSuffix(image, []byte{0xff, markerEOI}) {
+		image = append(image, 0xff, markerEOI)
+	}
+
+	pkt := av.Packet{
+		KeyFrame:  true,
+		Idx:       d.idx,
+		DTS:       d.dts,
+		Data:      image,
+		FrameID:   d.frameID,
+		CodecType: av.MJPEG,
+	}
+	d.frameID++
+
+	if d.codec == nil || d.codec.PicWidth != d.width || d.codec.PicHeight != d.height {
+		d.codec = &mjpeg.CodecData{PicWidth: d.width, PicHeight: d.height}
+		pkt.NewCodecs = []av.Stream{{Idx: d.idx, Codec: *d.codec}}
+	}
+
+	return pkt
+}
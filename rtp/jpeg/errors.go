@@ -0,0 +1,10 @@
+package jpeg
+
+import "errors"
+
+var (
+	ErrInvalidPayload  = errors.New("jpeg: invalid RTP payload")
+	ErrUnsupportedType = errors.New("jpeg: unsupported RTP/JPEG type")
+	ErrUnsupportedJPEG = errors.New("jpeg: image not representable in RTP/JPEG")
+	ErrMTUTooSmall     = errors.New("jpeg: MTU too small")
+)
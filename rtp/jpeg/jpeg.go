@@ -0,0 +1,268 @@
+// Package jpeg converts between baseline JPEG images and RTP packets
+// (RFC 2435). The RTP payload carries only the entropy-coded scan data; the
+// JPEG headers are rebuilt by the receiver from the Type, Q, Width and
+// Height fields, the quantization tables and the standard Huffman tables.
+package jpeg
+
+import "encoding/binary"
+
+// PayloadType is the static RTP payload type of JPEG.
+const PayloadType = 26
+
+// ClockRate is the RTP clock rate of JPEG.
+const ClockRate = 90000
+
+// Sizes of the RTP/JPEG headers.
+const (
+	mainHeaderSize    = 8
+	restartHeaderSize = 4
+	quantHeaderSize   = 4
+)
+
+// Types of image in RTP/JPEG; typeRestart is added when the scan has
+// restart markers.
+const (
+	type422     = 0
+	type420     = 1
+	typeRestart = 64
+)
+
+// qDynamic is the Q value announcing quantization tables sent with every
+// frame.
+const qDynamic = 255
+
+// JPEG markers.
+const (
+	markerSOF0 = 0xc0
+	markerSOF1 = 0xc1
+	markerDHT  = 0xc4
+	markerSOI  = 0xd8
+	markerEOI  = 0xd9
+	markerSOS  = 0xda
+	markerDQT  = 0xdb
+	markerDRI  = 0xdd
+)
+
+// Quantization tables of ISO/IEC 10918-1 Annex K, in zig-zag order, which
+// RFC 2435 scales for Q values from 1 to 99.
+//
+//nolint:gochecknoglobals
+var (
+	lumaQuant = [64]byte{
+		16, 11, 12, 14, 12, 10, 16, 14,
+		13, 14, 18, 17, 16, 19, 24, 40,
+		26, 24, 22, 22, 24, 49, 35, 37,
+		29, 40, 58, 51, 61, 60, 57, 51,
+		56, 55, 64, 72, 92, 78, 64, 68,
+		87, 69, 55, 56, 80, 109, 81, 87,
+		95, 98, 103, 104, 103, 62, 77, 113,
+		121, 112, 100, 120, 92, 101, 103, 99,
+	}
+	chromaQuant = [64]byte{
+		17, 18, 18, 24, 21, 24, 47, 26,
+		26, 47, 99, 66, 56, 66, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+	}
+)
+
+// huffmanTable is a DHT table: its class and ID, its code counts by length
+// and its symbols.
+type huffmanTable struct {
+	classAndID byte
+	counts     [16]byte
+	symbols    []byte
+}
+
+// Huffman tables of ISO/IEC 10918-1 Annex K, the only ones RTP/JPEG allows.
+//
+//nolint:gochecknoglobals
+var huffmanTables = [...]huffmanTable{
+	{
+		0x00, // luminance DC
+		[16]byte{0, 1, 5, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0},
+		[]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	},
+	{
+		0x10, // luminance AC
+		[16]byte{0, 2, 1, 3, 3, 2, 4, 3, 5, 5, 4, 4, 0, 0, 1, 125},
+		[]byte{
+			0x01, 0x02, 0x03, 0x00, 0x04, 0x11, 0x05, 0x12,
+			0x21, 0x31, 0x41, 0x06, 0x13, 0x51, 0x61, 0x07,
+			0x22, 0x71, 0x14, 0x32, 0x81, 0x91, 0xa1, 0x08,
+			0x23, 0x42, 0xb1, 0xc1, 0x15, 0x52, 0xd1, 0xf0,
+			0x24, 0x33, 0x62, 0x72, 0x82, 0x09, 0x0a, 0x16,
+			0x17, 0x18, 0x19, 0x1a, 0x25, 0x26, 0x27, 0x28,
+			0x29, 0x2a, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39,
+			0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48, 0x49,
+			0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59,
+			0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69,
+			0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79,
+			0x7a, 0x83, 0x84, 0x85, 0x86, 0x87, 0x88, 0x89,
+			0x8a, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98,
+			0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7,
+			0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6,
+			0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3, 0xc4, 0xc5,
+			0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2, 0xd3, 0xd4,
+			0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda, 0xe1, 0xe2,
+			0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea,
+			0xf1, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	},
+	{
+		0x01, // chrominance DC
+		[16]byte{0, 3, 1, 1, 1, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0},
+		[]byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11},
+	},
+	{
+		0x11, // chrominance AC
+		[16]byte{0, 2, 1, 2, 4, 4, 3, 4, 7, 5, 4, 4, 0, 1, 2, 119},
+		[]byte{
+			0x00, 0x01, 0x02, 0x03, 0x11, 0x04, 0x05, 0x21,
+			0x31, 0x06, 0x12, 0x41, 0x51, 0x07, 0x61, 0x71,
+			0x13, 0x22, 0x32, 0x81, 0x08, 0x14, 0x42, 0x91,
+			0xa1, 0xb1, 0xc1, 0x09, 0x23, 0x33, 0x52, 0xf0,
+			0x15, 0x62, 0x72, 0xd1, 0x0a, 0x16, 0x24, 0x34,
+			0xe1, 0x25, 0xf1, 0x17, 0x18, 0x19, 0x1a, 0x26,
+			0x27, 0x28, 0x29, 0x2a, 0x35, 0x36, 0x37, 0x38,
+			0x39, 0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48,
+			0x49, 0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58,
+			0x59, 0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68,
+			0x69, 0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78,
+			0x79, 0x7a, 0x82, 0x83, 0x84, 0x85, 0x86, 0x87,
+			0x88, 0x89, 0x8a, 0x92, 0x93, 0x94, 0x95, 0x96,
+			0x97, 0x98, 0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5,
+			0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4,
+			0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3,
+			0xc4, 0xc5, 0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2,
+			0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda,
+			0xe2, 0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9,
+			0xea, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		},
+	},
+}
+
+// makeTables returns the luminance and chrominance quantization tables for
+// a Q value from 1 to 99 (RFC 2435 Appendix A).
+func makeTables(q int) []byte {
+	q = min(max(q, 1), 99)
+
+	factor := 200 - 2*q
+	if q < 50 {
+		factor = 5000 / q
+	}
+
+	tables := make([]byte, 128)
+
+	for i := range 64 {
+		tables[i] = byte(min(max((int(lumaQuant[i])*factor+50)/100, 1), 255))
+		tables[64+i] = byte(min(max((int(chromaQuant[i])*factor+50)/100, 1), 255))
+	}
+
+	return tables
+}
+
+// frameHeader holds what an RTP/JPEG receiver needs to rebuild the headers
+// of a frame.
+type frameHeader struct {
+	typ             byte // type422 or type420
+	width           int  // in pixels
+	height          int
+	restartInterval uint16
+	precision       byte // bit i set if table i has 16-bit entries
+	tables          []byte
+}
+
+// quantTables splits the quantization tables of h; chrominance uses the
+// luminance table if there is only one.
+func (h *frameHeader) quantTables() ([][]byte, error) {
+	var tables [][]byte
+
+	for b := h.tables; len(b) > 0; {
+		size := 64
+		if h.precision>>len(tables)&1 != 0 {
+			size = 128
+		}
+
+		if len(b) < size || len(tables) == 2 {
+			return nil, ErrInvalidPayload
+		}
+
+		tables, b = append(tables, b[:size]), b[size:]
+	}
+
+	if len(tables) == 0 {
+		return nil, ErrInvalidPayload
+	}
+
+	return tables, nil
+}
+
+// appendHeaders appends the JPEG headers of h, from SOI to SOS, to b.
+func (h *frameHeader) appendHeaders(b []byte) ([]byte, error) {
+	tables, err := h.quantTables()
+	if err != nil {
+		return nil, err
+	}
+
+	segment := func(marker byte, size int) {
+		b = append(b, 0xff, marker)
+		b = binary.BigEndian.AppendUint16(b, uint16(2+size))
+	}
+
+	b = append(b, 0xff, markerSOI)
+
+	size := 0
+	for _, table := range tables {
+		size += 1 + len(table)
+	}
+
+	segment(markerDQT, size)
+
+	for i, table := range tables {
+		b = append(b, byte(len(table)/128)<<4|byte(i))
+		b = append(b, table...)
+	}
+
+	chromaTable := byte(len(tables) - 1)
+	lumaSampling := byte(0x21)
+
+	if h.typ == type420 {
+		lumaSampling = 0x22
+	}
+
+	segment(markerSOF0, 15)
+	b = append(b, 8)
+	b = binary.BigEndian.AppendUint16(b, uint16(h.height))
+	b = binary.BigEndian.AppendUint16(b, uint16(h.width))
+	b = append(b, 3, 1, lumaSampling, 0, 2, 0x11, chromaTable, 3, 0x11, chromaTable)
+
+	size = 0
+	for _, table := range huffmanTables {
+		size += 17 + len(table.symbols)
+	}
+
+	segment(markerDHT, size)
+
+	for _, table := range huffmanTables {
+		b = append(b, table.classAndID)
+		b = append(b, table.counts[:]...)
+		b = append(b, table.symbols...)
+	}
+
+	if h.restartInterval != 0 {
+		segment(markerDRI, 2)
+		b = binary.BigEndian.AppendUint16(b, h.restartInterval)
+	}
+
+	segment(markerSOS, 10)
+	b = append(b, 3, 1, 0x00, 2, 0x11, 3, 0x11, 0, 63, 0)
+
+	return b, nil
+}
@@ -0,0 +1,216 @@
+package jpeg_test
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	stdjpeg "image/jpeg"
+	"testing"
+	"time"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/codec/mjpeg"
+	"github.com/vtpl1/avsdk/rtp"
+	"github.com/vtpl1/avsdk/rtp/jpeg"
+)
+
+// encode returns a 4:2:0 baseline JPEG image with a busy pattern, as
+// produced by the standard library with the standard Huffman tables.
+func encode(t *testing.T, width, height, quality int) []byte {
+	t.Helper()
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := range height {
+		for x := range width {
+			img.Set(x, y, color.RGBA{uint8(x * x * 7), uint8(y * y * 5), uint8(x * y * 3), 0xff})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := stdjpeg.Encode(&buf, img, &stdjpeg.Options{Quality: quality}); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+// sameImage reports whether two JPEG images decode to the same pixels.
+func sameImage(t *testing.T, a, b []byte) bool {
+	t.Helper()
+
+	imgA, err := stdjpeg.Decode(bytes.NewReader(a))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	imgB, err := stdjpeg.Decode(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if imgA.Bounds() != imgB.Bounds() {
+		return false
+	}
+
+	for y := imgA.Bounds().Min.Y; y < imgA.Bounds().Max.Y; y++ {
+		for x := imgA.Bounds().Min.X; x < imgA.Bounds().Max.X; x++ {
+			if imgA.At(x, y) != imgB.At(x, y) {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// scanData returns the entropy-coded data of a JPEG image.
+func scanData(image []byte) []byte {
+	i := bytes.Index(image, []byte{0xff, 0xda})
+	size := int(image[i+2])<<8 | int(image[i+3])
+
+	return bytes.TrimSuffix(image[i+2+size:], []byte{0xff, 0xd9})
+}
+
+func depacketize(t *testing.T, d *jpeg.Depacketizer, pkts []*rtp.Packet) []av.Packet {
+	t.Helper()
+
+	var out []av.Packet
+
+	for _, pkt := range pkts {
+		got, err := d.Depacketize(pkt)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		out = append(out, got...)
+	}
+
+	return out
+}
+
+func TestRoundTrip(t *testing.T) {
+	p := jpeg.NewPacketizer(jpeg.PayloadType, 0x5eed, jpeg.WithMTU(400))
+	d := jpeg.NewDepacketizer(2)
+
+	images := [][]byte{encode(t, 64, 48, 90), encode(t, 64, 48, 60), encode(t, 128, 96, 75)}
+
+	var pkts []*rtp.Packet
+
+	for i, image := range images {
+		frame, err := p.Packetize(av.Packet{DTS: time.Duration(i) * 40 * time.Millisecond, Data: image})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(frame) < 2 || !frame[len(frame)-1].Marker || frame[0].Marker {
+			t.Fatalf("image %d: %d packets", i, len(frame))
+		}
+
+		for _, pkt := range frame {
+			if len(pkt.Payload)+rtp.HeaderSize > 400 || pkt.PayloadType != jpeg.PayloadType {
+				t.Fatalf("image %d: %d byte payload", i, len(pkt.Payload))
+			}
+		}
+
+		pkts = append(pkts, frame...)
+	}
+
+	out := depacketize(t, d, pkts)
+	if len(out) != len(images) {
+		t.Fatalf("got %d images, want %d", len(out), len(images))
+	}
+
+	for i, pkt := range out {
+		if pkt.Idx != 2 || !pkt.KeyFrame || pkt.CodecType != av.MJPEG || pkt.DTS != time.Duration(i)*40*time.Millisecond {
+			t.Fatalf("image %d: %v", i, pkt.String())
+		}
+
+		if !sameImage(t, pkt.Data, images[i]) {
+			t.Fatalf("image %d differs", i)
+		}
+
+		if (i != 1) != (len(pkt.NewCodecs) == 1) {
+			t.Fatalf("image %d: NewCodecs = %v", i, pkt.NewCodecs)
+		}
+	}
+
+	if codec := d.Codec().(mjpeg.CodecData); codec.Width() != 128 || codec.Height() != 96 {
+		t.Fatalf("codec = %+v", codec)
+	}
+}
+
+func TestDepacketizerComputedTables(t *testing.T) {
+	// The standard library scales the Annex K tables as RFC 2435 does, so
+	// a Q of 75 rebuilds the tables of an image encoded with quality 75.
+	image := encode(t, 32, 16, 75)
+	scan := scanData(image)
+
+	d := jpeg.NewDepacketizer(0)
+	half := len(scan) / 2
+
+	pkts := []*rtp.Packet{
+		{SequenceNumber: 1, Timestamp: 9000, Payload: append([]byte{0, 0, 0, 0, 1, 75, 4, 2}, scan[:half]...)},
+		{SequenceNumber: 2, Timestamp: 9000, Marker: true, Payload: append([]byte{0, 0, byte(half >> 8), byte(half), 1, 75, 4, 2}, scan[half:]...)},
+	}
+
+	out := depacketize(t, d, pkts)
+	if len(out) != 1 || !sameImage(t, out[0].Data, image) {
+		t.Fatalf("got %d images", len(out))
+	}
+
+	width, height, err := mjpeg.ParseSOF(out[0].Data)
+	if err != nil || width != 32 || height != 16 {
+		t.Fatalf("SOF = %dx%d, %v", width, height, err)
+	}
+}
+
+func TestDepacketizerLoss(t *testing.T) {
+	p := jpeg.NewPacketizer(jpeg.PayloadType, 1, jpeg.WithMTU(300))
+	d := jpeg.NewDepacketizer(0)
+
+	image := encode(t, 64, 64, 80)
+
+	first, err := p.Packetize(av.Packet{Data: image})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := p.Packetize(av.Packet{DTS: 100 * time.Millisecond, Data: image})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The middle of the first image is lost.
+	pkts := append(append([]*rtp.Packet{first[0]}, first[2:]...), second...)
+
+	out := depacketize(t, d, pkts)
+	if len(out) != 1 || out[0].DTS != 100*time.Millisecond || !sameImage(t, out[0].Data, image) {
+		t.Fatalf("got %d images", len(out))
+	}
+}
+
+func TestPacketizerUnsupported(t *testing.T) {
+	var gray bytes.Buffer
+	if err := stdjpeg.Encode(&gray, image.NewGray(image.Rect(0, 0, 16, 16)), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	p := jpeg.NewPacketizer(jpeg.PayloadType, 1)
+	if _, err := p.Packetize(av.Packet{Data: gray.Bytes()}); !errors.Is(err, jpeg.ErrUnsupportedJPEG) {
+		t.Fatalf("err = %v, want ErrUnsupportedJPEG", err)
+	}
+
+	// Optimized Huffman tables cannot be signalled.
+	custom := encode(t, 16, 16, 75)
+	custom[bytes.Index(custom, []byte{0xff, 0xc4})+4+17] ^= 1
+
+	if _, err := p.Packetize(av.Packet{Data: custom}); !errors.Is(err, jpeg.ErrUnsupportedJPEG) {
+		t.Fatalf("err = %v, want ErrUnsupportedJPEG", err)
+	}
+
+	d := jpeg.NewDepacketizer(0)
+	if _, err := d.Depacketize(&rtp.Packet{Payload: []byte{0, 0, 0, 0, 3, 50, 8, 8, 0}}); !errors.Is(err, jpeg.ErrUnsupportedType) {
+		t.Fatalf("err = %v, want ErrUnsupportedType", err)
+	}
+}
@@ -0,0 +1,226 @@
+package jpeg
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/rtp"
+	"github.com/vtpl1/avsdk/utils/bits/pio"
+)
+
+// PacketizerOption configures a Packetizer.
+type PacketizerOption func(*Packetizer)
+
+// WithMTU sets the maximum size of an RTP packet, header included. The
+// default is rtp.DefaultMTU.
+func WithMTU(mtu int) PacketizerOption {
+	return func(p *Packetizer) {
+		p.mtu = mtu
+	}
+}
+
+// Packetizer splits baseline JPEG images into RTP/JPEG packets that fit the
+// MTU. Their quantization tables are sent with every frame (Q=255). Images
+// must be YCbCr with 4:2:2 or 4:2:0 chroma subsampling, at most 2040 pixels
+// wide and high, and use the standard Huffman tables, which cannot be
+// signalled; other images are rejected with ErrUnsupportedJPEG. The marker bit is set on the last packet of each image. It
+// implements rtp.Packetizer.
+type Packetizer struct {
+	seq *rtp.Sequencer
+	mtu int
+}
+
+// NewPacketizer returns a Packetizer, typically with payload type
+// PayloadType.
+func NewPacketizer(payloadType uint8, ssrc uint32, opts ...PacketizerOption) *Packetizer {
+	p := &Packetizer{
+		seq: rtp.NewSequencer(payloadType, ssrc, ClockRate),
+		mtu: rtp.DefaultMTU,
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+// Packetize implements rtp.Packetizer. The RTP timestamp is the
+// presentation time.
+func (p *Packetizer) Packetize(pkt av.Packet) ([]*rtp.Packet, error) {
+	h, scan, err := parseJPEG(pkt.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, mainHeaderSize, mainHeaderSize+restartHeaderSize)
+	header[4], header[5] = h.typ, qDynamic
+	header[6], header[7] = byte((h.width+7)/8), byte((h.height+7)/8)
+
+	if h.restartInterval != 0 {
+		header[4] += typeRestart
+		header = binary.BigEndian.AppendUint16(header, h.restartInterval)
+		header = append(header, 0xff, 0xff) // F=1, L=1, restart count 0x3fff
+	}
+
+	quant := []byte{0, h.precision}
+	quant = binary.BigEndian.AppendUint16(quant, uint16(len(h.tables)))
+	quant = append(quant, h.tables...)
+
+	maxPayload := p.mtu - rtp.HeaderSize - len(header)
+	if maxPayload-len(quant) < 1 {
+		return nil, ErrMTUTooSmall
+	}
+
+	var pkts []*rtp.Packet
+
+	for offset, first := 0, true; first || offset < len(scan); first = false {
+		payload := make([]byte, 0, maxPayload+len(header))
+		payload = append(payload, header...)
+		payload[1], payload[2], payload[3] = byte(offset>>16), byte(offset>>8), byte(offset)
+
+		if first {
+			payload = append(payload, quant...)
+		}
+
+		n := min(maxPayload+len(header)-len(payload), len(scan)-offset)
+		payload = append(payload, scan[offset:offset+n]...)
+		offset += n
+
+		pkts = append(pkts, p.seq.Packet(payload, pkt.DTS+pkt.PTSOffset, offset == len(scan)))
+	}
+
+	return pkts, nil
+}
+
+// parseJPEG returns the RTP/JPEG description of a baseline JPEG image and
+// its scan data.
+//
+//nolint:gocognit,cyclop
+func parseJPEG(image []byte) (frameHeader, []byte, error) {
+	var (
+		h           frameHeader
+		tables      [4][]byte
+		lumaTable   = -1
+		chromaTable int
+	)
+
+	if len(image) < 4 || image[0] != 0xff || image[1] != markerSOI {
+		return h, nil, ErrUnsupportedJPEG
+	}
+
+	for i := 2; i+4 <= len(image); {
+		if image[i] != 0xff {
+			return h, nil, ErrUnsupportedJPEG
+		}
+
+		marker := image[i+1]
+		if marker == 0xff { // fill byte
+			i++
+
+			continue
+		}
+
+		size := int(pio.U16BE(image[i+2:]))
+		if size < 2 || i+2+size > len(image) {
+			return h, nil, ErrUnsupportedJPEG
+		}
+
+		segment := image[i+4 : i+2+size]
+		i += 2 + size
+
+		switch marker {
+		case markerDQT:
+			for len(segment) > 0 {
+				id, tableSize := segment[0]&0x0f, 64*(1+int(segment[0]>>4))
+				if id > 3 || len(segment) < 1+tableSize {
+					return h, nil, ErrUnsupportedJPEG
+				}
+
+				tables[id], segment = segment[1:1+tableSize], segment[1+tableSize:]
+			}
+		case markerSOF0, markerSOF1:
+			// P(1) Y(2) X(2) Nf(1), then C(1) H/V(1) Tq(1) per component.
+			if len(segment) != 6+3*3 || segment[0] != 8 || segment[5] != 3 ||
+				segment[10] != 0x11 || segment[13] != 0x11 || segment[11] != segment[14] {
+				return h, nil, ErrUnsupportedJPEG
+			}
+
+			switch segment[7] {
+			case 0x21:
+				h.typ = type422
+			case 0x22:
+				h.typ = type420
+			default:
+				return h, nil, ErrUnsupportedJPEG
+			}
+
+			h.height, h.width = int(pio.U16BE(segment[1:])), int(pio.U16BE(segment[3:]))
+			lumaTable, chromaTable = int(segment[8]&3), int(segment[11]&3)
+		case markerDHT:
+			if !standardHuffmanTables(segment) {
+				return h, nil, ErrUnsupportedJPEG
+			}
+		case markerDRI:
+			if len(segment) < 2 {
+				return h, nil, ErrUnsupportedJPEG
+			}
+
+			h.restartInterval = pio.U16BE(segment)
+		case markerSOS:
+			if lumaTable < 0 || h.width == 0 || h.width > 2040 || h.height == 0 || h.height > 2040 ||
+				tables[lumaTable] == nil || tables[chromaTable] == nil {
+				return h, nil, ErrUnsupportedJPEG
+			}
+
+			for j, id := range []int{lumaTable, chromaTable} {
+				if len(tables[id]) == 128 {
+					h.precision |= 1 << j
+				}
+
+				h.tables = append(h.tables, tables[id]...)
+			}
+
+			return h, bytes.TrimSuffix(image[i:], []byte{0xff, markerEOI}), nil
+		default:
+			if marker >= 0xc2 && marker <= 0xcf && marker != 0xc8 && marker != 0xcc {
+				// Progressive, lossless and arithmetic-coded images.
+				return h, nil, ErrUnsupportedJPEG
+			}
+		}
+	}
+
+	return h, nil, ErrUnsupportedJPEG
+}
+
+// standardHuffmanTables reports whether every table of a DHT segment is the
+// Annex K table of its class and ID.
+func standardHuffmanTables(segment []byte) bool {
+	for len(segment) > 0 {
+		if len(segment) < 17 {
+			return false
+		}
+
+		var table *huffmanTable
+
+		for i := range huffmanTables {
+			if huffmanTables[i].classAndID == segment[0] {
+				table = &huffmanTables[i]
+			}
+		}
+
+		if table == nil {
+			return false
+		}
+
+		size := 17 + len(table.symbols)
+		if len(segment) < size || !bytes.Equal(segment[1:17], table.counts[:]) || !bytes.Equal(segment[17:size], table.symbols) {
+			return false
+		}
+
+		segment = segment[size:]
+	}
+
+	return true
+}
@@ -0,0 +1,62 @@
+package rtp
+
+import (
+	"math/rand/v2"
+	"time"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/internal/ticks"
+)
+
+// DefaultMTU is the default maximum size of an RTP packet, header included.
+// It leaves room for IP, UDP and SRTP overhead on common paths.
+const DefaultMTU = 1200
+
+// Packetizer converts the av.Packets of one stream into RTP packets.
+type Packetizer interface {
+	Packetize(pkt av.Packet) ([]*Packet, error)
+}
+
+// Sequencer stamps the RTP packets of one stream with its payload type,
+// SSRC, sequence numbers and timestamps. The first sequence number and the
+// timestamp offset are random, as RFC 3550 recommends.
+type Sequencer struct {
+	PayloadType uint8
+	SSRC        uint32
+	seq         uint16
+	offset      uint32
+	rate        int64
+}
+
+// NewSequencer returns a Sequencer for a stream whose clock counts rate
+// ticks per second.
+func NewSequencer(payloadType uint8, ssrc uint32, rate int) *Sequencer {
+	return &Sequencer{
+		PayloadType: payloadType,
+		SSRC:        ssrc,
+		seq:         uint16(rand.Uint32()), //nolint:gosec
+		offset:      rand.Uint32(),         //nolint:gosec
+		rate:        int64(rate),
+	}
+}
+
+// Timestamp converts a presentation time into an RTP timestamp, rounded to
+// the nearest tick.
+func (s *Sequencer) Timestamp(pts time.Duration) uint32 {
+	return s.offset + uint32(ticks.FromDuration(pts, s.rate))
+}
+
+// Packet returns the next RTP packet of the stream.
+func (s *Sequencer) Packet(payload []byte, pts time.Duration, marker bool) *Packet {
+	pkt := &Packet{
+		Marker:         marker,
+		PayloadType:    s.PayloadType,
+		SequenceNumber: s.seq,
+		Timestamp:      s.Timestamp(pts),
+		SSRC:           s.SSRC,
+		Payload:        payload,
+	}
+	s.seq++
+
+	return pkt
+}
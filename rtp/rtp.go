@@ -0,0 +1,179 @@
+// Package rtp implements RTP packets (RFC 3550) and the pieces shared by the
+// payload format packages below it, which convert between RTP packets and
+// av.Packets.
+package rtp
+
+import (
+	"time"
+
+	"github.com/vtpl1/avsdk/av"
+	"github.com/vtpl1/avsdk/internal/ticks"
+	"github.com/vtpl1/avsdk/utils/bits/pio"
+)
+
+// HeaderSize is the size of an RTP header without CSRCs or extension.
+const HeaderSize = 12
+
+const version = 2
+
+// Packet is an RTP packet.
+type Packet struct {
+	Marker           bool
+	PayloadType      uint8
+	SequenceNumber   uint16
+	Timestamp        uint32
+	SSRC             uint32
+	CSRC             []uint32
+	ExtensionProfile uint16
+	Extension        []byte // header extension data, present if not nil
+	Payload          []byte
+}
+
+// Depacketizer converts the RTP packets of one stream into av.Packets. An
+// RTP packet yields zero or more av.Packets; lost packets are detected from
+// sequence numbers and never reported as errors.
+type Depacketizer interface {
+	Depacketize(pkt *Packet) ([]av.Packet, error)
+}
+
+// Unmarshal parses b into p. Padding is removed; Payload and Extension alias b.
+func (p *Packet) Unmarshal(b []byte) error {
+	if len(b) < HeaderSize {
+		return ErrPacketTooShort
+	}
+
+	if b[0]>>6 != version {
+		return ErrInvalidVersion
+	}
+
+	padding := b[0]&0x20 != 0
+	extension := b[0]&0x10 != 0
+	csrcCount := int(b[0] & 0x0f)
+
+	p.Marker = b[1]&0x80 != 0
+	p.PayloadType = b[1] & 0x7f
+	p.SequenceNumber = pio.U16BE(b[2:])
+	p.Timestamp = pio.U32BE(b[4:])
+	p.SSRC = pio.U32BE(b[8:])
+
+	n := HeaderSize + 4*csrcCount
+	if len(b) < n {
+		return ErrPacketTooShort
+	}
+
+	p.CSRC = p.CSRC[:0]
+	for i := HeaderSize; i < n; i += 4 {
+		p.CSRC = append(p.CSRC, pio.U32BE(b[i:]))
+	}
+
+	p.ExtensionProfile, p.Extension = 0, nil
+
+	if extension {
+		if len(b) < n+4 {
+			return ErrPacketTooShort
+		}
+
+		p.ExtensionProfile = pio.U16BE(b[n:])
+		size := 4 * int(pio.U16BE(b[n+2:]))
+		n += 4
+
+		if len(b) < n+size {
+			return ErrPacketTooShort
+		}
+
+		p.Extension = b[n : n+size]
+		n += size
+	}
+
+	end := len(b)
+	if padding {
+		end -= int(b[end-1])
+		if end < n {
+			return ErrPacketTooShort
+		}
+	}
+
+	p.Payload = b[n:end]
+
+	return nil
+}
+
+// Marshal returns the wire format of p, without padding.
+func (p *Packet) Marshal() []byte {
+	size := HeaderSize + 4*len(p.CSRC) + len(p.Payload)
+	if p.Extension != nil {
+		size += 4 + (len(p.Extension)+3)&^3
+	}
+
+	b := make([]byte, size)
+	b[0] = version<<6 | byte(len(p.CSRC))&0x0f
+	b[1] = p.PayloadType & 0x7f
+
+	if p.Marker {
+		b[1] |= 0x80
+	}
+
+	pio.PutU16BE(b[2:], p.SequenceNumber)
+	pio.PutU32BE(b[4:], p.Timestamp)
+	pio.PutU32BE(b[8:], p.SSRC)
+
+	n := HeaderSize
+	for _, csrc := range p.CSRC {
+		pio.PutU32BE(b[n:], csrc)
+		n += 4
+	}
+
+	if p.Extension != nil {
+		b[0] |= 0x10
+		words := (len(p.Extension) + 3) / 4
+		pio.PutU16BE(b[n:], p.ExtensionProfile)
+		pio.PutU16BE(b[n+2:], uint16(words))
+		copy(b[n+4:], p.Extension)
+		n += 4 + 4*words
+	}
+
+	copy(b[n:], p.Payload)
+
+	return b
+}
+
+// LossDetector reports gaps in the sequence numbers of one stream.
+type LossDetector struct {
+	last uint16
+	init bool
+}
+
+// Lost reports whether packets are missing before seq. Reordered and
+// duplicated packets count as losses.
+func (l *LossDetector) Lost(seq uint16) bool {
+	lost := l.init && seq != l.last+1
+	l.last, l.init = seq, true
+
+	return lost
+}
+
+// Clock converts RTP timestamps into durations since the first timestamp,
+// extending them past 32-bit wraparound.
+type Clock struct {
+	rate  int64
+	last  uint32
+	ticks int64
+	init  bool
+}
+
+// NewClock returns a Clock for timestamps counting rate ticks per second.
+func NewClock(rate int) *Clock {
+	return &Clock{rate: int64(rate)}
+}
+
+// Duration returns the time from the first timestamp to ts. Timestamps may
+// go backwards by less than half the 32-bit range.
+func (c *Clock) Duration(ts uint32) time.Duration {
+	if c.init {
+		c.ticks += int64(int32(ts - c.last))
+	}
+
+	c.last, c.init = ts, true
+
+	return ticks.ToDuration(c.ticks, c.rate)
+}
@@ -0,0 +1,82 @@
+package rtp_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/vtpl1/avsdk/rtp"
+)
+
+func TestPacketRoundTrip(t *testing.T) {
+	in := rtp.Packet{
+		Marker:           true,
+		PayloadType:      96,
+		SequenceNumber:   0xfffe,
+		Timestamp:        0x12345678,
+		SSRC:             0xdeadbeef,
+		CSRC:             []uint32{1, 2},
+		ExtensionProfile: 0xbede,
+		Extension:        []byte{1, 2, 3, 4},
+		Payload:          []byte{0x65, 0x88, 0x80},
+	}
+
+	b := in.Marshal()
+
+	var out rtp.Packet
+	if err := out.Unmarshal(b); err != nil {
+		t.Fatal(err)
+	}
+
+	if !out.Marker || out.PayloadType != 96 || out.SequenceNumber != 0xfffe || out.Timestamp != 0x12345678 ||
+		out.SSRC != 0xdeadbeef || len(out.CSRC) != 2 || out.CSRC[1] != 2 || out.ExtensionProfile != 0xbede ||
+		!bytes.Equal(out.Extension, in.Extension) || !bytes.Equal(out.Payload, in.Payload) {
+		t.Fatalf("packet = %+v", out)
+	}
+
+	// Padding is stripped from the payload.
+	padded := append(b[:len(b):len(b)], 0, 0, 3)
+	padded[0] |= 0x20
+
+	if err := out.Unmarshal(padded); err != nil || !bytes.Equal(out.Payload, in.Payload) {
+		t.Fatalf("padded payload = %x, err = %v", out.Payload, err)
+	}
+
+	if err := out.Unmarshal(b[:10]); !errors.Is(err, rtp.ErrPacketTooShort) {
+		t.Fatalf("err = %v, want ErrPacketTooShort", err)
+	}
+
+	if err := out.Unmarshal(append([]byte{0x40}, b[1:]...)); !errors.Is(err, rtp.ErrInvalidVersion) {
+		t.Fatalf("err = %v, want ErrInvalidVersion", err)
+	}
+}
+
+func TestClockAndLoss(t *testing.T) {
+	clock := rtp.NewClock(90000)
+
+	for _, tc := range []struct {
+		ts   uint32
+		want time.Duration
+	}{
+		{0xffffc000, 0},
+		{0xffffc000 + 3600, 40 * time.Millisecond},
+		{0x00001000, (0x1000 + 0x4000) * time.Second / 90000},
+		{0x00000000, 0x4000 * time.Second / 90000},
+	} {
+		if got := clock.Duration(tc.ts); got != tc.want {
+			t.Fatalf("Duration(%#x) = %v, want %v", tc.ts, got, tc.want)
+		}
+	}
+
+	var loss rtp.LossDetector
+
+	for i, tc := range []struct {
+		seq  uint16
+		lost bool
+	}{{0xfffe, false}, {0xffff, false}, {0, false}, {2, true}, {3, false}, {3, true}} {
+		if got := loss.Lost(tc.seq); got != tc.lost {
+			t.Fatalf("%d: Lost(%d) = %v, want %v", i, tc.seq, got, tc.lost)
+		}
+	}
+}